@@ -16,7 +16,9 @@ var gocker = &cobra.Command{
 
 // init registers the subcommands within the root command.
 func init() {
-	gocker.AddCommand(cmd.Run, cmd.Pull)
+	gocker.PersistentFlags().StringVarP(&cmd.Host, "host", "H", os.Getenv("GOCKER_HOST"), "Daemon socket to connect to (unix:///path), the local stores are used without one")
+
+	gocker.AddCommand(cmd.Run, cmd.Pull, cmd.Push, cmd.Sign, cmd.GenerateKeyPair, cmd.Build, cmd.Builder, cmd.Registry, cmd.Images, cmd.Rmi, cmd.Tag, cmd.Image, cmd.History, cmd.Save, cmd.Load, cmd.Import, cmd.Export, cmd.Commit, cmd.Cp, cmd.Diff, cmd.System, cmd.Ps, cmd.Inspect, cmd.Pause, cmd.Unpause, cmd.Update, cmd.Top, cmd.Network, cmd.Spec, cmd.Runtime, cmd.Stop, cmd.Wait, cmd.Rm)
 }
 
 func main() {
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/cmd"
+)
+
+// init registers the commands the daemon executes itself with. The supervisors of
+// containers run gocker run and images are pulled in gocker pull processes.
+func init() {
+	cmd.Run.Hidden, cmd.Pull.Hidden = true, true
+
+	cmd.Daemon.AddCommand(cmd.Run, cmd.Pull)
+}
+
+func main() {
+	if err := cmd.Daemon.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to gockerd over its Unix socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+// ParseHost returns the socket path of a daemon address, given as unix:///path or as a plain path.
+func ParseHost(host string) (string, error) {
+	if path, ok := strings.CutPrefix(host, "unix://"); ok {
+		host = path
+	} else if strings.Contains(host, "://") {
+		return "", fmt.Errorf("unsupported daemon address %q, only unix sockets are supported", host)
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("daemon address has no socket path")
+	}
+
+	return host, nil
+}
+
+// NewClient returns a client of the daemon listening at the given address.
+func NewClient(host string) (*Client, error) {
+	path, err := ParseHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+
+	return &Client{httpClient: &http.Client{Transport: transport}}, nil
+}
+
+// do sends a request to the daemon and returns the response, or the error message the daemon responded with.
+func (c *Client) do(method, path string, query url.Values, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	u := "http://gocker/v" + APIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the daemon: %v", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+
+		var msg ErrorMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil || msg.Message == "" {
+			return nil, fmt.Errorf("daemon responded with %s", resp.Status)
+		}
+		return nil, fmt.Errorf("%s", msg.Message)
+	}
+
+	return resp, nil
+}
+
+// decode reads the JSON body of a response into v.
+func decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return nil
+}
+
+// CreateContainer creates a container without starting it and returns its ID.
+func (c *Client) CreateContainer(name string, cfg *ContainerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	resp, err := c.do(http.MethodPost, "/containers/create", query, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	var created CreateResponse
+	if err := decode(resp, &created); err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+// StartContainer starts a created container.
+func (c *Client) StartContainer(id string) error {
+	resp, err := c.do(http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// WaitContainer blocks until a container stops and returns its exit code.
+func (c *Client) WaitContainer(id string) (int, error) {
+	resp, err := c.do(http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait WaitResponse
+	if err := decode(resp, &wait); err != nil {
+		return 0, err
+	}
+
+	if wait.Error != nil {
+		return 0, fmt.Errorf("%s", wait.Error.Message)
+	}
+
+	return wait.StatusCode, nil
+}
+
+// ContainerLogs opens the log stream of a container, followed until the container stops if follow is set.
+// The stream is in the multiplexed format, which CopyLogs splits into the output streams.
+func (c *Client) ContainerLogs(id string, follow bool) (io.ReadCloser, error) {
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if follow {
+		query.Set("follow", "1")
+	}
+
+	resp, err := c.do(http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// CopyLogs copies the frames of a multiplexed log stream to the writer of their stream.
+func CopyLogs(stdout, stderr io.Writer, r io.Reader) error {
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read log stream: %v", err)
+		}
+
+		w := stdout
+		if header[0] == StreamStderr {
+			w = stderr
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return fmt.Errorf("failed to read log stream: %v", err)
+		}
+	}
+}
+
+// PullImage pulls an image, writing the progress messages of the daemon to out.
+func (c *Client) PullImage(ref string, out io.Writer) error {
+	resp, err := c.do(http.MethodPost, "/images/create", url.Values{"fromImage": {ref}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg ProgressMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode progress: %v", err)
+		}
+
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+
+		fmt.Fprintln(out, msg.Status)
+	}
+}
+
+// Images returns the images of the daemon.
+func (c *Client) Images() ([]ImageSummary, error) {
+	resp, err := c.do(http.MethodGet, "/images/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageSummary
+	if err := decode(resp, &images); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
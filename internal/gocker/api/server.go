@@ -0,0 +1,554 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// pollInterval is how often waiting requests check the state of their container.
+const pollInterval = 100 * time.Millisecond
+
+// versionPrefix matches the API version clients may prefix paths with.
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+\.[0-9]+/`)
+
+// Server is an http.Handler serving the subset of the Docker Engine API gocker supports.
+//
+// Containers and images live in the same stores the CLI uses. The supervisors of the
+// containers it starts re-execute the daemon with the arguments of gocker run, so the
+// daemon binary has to understand them, like it has to understand gocker pull.
+type Server struct {
+	mux *http.ServeMux
+
+	// mu guards the containers the daemon keeps track of, and serializes starts
+	mu sync.Mutex
+	// removing are the started containers the daemon removes once they exit
+	removing map[string]bool
+	// exits are the exit codes of the containers the daemon removed, for later wait requests
+	exits map[string]int
+}
+
+// NewServer returns a server of the gocker API.
+func NewServer() *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		removing: make(map[string]bool),
+		exits:    make(map[string]int),
+	}
+
+	s.mux.HandleFunc("GET /_ping", s.ping)
+	s.mux.HandleFunc("HEAD /_ping", s.ping)
+	s.mux.HandleFunc("GET /version", s.version)
+	s.mux.HandleFunc("POST /containers/create", s.createContainer)
+	s.mux.HandleFunc("POST /containers/{id}/start", s.startContainer)
+	s.mux.HandleFunc("POST /containers/{id}/wait", s.waitContainer)
+	s.mux.HandleFunc("GET /containers/{id}/logs", s.containerLogs)
+	s.mux.HandleFunc("POST /images/create", s.createImage)
+	s.mux.HandleFunc("GET /images/json", s.listImages)
+
+	return s
+}
+
+// ServeHTTP routes a request to the endpoint handling it, with or without a version prefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Api-Version", APIVersion)
+
+	if loc := versionPrefix.FindStringIndex(req.URL.Path); loc != nil {
+		req.URL.Path = req.URL.Path[loc[1]-1:]
+	}
+
+	s.mux.ServeHTTP(w, req)
+}
+
+// ping reports that the daemon is up.
+func (s *Server) ping(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write([]byte("OK"))
+}
+
+// version reports the version of the API and the platform of the daemon.
+func (s *Server) version(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"ApiVersion": APIVersion,
+		"Os":         runtime.GOOS,
+		"Arch":       runtime.GOARCH,
+	})
+}
+
+// createContainer registers a container from an image without starting it.
+// Its command is the entrypoint and command of the request, or else of the image.
+func (s *Server) createContainer(w http.ResponseWriter, req *http.Request) {
+	var cfg ContainerConfig
+	if err := json.NewDecoder(req.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode request body: %v", err))
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name != "" {
+		if st, err := container.FindState(name); err == nil && st.Name == name {
+			writeError(w, http.StatusConflict, fmt.Sprintf("container name %q is already in use by container %s", name, st.ShortID()))
+			return
+		}
+	}
+
+	img, err := image.Get(cfg.Image)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such image: %s", cfg.Image))
+		return
+	}
+
+	// A new entrypoint drops the command of the image, like it does in Docker
+	entrypoint, cmd := cfg.Entrypoint, cfg.Cmd
+	if entrypoint == nil {
+		entrypoint = img.Config.Config.Entrypoint
+		if cmd == nil {
+			cmd = img.Config.Config.Cmd
+		}
+	}
+
+	argv := append(append([]string{}, entrypoint...), cmd...)
+	if len(argv) == 0 {
+		writeError(w, http.StatusBadRequest, "no command specified")
+		return
+	}
+
+	opts := container.Options{
+		Name:          name,
+		Detach:        true,
+		Workdir:       cfg.WorkingDir,
+		User:          cfg.User,
+		Hostname:      cfg.Hostname,
+		RestartPolicy: cfg.HostConfig.RestartPolicy,
+	}
+
+	// Variables without a value would come from the environment of the daemon
+	for _, kv := range cfg.Env {
+		if strings.Contains(kv, "=") {
+			opts.EnvVars = append(opts.EnvVars, kv)
+		}
+	}
+
+	// Containers share the network of the host by default
+	if mode := cfg.HostConfig.NetworkMode; mode != "default" {
+		opts.Network = mode
+	}
+
+	if opts.RestartPolicy, err = container.ParseRestartPolicy(opts.RestartPolicy.String()); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts.ExecArgs = runArgs(opts, cfg.Image, argv)
+
+	c, err := container.NewContainer(cfg.Image, argv[0], argv[1:], opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := c.Create(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The daemon removes the container rather than its supervisor, keeping the exit code for wait requests
+	if cfg.HostConfig.AutoRemove {
+		if err := container.UpdateState(c.ID, func(st *container.State) { st.AutoRemove = true }); err != nil {
+			container.Remove(c.ID, false)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, CreateResponse{ID: c.ID, Warnings: []string{}})
+}
+
+// runArgs returns the gocker run arguments the supervisor of a container is executed with.
+func runArgs(opts container.Options, img string, argv []string) []string {
+	args := []string{"run", "--detach", "--restart", opts.RestartPolicy.String()}
+
+	for _, flag := range []struct{ name, value string }{
+		{"--name", opts.Name},
+		{"--workdir", opts.Workdir},
+		{"--user", opts.User},
+		{"--hostname", opts.Hostname},
+		{"--network", opts.Network},
+	} {
+		if flag.value != "" {
+			args = append(args, flag.name, flag.value)
+		}
+	}
+
+	for _, kv := range opts.EnvVars {
+		args = append(args, "--env", kv)
+	}
+
+	return append(append(args, img), argv...)
+}
+
+// startContainer starts a container created through the API. The container is restored
+// from its state, so containers created before the daemon restarted are started too.
+func (s *Server) startContainer(w http.ResponseWriter, req *http.Request) {
+	// The state is read while starts are serialized, so a container is started once
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := findContainer(w, req)
+	if !ok {
+		return
+	}
+
+	c, err := container.Restore(st)
+	switch {
+	case err != nil && st.IsRunning():
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case err != nil:
+		writeError(w, http.StatusConflict, fmt.Sprintf("container %s was not created through the API or has already run", req.PathValue("id")))
+		return
+	}
+
+	if st.AutoRemove {
+		s.removing[st.ID] = true
+	}
+
+	if err := c.Start(); err != nil {
+		delete(s.removing, st.ID)
+
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if st.AutoRemove {
+		go s.removeOnExit(st.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeOnExit removes a container once it exits, keeping its exit code for wait requests.
+func (s *Server) removeOnExit(id string) {
+	code := -1
+	if st, err := container.Wait(id); err == nil {
+		code = st.ExitCode
+	}
+
+	s.mu.Lock()
+	s.exits[id] = code
+	delete(s.removing, id)
+	s.mu.Unlock()
+
+	if err := container.Remove(id, false); err != nil {
+		fmt.Printf("Warning: Failed to remove container %s: %v\n", id, err)
+	}
+}
+
+// waitContainer blocks until a container stops, then responds with its exit code.
+// Containers that are created but not started yet are waited for too.
+func (s *Server) waitContainer(w http.ResponseWriter, req *http.Request) {
+	st, ok := findContainer(w, req)
+	if !ok {
+		return
+	}
+
+	code, err := s.wait(req.Context(), st.ID)
+	if err != nil {
+		if req.Context().Err() == nil {
+			writeJSON(w, http.StatusOK, WaitResponse{StatusCode: -1, Error: &WaitError{Message: err.Error()}})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, WaitResponse{StatusCode: code})
+}
+
+// wait polls the state of a container until it stops or the context is done.
+func (s *Server) wait(ctx context.Context, id string) (int, error) {
+	for {
+		s.mu.Lock()
+		code, exited := s.exits[id]
+		removing := s.removing[id]
+		s.mu.Unlock()
+
+		if exited {
+			return code, nil
+		}
+
+		// Containers the daemon removes are done once they're gone
+		if !removing {
+			st, err := container.LoadState(id)
+			if err != nil {
+				return 0, err
+			}
+
+			if st.Abandoned() {
+				return 0, fmt.Errorf("supervisor of container %s exited before running it", st.ShortID())
+			}
+			if st.Status != container.StatusCreated && !st.IsRunning() {
+				return st.ExitCode, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// containerLogs streams the log of a container in the multiplexed format of the Docker API.
+// The log holds both output streams of the container, so it's all sent as the first one asked for.
+func (s *Server) containerLogs(w http.ResponseWriter, req *http.Request) {
+	st, ok := findContainer(w, req)
+	if !ok {
+		return
+	}
+
+	query := req.URL.Query()
+	stdout, stderr, follow := boolParam(query.Get("stdout")), boolParam(query.Get("stderr")), boolParam(query.Get("follow"))
+	if !stdout && !stderr {
+		writeError(w, http.StatusBadRequest, "you must choose at least one stream")
+		return
+	}
+
+	stream := byte(StreamStdout)
+	if !stdout {
+		stream = StreamStderr
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	var log *os.File
+	defer func() {
+		if log != nil {
+			log.Close()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for last := false; ; {
+		// Containers that aren't started yet have no log
+		if log == nil {
+			if f, err := os.Open(container.LogPath(st.ID)); err == nil {
+				log = f
+			}
+		}
+
+		if log != nil {
+			n, err := log.Read(buf)
+			if n > 0 {
+				if writeFrame(w, stream, buf[:n]) != nil {
+					return
+				}
+				flush(w)
+				continue
+			}
+			if err != nil && err != io.EOF {
+				return
+			}
+		}
+
+		if !follow || last {
+			return
+		}
+
+		// The log is read once more after the container stops, for what it wrote meanwhile
+		if cur, err := container.LoadState(st.ID); err != nil || (cur.Status != container.StatusCreated && !cur.IsRunning()) {
+			last = true
+			continue
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// writeFrame writes a frame of the multiplexed stream, an 8 byte header
+// with the stream type and the size of the payload followed by the payload.
+func writeFrame(w io.Writer, stream byte, payload []byte) error {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+// createImage pulls an image, streaming the progress as JSON messages. The pull runs in
+// a gocker pull process, which enters a user namespace of its own when unprivileged.
+func (s *Server) createImage(w http.ResponseWriter, req *http.Request) {
+	ref, tag := req.URL.Query().Get("fromImage"), req.URL.Query().Get("tag")
+	if ref == "" {
+		writeError(w, http.StatusBadRequest, "only pulling images with fromImage is supported")
+		return
+	}
+
+	// The tag may be part of the reference already
+	if tag != "" && !strings.Contains(ref, "@") && strings.LastIndex(ref, ":") <= strings.LastIndex(ref, "/") {
+		ref += ":" + tag
+	}
+
+	cmd := exec.CommandContext(req.Context(), "/proc/self/exe", "pull", ref)
+
+	pr, pw := io.Pipe()
+	cmd.Stdout, cmd.Stderr = pw, pw
+
+	if err := cmd.Start(); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to start pull: %v", err))
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+
+	// Lines are sent one behind, since the last one of a failed pull is its error
+	var last string
+	scanner := bufio.NewScanner(pr)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if last != "" {
+			enc.Encode(ProgressMessage{Status: last})
+			flush(w)
+		}
+		last = line
+	}
+	io.Copy(io.Discard, pr)
+
+	if err := <-done; err != nil {
+		msg := fmt.Sprintf("failed to pull %s: %v", ref, err)
+		if last != "" {
+			msg = strings.TrimPrefix(last, fmt.Sprintf("Error while pulling %q image: ", ref))
+		}
+		enc.Encode(ProgressMessage{Error: msg, ErrorDetail: &ErrorMessage{Message: msg}})
+	} else if last != "" {
+		enc.Encode(ProgressMessage{Status: last})
+	}
+}
+
+// scanLines is a bufio.SplitFunc splitting at both newlines and the carriage returns progress bars redraw their line with.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// listImages responds with the images of the local image store. Names of
+// the same image are listed together, like Docker lists the tags of an image.
+func (s *Server) listImages(w http.ResponseWriter, req *http.Request) {
+	images, err := image.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	summaries := []*ImageSummary{}
+	byID := make(map[string]*ImageSummary)
+
+	for _, img := range images {
+		sum := byID[img.ID]
+		if sum == nil {
+			sum = &ImageSummary{ID: img.ID, RepoTags: []string{}, RepoDigests: []string{}, Created: img.Created.Unix(),
+				Size: img.Size, SharedSize: -1, Labels: img.Config.Config.Labels, Containers: -1}
+			if img.Created.IsZero() {
+				sum.Created = 0
+			}
+
+			byID[img.ID] = sum
+			summaries = append(summaries, sum)
+		}
+
+		if img.Tag() != "" {
+			sum.RepoTags = append(sum.RepoTags, img.Repository()+":"+img.Tag())
+		}
+		if img.Digest != "" && img.Repository() != "" {
+			sum.RepoDigests = append(sum.RepoDigests, img.Repository()+"@"+img.Digest)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// findContainer looks up the container of a request by its name or ID, responding with an error if there's none.
+func findContainer(w http.ResponseWriter, req *http.Request) (*container.State, bool) {
+	st, err := container.FindState(req.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such container: %s", req.PathValue("id")))
+		return nil, false
+	}
+
+	return st, true
+}
+
+// boolParam parses a boolean query parameter the way the Docker API does.
+func boolParam(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "0", "no", "false", "none":
+		return false
+	}
+
+	return true
+}
+
+// flush sends what has been written of a streamed response, through the writers wrapping it too.
+func flush(w http.ResponseWriter) {
+	http.NewResponseController(w).Flush()
+}
+
+// writeJSON writes a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response in the format of the Docker API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorMessage{Message: message})
+}
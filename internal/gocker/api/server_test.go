@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// newTestServer serves the API with a temporary home directory.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	setupHome(t)
+
+	server := httptest.NewServer(NewServer())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// setupHome sets up a temporary home directory holding an image without a command.
+func setupHome(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+
+	dir := image.Path("nocmd")
+	if err := os.MkdirAll(filepath.Join(dir, "rootfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".config.json"), []byte(`{"created": "2024-01-02T03:04:05Z", "config": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// request sends a request to the test server and returns the response with its body read.
+func request(t *testing.T, method, url, body string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, data
+}
+
+// TestPing tests that paths are served with and without a version prefix
+func TestPing(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, path := range []string{"/_ping", "/v1.41/_ping", "/v1.24/_ping"} {
+		resp, body := request(t, http.MethodGet, server.URL+path, "")
+		if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+			t.Errorf("Expected %s to respond OK, got %d %q", path, resp.StatusCode, body)
+		}
+		if v := resp.Header.Get("Api-Version"); v != APIVersion {
+			t.Errorf("Expected API version %s, got %q", APIVersion, v)
+		}
+	}
+
+	if resp, _ := request(t, http.MethodPost, server.URL+"/images/json", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a wrong method to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+// TestContainerErrors tests the errors of container requests
+func TestContainerErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+		message            string
+	}{
+		{http.MethodPost, "/containers/create", `{"Image": `, http.StatusBadRequest, "failed to decode"},
+		{http.MethodPost, "/containers/create", `{"Image": "missing", "Cmd": ["true"]}`, http.StatusNotFound, "No such image: missing"},
+		{http.MethodPost, "/containers/create", `{"Image": "nocmd"}`, http.StatusBadRequest, "no command specified"},
+		{http.MethodPost, "/containers/create", `{"Image": "nocmd", "Cmd": ["true"], "HostConfig": {"RestartPolicy": {"Name": "sometimes"}}}`, http.StatusBadRequest, "invalid restart policy"},
+		{http.MethodPost, "/containers/missing/start", "", http.StatusNotFound, "No such container: missing"},
+		{http.MethodPost, "/containers/missing/wait", "", http.StatusNotFound, "No such container: missing"},
+		{http.MethodGet, "/containers/missing/logs?stdout=1", "", http.StatusNotFound, "No such container: missing"},
+	} {
+		resp, body := request(t, tt.method, server.URL+tt.path, tt.body)
+
+		var msg ErrorMessage
+		json.Unmarshal(body, &msg)
+
+		if resp.StatusCode != tt.status || !strings.Contains(msg.Message, tt.message) {
+			t.Errorf("Expected %s %s to fail with %d %q, got %d %s", tt.method, tt.path, tt.status, tt.message, resp.StatusCode, body)
+		}
+	}
+}
+
+// TestListImages tests that images are listed with their names
+func TestListImages(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, body := request(t, http.MethodGet, server.URL+"/images/json", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected images to be listed, got %d %s", resp.StatusCode, body)
+	}
+
+	var images []ImageSummary
+	if err := json.Unmarshal(body, &images); err != nil {
+		t.Fatalf("Failed to decode images: %v", err)
+	}
+
+	if len(images) != 1 || !slices.Contains(images[0].RepoTags, "nocmd:latest") || !strings.HasPrefix(images[0].ID, "sha256:") {
+		t.Errorf("Expected the image to be listed with its tag, got %s", body)
+	}
+	if images[0].Created != 1704164645 {
+		t.Errorf("Expected the creation time of the image, got %d", images[0].Created)
+	}
+}
+
+// TestRunArgs tests that supervisors get the run arguments of the container settings
+func TestRunArgs(t *testing.T) {
+	opts := container.Options{
+		Name:          "web",
+		EnvVars:       []string{"A=1", "B=2"},
+		Workdir:       "/srv",
+		Network:       "none",
+		RestartPolicy: container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3},
+	}
+
+	expected := []string{"run", "--detach", "--restart", "on-failure:3", "--name", "web", "--workdir", "/srv",
+		"--network", "none", "--env", "A=1", "--env", "B=2", "alpine", "sh", "-c", "exit 1"}
+
+	if args := runArgs(opts, "alpine", []string{"sh", "-c", "exit 1"}); !slices.Equal(args, expected) {
+		t.Errorf("Expected run arguments %q, got %q", expected, args)
+	}
+}
+
+// TestCopyLogs tests that frames of the multiplexed stream end up in their streams
+func TestCopyLogs(t *testing.T) {
+	var stream bytes.Buffer
+	writeFrame(&stream, StreamStdout, []byte("out 1\n"))
+	writeFrame(&stream, StreamStderr, []byte("err\n"))
+	writeFrame(&stream, StreamStdout, []byte("out 2\n"))
+
+	var stdout, stderr bytes.Buffer
+	if err := CopyLogs(&stdout, &stderr, &stream); err != nil {
+		t.Fatalf("Failed to copy logs: %v", err)
+	}
+
+	if stdout.String() != "out 1\nout 2\n" || stderr.String() != "err\n" {
+		t.Errorf("Expected the frames in their streams, got %q and %q", stdout.String(), stderr.String())
+	}
+
+	if err := CopyLogs(io.Discard, io.Discard, bytes.NewReader([]byte{1, 0, 0, 0, 0, 0, 0, 9, 'x'})); err == nil {
+		t.Errorf("Expected a truncated frame to fail")
+	}
+}
+
+// TestClient tests the client against a server on a Unix socket
+func TestClient(t *testing.T) {
+	setupHome(t)
+	server := httptest.NewUnstartedServer(NewServer())
+
+	socket := filepath.Join(t.TempDir(), "gockerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client, err := NewClient("unix://" + socket)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	images, err := client.Images()
+	if err != nil || len(images) != 1 {
+		t.Errorf("Expected the image of the daemon, got %v (%v)", images, err)
+	}
+
+	if _, err := client.CreateContainer("", &ContainerConfig{Image: "missing", Cmd: []string{"true"}}); err == nil || err.Error() != "No such image: missing" {
+		t.Errorf("Expected the error of the daemon, got %v", err)
+	}
+
+	if _, err := client.WaitContainer("missing"); err == nil {
+		t.Errorf("Expected waiting for a missing container to fail")
+	}
+}
+
+// TestParseHost tests the parsing of daemon addresses
+func TestParseHost(t *testing.T) {
+	tests := map[string]string{
+		"unix:///run/gockerd.sock": "/run/gockerd.sock",
+		"/run/gockerd.sock":        "/run/gockerd.sock",
+	}
+
+	for host, expected := range tests {
+		if path, err := ParseHost(host); err != nil || path != expected {
+			t.Errorf("Expected %q to be parsed as %q, got %q (%v)", host, expected, path, err)
+		}
+	}
+
+	for _, host := range []string{"tcp://127.0.0.1:2375", "unix://", ""} {
+		if _, err := ParseHost(host); err == nil {
+			t.Errorf("Expected %q to be rejected", host)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Package api implements the subset of the Docker Engine API served by gockerd on a Unix
+// socket, and the client the gocker CLI uses to talk to it.
+package api
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+const (
+	// APIVersion is the Docker Engine API version the served subset comes from
+	APIVersion = "1.41"
+
+	// RelativeSocketPath is the path of the default daemon socket under the home directory
+	RelativeSocketPath = ".local/share/gocker/gockerd.sock"
+)
+
+// ContainerConfig is the body of a container create request.
+type ContainerConfig struct {
+	Image      string
+	Cmd        []string `json:",omitempty"`
+	Entrypoint []string `json:",omitempty"`
+	Env        []string `json:",omitempty"`
+	WorkingDir string   `json:",omitempty"`
+	User       string   `json:",omitempty"`
+	Hostname   string   `json:",omitempty"`
+	HostConfig HostConfig
+}
+
+// HostConfig holds the settings of a container that depend on the host.
+type HostConfig struct {
+	AutoRemove    bool   `json:",omitempty"`
+	NetworkMode   string `json:",omitempty"`
+	RestartPolicy container.RestartPolicy
+}
+
+// CreateResponse is the response to a container create request.
+type CreateResponse struct {
+	ID       string `json:"Id"`
+	Warnings []string
+}
+
+// WaitResponse is the response to a container wait request.
+type WaitResponse struct {
+	StatusCode int
+	Error      *WaitError `json:",omitempty"`
+}
+
+// WaitError describes why waiting for a container failed.
+type WaitError struct {
+	Message string
+}
+
+// ImageSummary describes an image in the response to an image list request.
+type ImageSummary struct {
+	ID          string `json:"Id"`
+	ParentID    string `json:"ParentId"`
+	RepoTags    []string
+	RepoDigests []string
+	Created     int64
+	Size        int64
+	SharedSize  int64
+	Labels      map[string]string
+	Containers  int64
+}
+
+// ProgressMessage is a line of the JSON stream an image create request responds with.
+type ProgressMessage struct {
+	Status      string        `json:"status,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ErrorDetail *ErrorMessage `json:"errorDetail,omitempty"`
+}
+
+// ErrorMessage is the body of error responses.
+type ErrorMessage struct {
+	Message string `json:"message"`
+}
+
+// Stream types of the multiplexed log stream, the first byte of the header of every frame.
+const (
+	StreamStdout = 1
+	StreamStderr = 2
+)
+
+// DefaultHost returns the address of the daemon socket gockerd listens on by default.
+func DefaultHost() string {
+	return "unix://" + filepath.Join(os.Getenv("HOME"), RelativeSocketPath)
+}
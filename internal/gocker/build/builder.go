@@ -0,0 +1,694 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// Options holds the user-supplied build settings.
+type Options struct {
+	// Tag is the name the image is stored under
+	Tag string
+	// Dockerfile is the path of the Dockerfile, Dockerfile in the context directory by default
+	Dockerfile string
+	// Context is the directory COPY and ADD sources are relative to
+	Context string
+	// BuildArgs override the defaults of ARG instructions
+	BuildArgs map[string]string
+	// NoCache executes every step instead of reusing the layers of earlier builds
+	NoCache bool
+}
+
+// Builder builds an image from the instructions of a Dockerfile.
+type Builder struct {
+	Options
+
+	out          io.Writer
+	instructions []Instruction
+	idMappings   *idmap.Mappings
+
+	// dir holds the root filesystems of the build stages
+	dir        string
+	stages     []*stage
+	globalArgs map[string]string
+	usedArgs   map[string]bool
+}
+
+// stage is a build stage, started by a FROM instruction.
+type stage struct {
+	name   string
+	rootfs string
+	config registry.ImageConfig
+	layers []registry.Descriptor
+	args   map[string]string
+
+	// cmdSet reports whether the stage set CMD itself rather than inheriting it
+	cmdSet bool
+
+	// cacheKey identifies the state of the stage after the instructions executed so far
+	cacheKey string
+}
+
+// handler executes an instruction within a build stage.
+type handler func(b *Builder, s *stage, inst Instruction) error
+
+// handlers are the supported instructions besides FROM.
+var handlers = map[string]handler{
+	"ARG":        (*Builder).arg,
+	"ENV":        (*Builder).env,
+	"LABEL":      (*Builder).label,
+	"WORKDIR":    (*Builder).workdir,
+	"USER":       (*Builder).user,
+	"RUN":        (*Builder).run,
+	"COPY":       (*Builder).copy,
+	"ADD":        (*Builder).copy,
+	"CMD":        (*Builder).cmd,
+	"ENTRYPOINT": (*Builder).entrypoint,
+	"EXPOSE":     (*Builder).expose,
+}
+
+// allowedFlags are the flags each instruction accepts.
+var allowedFlags = map[string][]string{
+	"COPY": {"chown", "from"},
+	"ADD":  {"chown"},
+}
+
+// NewBuilder creates a Builder for the Dockerfile of the given options.
+func NewBuilder(opts Options, out io.Writer) (*Builder, error) {
+	if _, err := image.ParseReference(opts.Tag); err != nil {
+		return nil, err
+	}
+
+	ctx, err := filepath.Abs(opts.Context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve build context: %v", err)
+	}
+	if fi, err := os.Stat(ctx); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", opts.Context)
+	}
+	opts.Context = ctx
+
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = filepath.Join(ctx, "Dockerfile")
+	}
+
+	f, err := os.Open(opts.Dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Dockerfile: %v", err)
+	}
+	defer f.Close()
+
+	instructions, err := Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build files are given to the host IDs of their owners in the container, like pulled ones
+	m, err := idmap.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Builder{
+		Options:      opts,
+		out:          out,
+		instructions: instructions,
+		idMappings:   m,
+		globalArgs:   make(map[string]string),
+		usedArgs:     make(map[string]bool),
+	}, nil
+}
+
+// Build executes the instructions and stores the final stage as an image.
+// It returns the image ID.
+func (b *Builder) Build() (string, error) {
+	dir, err := image.TempDir("build-")
+	if err != nil {
+		return "", err
+	}
+	b.dir = dir
+	defer os.RemoveAll(dir)
+
+	for i, inst := range b.instructions {
+		fmt.Fprintf(b.out, "Step %d/%d : %s\n", i+1, len(b.instructions), inst.Original)
+
+		if err := b.dispatch(inst); err != nil {
+			return "", fmt.Errorf("line %d: %v", inst.Line, err)
+		}
+	}
+
+	var unused []string
+	for name := range b.BuildArgs {
+		if !b.usedArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Fprintf(b.out, "[Warning] One or more build-args %v were not consumed\n", unused)
+	}
+
+	s := b.stages[len(b.stages)-1]
+	s.config.Created = now()
+
+	id, err := image.Save(b.Tag, s.rootfs, &s.config, s.layers)
+	if err != nil {
+		return "", err
+	}
+
+	ref, _ := image.ParseReference(b.Tag)
+
+	fmt.Fprintf(b.out, "Successfully built %s\n", image.ShortDigest(id))
+	fmt.Fprintf(b.out, "Successfully tagged %s:%s\n", ref.Name(), ref.Tag)
+
+	return id, nil
+}
+
+// dispatch executes an instruction. Only ARG instructions may come before the first FROM.
+func (b *Builder) dispatch(inst Instruction) error {
+	for name := range inst.Flags {
+		if !slices.Contains(allowedFlags[inst.Command], name) {
+			return fmt.Errorf("unknown flag for %s: --%s", inst.Command, name)
+		}
+	}
+
+	if inst.Command == "FROM" {
+		return b.from(inst)
+	}
+
+	h, ok := handlers[inst.Command]
+	if !ok {
+		return fmt.Errorf("unknown instruction: %s", inst.Command)
+	}
+
+	if len(b.stages) == 0 {
+		if inst.Command != "ARG" {
+			return fmt.Errorf("%s before the first FROM", inst.Command)
+		}
+		return b.arg(nil, inst)
+	}
+
+	s := b.stages[len(b.stages)-1]
+	s.cacheKey = chainKey(s.cacheKey, inst.Original)
+
+	return h(b, s, inst)
+}
+
+// from starts a new build stage from an image, an earlier stage or from scratch.
+func (b *Builder) from(inst Instruction) error {
+	words, err := splitWords(inst.Args, lookupIn(b.globalArgs))
+	if err != nil {
+		return err
+	}
+	if len(words) != 1 && (len(words) != 3 || !strings.EqualFold(words[1], "as")) {
+		return fmt.Errorf("FROM requires an image and optionally AS and a stage name")
+	}
+
+	s := &stage{
+		rootfs: filepath.Join(b.dir, strconv.Itoa(len(b.stages))),
+		args:   make(map[string]string),
+	}
+	if len(words) == 3 {
+		s.name = strings.ToLower(words[2])
+	}
+
+	if err := os.Mkdir(s.rootfs, 0755); err != nil {
+		return fmt.Errorf("failed to create stage root filesystem: %v", err)
+	}
+
+	base := words[0]
+
+	switch prev := b.stage(base); {
+	case base == "scratch":
+		s.config = registry.ImageConfig{Architecture: runtime.GOARCH, Os: "linux"}
+		s.config.Rootfs.Type = "layers"
+		s.cacheKey = chainKey("", base)
+
+	case prev != nil:
+		s.config, s.layers = cloneConfig(prev.config), slices.Clone(prev.layers)
+		s.cacheKey = prev.cacheKey
+
+	default:
+		cfg, layers, id, err := b.loadImage(base)
+		if err != nil {
+			return err
+		}
+		s.config, s.layers = *cfg, layers
+		s.cacheKey = chainKey("", id)
+	}
+
+	for _, layer := range s.layers {
+		if err := b.extract(layer, s.rootfs); err != nil {
+			return err
+		}
+	}
+
+	// The config of the base image describes it, not the container it was committed from
+	s.config.Container, s.config.ContainerConfig, s.config.DockerVersion = "", registry.Config{}, ""
+
+	b.stages = append(b.stages, s)
+
+	return nil
+}
+
+// stage returns the earlier build stage with the given name or index, or nil if there is none.
+func (b *Builder) stage(name string) *stage {
+	for i, s := range b.stages {
+		if s.name == strings.ToLower(name) || strconv.Itoa(i) == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// loadImage returns the config, layers and ID of a local image, pulling it if it's missing.
+func (b *Builder) loadImage(name string) (*registry.ImageConfig, []registry.Descriptor, string, error) {
+	if !image.Exists(name) {
+		fmt.Fprintf(b.out, "Unable to find image '%s' locally\n", name)
+
+		client, err := image.NewClient(name, http.NewHttpClient())
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if err := client.Pull(); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	manifest, _, err := image.LoadManifest(name)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	cfg, err := image.LoadConfig(name)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	// Docker layers are gzip compressed tar archives just like OCI ones
+	layers := slices.Clone(manifest.Layers)
+	for i := range layers {
+		if layers[i].MediaType == registry.MediaTypeDockerLayer {
+			layers[i].MediaType = registry.MediaTypeOCILayer
+		}
+	}
+
+	return cfg, layers, manifest.Config.Digest, nil
+}
+
+// extract unpacks a layer from the blob store into a root filesystem.
+func (b *Builder) extract(layer registry.Descriptor, rootfs string) error {
+	f, err := image.OpenBlob(layer.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to open layer %s: %v", image.ShortDigest(layer.Digest), err)
+	}
+	defer f.Close()
+
+	if err := image.ApplyLayer(f, rootfs, b.idMappings); err != nil {
+		return fmt.Errorf("failed to extract layer %s: %v", image.ShortDigest(layer.Digest), err)
+	}
+
+	return nil
+}
+
+// arg declares build arguments. Before the first FROM they're global and
+// can only be used by FROM, stages have to declare them again to use them.
+func (b *Builder) arg(s *stage, inst Instruction) error {
+	lookup := lookupIn(b.globalArgs)
+	if s != nil {
+		lookup = s.lookup
+	}
+
+	words, err := splitWords(inst.Args, lookup)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range words {
+		name, value, hasDefault := strings.Cut(w, "=")
+		if name == "" {
+			return fmt.Errorf("ARG requires a name")
+		}
+
+		if global, ok := b.globalArgs[name]; ok && !hasDefault && s != nil {
+			value = global
+		}
+		if v, ok := b.BuildArgs[name]; ok {
+			value = v
+			b.usedArgs[name] = true
+		}
+
+		if s == nil {
+			b.globalArgs[name] = value
+		} else {
+			s.args[name] = value
+		}
+	}
+
+	return nil
+}
+
+// env sets environment variables of the image.
+func (b *Builder) env(s *stage, inst Instruction) error {
+	pairs, err := keyValues(inst.Args, s.lookup, true)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range pairs {
+		s.config.Config.Env = setEnv(s.config.Config.Env, kv[0], kv[1])
+	}
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// label adds metadata labels to the image.
+func (b *Builder) label(s *stage, inst Instruction) error {
+	pairs, err := keyValues(inst.Args, s.lookup, false)
+	if err != nil {
+		return err
+	}
+
+	if s.config.Config.Labels == nil {
+		s.config.Config.Labels = make(map[string]string)
+	}
+	for _, kv := range pairs {
+		s.config.Config.Labels[kv[0]] = kv[1]
+	}
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// workdir sets the working directory, creating it in a layer of its own if it doesn't exist yet.
+func (b *Builder) workdir(s *stage, inst Instruction) error {
+	words, err := splitWords(inst.Args, s.lookup)
+	if err != nil {
+		return err
+	}
+
+	dir := strings.Join(words, " ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.workingDir(), dir)
+	}
+	s.config.Config.WorkingDir = filepath.Clean(dir)
+	s.cacheKey = chainKey(s.cacheKey, s.config.Config.WorkingDir)
+
+	path, err := image.ResolveInRoot(s.rootfs, s.config.Config.WorkingDir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		s.addHistory(inst, true)
+		return nil
+	}
+
+	return b.commit(s, inst, true, func() error {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("failed to create working directory: %v", err)
+		}
+		return nil
+	})
+}
+
+// user sets the user RUN instructions and containers run as.
+func (b *Builder) user(s *stage, inst Instruction) error {
+	words, err := splitWords(inst.Args, s.lookup)
+	if err != nil {
+		return err
+	}
+	if len(words) != 1 {
+		return fmt.Errorf("USER requires exactly one argument")
+	}
+
+	s.config.Config.User = words[0]
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// cmd sets the default command of containers.
+func (b *Builder) cmd(s *stage, inst Instruction) error {
+	s.config.Config.Cmd = command(inst.Args)
+	s.cmdSet = true
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// entrypoint sets the entrypoint of containers. A CMD inherited from the base image is dropped.
+func (b *Builder) entrypoint(s *stage, inst Instruction) error {
+	s.config.Config.Entrypoint = command(inst.Args)
+	if !s.cmdSet {
+		s.config.Config.Cmd = nil
+	}
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// expose records the ports containers listen on.
+func (b *Builder) expose(s *stage, inst Instruction) error {
+	words, err := splitWords(inst.Args, s.lookup)
+	if err != nil {
+		return err
+	}
+
+	if s.config.Config.ExposedPorts == nil {
+		s.config.Config.ExposedPorts = make(map[string]struct{})
+	}
+
+	for _, w := range words {
+		port, proto, ok := strings.Cut(strings.ToLower(w), "/")
+		if !ok {
+			proto = "tcp"
+		}
+		if proto != "tcp" && proto != "udp" && proto != "sctp" {
+			return fmt.Errorf("invalid protocol in port %q", w)
+		}
+
+		for _, p := range strings.SplitN(port, "-", 2) {
+			if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("invalid port %q", w)
+			}
+		}
+
+		s.config.Config.ExposedPorts[port+"/"+proto] = struct{}{}
+	}
+	s.addHistory(inst, true)
+
+	return nil
+}
+
+// run executes a command in a container on the stage root filesystem and commits its changes.
+func (b *Builder) run(s *stage, inst Instruction) error {
+	argv, ok := execForm(inst.Args)
+	if !ok {
+		argv = []string{"/bin/sh", "-c", inst.Args}
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("RUN requires a command")
+	}
+
+	// Build arguments are visible to the command unless an environment variable overrides them
+	env := slices.Clone(s.config.Config.Env)
+	for _, name := range slices.Sorted(maps.Keys(s.args)) {
+		if _, set := lookupEnv(env, name); !set {
+			env = append(env, name+"="+s.args[name])
+		}
+	}
+
+	opts := container.Options{
+		Remove:  true,
+		Rootfs:  true,
+		EnvVars: env,
+		Workdir: s.workingDir(),
+		User:    s.config.Config.User,
+	}
+
+	// The container processes are started as the run command of the same executable
+	opts.ExecArgs = []string{"run", "--rm", "--rootfs", "-w", opts.Workdir}
+	for _, kv := range env {
+		opts.ExecArgs = append(opts.ExecArgs, "-e", kv)
+	}
+	if opts.User != "" {
+		opts.ExecArgs = append(opts.ExecArgs, "-u", opts.User)
+	}
+	opts.ExecArgs = append(append(opts.ExecArgs, s.rootfs), argv...)
+
+	// Values from build arguments only invalidate the cache of the commands they're visible to
+	s.cacheKey = chainKey(s.cacheKey, append(env, "WORKDIR="+opts.Workdir, "USER="+opts.User)...)
+
+	return b.commit(s, inst, true, func() error {
+		c, err := container.NewContainer(s.rootfs, argv[0], argv[1:], opts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(b.out, " ---> Running in %s\n", c.ID[:12])
+
+		if err := c.Run(); err != nil {
+			if code, exited := container.ExitCode(err); exited {
+				return fmt.Errorf("the command '%s' returned a non-zero code: %d", strings.Join(argv, " "), code)
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+// commit applies a change to the stage root filesystem and adds the changed files as a new layer.
+// The layers of cacheable steps are recorded in the build cache and reused by later builds.
+func (b *Builder) commit(s *stage, inst Instruction, cached bool, change func() error) error {
+	if cached && !b.NoCache {
+		if e, ok := loadCache(s.cacheKey); ok {
+			fmt.Fprintln(b.out, " ---> Using cache")
+
+			if err := b.extract(e.Layer, s.rootfs); err != nil {
+				return err
+			}
+			b.addLayer(s, inst, e.Layer, e.DiffID)
+
+			return nil
+		}
+	}
+
+	before, err := image.TakeSnapshot(s.rootfs)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot root filesystem: %v", err)
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	layer, diffID, err := image.WriteLayer(s.rootfs, before, b.idMappings)
+	if err != nil {
+		return err
+	}
+
+	if cached {
+		if err := saveCache(s.cacheKey, cacheEntry{Layer: layer, DiffID: diffID, Created: now()}); err != nil {
+			return err
+		}
+	}
+	b.addLayer(s, inst, layer, diffID)
+
+	return nil
+}
+
+// addLayer adds a layer to the stage. Later steps depend on its exact contents, so it's part of their cache keys.
+func (b *Builder) addLayer(s *stage, inst Instruction, layer registry.Descriptor, diffID string) {
+	s.layers = append(s.layers, layer)
+	s.config.Rootfs.DiffIds = append(s.config.Rootfs.DiffIds, diffID)
+	s.addHistory(inst, false)
+	s.cacheKey = chainKey(s.cacheKey, layer.Digest)
+
+	fmt.Fprintf(b.out, " ---> %s\n", image.ShortDigest(layer.Digest))
+}
+
+// lookup returns the value of a variable, where environment variables take precedence over build arguments.
+func (s *stage) lookup(name string) (string, bool) {
+	if value, ok := lookupEnv(s.config.Config.Env, name); ok {
+		return value, true
+	}
+
+	value, ok := s.args[name]
+
+	return value, ok
+}
+
+// workingDir returns the working directory of the stage.
+func (s *stage) workingDir() string {
+	if s.config.Config.WorkingDir == "" {
+		return "/"
+	}
+
+	return s.config.Config.WorkingDir
+}
+
+// addHistory records the instruction in the image history.
+func (s *stage) addHistory(inst Instruction, emptyLayer bool) {
+	s.config.History = append(s.config.History, registry.History{
+		Created:    now(),
+		CreatedBy:  inst.Original,
+		EmptyLayer: emptyLayer,
+	})
+}
+
+// command returns the command of CMD and ENTRYPOINT instructions, running the shell form with /bin/sh.
+func command(args string) []string {
+	if argv, ok := execForm(args); ok {
+		return argv
+	}
+
+	return []string{"/bin/sh", "-c", args}
+}
+
+// lookupIn returns a lookup function for variables in the map.
+func lookupIn(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// lookupEnv returns the value of a variable in the environment.
+func lookupEnv(env []string, name string) (string, bool) {
+	for _, kv := range env {
+		if k, v, _ := strings.Cut(kv, "="); k == name {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// setEnv sets a variable in the environment, replacing its earlier value.
+func setEnv(env []string, name, value string) []string {
+	for i, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == name {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+
+	return append(env, name+"="+value)
+}
+
+// cloneConfig returns a copy of the config that shares no slices or maps with it.
+func cloneConfig(cfg registry.ImageConfig) registry.ImageConfig {
+	cfg.Config.Env = slices.Clone(cfg.Config.Env)
+	cfg.Config.Cmd = slices.Clone(cfg.Config.Cmd)
+	cfg.Config.Entrypoint = slices.Clone(cfg.Config.Entrypoint)
+	cfg.Config.Labels = maps.Clone(cfg.Config.Labels)
+	cfg.Config.ExposedPorts = maps.Clone(cfg.Config.ExposedPorts)
+	cfg.History = slices.Clone(cfg.History)
+	cfg.Rootfs.DiffIds = slices.Clone(cfg.Rootfs.DiffIds)
+
+	return cfg
+}
+
+// now returns the current time in the format of image configs.
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
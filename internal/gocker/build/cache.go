@@ -0,0 +1,207 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// RelativeCachePath is the relative path of the build cache under the user's home directory.
+const RelativeCachePath = ".local/share/gocker/buildcache/"
+
+// cacheEntry records the layer a build step produced.
+type cacheEntry struct {
+	Layer   registry.Descriptor `json:"layer"`
+	DiffID  string              `json:"diff_id"`
+	Created string              `json:"created"`
+}
+
+// chainKey derives the cache key of a build step from the key of the step before it.
+// Keys are chained through every instruction, so a change invalidates all later steps.
+func chainKey(parent string, parts ...string) string {
+	hash := sha256.New()
+
+	io.WriteString(hash, parent)
+	for _, part := range parts {
+		io.WriteString(hash, "\x00"+part)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cachePath returns the path of the cache entry with the given key.
+func cachePath(key string) string {
+	return filepath.Join(os.Getenv("HOME"), RelativeCachePath, key+".json")
+}
+
+// loadCache returns the cache entry with the given key. Entries whose layer is gone are misses.
+func loadCache(key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(cachePath(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil || !image.HasBlob(e.Layer.Digest) {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// saveCache records the layer produced by the build step with the given key.
+func saveCache(key string, e cacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath(key)), 0755); err != nil {
+		return fmt.Errorf("failed to create build cache: %v", err)
+	}
+
+	tmp := cachePath(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+
+	return os.Rename(tmp, cachePath(key))
+}
+
+// hashSources returns a hash of the names, modes and contents of the files matching the source
+// patterns. Modification times are left out, so touching a file doesn't invalidate the cache.
+func (b *Builder) hashSources(spec copySpec, srcs []string) (string, error) {
+	hash := sha256.New()
+
+	for _, src := range srcs {
+		matches, err := matchSources(spec.srcRoot, src)
+		if err != nil {
+			return "", err
+		}
+
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				fi, err := d.Info()
+				if err != nil {
+					return err
+				}
+
+				rel, err := filepath.Rel(spec.srcRoot, p)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "%s\x00%o\x00", rel, fi.Mode())
+
+				switch {
+				case fi.Mode()&os.ModeSymlink != 0:
+					link, err := os.Readlink(p)
+					if err != nil {
+						return err
+					}
+					io.WriteString(hash, link)
+
+				case fi.Mode().IsRegular():
+					f, err := os.Open(p)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+
+					if _, err := io.Copy(hash, f); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed to hash %s: %v", src, err)
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CachedLayers returns the number of build cache entries and the digests of the layers they refer to.
+func CachedLayers() (int, map[string]bool, error) {
+	entries, err := os.ReadDir(filepath.Join(os.Getenv("HOME"), RelativeCachePath))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, nil, fmt.Errorf("failed to read build cache: %v", err)
+	}
+
+	count, layers := 0, make(map[string]bool)
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		count++
+
+		if e, found := loadCache(key); found {
+			layers[e.Layer.Digest] = true
+		}
+	}
+
+	return count, layers, nil
+}
+
+// Prune removes the build cache along with the layers that aren't referenced elsewhere.
+// It returns the keys of the removed entries and the space reclaimed.
+func Prune(referenced map[string]bool) ([]string, int64, error) {
+	dir := filepath.Join(os.Getenv("HOME"), RelativeCachePath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read build cache: %v", err)
+	}
+
+	var (
+		keys      []string
+		reclaimed int64
+	)
+
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		e, found := loadCache(key)
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return keys, reclaimed, fmt.Errorf("failed to remove cache entry: %v", err)
+		}
+		keys = append(keys, key)
+
+		if !found || referenced[e.Layer.Digest] {
+			continue
+		}
+
+		// Several entries can share a layer, which is only removed once
+		if fi, err := os.Stat(image.BlobPath(e.Layer.Digest)); err == nil {
+			if err := os.Remove(image.BlobPath(e.Layer.Digest)); err != nil {
+				return keys, reclaimed, fmt.Errorf("failed to remove layer: %v", err)
+			}
+			reclaimed += fi.Size()
+		}
+	}
+
+	return keys, reclaimed, nil
+}
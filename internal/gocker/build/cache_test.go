@@ -0,0 +1,97 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// TestHashSources tests that source hashes follow contents but not modification times
+func TestHashSources(t *testing.T) {
+	ctx := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ctx, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(ctx, "src", "main.go")
+	if err := os.WriteFile(file, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Builder{}
+	spec := copySpec{srcRoot: ctx}
+
+	hash := func() string {
+		t.Helper()
+
+		h, err := b.hashSources(spec, []string{"src"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return h
+	}
+
+	original := hash()
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if hash() != original {
+		t.Error("Expected the hash to ignore modification times")
+	}
+
+	if err := os.WriteFile(file, []byte("package other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash() == original {
+		t.Error("Expected the hash to change with the contents")
+	}
+
+	if _, err := b.hashSources(spec, []string{"missing"}); err == nil {
+		t.Error("Expected error for a missing source")
+	}
+}
+
+// TestCacheEntries tests that entries are only found while their layer is in the blob store
+func TestCacheEntries(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	key := chainKey(chainKey("", "scratch"), "RUN true")
+
+	if err := saveCache(key, cacheEntry{Layer: registry.Descriptor{Digest: digest}}); err != nil {
+		t.Fatalf("Failed to save cache entry: %v", err)
+	}
+
+	if _, ok := loadCache(key); ok {
+		t.Error("Expected a miss for an entry without its layer")
+	}
+
+	blobs := filepath.Join(home, ".local/share/gocker/blobs/sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobs, digest[len("sha256:"):]), []byte("layer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, ok := loadCache(key); !ok || e.Layer.Digest != digest {
+		t.Errorf("Expected a hit for %s, got %+v", digest, e)
+	}
+
+	keys, reclaimed, err := Prune(map[string]bool{})
+	if err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if len(keys) != 1 || reclaimed != int64(len("layer")) {
+		t.Errorf("Expected one entry and its layer to be pruned, got %v and %d bytes", keys, reclaimed)
+	}
+
+	if _, ok := loadCache(key); ok {
+		t.Error("Expected a miss after pruning")
+	}
+}
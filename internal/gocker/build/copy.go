@@ -0,0 +1,428 @@
+package build
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	gockerhttp "github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// permissionBits are the mode bits copied along with files.
+const permissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// copySpec describes the files a COPY or ADD instruction adds to the root filesystem.
+type copySpec struct {
+	// srcRoot is the directory sources are relative to
+	srcRoot string
+	// dest is the destination path in the container
+	dest string
+	// destIsDir reports whether sources are copied into the destination rather than onto it
+	destIsDir bool
+	uid, gid  int
+	// add enables the URL and archive handling of ADD
+	add bool
+}
+
+// copy adds files from the build context, an earlier stage or an image to the root filesystem.
+// ADD also downloads URLs and unpacks local tar archives.
+func (b *Builder) copy(s *stage, inst Instruction) error {
+	words, ok := execForm(inst.Args)
+	if !ok {
+		var err error
+		if words, err = splitWords(inst.Args, s.lookup); err != nil {
+			return err
+		}
+	}
+	if len(words) < 2 {
+		return fmt.Errorf("%s requires at least two arguments", inst.Command)
+	}
+	srcs, dest := words[:len(words)-1], words[len(words)-1]
+
+	spec := copySpec{
+		srcRoot:   b.Context,
+		dest:      dest,
+		destIsDir: strings.HasSuffix(dest, "/") || len(srcs) > 1,
+		add:       inst.Command == "ADD",
+	}
+
+	if !path.IsAbs(spec.dest) {
+		spec.dest = path.Join(s.workingDir(), spec.dest)
+	}
+
+	if from, ok := inst.Flags["from"]; ok {
+		root, err := b.sourceRoot(from)
+		if err != nil {
+			return err
+		}
+		spec.srcRoot = root
+	}
+
+	if chown := inst.Flags["chown"]; chown != "" {
+		cred, _, err := container.LookupUser(s.rootfs, chown)
+		if err != nil {
+			return err
+		}
+		spec.uid, spec.gid = int(cred.Uid), int(cred.Gid)
+	}
+
+	// Remote files may change at any time, so steps downloading them are never cached
+	cached := !spec.add || !slices.ContainsFunc(srcs, isURL)
+	if cached {
+		hash, err := b.hashSources(spec, srcs)
+		if err != nil {
+			return err
+		}
+		s.cacheKey = chainKey(s.cacheKey, hash, spec.dest, fmt.Sprintf("%d:%d", spec.uid, spec.gid))
+	}
+
+	return b.commit(s, inst, cached, func() error {
+		for _, src := range srcs {
+			if err := b.copyPattern(s, spec, src); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sourceRoot returns the root filesystem COPY --from copies from, an earlier stage or an image.
+func (b *Builder) sourceRoot(from string) (string, error) {
+	if prev := b.stage(from); prev != nil {
+		return prev.rootfs, nil
+	}
+
+	if _, _, _, err := b.loadImage(from); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(image.Path(from), "rootfs"), nil
+}
+
+// copyPattern copies the files matching a single source pattern.
+func (b *Builder) copyPattern(s *stage, spec copySpec, src string) error {
+	if spec.add && isURL(src) {
+		return b.download(s, spec, src)
+	}
+
+	matches, err := matchSources(spec.srcRoot, src)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 1 {
+		spec.destIsDir = true
+	}
+
+	for _, match := range matches {
+		fi, err := os.Lstat(match)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %v", src, err)
+		}
+
+		// The contents of directories are copied, not the directories themselves
+		if fi.IsDir() {
+			if err := b.copyTree(s, spec, match, spec.dest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dest := spec.dest
+		if spec.destIsDir || b.isDir(s, dest) {
+			dest = path.Join(dest, filepath.Base(match))
+		}
+
+		if spec.add && fi.Mode().IsRegular() && isArchive(match) {
+			if err := b.unpack(s, match, spec.dest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := b.copyFile(s, spec, match, fi, dest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchSources returns the files under the source root that match a source pattern.
+func matchSources(root, src string) ([]string, error) {
+	pattern, err := image.ResolveInRoot(root, src)
+	if err != nil {
+		return nil, err
+	}
+
+	globbed, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source pattern %q: %v", src, err)
+	}
+
+	// Wildcards may match symlinked directories, which are only followed within the root
+	var matches []string
+	for _, match := range globbed {
+		rel, err := filepath.Rel(root, match)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved, err := image.ResolveInRoot(root, rel)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Lstat(resolved); err == nil && !slices.Contains(matches, resolved) {
+			matches = append(matches, resolved)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory", src)
+	}
+
+	return matches, nil
+}
+
+// copyTree copies the contents of a directory into a directory of the root filesystem.
+func (b *Builder) copyTree(s *stage, spec copySpec, srcDir, dest string) error {
+	return filepath.WalkDir(srcDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil {
+			return err
+		}
+
+		// An existing destination directory keeps its own owner and mode
+		if rel == "." && b.isDir(s, dest) {
+			return nil
+		}
+
+		return b.copyFile(s, spec, p, fi, path.Join(dest, filepath.ToSlash(rel)))
+	})
+}
+
+// copyFile copies a single file, directory or symlink to a path in the root filesystem,
+// giving it the owner of the copy spec while keeping its mode and modification time.
+func (b *Builder) copyFile(s *stage, spec copySpec, src string, fi fs.FileInfo, dest string) error {
+	target, err := image.ResolveInRoot(s.rootfs, dest)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory of %s: %v", dest, err)
+	}
+
+	// Existing files are replaced, existing directories merged with the copied ones
+	if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && fi.IsDir()) {
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %v", dest, err)
+		}
+	}
+
+	switch {
+	case fi.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dest, err)
+		}
+
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %v", src, err)
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %v", dest, err)
+		}
+
+	case fi.Mode().IsRegular():
+		if err := copyContents(src, target); err != nil {
+			return fmt.Errorf("failed to copy %s: %v", src, err)
+		}
+
+	default:
+		// Devices, pipes and sockets aren't part of build contexts
+		return nil
+	}
+
+	if err := b.idMappings.Lchown(target, spec.uid, spec.gid); err != nil {
+		return fmt.Errorf("failed to change owner of %s: %v", dest, err)
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	// Changing the owner clears the setuid and setgid bits, so the mode comes last
+	if err := os.Chmod(target, fi.Mode()&permissionBits); err != nil {
+		return fmt.Errorf("failed to change mode of %s: %v", dest, err)
+	}
+
+	return os.Chtimes(target, fi.ModTime(), fi.ModTime())
+}
+
+// copyContents copies the contents of a regular file.
+func copyContents(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// isURL reports whether the ADD source is a remote file.
+func isURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// isDir reports whether the path is an existing directory in the root filesystem.
+func (b *Builder) isDir(s *stage, p string) bool {
+	target, err := image.ResolveInRoot(s.rootfs, p)
+	if err != nil {
+		return false
+	}
+
+	fi, err := os.Stat(target)
+
+	return err == nil && fi.IsDir()
+}
+
+// isArchive reports whether the file is a tar archive, gzip compressed or not.
+func isArchive(p string) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return true
+	}
+
+	_, err = tar.NewReader(br).Next()
+
+	return err == nil
+}
+
+// unpack extracts a local tar archive into a directory of the root filesystem.
+func (b *Builder) unpack(s *stage, archive, dest string) error {
+	target, err := image.ResolveInRoot(s.rootfs, dest)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dest, err)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to unpack %s: %v", filepath.Base(archive), err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	// Archives are plain tarballs rather than layers, whiteout files included
+	opts := image.CopyOptions{Mappings: b.idMappings, Archive: true}
+	if err := image.ExtractCopy(r, s.rootfs, dest, nil, opts); err != nil {
+		return fmt.Errorf("failed to unpack %s: %v", filepath.Base(archive), err)
+	}
+
+	return nil
+}
+
+// download adds a remote file to the root filesystem. Downloaded files are only readable by their owner.
+func (b *Builder) download(s *stage, spec copySpec, src string) error {
+	u, err := url.Parse(src)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", src, err)
+	}
+
+	dest := spec.dest
+	if spec.destIsDir || b.isDir(s, dest) {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return fmt.Errorf("cannot determine a file name for %s", src)
+		}
+		dest = path.Join(dest, name)
+	}
+
+	resp, err := gockerhttp.NewHttpClient().SendRequest(gockerhttp.MethodGet, src, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", src, err)
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(b.dir, "download-")
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", src, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tmp.Name(), modified, modified)
+	}
+
+	fi, err := os.Stat(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	return b.copyFile(s, spec, tmp.Name(), fi, dest)
+}
@@ -0,0 +1,84 @@
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUnpack tests that ADD extracts archives as they are, whiteout files included
+func TestUnpack(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootfs, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "app", "config"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "files.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, data := range map[string]string{".wh.config": "", "src/main.go": "package main", "../escape": "x"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := &Builder{}
+	if err := b.unpack(&stage{rootfs: rootfs}, archive, "/app"); err != nil {
+		t.Fatalf("Failed to unpack: %v", err)
+	}
+
+	for _, name := range []string{"app/config", "app/.wh.config", "app/src/main.go", "app/escape"} {
+		if _, err := os.Stat(filepath.Join(rootfs, name)); err != nil {
+			t.Errorf("Expected %s to be unpacked: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(rootfs), "escape")); err == nil {
+		t.Errorf("Expected no file to be unpacked outside of the root filesystem")
+	}
+}
+
+// TestMatchSources tests that wildcards don't follow symlinked directories out of the build context
+func TestMatchSources(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ctx, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ctx, "data", "secret"), []byte("public"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(ctx, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := matchSources(ctx, "l*/secret")
+	if err == nil {
+		t.Errorf("Expected the link out of the context not to be followed, got %v", matches)
+	}
+
+	matches, err = matchSources(ctx, "*/secret")
+	if err != nil || len(matches) != 1 || matches[0] != filepath.Join(ctx, "data", "secret") {
+		t.Errorf("Expected only the file in the context to match, got %v (%v)", matches, err)
+	}
+}
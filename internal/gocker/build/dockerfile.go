@@ -0,0 +1,307 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Instruction is a single Dockerfile instruction.
+type Instruction struct {
+	// Command is the upper case instruction keyword
+	Command string
+	// Flags are the leading --name=value options, like --chown of COPY
+	Flags map[string]string
+	// Args is the rest of the instruction after the flags, with line continuations joined
+	Args string
+	// Original is the instruction as written, for the build output and image history
+	Original string
+	// Line is the line the instruction starts on
+	Line int
+}
+
+// Parse reads the instructions of a Dockerfile. Lines ending with a backslash continue on
+// the next line and lines starting with # are comments, even within continued lines.
+func Parse(r io.Reader) ([]Instruction, error) {
+	var (
+		instructions []Instruction
+		current      strings.Builder
+		start        int
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if current.Len() == 0 {
+			start = line
+		}
+
+		if continued, ok := strings.CutSuffix(text, "\\"); ok {
+			current.WriteString(continued)
+			current.WriteString(" ")
+			continue
+		}
+
+		current.WriteString(text)
+
+		inst, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		current.Reset()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Dockerfile: %v", err)
+	}
+
+	if current.Len() > 0 {
+		inst, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+	}
+
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("the Dockerfile has no instructions")
+	}
+
+	return instructions, nil
+}
+
+// parseInstruction splits an instruction into its keyword, flags and arguments.
+func parseInstruction(text string, line int) (Instruction, error) {
+	text = strings.TrimSpace(text)
+	command, args := cutSpace(text)
+
+	inst := Instruction{
+		Command:  strings.ToUpper(command),
+		Flags:    make(map[string]string),
+		Original: text,
+		Line:     line,
+	}
+
+	args = strings.TrimSpace(args)
+	for strings.HasPrefix(args, "--") {
+		flag, rest := cutSpace(args)
+
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		inst.Flags[name] = value
+
+		args = strings.TrimSpace(rest)
+	}
+	inst.Args = args
+
+	if inst.Args == "" {
+		return Instruction{}, fmt.Errorf("line %d: %s requires at least one argument", line, inst.Command)
+	}
+
+	return inst, nil
+}
+
+// cutSpace splits text around the first whitespace, trimming the whitespace off what follows it.
+func cutSpace(text string) (string, string) {
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+// execForm parses arguments in the JSON array form. It returns false for the shell form.
+func execForm(args string) ([]string, bool) {
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+
+	var list []string
+	if err := json.Unmarshal([]byte(args), &list); err != nil {
+		return nil, false
+	}
+
+	return list, true
+}
+
+// splitWords splits arguments into words the way a shell would, removing quotes
+// and replacing variable references with their values from the lookup function.
+// Variables can be written as $name, ${name}, ${name:-default} and ${name:+alternative}.
+// Single quotes and backslashes prevent expansion.
+func splitWords(s string, lookup func(string) (string, bool)) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				switch {
+				case s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`, s[i+1]) >= 0:
+					i++
+					word.WriteByte(s[i])
+				case s[i] == '$':
+					value, next, err := expandVariable(s, i, lookup)
+					if err != nil {
+						return nil, err
+					}
+					word.WriteString(value)
+					i = next - 1
+				default:
+					word.WriteByte(s[i])
+				}
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+
+		case c == '$':
+			value, next, err := expandVariable(s, i, lookup)
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(value)
+			i = next - 1
+
+		default:
+			word.WriteByte(c)
+		}
+
+		inWord = true
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// expandVariable expands the variable reference starting with the $ at index i.
+// It returns the value and the index following the reference.
+func expandVariable(s string, i int, lookup func(string) (string, bool)) (string, int, error) {
+	i++
+	if i >= len(s) {
+		return "$", i, nil
+	}
+
+	if s[i] != '{' {
+		end := i
+		for end < len(s) && isNameChar(s[end], end == i) {
+			end++
+		}
+		if end == i {
+			return "$", i, nil
+		}
+
+		value, _ := lookup(s[i:end])
+		return value, end, nil
+	}
+
+	end := strings.IndexByte(s[i:], '}')
+	if end < 0 {
+		return "", 0, fmt.Errorf("missing '}' in %q", s)
+	}
+	expr := s[i+1 : i+end]
+	next := i + end + 1
+
+	name, modifier, hasModifier := strings.Cut(expr, ":")
+	value, set := lookup(name)
+
+	if !hasModifier {
+		return value, next, nil
+	}
+
+	if len(modifier) == 0 || (modifier[0] != '-' && modifier[0] != '+') {
+		return "", 0, fmt.Errorf("unsupported modifier in ${%s}", expr)
+	}
+
+	word, err := splitWords(modifier[1:], lookup)
+	if err != nil {
+		return "", 0, err
+	}
+	alternative := strings.Join(word, " ")
+
+	switch {
+	case modifier[0] == '-' && (!set || value == ""):
+		return alternative, next, nil
+	case modifier[0] == '+' && set && value != "":
+		return alternative, next, nil
+	case modifier[0] == '+':
+		return "", next, nil
+	}
+
+	return value, next, nil
+}
+
+// isNameChar reports whether the character can be part of a variable name.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// keyValues parses arguments in the key=value format used by ENV, LABEL and ARG.
+// The legacy "key value" format of ENV is accepted when legacy is set.
+func keyValues(args string, lookup func(string) (string, bool), legacy bool) ([][2]string, error) {
+	words, err := splitWords(args, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy && len(words) > 0 && !strings.Contains(words[0], "=") {
+		if len(words) < 2 {
+			return nil, fmt.Errorf("%s requires a value", words[0])
+		}
+		return [][2]string{{words[0], strings.Join(words[1:], " ")}}, nil
+	}
+
+	var pairs [][2]string
+	for _, w := range words {
+		key, value, ok := strings.Cut(w, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be in the key=value format", w)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", w)
+		}
+
+		pairs = append(pairs, [2]string{key, value})
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,146 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParse tests splitting a Dockerfile into instructions
+func TestParse(t *testing.T) {
+	dockerfile := `# syntax comment
+from alpine AS base
+
+RUN echo one \
+    # comments inside continued lines are skipped
+    && echo two
+COPY --chown=app:app --from=base /src /dst
+RUN	echo	tab
+COPY	--from=base  /a /b
+`
+
+	instructions, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Instruction{
+		{Command: "FROM", Flags: map[string]string{}, Args: "alpine AS base", Original: "from alpine AS base", Line: 2},
+		{Command: "RUN", Flags: map[string]string{}, Args: "echo one  && echo two", Original: "RUN echo one  && echo two", Line: 4},
+		{Command: "COPY", Flags: map[string]string{"chown": "app:app", "from": "base"}, Args: "/src /dst", Original: "COPY --chown=app:app --from=base /src /dst", Line: 7},
+		{Command: "RUN", Flags: map[string]string{}, Args: "echo\ttab", Original: "RUN\techo\ttab", Line: 8},
+		{Command: "COPY", Flags: map[string]string{"from": "base"}, Args: "/a /b", Original: "COPY\t--from=base  /a /b", Line: 9},
+	}
+
+	if !reflect.DeepEqual(instructions, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, instructions)
+	}
+
+	if _, err := Parse(strings.NewReader("# only a comment\n")); err == nil {
+		t.Error("Expected error for a Dockerfile without instructions")
+	}
+
+	if _, err := Parse(strings.NewReader("FROM\n")); err == nil {
+		t.Error("Expected error for an instruction without arguments")
+	}
+}
+
+// TestSplitWords tests quote removal and variable expansion
+func TestSplitWords(t *testing.T) {
+	vars := map[string]string{"NAME": "world", "EMPTY": "", "DIR": "/app"}
+
+	tests := []struct {
+		input         string
+		expected      []string
+		expectedError bool
+	}{
+		{input: "a  b\tc", expected: []string{"a", "b", "c"}},
+		{input: `"hello $NAME" 'hello $NAME'`, expected: []string{"hello world", "hello $NAME"}},
+		{input: `\$NAME $NAME`, expected: []string{"$NAME", "world"}},
+		{input: "${DIR}/bin $DIR_x", expected: []string{"/app/bin", ""}},
+		{input: "${MISSING:-default} ${EMPTY:-default} ${NAME:-default}", expected: []string{"default", "default", "world"}},
+		{input: "${NAME:+set} x${MISSING:+set}", expected: []string{"set", "x"}},
+		{input: "cost $ 5", expected: []string{"cost", "$", "5"}},
+		{input: `"unterminated`, expectedError: true},
+		{input: "${NAME", expectedError: true},
+		{input: "${NAME:?error}", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			words, err := splitWords(tt.input, lookupIn(vars))
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %q", tt.input, words)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(words, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, words)
+			}
+		})
+	}
+}
+
+// TestKeyValues tests the key=value and legacy formats of ENV and LABEL
+func TestKeyValues(t *testing.T) {
+	vars := map[string]string{"V": "1"}
+
+	tests := []struct {
+		input         string
+		legacy        bool
+		expected      [][2]string
+		expectedError bool
+	}{
+		{input: `A=1 B="two words" C=$V`, expected: [][2]string{{"A", "1"}, {"B", "two words"}, {"C", "1"}}},
+		{input: "KEY some value", legacy: true, expected: [][2]string{{"KEY", "some value"}}},
+		{input: "KEY=value", legacy: true, expected: [][2]string{{"KEY", "value"}}},
+		{input: "KEY value", expectedError: true},
+		{input: "KEY", legacy: true, expectedError: true},
+		{input: "=value", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			pairs, err := keyValues(tt.input, lookupIn(vars), tt.legacy)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %q", tt.input, pairs)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(pairs, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, pairs)
+			}
+		})
+	}
+}
+
+// TestExecForm tests telling the JSON form of commands from the shell form
+func TestExecForm(t *testing.T) {
+	if argv, ok := execForm(`["echo", "hello world"]`); !ok || !reflect.DeepEqual(argv, []string{"echo", "hello world"}) {
+		t.Errorf("Expected the exec form, got %q", argv)
+	}
+
+	for _, args := range []string{"echo hello", "[not json", `[1, 2]`} {
+		if _, ok := execForm(args); ok {
+			t.Errorf("Expected the shell form for %q", args)
+		}
+	}
+
+	if cmd := command("echo hi"); !reflect.DeepEqual(cmd, []string{"/bin/sh", "-c", "echo hi"}) {
+		t.Errorf("Expected the shell form to run with /bin/sh, got %q", cmd)
+	}
+}
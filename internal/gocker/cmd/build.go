@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/build"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+var (
+	buildOpts build.Options
+	buildArgs []string
+)
+
+// Build is the Cobra command for building an image from a Dockerfile.
+var Build = &cobra.Command{
+	Use:   "build [flags] context",
+	Short: "Build an image from a Dockerfile",
+	Long:  "Build an image from the Dockerfile in the context directory and store it in local image storage",
+	Args:  cobra.ExactArgs(1),
+	Run:   buildImage,
+}
+
+func init() {
+	Build.Flags().StringVarP(&buildOpts.Tag, "tag", "t", "", "Name and optionally a tag in the name:tag format")
+	Build.Flags().StringVarP(&buildOpts.Dockerfile, "file", "f", "", "Name of the Dockerfile (default \"context/Dockerfile\")")
+	Build.Flags().StringArrayVar(&buildArgs, "build-arg", nil, "Set build-time variables")
+	Build.Flags().BoolVar(&buildOpts.NoCache, "no-cache", false, "Do not use cache when building the image")
+
+	Build.MarkFlagRequired("tag")
+}
+
+// buildImage is the command handler function that builds the image.
+func buildImage(c *cobra.Command, args []string) {
+	buildOpts.Context = args[0]
+
+	// Unprivileged users build images inside a user namespace to own files with subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	// Arguments without a value are taken from the host environment
+	buildOpts.BuildArgs = make(map[string]string)
+	for _, kv := range buildArgs {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			if value, ok = os.LookupEnv(name); !ok {
+				continue
+			}
+		}
+		buildOpts.BuildArgs[name] = value
+	}
+
+	b, err := build.NewBuilder(buildOpts, os.Stdout)
+	if err == nil {
+		_, err = b.Build()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while building %q image: %v\n", buildOpts.Tag, err)
+
+		os.Exit(1)
+	}
+}
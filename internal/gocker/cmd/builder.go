@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/build"
+	"github.com/z1z0v1c/gclone/internal/gocker/system"
+)
+
+var forcePrune bool
+
+// Builder is the Cobra command grouping the build cache commands.
+var Builder = &cobra.Command{
+	Use:   "builder command",
+	Short: "Manage builds",
+}
+
+// BuilderPrune is the Cobra command for removing the build cache.
+var BuilderPrune = &cobra.Command{
+	Use:   "prune [flags]",
+	Short: "Remove build cache",
+	Args:  cobra.NoArgs,
+	Run:   builderPrune,
+}
+
+func init() {
+	BuilderPrune.Flags().BoolVarP(&forcePrune, "force", "f", false, "Do not prompt for confirmation")
+
+	Builder.AddCommand(BuilderPrune)
+}
+
+// builderPrune is the command handler function that removes the build cache.
+func builderPrune(c *cobra.Command, args []string) {
+	if !forcePrune && !confirm("WARNING! This will remove all build cache.") {
+		return
+	}
+
+	referenced, err := system.ReferencedBlobs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while pruning build cache: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	keys, reclaimed, err := build.Prune(referenced)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while pruning build cache: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	if len(keys) > 0 {
+		fmt.Println("Deleted build cache objects:")
+		for _, key := range keys {
+			fmt.Println(key[:25])
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+}
+
+// confirm asks the user to confirm a destructive operation.
+func confirm(warning string) bool {
+	fmt.Printf("%s\nAre you sure you want to continue? [y/N] ", warning)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// humanSize formats a size in bytes with decimal units the way docker does.
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+
+	value, unit := float64(size), 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	return fmt.Sprintf("%.4g%s", value, units[unit])
+}
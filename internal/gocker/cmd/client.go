@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/z1z0v1c/gclone/internal/gocker/api"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// Host is the address of the daemon the commands talk to, set with --host or GOCKER_HOST.
+// The commands work on the local stores themselves when it's empty.
+var Host string
+
+// remoteRunFlags are the run flags the API supports.
+var remoteRunFlags = []string{"name", "detach", "rm", "env", "workdir", "user", "hostname", "network", "restart"}
+
+// newClient returns a client of the daemon at Host.
+func newClient() *api.Client {
+	client, err := api.NewClient(Host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	return client
+}
+
+// runRemote runs the container through the daemon. Containers that aren't detached
+// have their log followed until they stop, and their exit code is the exit code of run.
+func runRemote(c *cobra.Command, imgName string, argv []string) {
+	var unsupported []string
+	c.Flags().Visit(func(f *pflag.Flag) {
+		if !slices.Contains(remoteRunFlags, f.Name) && c.InheritedFlags().Lookup(f.Name) == nil {
+			unsupported = append(unsupported, "--"+f.Name)
+		}
+	})
+	if len(unsupported) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s not supported with --host\n", strings.Join(unsupported, ", "))
+
+		os.Exit(1)
+	}
+
+	client := newClient()
+
+	cfg := &api.ContainerConfig{
+		Image:      imgName,
+		Cmd:        argv,
+		Env:        runOpts.EnvVars,
+		WorkingDir: runOpts.Workdir,
+		User:       runOpts.User,
+		Hostname:   runOpts.Hostname,
+		HostConfig: api.HostConfig{
+			AutoRemove:    runOpts.Remove,
+			NetworkMode:   runOpts.Network,
+			RestartPolicy: runOpts.RestartPolicy,
+		},
+	}
+
+	// An image entrypoint would be prepended to the command otherwise
+	if len(argv) > 0 {
+		cfg.Entrypoint = []string{argv[0]}
+		cfg.Cmd = argv[1:]
+	}
+
+	id, err := client.CreateContainer(runOpts.Name, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error during container creation: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	if runOpts.Detach {
+		if err := client.StartContainer(id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while starting %q container: %v\n", id, err)
+
+			os.Exit(1)
+		}
+
+		fmt.Println(id)
+		return
+	}
+
+	// The log is opened first, since removed containers have none
+	logs, err := client.ContainerLogs(id, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading logs of %q container: %v\n", id, err)
+
+		os.Exit(1)
+	}
+	defer logs.Close()
+
+	copied := make(chan error, 1)
+	go func() { copied <- api.CopyLogs(os.Stdout, os.Stderr, logs) }()
+
+	if err := client.StartContainer(id); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while starting %q container: %v\n", id, err)
+
+		os.Exit(1)
+	}
+
+	code, err := client.WaitContainer(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while waiting for %q container: %v\n", id, err)
+
+		os.Exit(1)
+	}
+
+	if err := <-copied; err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading logs of %q container: %v\n", id, err)
+	}
+
+	os.Exit(code)
+}
+
+// pullRemote pulls the image through the daemon, which reports the elapsed time itself.
+func pullRemote(imgName string) {
+	if err := newClient().PullImage(imgName, os.Stdout); err != nil {
+		fmt.Printf("Error while pulling %q image: %v\n", imgName, err)
+
+		os.Exit(1)
+	}
+}
+
+// remoteImages returns the images of the daemon, one per name like the local image store has them.
+func remoteImages() ([]*image.Image, error) {
+	summaries, err := newClient().Images()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*image.Image
+	for _, sum := range summaries {
+		digest := ""
+		if len(sum.RepoDigests) > 0 {
+			_, digest, _ = strings.Cut(sum.RepoDigests[0], "@")
+		}
+
+		img := image.Image{ID: sum.ID, Digest: digest, Size: sum.Size}
+		if sum.Created > 0 {
+			img.Created = time.Unix(sum.Created, 0)
+		}
+
+		// Images without a name are stored under their ID
+		names := sum.RepoTags
+		if len(names) == 0 {
+			names = []string{sum.ID}
+		}
+
+		for _, name := range names {
+			named := img
+			named.Name = name
+			list = append(list, &named)
+		}
+	}
+
+	return list, nil
+}
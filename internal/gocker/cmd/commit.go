@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+var commitOptions container.CommitOptions
+
+// Commit is the Cobra command for creating an image from the changes of a container.
+var Commit = &cobra.Command{
+	Use:   "commit [flags] container repository[:tag]",
+	Short: "Create a new image from a container's changes",
+	Args:  cobra.ExactArgs(2),
+	Run:   commit,
+}
+
+func init() {
+	Commit.Flags().StringVarP(&commitOptions.Message, "message", "m", "", "Commit message")
+	Commit.Flags().StringVarP(&commitOptions.Author, "author", "a", "", `Author (e.g., "John Hannibal Smith <hannibal@a-team.com>")`)
+}
+
+// commit is the command handler function that commits the container.
+func commit(c *cobra.Command, args []string) {
+	// The changes are written with the IDs the container sees, which needs the namespace
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	s, err := container.FindState(args[0])
+	if err == nil {
+		var id string
+		if id, err = container.Commit(s, args[1], commitOptions); err == nil {
+			fmt.Println(id)
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Error while committing %q container: %v\n", args[0], err)
+
+	os.Exit(1)
+}
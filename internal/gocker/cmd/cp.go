@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var (
+	cpArchive    bool
+	cpFollowLink bool
+)
+
+// Cp is the Cobra command for copying files between a container and the local filesystem.
+var Cp = &cobra.Command{
+	Use:   "cp [flags] container:src_path dest_path|-",
+	Short: "Copy files/folders between a container and the local filesystem",
+	Long: "Copy files/folders between a container and the local filesystem, in either direction:\n\n" +
+		"  gocker cp [flags] container:src_path dest_path|-\n  gocker cp [flags] src_path|- container:dest_path\n\n" +
+		"Use - as the source to extract a tar archive read from STDIN into a directory of the container, " +
+		"or as the destination to write a tar archive of the container source to STDOUT",
+	Args: cobra.ExactArgs(2),
+	Run:  cp,
+}
+
+func init() {
+	Cp.Flags().BoolVarP(&cpArchive, "archive", "a", false, "Archive mode (copy all uid/gid information)")
+	Cp.Flags().BoolVarP(&cpFollowLink, "follow-link", "L", false, "Always follow symbol link in src_path")
+}
+
+// cp is the command handler function that copies the files between the container and the host.
+func cp(c *cobra.Command, args []string) {
+	// Container files are owned by subordinate IDs, which only map back to container IDs in a namespace
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	srcContainer, src := splitCopyArg(args[0])
+	destContainer, dest := splitCopyArg(args[1])
+
+	switch {
+	case srcContainer != "" && destContainer != "":
+		fmt.Fprintf(os.Stderr, "Error: copying between containers is not supported\n")
+
+		os.Exit(1)
+	case srcContainer == "" && destContainer == "":
+		fmt.Fprintf(os.Stderr, "Error: must specify at least one container source\n")
+
+		os.Exit(1)
+	}
+
+	ref := srcContainer + destContainer
+
+	s, err := container.FindState(ref)
+	if err == nil {
+		if srcContainer != "" {
+			err = copyFromContainer(s, src, dest)
+		} else {
+			err = copyToContainer(s, src, dest)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while copying files of %q container: %v\n", ref, err)
+
+		os.Exit(1)
+	}
+}
+
+// splitCopyArg splits a cp argument into the container and its path. Local paths containing
+// a colon need to start with / or ., like with docker.
+func splitCopyArg(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+
+	if name, p, ok := strings.Cut(arg, ":"); ok {
+		return name, p
+	}
+
+	return "", arg
+}
+
+// copyFromContainer copies a file or directory of the container to the local path, or writes it to STDOUT as a tar archive.
+func copyFromContainer(s *container.State, src, dest string) error {
+	root, err := s.Rootfs()
+	if err != nil {
+		return err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return err
+	}
+
+	source, err := image.StatSource(root, src, cpFollowLink)
+	if err != nil {
+		return err
+	}
+
+	if dest == "-" {
+		return source.WriteTar(os.Stdout, m)
+	}
+
+	// Copied files belong to the user running the command, unless the archive mode keeps their owners
+	opts := image.CopyOptions{}
+	if cpArchive {
+		opts.Mappings, opts.Archive = m, true
+	}
+
+	return streamCopy(source, m, localPath(dest), "/", opts)
+}
+
+// copyToContainer copies a local file or directory, or a tar archive read from STDIN, to the path in the container.
+func copyToContainer(s *container.State, src, dest string) error {
+	root, err := s.Rootfs()
+	if err != nil {
+		return err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return err
+	}
+
+	opts := image.CopyOptions{Mappings: m, Archive: cpArchive}
+
+	if src == "-" {
+		return image.ExtractCopy(os.Stdin, root, dest, nil, opts)
+	}
+
+	source, err := image.StatSource("/", localPath(src), cpFollowLink)
+	if err != nil {
+		return err
+	}
+
+	return streamCopy(source, nil, dest, root, opts)
+}
+
+// streamCopy streams the source as a tar archive to the destination path in the root filesystem.
+func streamCopy(source *image.CopySource, m *idmap.Mappings, dest, root string, opts image.CopyOptions) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(source.WriteTar(pw, m))
+	}()
+
+	err := image.ExtractCopy(pr, root, dest, source, opts)
+	pr.CloseWithError(err)
+
+	return err
+}
+
+// localPath makes a local path absolute, keeping the trailing / or /. that tell how it's copied.
+func localPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+
+	switch {
+	case strings.HasSuffix(p, "/.") || p == ".":
+		return strings.TrimSuffix(abs, "/") + "/."
+	case strings.HasSuffix(p, "/") && abs != "/":
+		return abs + "/"
+	}
+
+	return abs
+}
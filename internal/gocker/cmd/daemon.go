@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/api"
+)
+
+var daemonHost string
+
+// Daemon is the Cobra command serving the gocker API, the root command of gockerd.
+var Daemon = &cobra.Command{
+	Use:   "gockerd [flags]",
+	Short: "Serve the gocker API on a Unix socket",
+	Long: "Serve a subset of the Docker Engine API on a Unix socket, so the gocker CLI given --host " +
+		"and Docker clients can create, start and wait for containers, read their logs and pull images",
+	Args: cobra.NoArgs,
+	Run:  serveDaemon,
+}
+
+func init() {
+	Daemon.Flags().StringVarP(&daemonHost, "host", "H", api.DefaultHost(), "Unix socket to listen on")
+}
+
+// serveDaemon is the command handler function that serves the API until the daemon is stopped.
+func serveDaemon(c *cobra.Command, args []string) {
+	path, err := api.ParseHost(daemonHost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listening on %q: %v\n", path, err)
+
+		os.Exit(1)
+	}
+
+	// The socket is removed when the daemon is stopped, the containers keep running
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		listener.Close()
+	}()
+
+	fmt.Printf("Serving the gocker API on %s\n", path)
+
+	if err := http.Serve(listener, logRequests(api.NewServer())); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Fprintf(os.Stderr, "Error while serving on %q: %v\n", path, err)
+
+		os.Exit(1)
+	}
+}
+
+// listenUnix listens on the socket at the given path, replacing the socket a stopped daemon left behind.
+func listenUnix(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is listening on it")
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket dir: %v", err)
+	}
+
+	// Only the owner of the daemon may run containers through it, the socket
+	// is created with its permissions so it's never accessible to others
+	mask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+
+	return listener, err
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+// Diff is the Cobra command for listing the changes made to the filesystem of a container.
+var Diff = &cobra.Command{
+	Use:   "diff container",
+	Short: "Inspect changes to files or directories on a container's filesystem",
+	Long: "List the files added (A), changed (C) and deleted (D) in the filesystem of a container since " +
+		"its image was stored. Containers of the same image share its filesystem and their changes",
+	Args: cobra.ExactArgs(1),
+	Run:  diff,
+}
+
+// diff is the command handler function that prints the changes of the container filesystem.
+func diff(c *cobra.Command, args []string) {
+	// Owners are recorded the way the container sees them, which needs the namespace
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	s, err := container.FindState(args[0])
+	if err == nil {
+		changes, derr := container.Diff(s)
+		if derr == nil {
+			for _, change := range changes {
+				fmt.Printf("%s %s\n", change.Kind, change.Path)
+			}
+			return
+		}
+		err = derr
+	}
+
+	fmt.Fprintf(os.Stderr, "Error while diffing %q container: %v\n", args[0], err)
+
+	os.Exit(1)
+}
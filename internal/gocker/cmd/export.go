@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+var exportOutput string
+
+// Export is the Cobra command for writing the filesystem of a container into a tar archive.
+var Export = &cobra.Command{
+	Use:   "export [flags] container",
+	Short: "Export a container's filesystem as a tar archive",
+	Args:  cobra.ExactArgs(1),
+	Run:   export,
+}
+
+func init() {
+	Export.Flags().StringVarP(&exportOutput, "output", "o", "", "Write to a file, instead of STDOUT")
+}
+
+// export is the command handler function that streams the container filesystem.
+func export(c *cobra.Command, args []string) {
+	// Files are owned by subordinate IDs, which only map back to container IDs in a namespace
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	s, err := container.FindState(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while exporting %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if exportOutput != "" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while exporting %q container: %v\n", args[0], err)
+
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		w = f
+	} else if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "Error while exporting %q container: refusing to write an archive to a terminal, use the -o flag or redirect\n", args[0])
+
+		os.Exit(1)
+	}
+
+	if err := container.Export(s, w); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while exporting %q container: %v\n", args[0], err)
+		if exportOutput != "" {
+			os.Remove(exportOutput)
+		}
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var historyNoTrunc bool
+
+// History is the Cobra command for showing how an image was built.
+var History = &cobra.Command{
+	Use:   "history [flags] image",
+	Short: "Show the history of an image",
+	Args:  cobra.ExactArgs(1),
+	Run:   history,
+}
+
+func init() {
+	History.Flags().BoolVar(&historyNoTrunc, "no-trunc", false, "Don't truncate output")
+}
+
+// history is the command handler function that prints the image history, newest step first.
+// Sizes are those of the compressed layers the steps created.
+func history(c *cobra.Command, args []string) {
+	imgs, err := image.Find(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading history of %q image: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+	img := imgs[0]
+
+	var layerSizes []int64
+	if manifest, _, err := image.LoadManifest(img.Name); err == nil {
+		for _, layer := range manifest.Layers {
+			layerSizes = append(layerSizes, layer.Size)
+		}
+	}
+
+	type row struct{ created, createdBy, size, comment string }
+
+	var rows []row
+	layer := 0
+	for _, h := range img.Config.History {
+		r := row{created: "N/A", createdBy: h.CreatedBy, size: "0B", comment: h.Comment}
+
+		if created, err := time.Parse(time.RFC3339Nano, h.Created); err == nil {
+			r.created = humanDuration(time.Since(created)) + " ago"
+		}
+
+		if !h.EmptyLayer {
+			if layer < len(layerSizes) {
+				r.size = humanSize(layerSizes[layer])
+			}
+			layer++
+		}
+
+		if !historyNoTrunc {
+			r.createdBy = strings.ReplaceAll(r.createdBy, "\n", " ")
+			if len(r.createdBy) > 45 {
+				r.createdBy = r.createdBy[:44] + "…"
+			}
+		}
+
+		rows = append(rows, r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT")
+
+	// Only the last step produced the image itself, earlier ones have no image of their own
+	for i := len(rows) - 1; i >= 0; i-- {
+		id := "<missing>"
+		if i == len(rows)-1 {
+			id = image.ShortDigest(img.ID)
+			if historyNoTrunc {
+				id = img.ID
+			}
+		}
+
+		r := rows[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id, r.created, r.createdBy, r.size, r.comment)
+	}
+
+	w.Flush()
+}
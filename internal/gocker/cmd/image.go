@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// Image is the Cobra command grouping the image commands.
+var Image = &cobra.Command{
+	Use:   "image command",
+	Short: "Manage images",
+}
+
+// ImageInspect is the Cobra command for printing the configs of local images.
+var ImageInspect = &cobra.Command{
+	Use:   "inspect image [image...]",
+	Short: "Display the configuration of one or more images",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   imageInspect,
+}
+
+func init() {
+	Image.AddCommand(ImageInspect)
+}
+
+// imageInspect is the command handler function that prints the image configs as a JSON array.
+func imageInspect(c *cobra.Command, args []string) {
+	failed := false
+	configs := []*registry.ImageConfig{}
+
+	for _, ref := range args {
+		imgs, err := image.Find(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while inspecting %q image: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		configs = append(configs, imgs[0].Config)
+	}
+
+	data, err := json.MarshalIndent(configs, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while encoding image configs: %v\n", err)
+
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+
+	if failed {
+		os.Exit(1)
+	}
+}
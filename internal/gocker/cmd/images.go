@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var imagesQuiet bool
+
+// Images is the Cobra command for listing local images.
+var Images = &cobra.Command{
+	Use:   "images [flags] [repository[:tag]]",
+	Short: "List images",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   images,
+}
+
+func init() {
+	Images.Flags().BoolVarP(&imagesQuiet, "quiet", "q", false, "Only show image IDs")
+}
+
+// images is the command handler function that prints the image list.
+func images(c *cobra.Command, args []string) {
+	var (
+		list []*image.Image
+		err  error
+	)
+	if Host != "" {
+		list, err = remoteImages()
+	} else {
+		list, err = image.List()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listing images: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	if len(args) == 1 {
+		list = slices.DeleteFunc(list, func(img *image.Image) bool { return !matchesImage(img, args[0]) })
+	}
+
+	if imagesQuiet {
+		var ids []string
+		for _, img := range list {
+			if id := image.ShortDigest(img.ID); !slices.Contains(ids, id) {
+				ids = append(ids, id)
+				fmt.Println(id)
+			}
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tIMAGE ID\tCREATED\tSIZE")
+
+	for _, img := range list {
+		created := "N/A"
+		if !img.Created.IsZero() {
+			created = humanDuration(time.Since(img.Created)) + " ago"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", orNone(img.Repository()), orNone(img.Tag()), orNone(img.Digest),
+			image.ShortDigest(img.ID), created, humanSize(img.Size))
+	}
+
+	w.Flush()
+}
+
+// matchesImage reports whether the image is in the repository, and has the tag if the filter has one.
+func matchesImage(img *image.Image, filter string) bool {
+	repo, tag := filter, ""
+	if i := strings.LastIndex(filter, ":"); i > strings.LastIndex(filter, "/") {
+		repo, tag = filter[:i], filter[i+1:]
+	}
+
+	if ref, err := image.ParseReference(repo); err == nil {
+		repo = ref.Name()
+	}
+
+	return img.Repository() == repo && (tag == "" || img.Tag() == tag)
+}
+
+// orNone returns the value, or <none> for empty values like docker shows them.
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+
+	return s
+}
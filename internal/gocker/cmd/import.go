@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// Import is the Cobra command for creating an image from a tar archive of a filesystem.
+var Import = &cobra.Command{
+	Use:                   "import file|- repository[:tag]",
+	Short:                 "Import the contents from a tarball to create a filesystem image",
+	Long:                  "Create a single layer image from a tar archive of a root filesystem, like one written by gocker export. Use - to read the archive from STDIN",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run:                   importImage,
+}
+
+// importImage is the command handler function that imports the archive.
+func importImage(c *cobra.Command, args []string) {
+	source, name := args[0], args[1]
+
+	// The layer is extracted like pulled ones, owned by subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if source != "-" {
+		f, err := os.Open(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while importing %q: %v\n", source, err)
+
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	id, err := image.Import(r, name, source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while importing %q: %v\n", source, err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(id)
+}
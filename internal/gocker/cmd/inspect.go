@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+// Inspect is the Cobra command for printing the state of containers.
+var Inspect = &cobra.Command{
+	Use:   "inspect container [container...]",
+	Short: "Display detailed information on one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   inspect,
+}
+
+// inspect is the command handler function that prints the container states as a JSON array.
+func inspect(c *cobra.Command, args []string) {
+	failed := false
+	states := []*container.State{}
+
+	for _, ref := range args {
+		s, err := container.FindState(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while inspecting %q container: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		states = append(states, s)
+	}
+
+	data, err := json.MarshalIndent(states, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while encoding container states: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(string(data))
+
+	if failed {
+		os.Exit(1)
+	}
+}
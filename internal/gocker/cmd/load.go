@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var loadInput string
+
+// Load is the Cobra command for loading images from a tar archive.
+var Load = &cobra.Command{
+	Use:   "load [flags]",
+	Short: "Load images from a tar archive (read from STDIN by default)",
+	Long:  "Load the images of an OCI image layout or docker archive, as written by gocker save or docker save",
+	Args:  cobra.NoArgs,
+	Run:   load,
+}
+
+func init() {
+	Load.Flags().StringVarP(&loadInput, "input", "i", "", "Read from tar archive file, instead of STDIN")
+}
+
+// load is the command handler function that loads the images.
+func load(c *cobra.Command, args []string) {
+	// Loaded images are extracted like pulled ones, owned by subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if loadInput != "" {
+		f, err := os.Open(loadInput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while loading images from %q: %v\n", loadInput, err)
+
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	names, err := image.LoadArchive(r)
+	for _, name := range names {
+		if image.ValidDigest(name) {
+			fmt.Printf("Loaded image ID: %s\n", name)
+		} else {
+			fmt.Printf("Loaded image: %s\n", name)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading images: %v\n", err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+)
+
+var (
+	networkOpts    network.CreateOptions
+	connectAliases []string
+	connectIP      string
+)
+
+// Network is the Cobra command grouping the network management commands.
+var Network = &cobra.Command{
+	Use:   "network command",
+	Short: "Manage networks",
+}
+
+// NetworkCreate is the Cobra command for creating a bridge network.
+var NetworkCreate = &cobra.Command{
+	Use:   "create [flags] network",
+	Short: "Create a network",
+	Long: "Create a bridge network. Containers on it reach each other through the bridge and " +
+		"resolve each other by name and alias through the DNS server on its gateway",
+	Args: cobra.ExactArgs(1),
+	Run:  networkCreate,
+}
+
+// NetworkLs is the Cobra command for listing networks.
+var NetworkLs = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List networks",
+	Args:    cobra.NoArgs,
+	Run:     networkLs,
+}
+
+// NetworkRm is the Cobra command for removing networks.
+var NetworkRm = &cobra.Command{
+	Use:     "rm network [network...]",
+	Aliases: []string{"remove"},
+	Short:   "Remove one or more networks",
+	Args:    cobra.MinimumNArgs(1),
+	Run:     networkRm,
+}
+
+// NetworkInspect is the Cobra command for printing networks and their containers.
+var NetworkInspect = &cobra.Command{
+	Use:   "inspect network [network...]",
+	Short: "Display detailed information on one or more networks",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   networkInspect,
+}
+
+// NetworkConnect is the Cobra command for connecting a container to a network.
+var NetworkConnect = &cobra.Command{
+	Use:   "connect [flags] network container",
+	Short: "Connect a container to a network",
+	Args:  cobra.ExactArgs(2),
+	Run:   networkConnect,
+}
+
+// NetworkDisconnect is the Cobra command for disconnecting a container from a network.
+var NetworkDisconnect = &cobra.Command{
+	Use:   "disconnect network container",
+	Short: "Disconnect a container from a network",
+	Args:  cobra.ExactArgs(2),
+	Run:   networkDisconnect,
+}
+
+func init() {
+	NetworkCreate.Flags().StringVarP(&networkOpts.Driver, "driver", "d", network.DriverBridge, "Driver to manage the network")
+	NetworkCreate.Flags().StringVar(&networkOpts.Subnet, "subnet", "", "Subnet in CIDR format, a free private one by default")
+	NetworkCreate.Flags().StringVar(&networkOpts.Gateway, "gateway", "", "Gateway of the subnet, its first address by default")
+	NetworkCreate.Flags().BoolVar(&networkOpts.Internal, "internal", false, "Restrict external access to the network")
+
+	NetworkConnect.Flags().StringSliceVar(&connectAliases, "alias", nil, "Add network-scoped aliases for the container")
+	NetworkConnect.Flags().StringVar(&connectIP, "ip", "", "IPv4 address of the container on the network")
+
+	Network.AddCommand(NetworkCreate, NetworkLs, NetworkRm, NetworkInspect, NetworkConnect, NetworkDisconnect)
+}
+
+// networkCreate is the command handler function that creates the network and prints its ID.
+func networkCreate(c *cobra.Command, args []string) {
+	n, err := network.Create(args[0], networkOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while creating %q network: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(n.ID)
+}
+
+// networkLs is the command handler function that prints the network list.
+func networkLs(c *cobra.Command, args []string) {
+	networks, err := network.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listing networks: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NETWORK ID\tNAME\tDRIVER\tSCOPE")
+
+	for _, n := range networks {
+		fmt.Fprintf(w, "%s\t%s\t%s\tlocal\n", n.ShortID(), n.Name, n.Driver)
+	}
+
+	w.Flush()
+}
+
+// networkRm is the command handler function that removes the networks.
+func networkRm(c *cobra.Command, args []string) {
+	failed := false
+
+	for _, ref := range args {
+		if err := network.Remove(ref); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while removing %q network: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// networkDetails is the inspect output of a network, with its containers keyed by ID.
+type networkDetails struct {
+	*network.Network
+	Containers map[string]*network.Endpoint
+}
+
+// networkInspect is the command handler function that prints the networks as a JSON array.
+func networkInspect(c *cobra.Command, args []string) {
+	failed := false
+	details := []networkDetails{}
+
+	for _, ref := range args {
+		n, err := network.Find(ref)
+
+		var endpoints []*network.Endpoint
+		if err == nil {
+			endpoints, err = n.Endpoints()
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while inspecting %q network: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		d := networkDetails{Network: n, Containers: map[string]*network.Endpoint{}}
+		for _, ep := range endpoints {
+			d.Containers[ep.ContainerID] = ep
+		}
+
+		details = append(details, d)
+	}
+
+	data, err := json.MarshalIndent(details, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while encoding networks: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(string(data))
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// networkConnect is the command handler function that connects the container to the network.
+func networkConnect(c *cobra.Command, args []string) {
+	ref, name := args[0], args[1]
+
+	s, err := container.FindState(name)
+	if err == nil {
+		err = container.ConnectNetwork(s.ID, ref, connectAliases, connectIP)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while connecting %q container to %q network: %v\n", name, ref, err)
+
+		os.Exit(1)
+	}
+}
+
+// networkDisconnect is the command handler function that disconnects the container from the network.
+func networkDisconnect(c *cobra.Command, args []string) {
+	ref, name := args[0], args[1]
+
+	s, err := container.FindState(name)
+	if err == nil {
+		err = container.DisconnectNetwork(s.ID, ref)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while disconnecting %q container from %q network: %v\n", name, ref, err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+// Pause is the Cobra command for freezing the processes of containers.
+var Pause = &cobra.Command{
+	Use:                   "pause container [container...]",
+	Short:                 "Pause all processes within one or more containers",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	Run:                   pause,
+}
+
+// Unpause is the Cobra command for thawing the processes of paused containers.
+var Unpause = &cobra.Command{
+	Use:                   "unpause container [container...]",
+	Short:                 "Unpause all processes within one or more containers",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	Run:                   unpause,
+}
+
+// pause is the command handler function that pauses the containers.
+func pause(c *cobra.Command, args []string) {
+	forEachContainer(args, "pausing", container.Pause)
+}
+
+// unpause is the command handler function that unpauses the containers.
+func unpause(c *cobra.Command, args []string) {
+	forEachContainer(args, "unpausing", container.Unpause)
+}
+
+// forEachContainer applies the operation to the referenced containers, printing the
+// references of those it succeeded for and exiting with an error if any failed.
+func forEachContainer(refs []string, action string, op func(id string) error) {
+	failed := false
+
+	for _, ref := range refs {
+		s, err := container.FindState(ref)
+		if err == nil {
+			err = op(s.ID)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while %s %q container: %v\n", action, ref, err)
+			failed = true
+
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
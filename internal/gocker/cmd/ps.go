@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+var all bool
+
+// Ps is the Cobra command for listing containers.
+var Ps = &cobra.Command{
+	Use:   "ps [flags]",
+	Short: "List containers",
+	Args:  cobra.NoArgs,
+	Run:   ps,
+}
+
+func init() {
+	Ps.Flags().BoolVarP(&all, "all", "a", false, "Show all containers (default shows just running)")
+}
+
+// ps is the command handler function that prints the container list.
+func ps(c *cobra.Command, args []string) {
+	states, err := container.ListStates()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listing containers: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tNAMES")
+
+	for _, s := range states {
+		if !all && !s.IsRunning() {
+			continue
+		}
+
+		command := strings.Join(s.Command, " ")
+		if len(command) > 20 {
+			command = command[:19] + "…"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%q\t%s ago\t%s\t%s\n",
+			s.ShortID(), s.Image, command, humanDuration(time.Since(s.Created)), status(s), s.Name)
+	}
+
+	w.Flush()
+}
+
+// status describes the container status the way docker ps does.
+func status(s *container.State) string {
+	switch {
+	case s.IsRunning() && s.Status == container.StatusRestarting:
+		return fmt.Sprintf("Restarting (%d) %s ago", s.ExitCode, humanDuration(time.Since(s.FinishedAt)))
+	case s.IsRunning() && s.Paused:
+		return fmt.Sprintf("Up %s (Paused)", humanDuration(time.Since(s.StartedAt)))
+	case s.IsRunning() && s.Health != nil:
+		health := string(s.Health.Status)
+		if s.Health.Status == container.HealthStarting {
+			health = "health: " + health
+		}
+		return fmt.Sprintf("Up %s (%s)", humanDuration(time.Since(s.StartedAt)), health)
+	case s.IsRunning():
+		return "Up " + humanDuration(time.Since(s.StartedAt))
+	case s.Status == container.StatusCreated:
+		return "Created"
+	case s.Status != container.StatusExited:
+		// The supervisor died without recording the exit
+		return "Dead"
+	}
+
+	return fmt.Sprintf("Exited (%d) %s ago", s.ExitCode, humanDuration(time.Since(s.FinishedAt)))
+}
+
+// humanDuration formats a duration in a rounded, human-readable form.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return "Less than a second"
+	case d < time.Minute:
+		return plural(int(d.Seconds()), "second")
+	case d < time.Hour:
+		return plural(int(d.Minutes()), "minute")
+	case d < 48*time.Hour:
+		return plural(int(d.Hours()), "hour")
+	}
+
+	return plural(int(d.Hours()/24), "day")
+}
+
+// plural formats a count followed by the unit in singular or plural form.
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+
+	return fmt.Sprintf("%d %ss", n, unit)
+}
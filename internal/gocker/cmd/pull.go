@@ -6,13 +6,19 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
 	"github.com/z1z0v1c/gclone/internal/gocker/image"
 	"github.com/z1z0v1c/gclone/pkg/http"
 )
 
+var (
+	maxConcurrentDownloads int
+	signaturePolicy        string
+)
+
 // Pull is the Cobra command for pulling a container image from Docker Hub.
 var Pull = &cobra.Command{
-	Use:                   "pull image",
+	Use:                   "pull [flags] image",
 	Short:                 "Pull an image from Docker Hub",
 	Long:                  "Pull an image from Docker Hub and extract it into local image storage",
 	DisableFlagsInUseLine: true,
@@ -20,13 +26,47 @@ var Pull = &cobra.Command{
 	Run:                   pull,
 }
 
+func init() {
+	Pull.Flags().IntVar(&maxConcurrentDownloads, "max-concurrent-downloads", image.DefaultMaxConcurrentDownloads,
+		"Maximum number of layers to download at once")
+	Pull.Flags().StringVar(&signaturePolicy, "signature-policy", "",
+		"Path to the signature verification policy, instead of $HOME/"+image.RelativePolicyPath)
+}
+
 // pull is the command handler function that pulls the image.
 func pull(c *cobra.Command, args []string) {
 	start := time.Now()
 	imgName := args[0]
+
+	if Host != "" {
+		pullRemote(imgName)
+		return
+	}
+
+	// Unprivileged users extract images inside a user namespace to own files with subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Printf("Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
 	httpClient := http.NewHttpClient()
 
-	img := image.NewClient(imgName, httpClient)
+	img, err := image.NewClient(imgName, httpClient)
+	if err != nil {
+		fmt.Printf("Error while pulling %q image: %v\n", imgName, err)
+
+		os.Exit(1)
+	}
+
+	img.SetMaxConcurrentDownloads(maxConcurrentDownloads)
+
+	if signaturePolicy != "" {
+		if err := img.SetSignaturePolicy(signaturePolicy); err != nil {
+			fmt.Printf("Error while pulling %q image: %v\n", imgName, err)
+
+			os.Exit(1)
+		}
+	}
 
 	if err := img.Pull(); err != nil {
 		fmt.Printf("Error while pulling %q image: %v\n", imgName, err)
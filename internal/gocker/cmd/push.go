@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// Push is the Cobra command for pushing a local image to a registry.
+var Push = &cobra.Command{
+	Use:                   "push image",
+	Short:                 "Push an image to a registry",
+	Long:                  "Upload the layers, config and manifest of a local image to the registry of its name",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run:                   push,
+}
+
+// push is the command handler function that pushes the image.
+func push(c *cobra.Command, args []string) {
+	imgName := args[0]
+
+	img, err := image.NewClient(imgName, http.NewHttpClient())
+	if err == nil {
+		err = img.Push()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while pushing %q image: %v\n", imgName, err)
+
+		os.Exit(1)
+	}
+}
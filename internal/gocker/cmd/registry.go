@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry/server"
+)
+
+var (
+	registryAddr string
+	registryRoot string
+)
+
+// Registry is the Cobra command grouping the local registry commands.
+var Registry = &cobra.Command{
+	Use:   "registry command",
+	Short: "Manage the local registry",
+}
+
+// RegistryServe is the Cobra command for serving a registry from a local directory.
+var RegistryServe = &cobra.Command{
+	Use:   "serve [flags]",
+	Short: "Serve an OCI distribution registry",
+	Long: "Serve the OCI distribution API from a local directory. By default the registry shares " +
+		"gocker's blob store, so layers of local images aren't stored twice",
+	Args: cobra.NoArgs,
+	Run:  registryServe,
+}
+
+func init() {
+	RegistryServe.Flags().StringVar(&registryAddr, "addr", ":5000", "Address to listen on")
+	RegistryServe.Flags().StringVar(&registryRoot, "root", "", "Directory to store the registry data in (default gocker's data directory)")
+
+	Registry.AddCommand(RegistryServe)
+}
+
+// registryServe is the command handler function that serves the registry until it fails.
+func registryServe(c *cobra.Command, args []string) {
+	root := registryRoot
+	if root == "" {
+		root = server.DefaultRoot()
+	}
+
+	reg, err := server.New(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while starting registry: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Printf("Serving registry from %s on %s\n", root, registryAddr)
+
+	if err := http.ListenAndServe(registryAddr, logRequests(reg)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while serving registry on %q: %v\n", registryAddr, err)
+
+		os.Exit(1)
+	}
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped writer, so streamed responses can still be flushed.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests prints a line for every request the handler serves.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		h.ServeHTTP(rec, req)
+
+		fmt.Printf("%s %s %s %d %s\n", start.Format(time.RFC3339), req.Method, req.URL.RequestURI(),
+			rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
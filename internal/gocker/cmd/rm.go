@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+var force bool
+
+// Rm is the Cobra command for removing containers.
+var Rm = &cobra.Command{
+	Use:   "rm [flags] container [container...]",
+	Short: "Remove one or more containers",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   rm,
+}
+
+func init() {
+	Rm.Flags().BoolVarP(&force, "force", "f", false, "Force the removal of a running container")
+}
+
+// rm is the command handler function that removes the containers.
+func rm(c *cobra.Command, args []string) {
+	failed := false
+
+	for _, ref := range args {
+		s, err := container.FindState(ref)
+		if err == nil {
+			err = container.Remove(s.ID, force)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while removing %q container: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var rmiForce bool
+
+// Rmi is the Cobra command for removing local images.
+var Rmi = &cobra.Command{
+	Use:   "rmi [flags] image [image...]",
+	Short: "Remove one or more images",
+	Long: "Remove images by name or ID. Images used by containers are only removed with --force, " +
+		"and never while the container is running",
+	Args: cobra.MinimumNArgs(1),
+	Run:  rmi,
+}
+
+func init() {
+	Rmi.Flags().BoolVarP(&rmiForce, "force", "f", false, "Force the removal of images used by stopped containers or with several names")
+}
+
+// rmi is the command handler function that removes the images.
+func rmi(c *cobra.Command, args []string) {
+	// Root filesystems of pulled images are owned by subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	failed := false
+
+	for _, ref := range args {
+		if err := removeImage(ref); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while removing %q image: %v\n", ref, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// removeImage removes the names a reference refers to and reports the
+// image as deleted once no name refers to its ID anymore.
+func removeImage(ref string) error {
+	imgs, err := image.Find(ref)
+	if err != nil {
+		return err
+	}
+
+	if len(imgs) > 1 && !rmiForce {
+		return fmt.Errorf("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories",
+			image.ShortDigest(imgs[0].ID))
+	}
+
+	for _, img := range imgs {
+		users, err := container.ImageUsers(img.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range users {
+			if s.IsRunning() {
+				return fmt.Errorf("conflict: unable to remove %s (cannot be forced) - image is being used by running container %s",
+					img.Name, s.ShortID())
+			}
+			if !rmiForce {
+				return fmt.Errorf("conflict: unable to remove %s (must force) - container %s is using its referenced image",
+					img.Name, s.ShortID())
+			}
+		}
+
+		if err := image.Remove(img.Name); err != nil {
+			return err
+		}
+
+		switch {
+		case img.Dangling():
+		case img.Tag() != "":
+			fmt.Printf("Untagged: %s:%s\n", img.Repository(), img.Tag())
+		default:
+			fmt.Printf("Untagged: %s\n", img.Name)
+		}
+	}
+
+	if remaining, err := image.Find(imgs[0].ID); err != nil || len(remaining) == 0 {
+		fmt.Printf("Deleted: %s\n", imgs[0].ID)
+	}
+
+	return nil
+}
@@ -3,26 +3,120 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/z1z0v1c/gclone/internal/gocker/container"
 )
 
+var (
+	runOpts      container.Options
+	restart      string
+	securityOpts []string
+	ipc          string
+	pid          string
+	uts          string
+	shmSize      string
+)
+
 // Run is the Cobra command to launch a container from a previously pulled image.
 var Run = &cobra.Command{
-	Use:                "run image command [flags]",
-	Short:              "Run a container from a downloaded image",
-	DisableFlagParsing: true,
-	Args:               cobra.MinimumNArgs(1),
-	Run:                run,
+	Use:   "run [flags] image command [args...]",
+	Short: "Run a container from a downloaded image",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   run,
+}
+
+func init() {
+	// Everything after the image name belongs to the container command
+	Run.Flags().SetInterspersed(false)
+
+	Run.Flags().StringVar(&runOpts.Name, "name", "", "Assign a name to the container")
+	Run.Flags().BoolVarP(&runOpts.Detach, "detach", "d", false, "Run container in background and print container ID")
+	Run.Flags().BoolVar(&runOpts.Remove, "rm", false, "Automatically remove the container when it exits")
+	Run.Flags().StringArrayVarP(&runOpts.EnvVars, "env", "e", nil, "Set environment variables")
+	Run.Flags().StringVarP(&runOpts.Workdir, "workdir", "w", "", "Working directory inside the container")
+	Run.Flags().StringVarP(&runOpts.User, "user", "u", "", "Username or UID (format: <name|uid>[:<group|gid>])")
+	Run.Flags().BoolVar(&runOpts.Rootfs, "rootfs", false, "The image argument is the path of a root filesystem directory")
+	Run.Flags().StringVar(&restart, "restart", "no", "Restart policy (no, on-failure[:max-retries], always, unless-stopped)")
+	Run.Flags().StringArrayVar(&securityOpts, "security-opt", nil, "Security options (seccomp=profile.json|unconfined, no-new-privileges)")
+	Run.Flags().StringSliceVar(&runOpts.CapAdd, "cap-add", nil, "Add Linux capabilities")
+	Run.Flags().StringSliceVar(&runOpts.CapDrop, "cap-drop", nil, "Drop Linux capabilities")
+	Run.Flags().BoolVar(&runOpts.ReadOnly, "read-only", false, "Mount the container's root filesystem as read only")
+	Run.Flags().StringVar(&runOpts.UserNS, "userns", "", "User namespace to use (host, or a private one by default)")
+	Run.Flags().StringArrayVar(&runOpts.UIDMaps, "uidmap", nil, "UID mapping in the container_id:host_id:size format")
+	Run.Flags().StringArrayVar(&runOpts.GIDMaps, "gidmap", nil, "GID mapping in the container_id:host_id:size format")
+	Run.Flags().StringVar(&ipc, "ipc", "", "IPC namespace to use (host, container:<name|id>, or a private one by default)")
+	Run.Flags().StringVar(&pid, "pid", "", "PID namespace to use (host, container:<name|id>, or a private one by default)")
+	Run.Flags().StringVar(&uts, "uts", "", "UTS namespace to use (host, container:<name|id>, or a private one by default)")
+	Run.Flags().StringVar(&runOpts.Hostname, "hostname", "", "Container host name")
+	Run.Flags().StringArrayVar(&runOpts.ExtraHosts, "add-host", nil, "Add a custom host-to-IP mapping (name:ip, ip may be host-gateway)")
+	Run.Flags().StringSliceVar(&runOpts.DNS, "dns", nil, "Set custom DNS servers")
+	Run.Flags().StringSliceVar(&runOpts.DNSSearch, "dns-search", nil, "Set custom DNS search domains")
+	Run.Flags().StringVar(&runOpts.Network, "network", "", "Network to connect the container to (host, none, container:<name|id>, or a network name; host by default)")
+	Run.Flags().StringSliceVar(&runOpts.NetworkAliases, "network-alias", nil, "Add network-scoped aliases for the container")
+	Run.Flags().StringVar(&runOpts.IP, "ip", "", "IPv4 address of the container on its network")
+	Run.Flags().StringArrayVar(&runOpts.Ulimits, "ulimit", nil, "Ulimit options (name=soft[:hard], e.g. nofile=65536:65536)")
+	Run.Flags().StringArrayVar(&runOpts.Sysctls, "sysctl", nil, "Namespaced kernel parameters (e.g. net.core.somaxconn=1024)")
+	Run.Flags().StringArrayVar(&runOpts.Devices, "device", nil, "Add a host device to the container (host_path[:container_path][:permissions])")
+	Run.Flags().StringVar(&shmSize, "shm-size", "", "Size of /dev/shm (default 64MB)")
+	Run.Flags().StringVar(&runOpts.HealthCmd, "health-cmd", "", "Command to run to check health")
+	Run.Flags().DurationVar(&runOpts.HealthInterval, "health-interval", 0, "Time between running the check (default 30s)")
+	Run.Flags().DurationVar(&runOpts.HealthTimeout, "health-timeout", 0, "Maximum time to allow one check to run (default 30s)")
+	Run.Flags().DurationVar(&runOpts.HealthStartPeriod, "health-start-period", 0, "Start period for the container to initialize before failures count towards unhealthy")
+	Run.Flags().IntVar(&runOpts.HealthRetries, "health-retries", 0, "Consecutive failures needed to report unhealthy (default 3)")
 }
 
 // run is the command handler function that creates and runs the container.
 func run(c *cobra.Command, args []string) {
 	imgName, cmd, args := args[0], args[1], args[2:]
 
-	cn, err := container.NewContainer(imgName, cmd, args)
+	policy, err := container.ParseRestartPolicy(restart)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		os.Exit(1)
+	}
+	runOpts.RestartPolicy = policy
+
+	// Variables without a value are taken from the host environment
+	var env []string
+	for _, kv := range runOpts.EnvVars {
+		if !strings.Contains(kv, "=") {
+			value, ok := os.LookupEnv(kv)
+			if !ok {
+				continue
+			}
+			kv += "=" + value
+		}
+		env = append(env, kv)
+	}
+	runOpts.EnvVars = env
+
+	if Host != "" {
+		runRemote(c, imgName, append([]string{cmd}, args...))
+		return
+	}
+
+	if shmSize != "" {
+		if runOpts.ShmSize, err = container.ParseMemory(shmSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --shm-size: %v\n", err)
+
+			os.Exit(1)
+		}
+	}
+
+	runOpts.IPC = container.NamespaceMode(ipc)
+	runOpts.PID = container.NamespaceMode(pid)
+	runOpts.UTS = container.NamespaceMode(uts)
+
+	if err := runOpts.SetSecurityOpts(securityOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	cn, err := container.NewContainer(imgName, cmd, args, runOpts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error during container creation: %v\n", err)
 
@@ -30,12 +124,12 @@ func run(c *cobra.Command, args []string) {
 	}
 
 	if err := cn.Run(); err != nil {
-		// Handle exit error for proper exit code propagation
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
+		// Handle exit error for proper exit code propagation, deaths by signal included
+		if code, exited := container.ExitCode(err); exited {
+			os.Exit(code)
 		} else {
 			fmt.Fprintf(os.Stderr, "Error during container excecution: %v\n", err)
-			
+
 			os.Exit(1)
 		}
 	}
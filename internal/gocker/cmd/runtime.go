@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+var (
+	bundle        string
+	runtimeDetach bool
+	deleteForce   bool
+)
+
+// Runtime is the Cobra command grouping the OCI runtime commands, which run the containers
+// of OCI bundles by their IDs like runc does.
+var Runtime = &cobra.Command{
+	Use:   "runtime command",
+	Short: "Run OCI bundles",
+}
+
+// RuntimeRun is the Cobra command for creating and starting a container from a bundle.
+var RuntimeRun = &cobra.Command{
+	Use:   "run [flags] container-id",
+	Short: "Create and run a container from a bundle",
+	Args:  cobra.ExactArgs(1),
+	Run:   runtimeRun,
+}
+
+// RuntimeCreate is the Cobra command for creating a container from a bundle without starting it.
+var RuntimeCreate = &cobra.Command{
+	Use:   "create [flags] container-id",
+	Short: "Create a container from a bundle",
+	Long: "Create a container from a bundle, its process waits for gocker runtime start in the " +
+		"namespaces of the container. The output of the container goes to its log",
+	Args: cobra.ExactArgs(1),
+	Run:  runtimeCreate,
+}
+
+// RuntimeStart is the Cobra command for starting the process of a created container.
+var RuntimeStart = &cobra.Command{
+	Use:   "start container-id",
+	Short: "Run the process of a created container",
+	Args:  cobra.ExactArgs(1),
+	Run:   runtimeStart,
+}
+
+// RuntimeState is the Cobra command for printing the OCI state of a container.
+var RuntimeState = &cobra.Command{
+	Use:   "state container-id",
+	Short: "Output the state of a container",
+	Args:  cobra.ExactArgs(1),
+	Run:   runtimeState,
+}
+
+// RuntimeKill is the Cobra command for sending a signal to the process of a container.
+var RuntimeKill = &cobra.Command{
+	Use:   "kill container-id [signal]",
+	Short: "Send a signal to the process of a container (SIGTERM by default)",
+	Args:  cobra.RangeArgs(1, 2),
+	Run:   runtimeKill,
+}
+
+// RuntimeDelete is the Cobra command for deleting a container.
+var RuntimeDelete = &cobra.Command{
+	Use:   "delete [flags] container-id",
+	Short: "Delete a stopped container",
+	Args:  cobra.ExactArgs(1),
+	Run:   runtimeDelete,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{RuntimeRun, RuntimeCreate} {
+		c.Flags().StringVarP(&bundle, "bundle", "b", ".", "Path to the bundle directory")
+	}
+	RuntimeRun.Flags().BoolVarP(&runtimeDetach, "detach", "d", false, "Run the container in background")
+	RuntimeDelete.Flags().BoolVarP(&deleteForce, "force", "f", false, "Kill the container if it isn't stopped")
+
+	Runtime.AddCommand(RuntimeRun, RuntimeCreate, RuntimeStart, RuntimeState, RuntimeKill, RuntimeDelete)
+}
+
+// runtimeRun is the command handler function that runs the container of a bundle.
+func runtimeRun(c *cobra.Command, args []string) {
+	cn := newBundleContainer("run", args[0], container.Options{Detach: runtimeDetach})
+
+	if err := cn.Run(); err != nil {
+		if code, exited := container.ExitCode(err); exited {
+			os.Exit(code)
+		}
+
+		fmt.Fprintf(os.Stderr, "Error while running %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
+
+// runtimeCreate is the command handler function that creates the container of a bundle.
+func runtimeCreate(c *cobra.Command, args []string) {
+	cn := newBundleContainer("create", args[0], container.Options{Detach: true, DeferStart: true})
+
+	if err := cn.Run(); err != nil {
+		if code, exited := container.ExitCode(err); exited {
+			os.Exit(code)
+		}
+
+		fmt.Fprintf(os.Stderr, "Error while creating %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
+
+// newBundleContainer creates the container of the bundle given with --bundle. The supervisor
+// and child processes run the same command with the absolute path of the bundle.
+func newBundleContainer(command, id string, opts container.Options) *container.Container {
+	dir, err := filepath.Abs(bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to resolve bundle path: %v\n", err)
+
+		os.Exit(1)
+	}
+	opts.ExecArgs = []string{"runtime", command, id, "--bundle", dir}
+
+	cn, err := container.NewBundleContainer(id, dir, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error during container creation: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	return cn
+}
+
+// runtimeStart is the command handler function that starts a created container.
+func runtimeStart(c *cobra.Command, args []string) {
+	s, err := container.FindBundle(args[0])
+	if err == nil {
+		err = container.Start(s.ID)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while starting %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
+
+// runtimeState is the command handler function that prints the OCI state of a container.
+func runtimeState(c *cobra.Command, args []string) {
+	s, err := container.FindBundle(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while inspecting %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+
+	data, err := json.MarshalIndent(s.OCIState(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while encoding container state: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	fmt.Println(string(data))
+}
+
+// runtimeKill is the command handler function that signals the process of a container.
+func runtimeKill(c *cobra.Command, args []string) {
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	sig, err := container.ParseSignal(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	s, err := container.FindBundle(args[0])
+	if err == nil {
+		err = container.Kill(s.ID, sig)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while killing %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
+
+// runtimeDelete is the command handler function that deletes a container.
+func runtimeDelete(c *cobra.Command, args []string) {
+	s, err := container.FindBundle(args[0])
+	if err == nil {
+		err = container.Delete(s.ID, deleteForce)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while deleting %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+var saveOutput string
+
+// Save is the Cobra command for writing images into a tar archive.
+var Save = &cobra.Command{
+	Use:   "save [flags] image [image...]",
+	Short: "Save one or more images to a tar archive (streamed to STDOUT by default)",
+	Long: "Save images into a tar archive that is both an OCI image layout and a docker archive, " +
+		"so it can be loaded by gocker, docker and other OCI tools",
+	Args: cobra.MinimumNArgs(1),
+	Run:  save,
+}
+
+func init() {
+	Save.Flags().StringVarP(&saveOutput, "output", "o", "", "Write to a file, instead of STDOUT")
+}
+
+// save is the command handler function that writes the archive.
+func save(c *cobra.Command, args []string) {
+	if saveOutput == "" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintf(os.Stderr, "Error while saving images: refusing to write an archive to a terminal, use the -o flag or redirect\n")
+
+			os.Exit(1)
+		}
+
+		if err := image.WriteArchive(os.Stdout, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error while saving images: %v\n", err)
+
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The archive is written next to its destination, so a failed save leaves no partial file behind
+	f, err := os.CreateTemp(filepath.Dir(saveOutput), ".gocker-save-")
+	if err == nil {
+		defer os.Remove(f.Name())
+
+		err = image.WriteArchive(f, args)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), saveOutput)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while saving images to %q: %v\n", saveOutput, err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+var (
+	signKey         string
+	outputKeyPrefix string
+)
+
+// Sign is the Cobra command for signing an image in a registry.
+var Sign = &cobra.Command{
+	Use:   "sign [flags] image",
+	Short: "Sign an image in a registry",
+	Long: "Sign the manifest an image reference resolves to in its registry with a private key, and push " +
+		"the signature as a cosign-style artifact referring to it",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run:                   signImage,
+}
+
+// GenerateKeyPair is the Cobra command for generating a key pair to sign images with.
+var GenerateKeyPair = &cobra.Command{
+	Use:                   "generate-key-pair [flags]",
+	Short:                 "Generate a key pair to sign images with",
+	Long:                  "Generate an ECDSA P-256 key pair, writing the private key to <prefix>.key and the public key to <prefix>.pub",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.NoArgs,
+	Run:                   generateKeyPair,
+}
+
+func init() {
+	Sign.Flags().StringVar(&signKey, "key", "", "Path to the private key to sign with")
+	Sign.MarkFlagRequired("key")
+
+	GenerateKeyPair.Flags().StringVar(&outputKeyPrefix, "output-key-prefix", "cosign", "Path prefix of the key files")
+}
+
+// signImage is the command handler function that signs the image.
+func signImage(c *cobra.Command, args []string) {
+	imgName := args[0]
+
+	key, err := image.LoadPrivateKey(signKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while loading key %q: %v\n", signKey, err)
+
+		os.Exit(1)
+	}
+
+	img, err := image.NewClient(imgName, http.NewHttpClient())
+	if err == nil {
+		_, err = img.Sign(key)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while signing %q image: %v\n", imgName, err)
+
+		os.Exit(1)
+	}
+}
+
+// generateKeyPair is the command handler function that generates the key pair.
+func generateKeyPair(c *cobra.Command, args []string) {
+	private, public := outputKeyPrefix+".key", outputKeyPrefix+".pub"
+
+	if err := image.GenerateKeyPair(private, public); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while generating key pair %q: %v\n", outputKeyPrefix, err)
+
+		os.Exit(1)
+	}
+
+	fmt.Printf("Private key written to %s\nPublic key written to %s\n", private, public)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+var specBundle string
+
+// Spec is the Cobra command for writing the config of a new OCI bundle.
+var Spec = &cobra.Command{
+	Use:   "spec [flags]",
+	Short: "Create a new OCI bundle config",
+	Long: "Write a config.json running a shell in the rootfs directory of the bundle, in private " +
+		"namespaces with the ID mappings of the current user. The bundle runs with gocker runtime",
+	Args: cobra.NoArgs,
+	Run:  generateSpec,
+}
+
+func init() {
+	Spec.Flags().StringVarP(&specBundle, "bundle", "b", ".", "Path to the bundle directory")
+}
+
+// generateSpec is the command handler function that writes the default bundle config.
+func generateSpec(c *cobra.Command, args []string) {
+	if _, err := os.Stat(filepath.Join(specBundle, spec.ConfigFile)); err == nil {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists in %s, remove it first\n", spec.ConfigFile, specBundle)
+
+		os.Exit(1)
+	}
+
+	s, err := container.DefaultSpec()
+	if err == nil {
+		err = s.Save(specBundle)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while creating bundle config: %v\n", err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+var stopTimeout int
+
+// Stop is the Cobra command for gracefully stopping running containers.
+var Stop = &cobra.Command{
+	Use:   "stop [flags] container [container...]",
+	Short: "Stop one or more running containers",
+	Long:  "Send the image's stop signal (SIGTERM by default) to the container and SIGKILL after a grace period",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   stop,
+}
+
+func init() {
+	Stop.Flags().IntVarP(&stopTimeout, "time", "t", 10, "Seconds to wait for stop before killing the container")
+}
+
+// stop is the command handler function that stops the containers.
+func stop(c *cobra.Command, args []string) {
+	failed := false
+
+	for _, ref := range args {
+		s, err := container.FindState(ref)
+		if err == nil {
+			err = container.Stop(s.ID, time.Duration(stopTimeout)*time.Second)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while stopping %q container: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
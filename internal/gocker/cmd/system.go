@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/system"
+)
+
+var (
+	pruneAll     bool
+	pruneVolumes bool
+)
+
+// System is the Cobra command grouping the commands managing gocker's data.
+var System = &cobra.Command{
+	Use:   "system command",
+	Short: "Manage gocker",
+}
+
+// SystemDf is the Cobra command for showing the disk usage.
+var SystemDf = &cobra.Command{
+	Use:   "df",
+	Short: "Show gocker disk usage",
+	Args:  cobra.NoArgs,
+	Run:   systemDf,
+}
+
+// SystemPrune is the Cobra command for removing unused data.
+var SystemPrune = &cobra.Command{
+	Use:   "prune [flags]",
+	Short: "Remove unused data",
+	Long: "Remove stopped containers, dangling images and the build cache, " +
+		"then sweep the blobs no image, cache entry or local registry repository refers to",
+	Args: cobra.NoArgs,
+	Run:  systemPrune,
+}
+
+func init() {
+	SystemPrune.Flags().BoolVarP(&pruneAll, "all", "a", false, "Remove all images not used by a container, not just dangling ones")
+	// gocker keeps no volumes yet, the flag is accepted for compatibility with docker
+	SystemPrune.Flags().BoolVar(&pruneVolumes, "volumes", false, "Prune volumes")
+	SystemPrune.Flags().BoolVarP(&forcePrune, "force", "f", false, "Do not prompt for confirmation")
+
+	System.AddCommand(SystemDf, SystemPrune)
+}
+
+// systemDf is the command handler function that prints the disk usage per type of data.
+func systemDf(c *cobra.Command, args []string) {
+	usage, err := system.DiskUsage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while computing disk usage: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE")
+
+	for _, u := range usage {
+		reclaimable := humanSize(u.Reclaimable)
+		if u.Size > 0 {
+			reclaimable += fmt.Sprintf(" (%d%%)", u.Reclaimable*100/u.Size)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", u.Type, u.Total, u.Active, humanSize(u.Size), reclaimable)
+	}
+
+	w.Flush()
+}
+
+// systemPrune is the command handler function that removes unused data.
+func systemPrune(c *cobra.Command, args []string) {
+	// Root filesystems of pulled images are owned by subordinate IDs
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	images := "all dangling images"
+	if pruneAll {
+		images = "all images without at least one container associated to them"
+	}
+
+	warning := "WARNING! This will remove:\n  - all stopped containers\n  - " + images
+	if pruneVolumes {
+		warning += "\n  - all volumes not used by at least one container"
+	}
+	warning += "\n  - all build cache\n  - all blobs no image refers to"
+
+	if !forcePrune && !confirm(warning) {
+		return
+	}
+
+	report, err := system.Prune(system.PruneOptions{All: pruneAll})
+
+	if len(report.Containers) > 0 {
+		fmt.Println("Deleted Containers:")
+		for _, id := range report.Containers {
+			fmt.Println(id)
+		}
+		fmt.Println()
+	}
+
+	if len(report.Untagged) > 0 || len(report.Blobs) > 0 {
+		fmt.Println("Deleted Images:")
+		for _, name := range report.Untagged {
+			fmt.Printf("untagged: %s\n", name)
+		}
+		for _, digest := range report.Blobs {
+			fmt.Printf("deleted: %s\n", digest)
+		}
+		fmt.Println()
+	}
+
+	if len(report.CacheKeys) > 0 {
+		fmt.Println("Deleted build cache objects:")
+		for _, key := range report.CacheKeys {
+			fmt.Println(key[:25])
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(report.Reclaimed))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while pruning: %v\n", err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// Tag is the Cobra command for giving a local image another name.
+var Tag = &cobra.Command{
+	Use:                   "tag source_image[:tag] target_image[:tag]",
+	Short:                 "Create a tag target_image that refers to source_image",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run:                   tag,
+}
+
+// tag is the command handler function that tags the image.
+func tag(c *cobra.Command, args []string) {
+	// The new name gets its own root filesystem, owned by subordinate IDs like pulled ones
+	if err := idmap.ReexecInNamespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while entering user namespace: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	if err := image.Tag(args[0], args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while tagging %q image: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+// Top is the Cobra command for listing the processes of a container.
+var Top = &cobra.Command{
+	Use:   "top container",
+	Short: "Display the running processes of a container",
+	Args:  cobra.ExactArgs(1),
+	Run:   top,
+}
+
+// top is the command handler function that prints the container processes the way ps -ef does.
+func top(c *cobra.Command, args []string) {
+	s, err := container.FindState(args[0])
+
+	var processes []container.Process
+	if err == nil {
+		processes, err = container.Top(s.ID)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while listing processes of %q container: %v\n", args[0], err)
+
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "UID\tPID\tPPID\tC\tSTIME\tTTY\tTIME\tCMD")
+
+	for _, p := range processes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			p.UID, p.PID, p.PPID, p.CPU, startTime(p.Start), p.TTY, cpuTime(p.Time), p.Cmd)
+	}
+
+	w.Flush()
+}
+
+// startTime formats the start time of a process, with the date for processes started before today.
+func startTime(t time.Time) string {
+	if y, m, d := t.Date(); y == time.Now().Year() && m == time.Now().Month() && d == time.Now().Day() {
+		return t.Format("15:04")
+	}
+
+	return t.Format("Jan02")
+}
+
+// cpuTime formats the CPU time of a process as [DD-]HH:MM:SS.
+func cpuTime(d time.Duration) string {
+	secs := int(d.Seconds())
+	days, hours, minutes := secs/86400, secs/3600%24, secs/60%60
+
+	if days > 0 {
+		return fmt.Sprintf("%d-%02d:%02d:%02d", days, hours, minutes, secs%60)
+	}
+
+	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, secs%60)
+}
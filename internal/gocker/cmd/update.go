@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+var (
+	updateMemory    string
+	updateCPUs      float64
+	updatePidsLimit int64
+)
+
+// Update is the Cobra command for changing the resource limits of containers.
+var Update = &cobra.Command{
+	Use:   "update [flags] container [container...]",
+	Short: "Update the resource limits of one or more containers",
+	Long:  "Rewrite the cgroup limits of running containers, and those stopped ones are started with",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   update,
+}
+
+func init() {
+	Update.Flags().StringVarP(&updateMemory, "memory", "m", "", "Memory limit (format: <number>[<unit>], unit may be b, k, m or g; 0 for unlimited)")
+	Update.Flags().Float64Var(&updateCPUs, "cpus", 0, "Number of CPUs (0 for unlimited)")
+	Update.Flags().Int64Var(&updatePidsLimit, "pids-limit", 0, "Tune container pids limit (-1 for unlimited)")
+}
+
+// update is the command handler function that updates the limits of the containers.
+func update(c *cobra.Command, args []string) {
+	if !c.Flags().Changed("memory") && !c.Flags().Changed("cpus") && !c.Flags().Changed("pids-limit") {
+		fmt.Fprintln(os.Stderr, "Error: you must provide one or more flags when using this command")
+
+		os.Exit(1)
+	}
+
+	var memory int64
+	if c.Flags().Changed("memory") {
+		var err error
+		if memory, err = container.ParseMemory(updateMemory); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+			os.Exit(1)
+		}
+	}
+
+	forEachContainer(args, "updating", func(id string) error {
+		return container.Update(id, func(r *container.Resources) {
+			if c.Flags().Changed("memory") {
+				r.Memory = memory
+			}
+			if c.Flags().Changed("cpus") {
+				r.CPUs = updateCPUs
+			}
+			if c.Flags().Changed("pids-limit") {
+				r.PidsLimit = updatePidsLimit
+			}
+		})
+	})
+}
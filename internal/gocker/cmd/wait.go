@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+)
+
+// Wait is the Cobra command for waiting on containers to stop.
+var Wait = &cobra.Command{
+	Use:                   "wait container [container...]",
+	Short:                 "Block until one or more containers stop, then print their exit codes",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	Run:                   wait,
+}
+
+// wait is the command handler function that waits for the containers.
+func wait(c *cobra.Command, args []string) {
+	failed := false
+
+	for _, ref := range args {
+		code, err := waitFor(ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while waiting for %q container: %v\n", ref, err)
+			failed = true
+
+			continue
+		}
+
+		fmt.Println(code)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// waitFor waits for the container to stop and returns its exit code, through the daemon if there's one.
+func waitFor(ref string) (int, error) {
+	if Host != "" {
+		return newClient().WaitContainer(ref)
+	}
+
+	s, err := container.FindState(ref)
+	if err == nil {
+		s, err = container.Wait(s.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return s.ExitCode, nil
+}
@@ -0,0 +1,428 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+// defaultDevices are bound from the host into the /dev tmpfs of bundles, like the devices runc creates.
+var defaultDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"}
+
+// mountOption is a mount option of bundles setting a mount flag, or clearing it if clear is set.
+type mountOption struct {
+	clear bool
+	flag  uintptr
+}
+
+// mountOptions maps the mount options of bundles that are mount flags to them.
+var mountOptions = map[string]mountOption{
+	"ro":            {false, syscall.MS_RDONLY},
+	"rw":            {true, syscall.MS_RDONLY},
+	"nosuid":        {false, syscall.MS_NOSUID},
+	"suid":          {true, syscall.MS_NOSUID},
+	"nodev":         {false, syscall.MS_NODEV},
+	"dev":           {true, syscall.MS_NODEV},
+	"noexec":        {false, syscall.MS_NOEXEC},
+	"exec":          {true, syscall.MS_NOEXEC},
+	"sync":          {false, syscall.MS_SYNCHRONOUS},
+	"async":         {true, syscall.MS_SYNCHRONOUS},
+	"noatime":       {false, syscall.MS_NOATIME},
+	"atime":         {true, syscall.MS_NOATIME},
+	"nodiratime":    {false, syscall.MS_NODIRATIME},
+	"diratime":      {true, syscall.MS_NODIRATIME},
+	"relatime":      {false, syscall.MS_RELATIME},
+	"norelatime":    {true, syscall.MS_RELATIME},
+	"strictatime":   {false, syscall.MS_STRICTATIME},
+	"nostrictatime": {true, syscall.MS_STRICTATIME},
+	"bind":          {false, syscall.MS_BIND},
+	"rbind":         {false, syscall.MS_BIND | syscall.MS_REC},
+}
+
+// bindFlags are the mount flags of recursive bind mounts.
+const bindFlags = syscall.MS_BIND | syscall.MS_REC
+
+// propagationOptions are the mount propagation options, which are ignored
+// since every mount of a container is private.
+var propagationOptions = []string{"private", "rprivate", "shared", "rshared", "slave", "rslave", "unbindable", "runbindable"}
+
+// NewBundleContainer creates a Container from the OCI bundle in the given directory, named
+// after its OCI ID. The process, root filesystem, mounts, namespaces, limits and security
+// settings come from the bundle config. The mount, cgroup and time namespaces are always
+// private, other namespaces missing from the config are shared with the host. Devices are
+// bound from the same path on the host.
+func NewBundleContainer(name, bundle string, opts Options) (*Container, error) {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bundle path: %v", err)
+	}
+
+	s, err := spec.Load(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Process.Terminal {
+		return nil, fmt.Errorf("terminal is not supported, the container uses the standard streams of gocker")
+	}
+
+	root := s.Root.Path
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(bundle, root)
+	}
+
+	opts.Name, opts.Bundle, opts.Rootfs = name, bundle, true
+	opts.ReadOnly = s.Root.Readonly
+	opts.NoNewPrivileges = s.Process.NoNewPrivileges
+	opts.EnvVars = s.Process.Env
+
+	if err := opts.setSpecNamespaces(s); err != nil {
+		return nil, err
+	}
+
+	for _, r := range s.Process.Rlimits {
+		opts.Ulimits = append(opts.Ulimits, fmt.Sprintf("%s=%d:%d", strings.ToLower(strings.TrimPrefix(r.Type, "RLIMIT_")), r.Soft, r.Hard))
+	}
+
+	// The capabilities of the process are those of its bounding set, none without one
+	opts.CapDrop = []string{"ALL"}
+	if s.Process.Capabilities != nil {
+		opts.CapAdd = s.Process.Capabilities.Bounding
+	}
+
+	if s.Linux != nil {
+		keys := make([]string, 0, len(s.Linux.Sysctl))
+		for key := range s.Linux.Sysctl {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			opts.Sysctls = append(opts.Sysctls, key+"="+s.Linux.Sysctl[key])
+		}
+
+		for _, d := range s.Linux.Devices {
+			opts.Devices = append(opts.Devices, d.Path)
+		}
+	}
+
+	// A /dev mount hides the devices of the root filesystem, so the default ones are bound into it
+	if slices.ContainsFunc(s.Mounts, func(m spec.Mount) bool { return filepath.Clean(m.Destination) == "/dev" }) {
+		for _, d := range defaultDevices {
+			if !slices.Contains(opts.Devices, d) {
+				opts.Devices = append(opts.Devices, d)
+			}
+		}
+	}
+
+	id := os.Getenv("CONTAINER_ID")
+	if id == "" {
+		id = newID()
+	}
+
+	c := &Container{
+		Options:    opts,
+		ID:         id,
+		imgName:    root,
+		imgRoot:    root,
+		dir:        containerDir(id),
+		cgroupPath: cgroupDir(id),
+		cmd:        s.Process.Args[0],
+		args:       s.Process.Args[1:],
+		spec:       s,
+	}
+
+	if err := c.fromFile(""); err != nil {
+		return nil, err
+	}
+
+	c.Env, c.WorkingDir = s.Process.Env, s.Process.Cwd
+	c.Config.User = fmt.Sprintf("%d:%d", s.Process.User.UID, s.Process.User.GID)
+	if s.Hostname != "" {
+		c.Config.Hostname = s.Hostname
+	}
+
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+
+	resources, err := specResources(s)
+	if err != nil {
+		return nil, err
+	}
+	c.resources = &resources
+
+	return c, nil
+}
+
+// DefaultSpec returns the bundle config gocker spec writes, for a container in private
+// namespaces with the default capabilities and ID mappings of gocker run.
+func DefaultSpec() (*spec.Spec, error) {
+	m, err := idmap.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	toSpec := func(mappings []idmap.Mapping) []spec.IDMapping {
+		var ids []spec.IDMapping
+		for _, m := range mappings {
+			ids = append(ids, spec.IDMapping{ContainerID: uint32(m.ContainerID), HostID: uint32(m.HostID), Size: uint32(m.Size)})
+		}
+		return ids
+	}
+
+	return spec.Default(toSpec(m.UIDs), toSpec(m.GIDs), slices.Clone(defaultCapabilities)), nil
+}
+
+// setSpecNamespaces selects the namespaces of the bundle config.
+func (o *Options) setSpecNamespaces(s *spec.Spec) error {
+	o.IPC, o.PID, o.UTS, o.Network, o.UserNS = "host", "host", "host", network.Host, "host"
+
+	if s.Linux == nil {
+		return nil
+	}
+
+	for _, ns := range s.Linux.Namespaces {
+		if ns.Path != "" {
+			return fmt.Errorf("joining the %s namespace at %s is not supported", ns.Type, ns.Path)
+		}
+
+		switch ns.Type {
+		case spec.IPCNamespace:
+			o.IPC = ""
+		case spec.PIDNamespace:
+			o.PID = ""
+		case spec.UTSNamespace:
+			o.UTS = ""
+		case spec.NetworkNamespace:
+			o.Network = network.None
+		case spec.UserNamespace:
+			o.UserNS = ""
+		case spec.MountNamespace, spec.CgroupNamespace, spec.TimeNamespace:
+		default:
+			return fmt.Errorf("unknown namespace type: %q", ns.Type)
+		}
+	}
+
+	if o.UserNS == "host" {
+		if len(s.Linux.UIDMappings) > 0 || len(s.Linux.GIDMappings) > 0 {
+			return fmt.Errorf("ID mappings need a user namespace")
+		}
+		return nil
+	}
+
+	for _, m := range s.Linux.UIDMappings {
+		o.UIDMaps = append(o.UIDMaps, fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size))
+	}
+	for _, m := range s.Linux.GIDMappings {
+		o.GIDMaps = append(o.GIDMaps, fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size))
+	}
+
+	return nil
+}
+
+// specResources returns the resource limits of the bundle config, no limits without any.
+func specResources(s *spec.Spec) (Resources, error) {
+	var r Resources
+	if s.Linux == nil || s.Linux.Resources == nil {
+		return r, nil
+	}
+
+	if m := s.Linux.Resources.Memory; m != nil && m.Limit != nil && *m.Limit > 0 {
+		r.Memory = *m.Limit
+	}
+
+	if cpu := s.Linux.Resources.CPU; cpu != nil && cpu.Quota != nil && *cpu.Quota > 0 {
+		period := uint64(cpuPeriod)
+		if cpu.Period != nil && *cpu.Period > 0 {
+			period = *cpu.Period
+		}
+		r.CPUs = float64(*cpu.Quota) / float64(period)
+	}
+
+	if p := s.Linux.Resources.Pids; p != nil {
+		r.PidsLimit = p.Limit
+	}
+
+	return r, r.validate()
+}
+
+// specSeccompProfile converts the seccomp filter of the bundle config, nil without one.
+func specSeccompProfile(s *spec.Spec) *SeccompProfile {
+	if s == nil || s.Linux == nil || s.Linux.Seccomp == nil {
+		return nil
+	}
+
+	p := &SeccompProfile{
+		DefaultAction:   s.Linux.Seccomp.DefaultAction,
+		DefaultErrnoRet: s.Linux.Seccomp.DefaultErrnoRet,
+		Architectures:   s.Linux.Seccomp.Architectures,
+	}
+
+	for _, sc := range s.Linux.Seccomp.Syscalls {
+		rule := SeccompSyscall{Names: sc.Names, Action: sc.Action, ErrnoRet: sc.ErrnoRet}
+		for _, a := range sc.Args {
+			rule.Args = append(rule.Args, SeccompArg{Index: a.Index, Value: a.Value, ValueTwo: a.ValueTwo, Op: a.Op})
+		}
+		p.Syscalls = append(p.Syscalls, rule)
+	}
+
+	return p
+}
+
+// parseMountOptions splits the options of a bundle mount into its flags and filesystem data.
+func parseMountOptions(options []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+
+	for _, o := range options {
+		if opt, ok := mountOptions[o]; ok {
+			if opt.clear {
+				flags &^= opt.flag
+			} else {
+				flags |= opt.flag
+			}
+			continue
+		}
+
+		if !slices.Contains(propagationOptions, o) {
+			data = append(data, o)
+		}
+	}
+
+	return flags, strings.Join(data, ",")
+}
+
+// mountBundle mounts the filesystems of the bundle config into the root filesystem.
+// The proc filesystem is mounted once the root is changed, like for other containers.
+func (c *Container) mountBundle() error {
+	for _, m := range c.spec.Mounts {
+		if m.Type == "proc" {
+			continue
+		}
+
+		target, err := image.ResolveInRoot(c.imgRoot, m.Destination)
+		if err != nil {
+			return err
+		}
+
+		// Mounts follow symlinks, which would resolve against the host's root
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("mount destination %s is a symlink", m.Destination)
+		}
+
+		flags, data := parseMountOptions(m.Options)
+
+		if flags&syscall.MS_BIND != 0 {
+			source := m.Source
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(c.Bundle, source)
+			}
+
+			if err := createMountPoint(source, target); err != nil {
+				return fmt.Errorf("failed to create mount point %s: %v", m.Destination, err)
+			}
+
+			if err := syscall.Mount(source, target, "", flags&bindFlags, ""); err != nil {
+				return fmt.Errorf("failed to bind mount %s: %v", m.Destination, err)
+			}
+		} else {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create mount point %s: %v", m.Destination, err)
+			}
+
+			err := syscall.Mount(m.Source, target, m.Type, flags, data)
+
+			// Only the owner of the network namespace can mount sysfs, so the host's is bound instead
+			if err == syscall.EPERM && m.Type == "sysfs" {
+				flags |= bindFlags
+				err = syscall.Mount("/sys", target, "", bindFlags, "")
+			}
+			if err != nil {
+				return fmt.Errorf("failed to mount %s: %v", m.Destination, err)
+			}
+		}
+
+		// Bind mounts take their other flags when remounted
+		if flags&bindFlags != 0 && flags&^bindFlags != 0 {
+			if err := remountBind(target, flags&^bindFlags); err != nil {
+				return fmt.Errorf("failed to remount %s: %v", m.Destination, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// createMountPoint creates the target a source is bound to, a directory for a directory and a file otherwise.
+func createMountPoint(source, target string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// restrictPaths makes the read-only paths of the bundle config read-only and hides its masked
+// paths, directories under an empty tmpfs and files under /dev/null, which is given as an open
+// file since it may be out of reach in the container. Missing paths are skipped.
+func (c *Container) restrictPaths(devNull *os.File) error {
+	if c.spec.Linux == nil {
+		return nil
+	}
+
+	for _, p := range c.spec.Linux.ReadonlyPaths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind mount %s: %v", p, err)
+		}
+
+		if err := remountBind(p, syscall.MS_RDONLY); err != nil {
+			return fmt.Errorf("failed to remount %s read-only: %v", p, err)
+		}
+	}
+
+	for _, p := range c.spec.Linux.MaskedPaths {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err == nil && fi.IsDir() {
+			err = syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/proc/self/fd/"+strconv.Itoa(int(devNull.Fd())), p, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mask %s: %v", p, err)
+		}
+	}
+
+	return nil
+}
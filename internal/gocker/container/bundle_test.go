@@ -0,0 +1,73 @@
+package container
+
+import (
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+// TestParseMountOptions tests the splitting of bundle mount options into flags and data
+func TestParseMountOptions(t *testing.T) {
+	flags, data := parseMountOptions([]string{"rbind", "ro", "nosuid", "rprivate", "mode=755", "rw", "size=65536k"})
+
+	if expected := uintptr(syscall.MS_BIND | syscall.MS_REC | syscall.MS_NOSUID); flags != expected {
+		t.Errorf("Expected flags %#x, got %#x", expected, flags)
+	}
+	if data != "mode=755,size=65536k" {
+		t.Errorf("Expected data %q, got %q", "mode=755,size=65536k", data)
+	}
+}
+
+// TestSetSpecNamespaces tests that the namespaces of bundles select the namespace modes
+func TestSetSpecNamespaces(t *testing.T) {
+	s := &spec.Spec{Linux: &spec.Linux{
+		Namespaces:  []spec.Namespace{{Type: spec.PIDNamespace}, {Type: spec.NetworkNamespace}, {Type: spec.UserNamespace}},
+		UIDMappings: []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}},
+	}}
+
+	var o Options
+	if err := o.setSpecNamespaces(s); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !o.PID.IsPrivate() || !o.IPC.IsHost() || !o.UTS.IsHost() || o.Network != "none" || o.UserNS != "" {
+		t.Errorf("Unexpected namespace modes: %+v", o)
+	}
+	if !slices.Equal(o.UIDMaps, []string{"0:1000:1"}) {
+		t.Errorf("Expected UID mappings [0:1000:1], got %v", o.UIDMaps)
+	}
+
+	for _, linux := range []*spec.Linux{
+		{Namespaces: []spec.Namespace{{Type: spec.NetworkNamespace, Path: "/var/run/netns/web"}}},
+		{Namespaces: []spec.Namespace{{Type: "bogus"}}},
+		{UIDMappings: []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}},
+	} {
+		if err := new(Options).setSpecNamespaces(&spec.Spec{Linux: linux}); err == nil {
+			t.Errorf("Expected namespaces %+v to be rejected", linux)
+		}
+	}
+}
+
+// TestSpecResources tests the conversion of bundle resources into container limits
+func TestSpecResources(t *testing.T) {
+	memory, quota, period := int64(64*1024*1024), int64(50000), uint64(100000)
+
+	r, err := specResources(&spec.Spec{Linux: &spec.Linux{Resources: &spec.Resources{
+		Memory: &spec.Memory{Limit: &memory},
+		CPU:    &spec.CPU{Quota: &quota, Period: &period},
+		Pids:   &spec.Pids{Limit: 100},
+	}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if expected := (Resources{Memory: memory, CPUs: 0.5, PidsLimit: 100}); r != expected {
+		t.Errorf("Expected resources %+v, got %+v", expected, r)
+	}
+
+	if r, err := specResources(&spec.Spec{}); err != nil || r != (Resources{}) {
+		t.Errorf("Expected no limits without resources, got %+v (%v)", r, err)
+	}
+}
@@ -0,0 +1,181 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	linuxCapabilityVersion3 = 0x20080522
+
+	capLastCapFile = "/proc/sys/kernel/cap_last_cap"
+)
+
+// capabilities maps capability names to their numbers.
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// defaultCapabilities is the capability set Docker grants to containers.
+var defaultCapabilities = []string{
+	"CAP_AUDIT_WRITE",
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_MKNOD",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_RAW",
+	"CAP_SETFCAP",
+	"CAP_SETGID",
+	"CAP_SETPCAP",
+	"CAP_SETUID",
+	"CAP_SYS_CHROOT",
+}
+
+// capabilitySet applies --cap-add and --cap-drop to the default capabilities.
+// Names are case-insensitive, the CAP_ prefix is optional and ALL stands for every capability.
+func capabilitySet(add, drop []string) ([]string, error) {
+	normalize := func(names []string) ([]string, error) {
+		var caps []string
+		for _, n := range names {
+			n = strings.ToUpper(n)
+			if n == "ALL" {
+				caps = append(caps, n)
+				continue
+			}
+
+			if !strings.HasPrefix(n, "CAP_") {
+				n = "CAP_" + n
+			}
+			if _, ok := capabilities[n]; !ok {
+				return nil, fmt.Errorf("unknown capability: %q", n)
+			}
+
+			caps = append(caps, n)
+		}
+		return caps, nil
+	}
+
+	add, err := normalize(add)
+	if err != nil {
+		return nil, err
+	}
+
+	drop, err = normalize(drop)
+	if err != nil {
+		return nil, err
+	}
+
+	var caps []string
+	if slices.Contains(add, "ALL") {
+		for n := range capabilities {
+			caps = append(caps, n)
+		}
+	} else {
+		caps = append(slices.Clone(defaultCapabilities), add...)
+	}
+
+	if slices.Contains(drop, "ALL") {
+		// Only explicitly added capabilities survive dropping all of them
+		caps = slices.DeleteFunc(add, func(n string) bool { return n == "ALL" })
+	} else {
+		caps = slices.DeleteFunc(caps, func(n string) bool { return slices.Contains(drop, n) })
+	}
+
+	slices.Sort(caps)
+
+	return slices.Compact(caps), nil
+}
+
+// dropCapabilities limits the calling thread to the given capabilities.
+// The bounding set is reduced first, so the capabilities can't be regained
+// when the command is executed, then the thread's own sets are replaced.
+func dropCapabilities(keep []string) error {
+	lastCap := uint(capabilities["CAP_CHECKPOINT_RESTORE"])
+	if data, err := os.ReadFile(capLastCapFile); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			lastCap = uint(n)
+		}
+	}
+
+	var mask [2]uint32
+	for _, n := range keep {
+		c := capabilities[n]
+		mask[c/32] |= 1 << (c % 32)
+	}
+
+	for c := uint(0); c <= lastCap; c++ {
+		if mask[c/32]&(1<<(c%32)) != 0 {
+			continue
+		}
+
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0); errno != 0 {
+			return fmt.Errorf("failed to drop capability %d from bounding set: %v", c, errno)
+		}
+	}
+
+	hdr := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapabilityVersion3}
+
+	var data [2]struct {
+		effective, permitted, inheritable uint32
+	}
+	for i := range data {
+		data[i].effective, data[i].permitted, data[i].inheritable = mask[i], mask[i], mask[i]
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to set capabilities: %v", errno)
+	}
+
+	return nil
+}
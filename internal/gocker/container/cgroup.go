@@ -0,0 +1,239 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cpuPeriod is the cpu.max period in microseconds the CPU quota is relative to
+	cpuPeriod = 100000
+
+	// minMemory is the lowest memory limit a container can start with, like docker's
+	minMemory = 6 * 1024 * 1024
+
+	// freezeTimeout is how long to wait for the processes of a container to freeze or thaw
+	freezeTimeout = 5 * time.Second
+)
+
+// Resources are the resource limits of a container, zero values meaning unlimited.
+type Resources struct {
+	// Memory is the memory limit in bytes
+	Memory int64
+	// CPUs is the number of CPUs the container may use
+	CPUs float64
+	// PidsLimit is the maximum number of processes
+	PidsLimit int64
+}
+
+// defaultResources are the limits of containers started without others.
+var defaultResources = Resources{Memory: 50 * 1024 * 1024, CPUs: 0.2}
+
+// validate checks that the limits can be applied on this host.
+func (r Resources) validate() error {
+	if r.Memory < 0 || (r.Memory > 0 && r.Memory < minMemory) {
+		return fmt.Errorf("minimum memory limit allowed is 6MB")
+	}
+
+	if r.CPUs < 0 || r.CPUs > float64(runtime.NumCPU()) {
+		return fmt.Errorf("range of CPUs is from 0.01 to %d.00, as there are only %d CPUs available", runtime.NumCPU(), runtime.NumCPU())
+	}
+	if r.CPUs > 0 && r.CPUs < 0.01 {
+		return fmt.Errorf("range of CPUs is from 0.01 to %d.00", runtime.NumCPU())
+	}
+
+	return nil
+}
+
+// custom reports whether the limits were asked for, rather than being the defaults or none at all.
+func (r Resources) custom() bool {
+	return r != defaultResources && r != Resources{}
+}
+
+// write applies the limits to the cgroup at the given path.
+func (r Resources) write(cgroupPath string) error {
+	memory := "max"
+	if r.Memory > 0 {
+		memory = strconv.FormatInt(r.Memory, 10)
+	}
+
+	// Format: "<max> <period>" where max and period are in microseconds
+	cpu := fmt.Sprintf("max %d", cpuPeriod)
+	if r.CPUs > 0 {
+		cpu = fmt.Sprintf("%d %d", int64(r.CPUs*cpuPeriod), cpuPeriod)
+	}
+
+	pids := "max"
+	if r.PidsLimit > 0 {
+		pids = strconv.FormatInt(r.PidsLimit, 10)
+	}
+
+	for _, limit := range []struct {
+		file, value, name string
+		unlimited         bool
+	}{
+		{"memory.max", memory, "memory", r.Memory == 0},
+		{"cpu.max", cpu, "CPU", r.CPUs == 0},
+		{"pids.max", pids, "PIDs", r.PidsLimit <= 0},
+	} {
+		path := filepath.Join(cgroupPath, limit.file)
+
+		// Controllers that aren't enabled leave their resources unlimited anyway
+		if _, err := os.Stat(path); limit.unlimited && os.IsNotExist(err) {
+			continue
+		}
+
+		if err := os.WriteFile(path, []byte(limit.value), 0644); err != nil {
+			return fmt.Errorf("failed to set %s limit: %v", limit.name, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseMemory parses a memory size given in bytes or with a b, k, m or g suffix.
+func ParseMemory(size string) (int64, error) {
+	units := map[byte]int64{'b': 1, 'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+
+	s := strings.ToLower(strings.TrimSpace(size))
+	if n := len(s); n > 2 && s[n-1] == 'b' && units[s[n-2]] > 1 {
+		s = s[:n-1]
+	}
+
+	unit := int64(1)
+	if n := len(s); n > 0 {
+		if u, ok := units[s[n-1]]; ok {
+			unit, s = u, s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// cgroupDir returns the cgroup of the container with the given ID.
+func cgroupDir(id string) string {
+	return filepath.Join(cgroupsRoot, "gocker-"+shortID(id))
+}
+
+// resources returns the limits of the container, the defaults for containers created without any.
+func (s *State) resources() Resources {
+	if s.Resources == nil {
+		return defaultResources
+	}
+
+	return *s.Resources
+}
+
+// Update changes the resource limits of a container. The limits of a running container
+// change right away, those of a stopped one when it's started again.
+func Update(id string, fn func(*Resources)) error {
+	var err error
+
+	uerr := UpdateState(id, func(s *State) {
+		r := s.resources()
+		fn(&r)
+
+		if err = r.validate(); err != nil {
+			return
+		}
+
+		if s.IsRunning() {
+			if _, err = os.Stat(cgroupDir(id)); err != nil {
+				err = fmt.Errorf("container %s has no cgroup to update", s.ShortID())
+				return
+			}
+
+			if err = r.write(cgroupDir(id)); err != nil {
+				return
+			}
+		}
+
+		s.Resources = &r
+	})
+	if uerr != nil {
+		return uerr
+	}
+
+	return err
+}
+
+// Pause freezes all processes of a running container.
+func Pause(id string) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if !s.IsRunning() {
+		return fmt.Errorf("container %s is not running", s.ShortID())
+	}
+	if s.Paused {
+		return fmt.Errorf("container %s is already paused", s.ShortID())
+	}
+
+	if err := freeze(id, true); err != nil {
+		return err
+	}
+
+	return UpdateState(id, func(s *State) { s.Paused = true })
+}
+
+// Unpause thaws the processes of a paused container.
+func Unpause(id string) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if !s.Paused {
+		return fmt.Errorf("container %s is not paused", s.ShortID())
+	}
+
+	if err := freeze(id, false); err != nil {
+		return err
+	}
+
+	return UpdateState(id, func(s *State) { s.Paused = false })
+}
+
+// freeze freezes or thaws the cgroup of the container through the cgroup v2 freezer
+// and waits until all its processes are in the requested state.
+func freeze(id string, frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+
+	if err := os.WriteFile(filepath.Join(cgroupDir(id), "cgroup.freeze"), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write cgroup.freeze of container %s: %v", shortID(id), err)
+	}
+
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		events, err := os.ReadFile(filepath.Join(cgroupDir(id), "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("failed to read cgroup events: %v", err)
+		}
+
+		if bytes.Contains(events, []byte("frozen "+value)) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for container %s to freeze or thaw", shortID(id))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
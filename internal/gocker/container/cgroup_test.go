@@ -0,0 +1,83 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseMemory tests the parsing of memory sizes
+func TestParseMemory(t *testing.T) {
+	for size, expected := range map[string]int64{
+		"0":       0,
+		"1048576": 1 << 20,
+		"100b":    100,
+		"512k":    512 << 10,
+		"64m":     64 << 20,
+		"64MB":    64 << 20,
+		"1.5g":    3 << 29,
+	} {
+		if n, err := ParseMemory(size); err != nil || n != expected {
+			t.Errorf("Expected %s to be %d bytes, got %d (%v)", size, expected, n, err)
+		}
+	}
+
+	for _, size := range []string{"", "m", "-1m", "12x", "1mm"} {
+		if _, err := ParseMemory(size); err == nil {
+			t.Errorf("Expected %q to be rejected", size)
+		}
+	}
+}
+
+// TestResources tests the validation of resource limits and the cgroup files they're written to
+func TestResources(t *testing.T) {
+	for _, r := range []Resources{{Memory: 1 << 20}, {Memory: -1}, {CPUs: -1}, {CPUs: 0.001}, {CPUs: 1 << 20}} {
+		if err := r.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", r)
+		}
+	}
+
+	// Only limits asked for make a container fail to start without cgroups
+	for r, custom := range map[Resources]bool{defaultResources: false, {}: false, {PidsLimit: 10}: true} {
+		if r.custom() != custom {
+			t.Errorf("Expected %+v to be custom: %t", r, custom)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, file := range []string{"memory.max", "cpu.max"} {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(expected map[string]string) {
+		t.Helper()
+
+		for file, value := range expected {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil || string(data) != value {
+				t.Errorf("Expected %s to be %q, got %q (%v)", file, value, data, err)
+			}
+		}
+	}
+
+	if err := (Resources{Memory: 64 << 20, CPUs: 1.5}).write(dir); err != nil {
+		t.Fatalf("Failed to write limits: %v", err)
+	}
+	check(map[string]string{"memory.max": "67108864", "cpu.max": "150000 100000"})
+
+	// Without the pids controller only an unlimited number of processes can be set
+	if _, err := os.Stat(filepath.Join(dir, "pids.max")); !os.IsNotExist(err) {
+		t.Errorf("Expected no pids.max to be created, got %v", err)
+	}
+
+	if err := (Resources{}).write(dir); err != nil {
+		t.Fatalf("Failed to write limits: %v", err)
+	}
+	check(map[string]string{"memory.max": "max", "cpu.max": "max 100000"})
+
+	if err := (Resources{PidsLimit: 10}).write(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected the limits of a missing cgroup to fail")
+	}
+}
@@ -0,0 +1,124 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// CommitOptions holds the metadata recorded with a committed image.
+type CommitOptions struct {
+	Message string
+	Author  string
+}
+
+// Commit creates an image from a container. The changes made to the root filesystem of its
+// image since the image was stored become a new layer on top of the image's layers. Containers
+// of an image share its root filesystem, so those are the changes of all of them.
+// It returns the ID of the new image.
+func Commit(s *State, name string, opts CommitOptions) (string, error) {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+
+	before, err := image.LoadSnapshot(s.Image)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, _, err := image.LoadManifest(s.Image)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := image.LoadConfig(s.Image)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return "", err
+	}
+
+	layer, diffID, err := image.WriteLayer(filepath.Join(image.Path(s.Image), "rootfs"), before, m)
+	if err != nil {
+		return "", err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	cfg.Created, cfg.Container = created, s.ID
+	if opts.Author != "" {
+		cfg.Author = opts.Author
+	}
+	cfg.Rootfs.DiffIds = append(cfg.Rootfs.DiffIds, diffID)
+	cfg.History = append(cfg.History, registry.History{
+		Created:   created,
+		CreatedBy: strings.Join(s.Command, " "),
+		Comment:   opts.Message,
+	})
+
+	layers := append(slices.Clone(manifest.Layers), layer)
+
+	rootfs, err := image.ExtractLayers(layers, m)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(rootfs)
+
+	return image.Save(ref.String(), rootfs, cfg, layers)
+}
+
+// Export writes the root filesystem of a container into a tar stream.
+func Export(s *State, w io.Writer) error {
+	root, err := s.Rootfs()
+	if err != nil {
+		return err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return err
+	}
+
+	return image.WriteTar(w, root, m)
+}
+
+// Diff returns the files of the root filesystem of a container that were added, changed or
+// deleted since its image was stored. Like with Commit, those are the changes of all the
+// containers of the image.
+func Diff(s *State) ([]image.Change, error) {
+	root, err := s.Rootfs()
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := image.LoadSnapshot(s.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	return image.Changes(root, before)
+}
+
+// Rootfs returns the root filesystem of a container, which it shares with the other containers of its image.
+func (s *State) Rootfs() (string, error) {
+	root := s.Root
+	if root == "" {
+		root = filepath.Join(image.Path(s.Image), "rootfs")
+	}
+	if _, err := os.Stat(root); err != nil {
+		return "", fmt.Errorf("root filesystem of container %s is gone: %v", s.ShortID(), err)
+	}
+
+	return root, nil
+}
@@ -3,42 +3,165 @@ package container
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
 	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
 	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
 )
 
 const (
 	cgroupsRoot = "/sys/fs/cgroup"
+
+	// cgroup2SuperMagic is the filesystem type of the cgroup v2 hierarchy
+	cgroup2SuperMagic = 0x63677270
+
+	// defaultPath is the PATH of containers whose image doesn't set one
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// parentSyncEnv holds the descriptor on which the child waits for the parent to set it up
+	parentSyncEnv = "PARENT_SYNC"
 )
 
+// Options holds the user-supplied container settings.
+type Options struct {
+	Name          string
+	Detach        bool
+	Remove        bool
+	RestartPolicy RestartPolicy
+
+	// Overrides of the image config
+	EnvVars []string
+	Workdir string
+	User    string
+
+	// Rootfs makes the image name the path of a root filesystem directory to run without an image config
+	Rootfs bool
+
+	// ExecArgs are the arguments the supervisor and child processes are executed with, os.Args[1:] by default
+	ExecArgs []string
+
+	// Bundle is the OCI bundle directory of containers created with NewBundleContainer
+	Bundle string
+	// DeferStart makes the container wait for Start before running its command
+	DeferStart bool
+
+	// Security settings
+	Seccomp         string
+	NoNewPrivileges bool
+	CapAdd          []string
+	CapDrop         []string
+	ReadOnly        bool
+
+	// User namespace settings
+	UserNS  string
+	UIDMaps []string
+	GIDMaps []string
+
+	// Namespace sharing settings
+	IPC NamespaceMode
+	PID NamespaceMode
+	UTS NamespaceMode
+
+	// Name resolution settings
+	Hostname   string
+	ExtraHosts []string
+	DNS        []string
+	DNSSearch  []string
+
+	// Network settings
+	Network        string
+	NetworkAliases []string
+	IP             string
+
+	// Limits, kernel parameters and devices of the container
+	Ulimits []string
+	Sysctls []string
+	Devices []string
+	ShmSize int64
+
+	// Health check overrides of the image config
+	HealthCmd         string
+	HealthInterval    time.Duration
+	HealthTimeout     time.Duration
+	HealthStartPeriod time.Duration
+	HealthRetries     int
+}
+
 // Container encapsulates container execution parameters.
 type Container struct {
 	registry.Config
+	Options
+
+	ID         string
 	imgName    string
 	imgRoot    string
+	dir        string
 	cgroupPath string
 	cmd        string
 	args       []string
+
+	// cgroup is the open cgroup directory the child is cloned into, if any
+	cgroup *os.File
+
+	caps          []string
+	seccompFilter []syscall.SockFilter
+	idMappings    *idmap.Mappings
+
+	ulimits []ulimit
+	sysctls []sysctl
+	devices []device
+
+	// spec is the config of the OCI bundle of the container, if any
+	spec *spec.Spec
+	// hostPid is the PID of the child in the host PID namespace, reported to the hooks it runs
+	hostPid int
+	// resources are the limits the container is created with, the defaults if nil
+	resources *Resources
+
+	// Namespaces created for the child and the container sharing the others
+	cloneFlags  uintptr
+	nsContainer string
+
+	// child is the running child process, guarded by mu
+	mu    sync.Mutex
+	child *os.Process
 }
 
 // NewContainer creates a new Container from the given arguments.
-func NewContainer(imgName, cmd string, args []string) (*Container, error) {
-	imgRoot := filepath.Join(os.Getenv("HOME"), image.RelativeImagesPath, imgName, "rootfs")
-	cfgPath := filepath.Join(os.Getenv("HOME"), image.RelativeImagesPath, imgName, ".config.json")
+func NewContainer(imgName, cmd string, args []string, opts Options) (*Container, error) {
+	imgRoot := filepath.Join(image.Path(imgName), "rootfs")
+	cfgPath := filepath.Join(image.Path(imgName), ".config.json")
+	if opts.Rootfs {
+		root, err := filepath.Abs(imgName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve rootfs path: %v", err)
+		}
+		imgRoot, cfgPath = root, ""
+	}
 
-	cgroupName := fmt.Sprintf("gocker%d", os.Getpid())
-	cgroupPath := filepath.Join(cgroupsRoot, cgroupName)
+	// Supervisor and child processes inherit the ID of their container
+	id := os.Getenv("CONTAINER_ID")
+	if id == "" {
+		id = newID()
+	}
 
 	c := &Container{
+		Options:    opts,
+		ID:         id,
 		imgName:    imgName,
 		imgRoot:    imgRoot,
-		cgroupPath: cgroupPath,
+		dir:        containerDir(id),
+		cgroupPath: cgroupDir(id),
 		cmd:        cmd,
 		args:       args,
 	}
@@ -48,61 +171,311 @@ func NewContainer(imgName, cmd string, args []string) (*Container, error) {
 		return nil, err
 	}
 
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+
 	// Append minimal required environment variables
 	c.Env = append(c.Env, "HOME=/root", "USER=root", "SHELL=/bin/sh", "TERM=xterm")
+	if !hasEnv(c.Env, "PATH") {
+		c.Env = append(c.Env, "PATH="+defaultPath)
+	}
+	c.Env = append(c.Env, c.EnvVars...)
+
+	if c.Workdir != "" {
+		c.WorkingDir = c.Workdir
+	}
+	if c.Options.User != "" {
+		c.Config.User = c.Options.User
+	}
+	if c.Options.Hostname != "" {
+		if !c.UTS.IsPrivate() {
+			return nil, fmt.Errorf("conflicting options: --hostname can't be used with --uts=%s", c.UTS)
+		}
+		c.Config.Hostname = c.Options.Hostname
+	}
 
 	return c, nil
 }
 
+// setup validates the settings of the container and resolves
+// what the child needs to start it in its namespaces.
+func (c *Container) setup() error {
+	if err := c.setupSecurity(); err != nil {
+		return err
+	}
+
+	if err := c.setupUserNamespace(); err != nil {
+		return err
+	}
+
+	if err := c.setupHealthcheck(); err != nil {
+		return err
+	}
+
+	if err := c.validateNetwork(); err != nil {
+		return err
+	}
+
+	if err := c.resolveNamespaces(); err != nil {
+		return err
+	}
+
+	if err := c.setupUlimits(); err != nil {
+		return err
+	}
+
+	if err := c.setupSysctls(); err != nil {
+		return err
+	}
+
+	if err := c.setupDevices(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Run starts the container execution.
 func (c *Container) Run() error {
 	var err error
 
-	if os.Getenv("IS_CHILD") == "1" {
+	switch {
+	case os.Getenv("IS_CHILD") == "1":
 		err = c.runChildProcess()
-	} else {
-		err = c.runParentProcess()
+	case os.Getenv("IS_SUPERVISOR") == "1":
+		err = c.supervise()
+	default:
+		if err = c.create(); err != nil {
+			return err
+		}
+
+		if c.Detach {
+			if err = c.detach(); err == nil && c.DeferStart {
+				err = c.waitCreated()
+			}
+		} else {
+			err = c.supervise()
+		}
 	}
 
 	return err
 }
 
-// runParentProcess sets up cgroups and forks a child process with namespace isolation.
-func (c *Container) runParentProcess() error {
-	c.setupCgroup()
-	defer c.cleanupCgroup()
+// execArgs returns the arguments the supervisor and child processes are executed with.
+func (c *Container) execArgs() []string {
+	if c.ExecArgs != nil {
+		return c.ExecArgs
+	}
+
+	return os.Args[1:]
+}
 
+// runParentProcess forks a child process with namespace isolation and waits for it to exit.
+func (c *Container) runParentProcess() error {
 	// Recreate the command for the child process
-	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd := exec.Command("/proc/self/exe", c.execArgs()...)
 
-	cmd.Env = append(c.Env, "IS_CHILD=1")
+	// Keep the host HOME so the child resolves the same image and container paths
+	cmd.Env = append(c.Env, "IS_CHILD=1", "CONTAINER_ID="+c.ID, "HOME="+os.Getenv("HOME"))
 
 	// Forward all standard streams exactly as they are
 	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
 
-	// Use a new UTS. PID, Mount and User namespaces
+	// Use new namespaces unless shared, the User namespace is added along with its mappings
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags:   syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER,
+		Cloneflags:   c.cloneFlags,
 		Unshareflags: syscall.CLONE_NEWNS,
-		UidMappings: []syscall.SysProcIDMap{
-			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
-		},
-		GidMappingsEnableSetgroups: false, // disable setgroups to avoid EPERM
+	}
+	if c.cgroup != nil {
+		cmd.SysProcAttr.UseCgroupFD, cmd.SysProcAttr.CgroupFD = true, int(c.cgroup.Fd())
+	}
+
+	endpoints, err := network.ContainerEndpoints(c.ID)
+	if err != nil {
+		return err
+	}
+
+	// The child waits for its network interfaces and the hooks run on its
+	// namespaces until the write end is closed. The sync pipe of the ID
+	// mappings comes first, if there is one.
+	var syncR, syncW *os.File
+	if len(endpoints) > 0 || len(specHooks(c.spec, hookPrestart)) > 0 || len(specHooks(c.spec, hookCreateRuntime)) > 0 {
+		if syncR, syncW, err = os.Pipe(); err != nil {
+			return fmt.Errorf("failed to create sync pipe: %v", err)
+		}
+		defer syncW.Close()
+
+		fd := 3
+		if c.nsContainer == "" && c.idMappings.NeedsSync() {
+			fd++
+		}
+
+		cmd.ExtraFiles = []*os.File{syncR}
+		cmd.Env = append(cmd.Env, parentSyncEnv+"="+strconv.Itoa(fd))
+	}
+
+	// Processes killed by the OOM killer before this run don't count
+	kills, kerr := oomKills(c.cgroupPath)
+	watchOOM := c.cgroup != nil && kerr == nil
+
+	start := func() error { return idmap.Start(cmd, c.idMappings) }
+	if c.nsContainer != "" {
+		start = func() error { return c.startInNamespaces(cmd) }
+	}
+
+	err = start()
+	if syncR != nil {
+		syncR.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	if syncW != nil {
+		if err := c.prepareChild(cmd.Process.Pid, endpoints); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+		syncW.Close()
 	}
 
-	return cmd.Run()
+	c.mu.Lock()
+	c.child = cmd.Process
+	c.mu.Unlock()
+
+	UpdateState(c.ID, func(s *State) {
+		s.Pid, s.ExitSignal, s.OOMKilled = cmd.Process.Pid, "", false
+
+		// Containers waiting for Start stay created until then
+		if !c.DeferStart {
+			s.Status, s.StartedAt = StatusRunning, time.Now()
+		}
+	})
+
+	// Containers waiting for Start run their poststart hooks when started
+	if !c.DeferStart {
+		if err := runHooks(c.spec, hookPoststart, c.hookState(spec.StatusRunning, cmd.Process.Pid)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	if watchOOM {
+		stopOOM := make(chan struct{})
+		defer close(stopOOM)
+
+		go c.watchOOM(kills, stopOOM)
+	} else if c.cgroup != nil {
+		c.logWarning("OOM kills of the container aren't reported: %v", kerr)
+	}
+
+	if c.Healthcheck != nil {
+		stopHealth := make(chan struct{})
+		defer close(stopHealth)
+
+		go c.monitorHealth(cmd.Process.Pid, stopHealth)
+	}
+
+	err = cmd.Wait()
+
+	c.mu.Lock()
+	c.child = nil
+	c.mu.Unlock()
+
+	// The watcher may miss the kill that ended the container
+	if n, kerr := oomKills(c.cgroupPath); watchOOM && kerr == nil && n > kills {
+		UpdateState(c.ID, func(s *State) { s.OOMKilled = true })
+	}
+
+	return err
+}
+
+// prepareChild sets up the child waiting on the sync pipe. Its network interfaces
+// are attached first, then the prestart and createRuntime hooks run.
+func (c *Container) prepareChild(pid int, endpoints []*network.Endpoint) error {
+	if len(endpoints) > 0 {
+		if err := attachNetworks(pid, endpoints); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range []string{hookPrestart, hookCreateRuntime} {
+		if err := runHooks(c.spec, kind, c.hookState(spec.StatusCreating, pid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForParent blocks the child until the parent has set it up.
+func waitForParent() error {
+	fd, err := strconv.Atoi(os.Getenv(parentSyncEnv))
+	if err != nil {
+		return nil
+	}
+
+	f := os.NewFile(uintptr(fd), "parent-sync")
+	defer f.Close()
+
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return fmt.Errorf("failed to wait for parent: %v", err)
+	}
+
+	return nil
 }
 
 // runChildProcess performs setup for the isolated container
 // environment and executes the target command inside it.
 func (c *Container) runChildProcess() error {
+	if err := idmap.WaitForMappings(); err != nil {
+		return err
+	}
+
+	if err := waitForParent(); err != nil {
+		return err
+	}
+
+	// The proc filesystem is still the host's, which hooks get the PID from
+	if c.spec != nil {
+		pid, err := hostPid()
+		if err != nil {
+			return err
+		}
+		c.hostPid = pid
+	}
+
 	if err := c.setupNamespaces(); err != nil {
 		return err
 	}
 
+	if err := c.applySysctls(); err != nil {
+		return err
+	}
+
+	// The signal that kills the command is recorded for the supervisor, which can't tell
+	// it from an exit code otherwise. Containers that can't write their dir don't record it.
+	signalFile, err := os.OpenFile(filepath.Join(c.dir, exitSignalFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err == nil {
+		defer signalFile.Close()
+	}
+
+	// The exec FIFO and /dev/null of bundles are out of reach once the root is changed
+	var execFifo, devNull *os.File
+	if c.DeferStart {
+		if execFifo, err = openPath(filepath.Join(c.dir, execFifoFile)); err != nil {
+			return err
+		}
+		defer execFifo.Close()
+	}
+	if c.spec != nil {
+		if devNull, err = os.Open(os.DevNull); err != nil {
+			return fmt.Errorf("failed to open %s: %v", os.DevNull, err)
+		}
+		defer devNull.Close()
+	}
+
 	if err := c.setupFilesystem(); err != nil {
 		return err
 	}
@@ -112,6 +485,12 @@ func (c *Container) runChildProcess() error {
 	}
 	defer c.unmountProc()
 
+	if c.spec != nil {
+		if err := c.restrictPaths(devNull); err != nil {
+			return err
+		}
+	}
+
 	// Create the command
 	cmd := exec.Command(c.cmd, c.args...)
 
@@ -120,22 +499,101 @@ func (c *Container) runChildProcess() error {
 
 	cmd.Env, cmd.Dir = c.Env, c.WorkingDir
 
-	return cmd.Run()
+	cred, home, err := LookupUser("/", c.Config.User)
+	if err != nil {
+		return err
+	}
+
+	if cred != nil {
+		if c.spec != nil && !cred.NoSetGroups {
+			cred.Groups = append(cred.Groups, c.spec.Process.User.AdditionalGids...)
+		}
+
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+
+		// Users get their own home directory unless it was set explicitly
+		if home != "" && !hasEnv(c.EnvVars, "HOME") {
+			cmd.Env = append(cmd.Env, "HOME="+home)
+		}
+	}
+
+	if err := c.applyUlimits(); err != nil {
+		return err
+	}
+
+	if execFifo != nil {
+		if err := waitForStart(execFifo); err != nil {
+			return err
+		}
+	}
+
+	if err := runHooks(c.spec, hookStartContainer, c.hookState(spec.StatusCreated, c.hostPid)); err != nil {
+		return err
+	}
+
+	if err := c.startConfined(cmd); err != nil {
+		return err
+	}
+
+	// As PID 1 the child only receives signals it handles, so relay them to the command
+	stopForwarding := forwardSignals(cmd.Process)
+	defer stopForwarding()
+
+	err = cmd.Wait()
+
+	if sig := killedBy(err); sig != 0 && signalFile != nil {
+		fmt.Fprint(signalFile, int(sig))
+	}
+
+	return err
 }
 
-// setupNamespaces sets up namespaces isolation.
-func (c *Container) setupNamespaces() error {
-	// Unshare the mount namespace to isolate mounts from host
-	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
-		return fmt.Errorf("failed to unshare mount namespace: %v", err)
+// setupUserNamespace resolves the ID mappings of the container user namespace.
+// With --userns=host the container shares the user namespace of the host.
+func (c *Container) setupUserNamespace() error {
+	switch c.UserNS {
+	case "":
+		m, err := idmap.New(c.UIDMaps, c.GIDMaps)
+		if err != nil {
+			return err
+		}
+		c.idMappings = m
+
+	case "host":
+		if len(c.UIDMaps) > 0 || len(c.GIDMaps) > 0 {
+			return fmt.Errorf("ID mappings can't be used with --userns=host")
+		}
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("--userns=host requires root privileges")
+		}
+
+	default:
+		return fmt.Errorf("invalid user namespace mode: %q", c.UserNS)
 	}
 
+	return nil
+}
+
+// setupNamespaces sets up namespaces isolation.
+func (c *Container) setupNamespaces() error {
+	// The child starts in a new mount namespace, unsharing it here would only move the calling thread.
 	// Make all mounts private to prevent mount propagation to parent namespace
 	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
 		return fmt.Errorf("failed to make mounts private: %v", err)
 	}
 
-	if err := syscall.Sethostname([]byte(c.Hostname)); err != nil {
+	if c.netMode().IsPrivate() {
+		if err := setupLoopback(); err != nil {
+			return err
+		}
+	}
+
+	// A shared UTS namespace keeps its hostname
+	if !c.UTS.IsPrivate() {
+		return nil
+	}
+
+	if err := syscall.Sethostname([]byte(c.Config.Hostname)); err != nil {
 		return fmt.Errorf("failed to set hostname: %v", err)
 	}
 
@@ -144,6 +602,48 @@ func (c *Container) setupNamespaces() error {
 
 // setupFilesystem changes the root filesystem to the container's rootfs.
 func (c *Container) setupFilesystem() error {
+	if c.spec != nil {
+		if err := c.mountBundle(); err != nil {
+			return err
+		}
+	}
+
+	// The network files, /dev/shm and devices stay writable in read-only containers
+	if err := c.mountNetworkFiles(); err != nil {
+		return err
+	}
+
+	if err := c.mountShm(); err != nil {
+		return err
+	}
+
+	if err := c.mountDevices(); err != nil {
+		return err
+	}
+
+	if c.ReadOnly {
+		if err := mountReadOnly(c.imgRoot); err != nil {
+			return err
+		}
+	}
+
+	// A proc filesystem can only be mounted by the owner of the PID namespace
+	// and the host's is never owned by the container, so it's bind mounted instead
+	if c.PID.IsHost() {
+		procDir := filepath.Join(c.imgRoot, "proc")
+		if err := os.MkdirAll(procDir, 0555); err != nil {
+			return fmt.Errorf("failed to create proc dir: %v", err)
+		}
+
+		if err := syscall.Mount("/proc", procDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind mount host proc dir: %v", err)
+		}
+	}
+
+	if err := runHooks(c.spec, hookCreateContainer, c.hookState(spec.StatusCreating, c.hostPid)); err != nil {
+		return err
+	}
+
 	if err := os.Chdir(c.imgRoot); err != nil {
 		return fmt.Errorf("failed to change dir: %v", err)
 	}
@@ -157,6 +657,10 @@ func (c *Container) setupFilesystem() error {
 		return fmt.Errorf("failed to change dir: %v", err)
 	}
 
+	if err := os.MkdirAll(c.WorkingDir, 0755); err != nil {
+		fmt.Printf("WARNING: failed to create working dir: %v\n", err)
+	}
+
 	if err := os.Chdir(c.WorkingDir); err != nil {
 		fmt.Printf("WARNING: failed to chdir to working dir: %v\n", err)
 	}
@@ -166,6 +670,10 @@ func (c *Container) setupFilesystem() error {
 
 // mountProc mounts the /proc filesystem inside the container.
 func (c *Container) mountProc() error {
+	if c.PID.IsHost() {
+		return nil
+	}
+
 	if err := os.MkdirAll("/proc", 0555); err != nil {
 		return fmt.Errorf("failed to create proc dir: %v", err)
 	}
@@ -180,14 +688,21 @@ func (c *Container) mountProc() error {
 
 // unmountProc unmounts the /proc filesystem before exiting.
 func (c *Container) unmountProc() {
-	if err := syscall.Unmount("/proc", 0); err != nil {
+	// Masked and read-only paths of bundles are mounted on top of it
+	if err := syscall.Unmount("/proc", syscall.MNT_DETACH); err != nil {
 		fmt.Printf("WARNING: failed to unmount proc dir: %v\n", err)
 	}
 }
 
 // fromFile loads environment variables, hostname,
 // and working directory from the image config file.
+// Root filesystems without an image config get the defaults.
 func (c *Container) fromFile(cfgPath string) error {
+	if cfgPath == "" {
+		c.WorkingDir, c.Config.Hostname = "/", shortID(c.ID)
+		return nil
+	}
+
 	cfgFile, err := os.Open(cfgPath)
 	if err != nil {
 		return fmt.Errorf("failed to open config file: %s", cfgPath)
@@ -204,52 +719,51 @@ func (c *Container) fromFile(cfgPath string) error {
 	if c.WorkingDir == "" {
 		c.WorkingDir = "/"
 	}
-	if c.Hostname == "" {
-		c.Hostname = c.imgName + "-container"
+	if c.Config.Hostname == "" {
+		c.Config.Hostname = c.imgName + "-container"
 	}
 
 	return nil
 }
 
-// setupCgroup creates and configures a new v2 cgroup for the container process.
+// setupCgroup creates and configures a new v2 cgroup for the container process
+// with the limits in the container state. The supervisor stays out of it, so
+// freezing the cgroup only pauses the container.
 func (c *Container) setupCgroup() error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(cgroupsRoot, &fs); err != nil || fs.Type != cgroup2SuperMagic {
+		return fmt.Errorf("cgroup v2 is not mounted at %s", cgroupsRoot)
+	}
+
 	if err := os.MkdirAll(c.cgroupPath, 0755); err != nil {
 		return fmt.Errorf("failed to create cgroup v2 path: %v", err)
 	}
 
-	// Set container's memory limit
-	memoryMaxFile := filepath.Join(c.cgroupPath, "memory.max")
-	if err := os.WriteFile(memoryMaxFile, []byte("50M"), 0644); err != nil {
-		return fmt.Errorf("failed to set memory limit: %v", err)
+	s, err := LoadState(c.ID)
+	if err != nil {
+		return err
 	}
 
-	// Set container's CPU limit (20%)
-	// Format: "<max> <period>" where max and period are in microseconds
-	cpuMaxFile := filepath.Join(c.cgroupPath, "cpu.max")
-	if err := os.WriteFile(cpuMaxFile, []byte("20000 100000"), 0644); err != nil {
-		return fmt.Errorf("failed to set CPU limit: %v", err)
+	if err := s.resources().write(c.cgroupPath); err != nil {
+		return err
 	}
 
-	// Add current process to the cgroup
-	cgroupProcsFile := filepath.Join(c.cgroupPath, "cgroup.procs")
-	if err := os.WriteFile(cgroupProcsFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
-		return fmt.Errorf("failed to add process to cgroup: %v", err)
+	// The child is cloned right into the cgroup
+	cgroup, err := os.Open(c.cgroupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup: %v", err)
 	}
+	c.cgroup = cgroup
 
 	return nil
 }
 
 // cleanupCgroup removes the custom cgroup created for the container process.
 func (c *Container) cleanupCgroup() {
-	rootProcs := filepath.Join(cgroupsRoot, "cgroup.procs")
-	selfPid := []byte(strconv.Itoa(os.Getpid()))
-
-	// Move the current process back to the root cgroup
-	if err := os.WriteFile(rootProcs, selfPid, 0644); err != nil {
-		fmt.Printf("Warning: Failed to move process out of cgroup: %v\n", err)
+	if c.cgroup != nil {
+		c.cgroup.Close()
 	}
 
-	// Now it's safe to remove the cgroup directory
 	if err := os.RemoveAll(c.cgroupPath); err != nil {
 		fmt.Printf("Warning: Failed to remove cgroup directory: %v\n", err)
 	}
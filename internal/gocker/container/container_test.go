@@ -5,10 +5,14 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
 )
 
 const gocker = "../../../bin/gocker"
@@ -118,7 +122,7 @@ func TestExitCodes(t *testing.T) {
 	}
 }
 
-// TestNamespaceIsolation tests that UTS namespace is properly isolated
+// TestNamespaceIsolation tests that namespaces are isolated or shared as requested
 func TestNamespaceIsolation(t *testing.T) {
 	// Skip test if not running as root (required for namespaces)
 	if os.Geteuid() != 0 {
@@ -152,6 +156,85 @@ func TestNamespaceIsolation(t *testing.T) {
 	if currentHostname != originalHostname {
 		t.Errorf("Host hostname changed from %q to %q", originalHostname, currentHostname)
 	}
+
+	// Start a container to share namespaces with
+	output, err = exec.Command(gocker, "run", "-d", "alpine", "sleep", "30").Output()
+	if err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	defer exec.Command(gocker, "rm", "-f", id).Run()
+
+	time.Sleep(time.Second)
+
+	s, err := FindState(id)
+	if err != nil {
+		t.Fatalf("Failed to load container state: %v", err)
+	}
+
+	tests := []struct {
+		namespace string
+		args      []string
+		expected  string // host, container or private
+	}{
+		{namespace: "ipc", expected: "private"},
+		{namespace: "cgroup", expected: "private"},
+		{namespace: "time", expected: "private"},
+		{namespace: "ipc", args: []string{"--ipc", "host"}, expected: "host"},
+		{namespace: "pid", args: []string{"--pid", "host"}, expected: "host"},
+		{namespace: "uts", args: []string{"--uts", "host"}, expected: "host"},
+		{namespace: "ipc", args: []string{"--ipc", "container:" + id}, expected: "container"},
+		{namespace: "pid", args: []string{"--pid", "container:" + id}, expected: "container"},
+		{namespace: "uts", args: []string{"--uts", "container:" + id}, expected: "container"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			nsPath := "/proc/self/ns/" + tt.namespace
+
+			args := append(append([]string{"run", "--rm"}, tt.args...), "alpine", "readlink", nsPath)
+			output, err := exec.Command(gocker, args...).Output()
+			if err != nil {
+				t.Fatalf("Failed to run container: %v", err)
+			}
+			containerNS := strings.TrimSpace(strings.Split(string(output), "\n")[0])
+
+			hostNS, err := os.Readlink(nsPath)
+			if err != nil {
+				t.Fatalf("Failed to read host namespace: %v", err)
+			}
+
+			sharedNS, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", s.Pid, tt.namespace))
+			if err != nil {
+				t.Fatalf("Failed to read container namespace: %v", err)
+			}
+
+			switch tt.expected {
+			case "host":
+				if containerNS != hostNS {
+					t.Errorf("Expected host namespace %s, got %s", hostNS, containerNS)
+				}
+			case "container":
+				if containerNS != sharedNS {
+					t.Errorf("Expected namespace %s of container, got %s", sharedNS, containerNS)
+				}
+			default:
+				if containerNS == hostNS || containerNS == sharedNS {
+					t.Errorf("Expected a private namespace, got %s", containerNS)
+				}
+			}
+		})
+	}
+
+	// The container sees the processes of the one it shares the PID namespace with
+	output, err = exec.Command(gocker, "run", "--rm", "--pid", "container:"+id, "alpine", "ps").Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+
+	if !strings.Contains(string(output), "sleep 30") {
+		t.Errorf("Expected the shared container's process in ps output, got:\n%s", output)
+	}
 }
 
 // TestFilesystemIsolation tests that the container can't access host files
@@ -434,6 +517,55 @@ func TestMemoryLimit(t *testing.T) {
 	}
 }
 
+// TestExitSignal tests that a container killed by a signal is told apart from one exiting normally
+func TestExitSignal(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping exit signal test: requires root privileges")
+	}
+
+	output, err := exec.Command(gocker, "run", "-d", "alpine", "sleep", "30").Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	defer exec.Command(gocker, "rm", "-f", id).Run()
+
+	time.Sleep(500 * time.Millisecond)
+
+	s, err := FindState(id)
+	if err != nil {
+		t.Fatalf("Failed to load container state: %v", err)
+	}
+	pid := strconv.Itoa(s.Pid)
+
+	// The command is the only child of the container init
+	children, err := os.ReadFile("/proc/" + pid + "/task/" + pid + "/children")
+	if err != nil {
+		t.Fatalf("Failed to find container command: %v", err)
+	}
+	command, err := strconv.Atoi(strings.TrimSpace(string(children)))
+	if err != nil {
+		t.Fatalf("Unexpected children of container init: %q", children)
+	}
+
+	if err := syscall.Kill(command, syscall.SIGKILL); err != nil {
+		t.Fatalf("Failed to kill container command: %v", err)
+	}
+
+	output, err = exec.Command(gocker, "wait", id).Output()
+	if err != nil {
+		t.Fatalf("Failed to wait for container: %v", err)
+	}
+	if code := strings.TrimSpace(string(output)); code != "137" {
+		t.Errorf("Expected exit code 137, got %s", code)
+	}
+
+	output, _ = exec.Command(gocker, "inspect", id).Output()
+	if !strings.Contains(string(output), `"ExitSignal": "SIGKILL"`) || !strings.Contains(string(output), `"OOMKilled": false`) {
+		t.Errorf("Expected the container to be killed by SIGKILL, got:\n%s", output)
+	}
+}
+
 func TestCPULimit(t *testing.T) {
 	start := time.Now()
 
@@ -455,3 +587,394 @@ func TestCPULimit(t *testing.T) {
 		t.Error("CPU limit may not be enforced: task finished too quickly")
 	}
 }
+
+// TestStopTimeout tests that stop kills a container ignoring SIGTERM after the timeout
+func TestStopTimeout(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping stop timeout test: requires root privileges")
+	}
+
+	output, err := exec.Command(gocker, "run", "-d", "alpine", "sh", "-c", "trap '' TERM; sleep 30").Output()
+	if err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	defer exec.Command(gocker, "rm", "-f", id).Run()
+
+	time.Sleep(time.Second)
+
+	start := time.Now()
+	if err := exec.Command(gocker, "stop", "-t", "1", id).Run(); err != nil {
+		t.Fatalf("Failed to stop container: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stop took %v, expected the container to be killed after the timeout", elapsed)
+	}
+
+	output, err = exec.Command(gocker, "wait", id).Output()
+	if err != nil {
+		t.Fatalf("Failed to wait for container: %v", err)
+	}
+
+	if code := strings.TrimSpace(string(output)); code != "137" {
+		t.Errorf("Expected exit code 137 for a killed container, got %s", code)
+	}
+}
+
+// TestRestartPolicy tests that on-failure restarts the container up to the retry limit
+func TestRestartPolicy(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping restart policy test: requires root privileges")
+	}
+
+	output, err := exec.Command(gocker, "run", "-d", "--restart", "on-failure:2", "alpine", "sh", "-c", "exit 3").Output()
+	if err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	id := strings.TrimSpace(string(output))
+	defer exec.Command(gocker, "rm", "-f", id).Run()
+
+	output, err = exec.Command(gocker, "wait", id).Output()
+	if err != nil {
+		t.Fatalf("Failed to wait for container: %v", err)
+	}
+
+	if code := strings.TrimSpace(string(output)); code != "3" {
+		t.Errorf("Expected exit code 3, got %s", code)
+	}
+
+	s, err := FindState(id)
+	if err != nil {
+		t.Fatalf("Failed to load container state: %v", err)
+	}
+
+	if s.RestartCount != 2 {
+		t.Errorf("Expected 2 restarts, got %d", s.RestartCount)
+	}
+}
+
+// TestSecurityOptions tests capability dropping, no_new_privs, seccomp and read-only rootfs
+func TestSecurityOptions(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping security options test: requires root privileges")
+	}
+
+	cmd := exec.Command(gocker, "run", "--rm", "--cap-drop", "ALL", "--security-opt", "no-new-privileges",
+		"alpine", "grep", "-E", "^(CapEff|NoNewPrivs|Seccomp):", "/proc/self/status")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+
+	for _, expected := range []string{"CapEff:\t0000000000000000", "NoNewPrivs:\t1", "Seccomp:\t2"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Expected %q in process status, got:\n%s", expected, output)
+		}
+	}
+
+	cmd = exec.Command(gocker, "run", "--rm", "--read-only", "alpine", "touch", "/read_only_test_file")
+	if err := cmd.Run(); err == nil {
+		t.Error("Expected write to a read-only rootfs to fail")
+	}
+}
+
+// TestNameResolution tests the generated /etc/hosts, /etc/resolv.conf and hostname
+func TestNameResolution(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping name resolution test: requires root privileges")
+	}
+
+	cmd := exec.Command(gocker, "run", "--rm", "--hostname", "web", "--add-host", "db:10.0.0.5",
+		"--dns", "1.1.1.1", "--dns-search", "example.com", "alpine", "cat", "/etc/hostname", "/etc/hosts", "/etc/resolv.conf")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+
+	for _, expected := range []string{"web\n", "10.0.0.5\tdb\n", "127.0.1.1\tweb\n", "nameserver 1.1.1.1\n", "search example.com\n"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Expected %q in the network files, got:\n%s", expected, output)
+		}
+	}
+
+	cmd = exec.Command(gocker, "run", "--rm", "--uts", "host", "--hostname", "web", "alpine", "true")
+	if err := cmd.Run(); err == nil {
+		t.Error("Expected --hostname to conflict with --uts=host")
+	}
+}
+
+// TestNetworks tests that containers on the same network resolve each other by name and alias
+func TestNetworks(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping networks test: requires root privileges")
+	}
+
+	if err := exec.Command(gocker, "network", "create", "--subnet", "10.123.0.0/24", "testnet").Run(); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	defer exec.Command(gocker, "network", "rm", "testnet").Run()
+
+	cmd := exec.Command(gocker, "run", "-d", "--name", "netdb", "--network", "testnet", "--network-alias", "database",
+		"--ip", "10.123.0.10", "alpine", "sleep", "30")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	defer exec.Command(gocker, "rm", "-f", "netdb").Run()
+
+	for _, name := range []string{"netdb", "database"} {
+		output, err := exec.Command(gocker, "run", "--rm", "--network", "testnet", "alpine", "nslookup", name).CombinedOutput()
+		if err != nil {
+			t.Errorf("Failed to resolve %s: %v\n%s", name, err, output)
+			continue
+		}
+
+		if !strings.Contains(string(output), "10.123.0.10") {
+			t.Errorf("Expected %s to resolve to 10.123.0.10, got:\n%s", name, output)
+		}
+	}
+
+	if err := exec.Command(gocker, "run", "--rm", "--network", "testnet", "--ip", "10.123.0.10", "alpine", "true").Run(); err == nil {
+		t.Error("Expected an address in use to be rejected")
+	}
+}
+
+// TestHealthcheck tests that health checks run inside the container and update its health status
+func TestHealthcheck(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping health check test: requires root privileges")
+	}
+
+	for name, cmd := range map[string]string{"healthy": "cat /etc/hostname", "unhealthy": "cat /nonexistent"} {
+		run := exec.Command(gocker, "run", "-d", "--name", "hc-"+name, "--health-cmd", cmd,
+			"--health-interval", "100ms", "--health-retries", "1", "alpine", "sleep", "10")
+		if err := run.Run(); err != nil {
+			t.Fatalf("Failed to run container: %v", err)
+		}
+		defer exec.Command(gocker, "rm", "-f", "hc-"+name).Run()
+	}
+
+	time.Sleep(time.Second)
+
+	for _, name := range []string{"healthy", "unhealthy"} {
+		output, err := exec.Command(gocker, "inspect", "hc-"+name).Output()
+		if err != nil {
+			t.Fatalf("Failed to inspect container: %v", err)
+		}
+
+		if !strings.Contains(string(output), `"Status": "`+name+`"`) {
+			t.Errorf("Expected the container to be %s, got:\n%s", name, output)
+		}
+	}
+}
+
+// TestPause tests that paused containers are frozen until they're unpaused
+func TestPause(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping pause test: requires root privileges")
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(cgroupsRoot, &fs); err != nil || fs.Type != cgroup2SuperMagic {
+		t.Skip("Skipping pause test: requires cgroup v2")
+	}
+
+	if err := exec.Command(gocker, "run", "-d", "--name", "paused", "alpine", "sleep", "10").Run(); err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	defer exec.Command(gocker, "rm", "-f", "paused").Run()
+
+	if output, err := exec.Command(gocker, "pause", "paused").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to pause container: %v\n%s", err, output)
+	}
+
+	output, _ := exec.Command(gocker, "ps").Output()
+	if !strings.Contains(string(output), "(Paused)") {
+		t.Errorf("Expected the container to be listed as paused, got:\n%s", output)
+	}
+
+	if err := exec.Command(gocker, "update", "--memory", "64m", "--cpus", "0.5", "paused").Run(); err != nil {
+		t.Errorf("Failed to update container: %v", err)
+	}
+
+	if err := exec.Command(gocker, "unpause", "paused").Run(); err != nil {
+		t.Errorf("Failed to unpause container: %v", err)
+	}
+	if err := exec.Command(gocker, "unpause", "paused").Run(); err == nil {
+		t.Error("Expected a running container not to be unpaused")
+	}
+}
+
+// TestCopy tests copying files into a container and back out of it
+func TestCopy(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping copy test: requires root privileges")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/copied.txt", []byte("copied"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exec.Command(gocker, "run", "-d", "--name", "copy", "alpine", "sleep", "10").Run(); err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	defer exec.Command(gocker, "rm", "-f", "copy").Run()
+
+	if output, err := exec.Command(gocker, "cp", dir+"/copied.txt", "copy:/tmp/gocker-cp-test.txt").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to copy into container: %v\n%s", err, output)
+	}
+	defer exec.Command(gocker, "run", "--rm", "alpine", "rm", "/tmp/gocker-cp-test.txt").Run()
+
+	output, err := exec.Command(gocker, "run", "--rm", "alpine", "cat", "/tmp/gocker-cp-test.txt").Output()
+	if err != nil || string(output) != "copied" {
+		t.Errorf("Expected the copied file in the container, got %q (%v)", output, err)
+	}
+
+	if output, err := exec.Command(gocker, "cp", "copy:/tmp/gocker-cp-test.txt", dir+"/back.txt").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to copy from container: %v\n%s", err, output)
+	}
+
+	if data, err := os.ReadFile(dir + "/back.txt"); err != nil || string(data) != "copied" {
+		t.Errorf("Expected the file copied back, got %q (%v)", data, err)
+	}
+
+	if err := exec.Command(gocker, "cp", "copy:/../../etc/shadow", dir+"/shadow").Run(); err == nil {
+		t.Error("Expected a file outside of the container root not to be copied")
+	}
+}
+
+// TestTop tests that the processes of a running container are listed
+func TestTop(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping top test: requires root privileges")
+	}
+
+	if err := exec.Command(gocker, "run", "-d", "--name", "top", "alpine", "sleep", "10").Run(); err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	defer exec.Command(gocker, "rm", "-f", "top").Run()
+
+	time.Sleep(500 * time.Millisecond)
+
+	output, err := exec.Command(gocker, "top", "top").Output()
+	if err != nil {
+		t.Fatalf("Failed to list container processes: %v", err)
+	}
+
+	if !strings.HasPrefix(string(output), "UID") || !strings.Contains(string(output), "sleep 10") {
+		t.Errorf("Expected the sleep process to be listed, got:\n%s", output)
+	}
+}
+
+// TestLimitsAndDevices tests that the resource limits, sysctls, /dev/shm and devices are set up
+func TestLimitsAndDevices(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping limits test: requires root privileges")
+	}
+
+	// The init process of the container starts the command with its limits
+	output, err := exec.Command(gocker, "run", "--rm", "--ulimit", "nofile=1024:2048", "alpine", "cat", "/proc/1/limits").Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	if !strings.Contains(string(output), "Max open files            1024                 2048") {
+		t.Errorf("Expected the open files limit to be 1024:2048, got:\n%s", output)
+	}
+
+	output, err = exec.Command(gocker, "run", "--rm", "--network", "none", "--sysctl", "net.core.somaxconn=1234",
+		"alpine", "cat", "/proc/sys/net/core/somaxconn").Output()
+	if err != nil || strings.TrimSpace(string(output)) != "1234" {
+		t.Errorf("Expected net.core.somaxconn to be 1234, got %q (%v)", output, err)
+	}
+
+	if err := exec.Command(gocker, "run", "--rm", "--sysctl", "kernel.pid_max=100", "alpine", "true").Run(); err == nil {
+		t.Error("Expected a sysctl that isn't namespaced to be rejected")
+	}
+
+	output, err = exec.Command(gocker, "run", "--rm", "--shm-size", "16m", "--device", "/dev/null:/dev/gocker-null:r",
+		"alpine", "cat", "/proc/mounts").Output()
+	if err != nil {
+		t.Fatalf("Failed to run container: %v", err)
+	}
+	if !strings.Contains(string(output), "/dev/shm tmpfs") || !strings.Contains(string(output), "size=16384k") {
+		t.Errorf("Expected a 16MB /dev/shm, got:\n%s", output)
+	}
+	if !strings.Contains(string(output), "/dev/gocker-null") {
+		t.Errorf("Expected /dev/null to be mounted at /dev/gocker-null, got:\n%s", output)
+	}
+}
+
+// TestRuntime tests the lifecycle of a container created from an OCI bundle
+func TestRuntime(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping runtime test: requires root privileges")
+	}
+
+	bundle := t.TempDir()
+
+	output, err := exec.Command(gocker, "spec", "--bundle", bundle).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to create bundle config: %v: %s", err, output)
+	}
+
+	// The bundle runs on the root filesystem of the image
+	config := filepath.Join(bundle, "config.json")
+	data, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootfs := filepath.Join(image.Path("alpine"), "rootfs")
+	data = []byte(strings.Replace(string(data), `"path": "rootfs"`, `"path": "`+rootfs+`"`, 1))
+	data = []byte(strings.Replace(string(data), `"sh"`, `"sleep", "30"`, 1))
+	if err := os.WriteFile(config, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state := func() string {
+		output, err := exec.Command(gocker, "runtime", "state", "oci-test").Output()
+		if err != nil {
+			t.Fatalf("Failed to get container state: %v", err)
+		}
+		return string(output)
+	}
+
+	if output, err := exec.Command(gocker, "runtime", "create", "--bundle", bundle, "oci-test").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create container: %v: %s", err, output)
+	}
+	defer exec.Command(gocker, "runtime", "delete", "--force", "oci-test").Run()
+
+	if s := state(); !strings.Contains(s, `"status": "created"`) || !strings.Contains(s, `"bundle": "`+bundle+`"`) {
+		t.Errorf("Expected a created container, got:\n%s", s)
+	}
+
+	if err := exec.Command(gocker, "runtime", "start", "oci-test").Run(); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	if s := state(); !strings.Contains(s, `"status": "running"`) {
+		t.Errorf("Expected a running container, got:\n%s", s)
+	}
+
+	if err := exec.Command(gocker, "runtime", "delete", "oci-test").Run(); err == nil {
+		t.Error("Expected deleting a running container to fail")
+	}
+
+	if err := exec.Command(gocker, "runtime", "kill", "oci-test", "KILL").Run(); err != nil {
+		t.Fatalf("Failed to kill container: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(state(), `"status": "stopped"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the container to stop, got:\n%s", state())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := exec.Command(gocker, "runtime", "delete", "oci-test").Run(); err != nil {
+		t.Errorf("Failed to delete container: %v", err)
+	}
+	if err := exec.Command(gocker, "runtime", "state", "oci-test").Run(); err == nil {
+		t.Error("Expected the deleted container to be gone")
+	}
+}
@@ -0,0 +1,181 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+// defaultShmSize is the size of the /dev/shm of containers, like docker's.
+const defaultShmSize = 64 * 1024 * 1024
+
+// device is a host device made available in the container with --device.
+type device struct {
+	hostPath, path string
+	writable       bool
+}
+
+// parseDevice parses a --device value in the host_path[:container_path][:permissions] format.
+// The permissions are a combination of r, w and m, rwm by default.
+func parseDevice(value string) (device, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return device{}, fmt.Errorf("invalid device specification: %q", value)
+	}
+
+	d := device{hostPath: parts[0], path: parts[0], writable: true}
+
+	perms := ""
+	switch len(parts) {
+	case 2:
+		// The second part is either the path in the container or the permissions
+		if isDevicePermissions(parts[1]) {
+			perms = parts[1]
+		} else {
+			d.path = parts[1]
+		}
+	case 3:
+		d.path, perms = parts[1], parts[2]
+		if !isDevicePermissions(perms) {
+			return device{}, fmt.Errorf("invalid device permissions %q in %q", perms, value)
+		}
+	}
+
+	if perms != "" {
+		d.writable = strings.Contains(perms, "w")
+	}
+
+	if !filepath.IsAbs(d.hostPath) || !filepath.IsAbs(d.path) {
+		return device{}, fmt.Errorf("device paths must be absolute: %q", value)
+	}
+
+	return d, nil
+}
+
+// isDevicePermissions reports whether the string is a combination of the r, w and m permissions.
+func isDevicePermissions(s string) bool {
+	if s == "" || len(s) > 3 {
+		return false
+	}
+
+	for _, p := range s {
+		if !strings.ContainsRune("rwm", p) || strings.Count(s, string(p)) > 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setupDevices validates the --device and --shm-size values.
+func (c *Container) setupDevices() error {
+	for _, value := range c.Devices {
+		d, err := parseDevice(value)
+		if err != nil {
+			return err
+		}
+
+		fi, err := os.Stat(d.hostPath)
+		if err != nil {
+			return fmt.Errorf("error gathering device information while adding custom device %q: %v", d.hostPath, err)
+		}
+		if fi.Mode()&os.ModeDevice == 0 {
+			return fmt.Errorf("%s is not a device", d.hostPath)
+		}
+
+		c.devices = append(c.devices, d)
+	}
+
+	if c.ShmSize < 0 {
+		return fmt.Errorf("shm size can't be negative")
+	}
+	if c.ShmSize > 0 && !c.IPC.IsPrivate() {
+		return fmt.Errorf("conflicting options: --shm-size can't be used with --ipc=%s", c.IPC)
+	}
+
+	return nil
+}
+
+// mountDevices bind mounts the devices of the container into its root filesystem.
+// Devices without the w permission are mounted read-only.
+func (c *Container) mountDevices() error {
+	for _, d := range c.devices {
+		target, err := image.ResolveInRoot(c.imgRoot, d.path)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory of device %s: %v", d.path, err)
+		}
+
+		// Mounts follow symlinks, which would resolve against the host's root,
+		// so the mount point is replaced with a regular file
+		if fi, err := os.Lstat(target); err == nil && !fi.Mode().IsRegular() {
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("failed to replace %s: %v", d.path, err)
+			}
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create mount point of device %s: %v", d.path, err)
+		}
+		f.Close()
+
+		if err := syscall.Mount(d.hostPath, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind mount device %s: %v", d.hostPath, err)
+		}
+
+		if d.writable {
+			continue
+		}
+
+		if err := remountBind(target, syscall.MS_RDONLY); err != nil {
+			return fmt.Errorf("failed to remount device %s read-only: %v", d.path, err)
+		}
+	}
+
+	return nil
+}
+
+// mountShm mounts the /dev/shm of a container with a private IPC namespace, a tmpfs
+// of the --shm-size size. Containers sharing an IPC namespace keep the /dev/shm of the image.
+func (c *Container) mountShm() error {
+	// Bundles mount their own
+	if !c.IPC.IsPrivate() || c.spec != nil {
+		return nil
+	}
+
+	size := c.ShmSize
+	if size == 0 {
+		size = defaultShmSize
+	}
+
+	target, err := image.ResolveInRoot(c.imgRoot, "/dev/shm")
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace /dev/shm: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(target, 01777); err != nil {
+		return fmt.Errorf("failed to create /dev/shm: %v", err)
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("shm", target, "tmpfs", flags, "mode=1777,size="+strconv.FormatInt(size, 10)); err != nil {
+		return fmt.Errorf("failed to mount /dev/shm: %v", err)
+	}
+
+	return nil
+}
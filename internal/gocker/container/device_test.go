@@ -0,0 +1,26 @@
+package container
+
+import "testing"
+
+// TestParseDevice tests the parsing of --device values
+func TestParseDevice(t *testing.T) {
+	tests := map[string]device{
+		"/dev/fuse":                 {"/dev/fuse", "/dev/fuse", true},
+		"/dev/fuse:r":               {"/dev/fuse", "/dev/fuse", false},
+		"/dev/sda:/dev/xvda":        {"/dev/sda", "/dev/xvda", true},
+		"/dev/sda:/dev/xvda:rm":     {"/dev/sda", "/dev/xvda", false},
+		"/dev/null:/dev/mynull:rwm": {"/dev/null", "/dev/mynull", true},
+	}
+
+	for value, expected := range tests {
+		if d, err := parseDevice(value); err != nil || d != expected {
+			t.Errorf("Expected %q to be parsed as %+v, got %+v (%v)", value, expected, d, err)
+		}
+	}
+
+	for _, value := range []string{"", "dev/fuse", "/dev/fuse:fuse", "/dev/fuse:/dev/fuse:x", "/dev/fuse:/dev/fuse:rr", "/a:/b:r:w"} {
+		if _, err := parseDevice(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+const (
+	// Defaults of the health check settings, like docker's
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 30 * time.Second
+	defaultHealthRetries  = 3
+
+	// maxHealthLog is the number of health check results kept in the container state
+	maxHealthLog = 5
+
+	// maxHealthOutput limits the output of a health check kept in the container state
+	maxHealthOutput = 4096
+)
+
+// HealthStatus describes the health of a running container.
+type HealthStatus string
+
+const (
+	HealthStarting  HealthStatus = "starting"
+	HealthHealthy   HealthStatus = "healthy"
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// Health is the health check record of a container.
+type Health struct {
+	Status        HealthStatus
+	FailingStreak int
+	Log           []HealthResult
+}
+
+// HealthResult is the outcome of a single health check.
+type HealthResult struct {
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Output   string
+}
+
+// setupHealthcheck resolves the health check of the container from the image
+// config and the --health-* overrides. Containers without a test have none.
+func (c *Container) setupHealthcheck() error {
+	hc := registry.HealthConfig{}
+	if c.Healthcheck != nil {
+		hc = *c.Healthcheck
+	}
+
+	if c.HealthCmd != "" {
+		hc.Test = []string{"CMD-SHELL", c.HealthCmd}
+	}
+
+	for _, d := range []struct {
+		value    *time.Duration
+		override time.Duration
+		name     string
+	}{
+		{&hc.Interval, c.HealthInterval, "interval"},
+		{&hc.Timeout, c.HealthTimeout, "timeout"},
+		{&hc.StartPeriod, c.HealthStartPeriod, "start period"},
+	} {
+		if d.override < 0 {
+			return fmt.Errorf("health check %s can't be negative", d.name)
+		}
+		if d.override > 0 {
+			*d.value = d.override
+		}
+	}
+
+	if c.HealthRetries < 0 {
+		return fmt.Errorf("health check retries can't be negative")
+	}
+	if c.HealthRetries > 0 {
+		hc.Retries = c.HealthRetries
+	}
+
+	if hc.Interval == 0 {
+		hc.Interval = defaultHealthInterval
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = defaultHealthTimeout
+	}
+	if hc.Retries == 0 {
+		hc.Retries = defaultHealthRetries
+	}
+
+	if len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+		c.Healthcheck = nil
+		return nil
+	}
+
+	if _, err := healthCommand(hc.Test); err != nil {
+		return err
+	}
+
+	c.Healthcheck = &hc
+
+	return nil
+}
+
+// healthCommand returns the command line of a health check test.
+func healthCommand(test []string) ([]string, error) {
+	switch {
+	case test[0] == "CMD" && len(test) > 1:
+		return test[1:], nil
+	case test[0] == "CMD-SHELL" && len(test) == 2:
+		return []string{"/bin/sh", "-c", test[1]}, nil
+	}
+
+	return nil, fmt.Errorf("invalid health check test: %q", test)
+}
+
+// monitorHealth periodically runs the health check inside the container with the
+// given init PID and records the results in its state, until stop is closed.
+func (c *Container) monitorHealth(pid int, stop <-chan struct{}) {
+	started := time.Now()
+
+	UpdateState(c.ID, func(s *State) {
+		if s.Health == nil {
+			s.Health = &Health{}
+		}
+		s.Health.Status, s.Health.FailingStreak = HealthStarting, 0
+	})
+
+	ticker := time.NewTicker(c.Healthcheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		result := c.runHealthcheck(pid)
+
+		// Once stopped, the container is no longer unhealthy
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		UpdateState(c.ID, func(s *State) {
+			if s.Health == nil {
+				s.Health = &Health{Status: HealthStarting}
+			}
+			s.Health.record(result, c.Healthcheck.Retries, time.Since(started) < c.Healthcheck.StartPeriod)
+		})
+	}
+}
+
+// runHealthcheck runs the health check command in the namespaces and root of the container.
+func (c *Container) runHealthcheck(pid int) HealthResult {
+	result := HealthResult{Start: time.Now(), ExitCode: -1}
+
+	args, err := healthCommand(c.Healthcheck.Test)
+	if err != nil {
+		result.End, result.Output = time.Now(), err.Error()
+		return result
+	}
+
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		result.End, result.Output = time.Now(), fmt.Sprintf("nsenter is required to run health checks: %v", err)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Healthcheck.Timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, nsenter, append([]string{"--target", strconv.Itoa(pid), "--all", "--root", "--wd", "--"}, args...)...)
+	cmd.Env, cmd.Stdout, cmd.Stderr = c.Env, &out, &out
+
+	// nsenter forks into the PID namespace, so the whole process group is killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	result.End = time.Now()
+
+	output := out.Bytes()
+	if len(output) > maxHealthOutput {
+		output = output[:maxHealthOutput]
+	}
+	result.Output = string(output)
+
+	switch code, exited := ExitCode(err); {
+	case ctx.Err() != nil:
+		result.Output = fmt.Sprintf("Health check exceeded timeout (%v)", c.Healthcheck.Timeout)
+	case !exited:
+		result.Output = fmt.Sprintf("failed to run health check: %v", err)
+	default:
+		result.ExitCode = code
+	}
+
+	return result
+}
+
+// record adds the result of a check to the health record. The container becomes unhealthy
+// after the given number of consecutive failures, which don't count during its start period.
+func (h *Health) record(result HealthResult, retries int, starting bool) {
+	h.Log = append(h.Log, result)
+	if len(h.Log) > maxHealthLog {
+		h.Log = h.Log[len(h.Log)-maxHealthLog:]
+	}
+
+	if result.ExitCode == 0 {
+		h.Status, h.FailingStreak = HealthHealthy, 0
+		return
+	}
+
+	if starting && h.Status == HealthStarting {
+		return
+	}
+
+	h.FailingStreak++
+	if h.FailingStreak >= retries {
+		h.Status = HealthUnhealthy
+	}
+}
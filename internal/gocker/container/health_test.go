@@ -0,0 +1,144 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// TestSetupHealthcheck tests that the --health-* options override the health check of the image
+func TestSetupHealthcheck(t *testing.T) {
+	image := &registry.HealthConfig{Test: []string{"CMD", "check"}, Interval: time.Minute, Retries: 5}
+
+	tests := []struct {
+		name          string
+		image         *registry.HealthConfig
+		opts          Options
+		expected      *registry.HealthConfig
+		expectedError bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "image",
+			image:    image,
+			expected: &registry.HealthConfig{Test: []string{"CMD", "check"}, Interval: time.Minute, Timeout: defaultHealthTimeout, Retries: 5},
+		},
+		{
+			name:  "overrides",
+			image: image,
+			opts:  Options{HealthCmd: "check --quick", HealthInterval: time.Second, HealthStartPeriod: time.Hour, HealthRetries: 1},
+			expected: &registry.HealthConfig{Test: []string{"CMD-SHELL", "check --quick"}, Interval: time.Second,
+				Timeout: defaultHealthTimeout, StartPeriod: time.Hour, Retries: 1},
+		},
+		{
+			name:     "defaults",
+			opts:     Options{HealthCmd: "check"},
+			expected: &registry.HealthConfig{Test: []string{"CMD-SHELL", "check"}, Interval: defaultHealthInterval, Timeout: defaultHealthTimeout, Retries: defaultHealthRetries},
+		},
+		{
+			name:  "disabled",
+			image: &registry.HealthConfig{Test: []string{"NONE"}},
+		},
+		{
+			name:          "invalid test",
+			image:         &registry.HealthConfig{Test: []string{"CMD-SHELL", "check", "extra"}},
+			expectedError: true,
+		},
+		{
+			name:          "negative interval",
+			opts:          Options{HealthCmd: "check", HealthInterval: -time.Second},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Options: tt.opts}
+			if tt.image != nil {
+				hc := *tt.image
+				c.Healthcheck = &hc
+			}
+
+			err := c.setupHealthcheck()
+
+			if tt.expectedError {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			switch {
+			case tt.expected == nil && c.Healthcheck != nil:
+				t.Errorf("Expected no health check, got %+v", c.Healthcheck)
+			case tt.expected != nil && c.Healthcheck == nil:
+				t.Errorf("Expected health check %+v, got none", tt.expected)
+			case tt.expected != nil && !equalHealthConfig(tt.expected, c.Healthcheck):
+				t.Errorf("Expected health check %+v, got %+v", tt.expected, c.Healthcheck)
+			}
+		})
+	}
+}
+
+// equalHealthConfig reports whether two health check settings are the same.
+func equalHealthConfig(a, b *registry.HealthConfig) bool {
+	if len(a.Test) != len(b.Test) {
+		return false
+	}
+	for i := range a.Test {
+		if a.Test[i] != b.Test[i] {
+			return false
+		}
+	}
+
+	return a.Interval == b.Interval && a.Timeout == b.Timeout && a.StartPeriod == b.StartPeriod && a.Retries == b.Retries
+}
+
+// TestHealthRecord tests the health status transitions
+func TestHealthRecord(t *testing.T) {
+	pass, fail := HealthResult{ExitCode: 0}, HealthResult{ExitCode: 1}
+
+	h := &Health{Status: HealthStarting}
+
+	// Failures during the start period don't count
+	h.record(fail, 2, true)
+	h.record(fail, 2, true)
+	if h.Status != HealthStarting || h.FailingStreak != 0 {
+		t.Errorf("Expected starting without failures, got %s with %d", h.Status, h.FailingStreak)
+	}
+
+	h.record(fail, 2, false)
+	if h.Status != HealthStarting || h.FailingStreak != 1 {
+		t.Errorf("Expected starting with 1 failure, got %s with %d", h.Status, h.FailingStreak)
+	}
+
+	h.record(fail, 2, false)
+	if h.Status != HealthUnhealthy {
+		t.Errorf("Expected unhealthy after 2 failures, got %s", h.Status)
+	}
+
+	h.record(pass, 2, false)
+	if h.Status != HealthHealthy || h.FailingStreak != 0 {
+		t.Errorf("Expected healthy after a success, got %s with %d", h.Status, h.FailingStreak)
+	}
+
+	// A healthy container counts failures even during its start period
+	h.record(fail, 2, true)
+	if h.FailingStreak != 1 {
+		t.Errorf("Expected 1 failure, got %d", h.FailingStreak)
+	}
+
+	for range maxHealthLog {
+		h.record(pass, 2, false)
+	}
+	if len(h.Log) != maxHealthLog {
+		t.Errorf("Expected the last %d results in the log, got %d", maxHealthLog, len(h.Log))
+	}
+}
@@ -0,0 +1,128 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+// Hook kinds, the names of the hooks in the bundle configuration.
+const (
+	hookPrestart        = "prestart"
+	hookCreateRuntime   = "createRuntime"
+	hookCreateContainer = "createContainer"
+	hookStartContainer  = "startContainer"
+	hookPoststart       = "poststart"
+	hookPoststop        = "poststop"
+)
+
+// specHooks returns the hooks of the given kind from the bundle configuration.
+func specHooks(s *spec.Spec, kind string) []spec.Hook {
+	if s == nil || s.Hooks == nil {
+		return nil
+	}
+
+	switch kind {
+	case hookPrestart:
+		return s.Hooks.Prestart
+	case hookCreateRuntime:
+		return s.Hooks.CreateRuntime
+	case hookCreateContainer:
+		return s.Hooks.CreateContainer
+	case hookStartContainer:
+		return s.Hooks.StartContainer
+	case hookPoststart:
+		return s.Hooks.Poststart
+	case hookPoststop:
+		return s.Hooks.Poststop
+	}
+
+	return nil
+}
+
+// hookState returns the OCI state of the container passed to its hooks.
+func (c *Container) hookState(status string, pid int) *spec.State {
+	st := &spec.State{Version: spec.Version, ID: c.Name, Status: status, Pid: pid, Bundle: c.Bundle}
+	if c.spec != nil {
+		st.Annotations = c.spec.Annotations
+	}
+
+	return st
+}
+
+// runHooks runs the hooks of the given kind in order, stopping at the first that fails.
+func runHooks(s *spec.Spec, kind string, state *spec.State) error {
+	hooks := specHooks(s, kind)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	for _, h := range hooks {
+		if err := runHook(h, data); err != nil {
+			return fmt.Errorf("%s hook %s failed: %v", kind, h.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// runHook runs a hook with the state of the container on its standard input. The hook gets
+// exactly the arguments and environment of its configuration and is killed when it times out.
+func runHook(h spec.Hook, state []byte) error {
+	ctx := context.Background()
+	if h.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = append([]string{}, h.Env...)
+
+	// Processes the hook leaves behind don't keep it running through its output
+	cmd.WaitDelay = time.Second
+
+	// The output of hooks only shows up in their errors
+	var out bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(state), &out, &out
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %ds", *h.Timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// hostPid returns the PID of the calling process in the PID namespace of the mounted proc
+// filesystem, the host's until the container mounts its own.
+func hostPid() (int, error) {
+	link, err := os.Readlink("/proc/self")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read own PID: %v", err)
+	}
+
+	return strconv.Atoi(link)
+}
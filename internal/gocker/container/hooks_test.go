@@ -0,0 +1,64 @@
+package container
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+// TestRunHooks tests that hooks get the state on stdin with their own arguments and environment
+func TestRunHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	timeout := 1
+
+	s := &spec.Spec{Hooks: &spec.Hooks{CreateRuntime: []spec.Hook{
+		{Path: "/bin/sh", Args: []string{"sh", "-c", `cat > "$1"; printf "\n%s\n" "$FOO" >> "$1"`, "sh", out}, Env: []string{"FOO=bar"}},
+	}}}
+	state := &spec.State{Version: spec.Version, ID: "hooked", Status: spec.StatusCreating, Pid: 42, Bundle: "/bundle"}
+
+	if err := runHooks(s, hookCreateRuntime, state); err != nil {
+		t.Fatalf("Failed to run hooks: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Hook did not run: %v", err)
+	}
+
+	lines := strings.SplitN(string(data), "\n", 2)
+	var got spec.State
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil || got.ID != "hooked" || got.Pid != 42 {
+		t.Errorf("Expected the state on stdin, got %q (%v)", lines[0], err)
+	}
+	if len(lines) < 2 || strings.TrimSpace(lines[1]) != "bar" {
+		t.Errorf("Expected the hook environment, got %q", data)
+	}
+
+	// Other kinds of hooks don't run
+	os.Remove(out)
+	if err := runHooks(s, hookPoststart, state); err != nil {
+		t.Errorf("Expected no hooks to run, got %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Errorf("Expected only hooks of the given kind to run")
+	}
+
+	s.Hooks.CreateRuntime = []spec.Hook{{Path: "/bin/sh", Args: []string{"sh", "-c", "echo boom >&2; exit 3"}}}
+	if err := runHooks(s, hookCreateRuntime, state); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected a failing hook to report its output, got %v", err)
+	}
+
+	s.Hooks.CreateRuntime = []spec.Hook{{Path: "/bin/sleep", Args: []string{"sleep", "10"}, Timeout: &timeout}}
+	started := time.Now()
+	if err := runHooks(s, hookCreateRuntime, state); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a hook to time out, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected a hook to be killed when it times out, took %v", elapsed)
+	}
+}
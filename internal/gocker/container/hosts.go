@@ -0,0 +1,259 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+)
+
+const (
+	hostsFile    = "hosts"
+	resolvFile   = "resolv.conf"
+	hostnameFile = "hostname"
+
+	// hostResolvConf is the resolver configuration containers start from
+	hostResolvConf = "/etc/resolv.conf"
+
+	// hostGateway is the address --add-host name:host-gateway maps to in containers
+	// sharing the network of the host, which is reachable on its loopback address.
+	// On bridge networks it's the gateway.
+	hostGateway = "127.0.0.1"
+
+	// hostnameAddress is the address the container's own hostname resolves to
+	hostnameAddress = "127.0.1.1"
+)
+
+// defaultNameservers are the nameservers of containers when the host has none, like docker's.
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+// writeNetworkFiles generates the /etc/hosts, /etc/resolv.conf and /etc/hostname files of
+// the container in its directory, from where they're bind mounted into the rootfs. Containers
+// on bridge networks resolve through the embedded DNS server on the gateway of the first one.
+func (c *Container) writeNetworkFiles(endpoints []*network.Endpoint) error {
+	hostname, err := c.resolveHostname()
+	if err != nil {
+		return err
+	}
+
+	address, gateway := hostnameAddress, hostGateway
+	if len(endpoints) > 0 {
+		address, gateway = endpoints[0].IPAddress, endpoints[0].Network.Gateway
+	}
+
+	hosts, err := buildHosts(hostname, address, gateway, c.ExtraHosts)
+	if err != nil {
+		return err
+	}
+
+	host, err := os.ReadFile(hostResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read host resolv.conf: %v", err)
+	}
+
+	// The embedded DNS server forwards the queries about other names to the --dns nameservers
+	nameservers := c.DNS
+	if len(endpoints) > 0 {
+		nameservers = []string{gateway}
+	}
+
+	resolv, err := buildResolvConf(host, nameservers, c.DNSSearch)
+	if err != nil {
+		return err
+	}
+
+	for name, data := range map[string][]byte{hostsFile: hosts, resolvFile: resolv, hostnameFile: []byte(hostname + "\n")} {
+		if err := os.WriteFile(filepath.Join(c.dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// resolveHostname returns the hostname the container sees: its own, the host's,
+// or the one of the container whose UTS namespace it shares.
+func (c *Container) resolveHostname() (string, error) {
+	if c.UTS.IsHost() {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get hostname: %v", err)
+		}
+		return hostname, nil
+	}
+
+	if ref := c.UTS.Container(); ref != "" {
+		s, err := FindState(ref)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := os.ReadFile(filepath.Join(containerDir(s.ID), hostnameFile))
+		if err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+
+	return c.Config.Hostname, nil
+}
+
+// buildHosts returns the hosts file of a container with the given hostname, resolving to
+// the address, and --add-host entries in the name:ip format. host-gateway maps to the gateway.
+func buildHosts(hostname, address, gateway string, extraHosts []string) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	b.WriteString("fe00::0\tip6-localnet\n")
+	b.WriteString("ff00::0\tip6-mcastprefix\n")
+	b.WriteString("ff02::1\tip6-allnodes\n")
+	b.WriteString("ff02::2\tip6-allrouters\n")
+
+	for _, entry := range extraHosts {
+		name, ip, err := parseExtraHost(entry, gateway)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", ip, name)
+	}
+
+	if hostname != "" {
+		fmt.Fprintf(&b, "%s\t%s\n", address, hostname)
+	}
+
+	return b.Bytes(), nil
+}
+
+// parseExtraHost parses an --add-host value in the name:ip or name=ip format.
+func parseExtraHost(entry, gateway string) (string, string, error) {
+	name, ip, ok := strings.Cut(entry, "=")
+	if !ok {
+		name, ip, ok = strings.Cut(entry, ":")
+	}
+
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid extra host %q, expected name:ip", entry)
+	}
+
+	if ip == "host-gateway" {
+		return name, gateway, nil
+	}
+
+	// IPv6 addresses may be given in brackets
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid IP address in extra host %q", entry)
+	}
+
+	return name, ip, nil
+}
+
+// buildResolvConf returns the resolv.conf of a container from the host's. The --dns and
+// --dns-search values replace the nameservers and search domains of the host, a search
+// domain of "." leaves them out.
+func buildResolvConf(host []byte, dns, dnsSearch []string) ([]byte, error) {
+	if err := checkNameservers(dns); err != nil {
+		return nil, err
+	}
+
+	var nameservers, search, options []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(host))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			// The last search or domain line wins
+			search = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+
+	if len(dns) > 0 {
+		nameservers = dns
+	}
+	if len(nameservers) == 0 {
+		nameservers = defaultNameservers
+	}
+
+	if len(dnsSearch) > 0 {
+		search = nil
+		for _, domain := range dnsSearch {
+			if domain != "." {
+				search = append(search, domain)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(options, " "))
+	}
+
+	return b.Bytes(), nil
+}
+
+// checkNameservers validates the --dns values.
+func checkNameservers(dns []string) error {
+	for _, ns := range dns {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("invalid DNS server address: %q", ns)
+		}
+	}
+
+	return nil
+}
+
+// mountNetworkFiles bind mounts the generated network files over those of the rootfs.
+func (c *Container) mountNetworkFiles() error {
+	if err := os.MkdirAll(filepath.Join(c.imgRoot, "etc"), 0755); err != nil {
+		return fmt.Errorf("failed to create etc dir: %v", err)
+	}
+
+	for _, name := range []string{hostsFile, resolvFile, hostnameFile} {
+		source := filepath.Join(c.dir, name)
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("failed to find %s of container: %v", name, err)
+		}
+
+		// Mounts follow symlinks, which would resolve against the host's root,
+		// so the mount point is replaced with a regular file
+		target := filepath.Join(c.imgRoot, "etc", name)
+		if fi, err := os.Lstat(target); err == nil && !fi.Mode().IsRegular() {
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("failed to replace /etc/%s: %v", name, err)
+			}
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create /etc/%s: %v", name, err)
+		}
+		f.Close()
+
+		if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind mount /etc/%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
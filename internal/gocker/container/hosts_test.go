@@ -0,0 +1,87 @@
+package container
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBuildHosts tests the generated hosts file and the validation of --add-host values
+func TestBuildHosts(t *testing.T) {
+	hosts, err := buildHosts("web", hostnameAddress, hostGateway, []string{"db:10.0.0.5", "v6=[2001:db8::1]", "gw:host-gateway"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, expected := range []string{"127.0.0.1\tlocalhost\n", "10.0.0.5\tdb\n", "2001:db8::1\tv6\n", hostGateway + "\tgw\n", "127.0.1.1\tweb\n"} {
+		if !strings.Contains(string(hosts), expected) {
+			t.Errorf("Expected %q in hosts file, got:\n%s", expected, hosts)
+		}
+	}
+
+	for _, invalid := range []string{"db", ":10.0.0.5", "db:10.0.0", "my db:10.0.0.5"} {
+		if _, err := buildHosts("web", hostnameAddress, hostGateway, []string{invalid}); err == nil {
+			t.Errorf("Expected extra host %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestBuildResolvConf tests that --dns and --dns-search replace the settings of the host
+func TestBuildResolvConf(t *testing.T) {
+	host := []byte("# generated\nnameserver 10.0.0.2\nnameserver 10.0.0.3\ndomain corp.internal\nsearch corp.internal lab.internal\noptions ndots:2 timeout:1\n")
+
+	tests := []struct {
+		name          string
+		host          []byte
+		dns, search   []string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "host",
+			host:     host,
+			expected: "nameserver 10.0.0.2\nnameserver 10.0.0.3\nsearch corp.internal lab.internal\noptions ndots:2 timeout:1\n",
+		},
+		{
+			name:     "overrides",
+			host:     host,
+			dns:      []string{"1.1.1.1", "2606:4700:4700::1111"},
+			search:   []string{"example.com"},
+			expected: "nameserver 1.1.1.1\nnameserver 2606:4700:4700::1111\nsearch example.com\noptions ndots:2 timeout:1\n",
+		},
+		{
+			name:     "no search domains",
+			host:     host,
+			search:   []string{"."},
+			expected: "nameserver 10.0.0.2\nnameserver 10.0.0.3\noptions ndots:2 timeout:1\n",
+		},
+		{
+			name:     "no host nameservers",
+			expected: "nameserver 8.8.8.8\nnameserver 8.8.4.4\n",
+		},
+		{
+			name:          "invalid nameserver",
+			dns:           []string{"dns.google"},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolv, err := buildResolvConf(tt.host, tt.dns, tt.search)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for nameservers %v", tt.dns)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(resolv) != tt.expected {
+				t.Errorf("Expected resolv.conf %q, got %q", tt.expected, resolv)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Resource limits missing from the syscall package, with the generic Linux values
+// shared by the architectures gocker supports
+const (
+	rlimitRSS        = 5
+	rlimitNproc      = 6
+	rlimitMemlock    = 8
+	rlimitLocks      = 10
+	rlimitSigpending = 11
+	rlimitMsgqueue   = 12
+	rlimitNice       = 13
+	rlimitRtprio     = 14
+	rlimitRttime     = 15
+)
+
+// rlimitInfinity is RLIM_INFINITY, the value of unlimited resources.
+const rlimitInfinity = ^uint64(0)
+
+// rlimitResources maps the --ulimit names to their resources.
+var rlimitResources = map[string]int{
+	"as":         syscall.RLIMIT_AS,
+	"core":       syscall.RLIMIT_CORE,
+	"cpu":        syscall.RLIMIT_CPU,
+	"data":       syscall.RLIMIT_DATA,
+	"fsize":      syscall.RLIMIT_FSIZE,
+	"locks":      rlimitLocks,
+	"memlock":    rlimitMemlock,
+	"msgqueue":   rlimitMsgqueue,
+	"nice":       rlimitNice,
+	"nofile":     syscall.RLIMIT_NOFILE,
+	"nproc":      rlimitNproc,
+	"rss":        rlimitRSS,
+	"rtprio":     rlimitRtprio,
+	"rttime":     rlimitRttime,
+	"sigpending": rlimitSigpending,
+	"stack":      syscall.RLIMIT_STACK,
+}
+
+// ulimit is a resource limit set with --ulimit.
+type ulimit struct {
+	name     string
+	resource int
+	limit    syscall.Rlimit
+}
+
+// parseUlimit parses a --ulimit value in the name=soft[:hard] format, where
+// the hard limit defaults to the soft one and -1 or unlimited mean no limit.
+func parseUlimit(value string) (ulimit, error) {
+	name, limits, ok := strings.Cut(value, "=")
+	if !ok {
+		return ulimit{}, fmt.Errorf("invalid ulimit argument: %q", value)
+	}
+
+	resource, ok := rlimitResources[name]
+	if !ok {
+		return ulimit{}, fmt.Errorf("invalid ulimit type: %q", name)
+	}
+
+	soft, hard, hasHard := strings.Cut(limits, ":")
+	if !hasHard {
+		hard = soft
+	}
+
+	u := ulimit{name: name, resource: resource}
+
+	var err error
+	if u.limit.Cur, err = parseRlimit(soft); err != nil {
+		return ulimit{}, fmt.Errorf("invalid soft limit of ulimit %q: %v", value, err)
+	}
+	if u.limit.Max, err = parseRlimit(hard); err != nil {
+		return ulimit{}, fmt.Errorf("invalid hard limit of ulimit %q: %v", value, err)
+	}
+
+	if u.limit.Cur > u.limit.Max {
+		return ulimit{}, fmt.Errorf("ulimit soft limit must be less than or equal to hard limit: %d > %d", u.limit.Cur, u.limit.Max)
+	}
+
+	return u, nil
+}
+
+// parseRlimit parses a single limit value.
+func parseRlimit(s string) (uint64, error) {
+	if s == "-1" || s == "unlimited" {
+		return rlimitInfinity, nil
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// setupUlimits parses the --ulimit values, later values of a resource overriding earlier ones.
+func (c *Container) setupUlimits() error {
+	for _, value := range c.Ulimits {
+		u, err := parseUlimit(value)
+		if err != nil {
+			return err
+		}
+
+		c.ulimits = append(c.ulimits, u)
+	}
+
+	return nil
+}
+
+// applyUlimits sets the resource limits of the child through prlimit. Limits are
+// inherited by the command it starts. Raising a hard limit takes CAP_SYS_RESOURCE
+// in the initial user namespace, which rootless containers don't have.
+func (c *Container) applyUlimits() error {
+	for _, u := range c.ulimits {
+		// Also keeps the Go runtime from restoring its original open files limit in the command
+		if err := syscall.Setrlimit(u.resource, &u.limit); err != nil {
+			return fmt.Errorf("failed to set %s limit: %v", u.name, err)
+		}
+	}
+
+	return nil
+}
+
+// ipcSysctls are the sysctls isolated by IPC namespaces besides those under fs.mqueue.
+var ipcSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// sysctl is a kernel parameter set with --sysctl.
+type sysctl struct {
+	key, value string
+}
+
+// setupSysctls validates the --sysctl values. Only the sysctls isolated by a namespace
+// of its own can be set for a container, others would change the host.
+func (c *Container) setupSysctls() error {
+	for _, s := range c.Sysctls {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid sysctl %q: expected key=value", s)
+		}
+
+		var ns string
+		var mode NamespaceMode
+
+		switch {
+		case ipcSysctls[key] || strings.HasPrefix(key, "fs.mqueue."):
+			ns, mode = "IPC", c.IPC
+		case strings.HasPrefix(key, "net."):
+			ns, mode = "network", c.netMode()
+		case key == "kernel.domainname":
+			ns, mode = "UTS", c.UTS
+		default:
+			return fmt.Errorf("sysctl %q is not allowed: it isn't namespaced", key)
+		}
+
+		if !mode.IsPrivate() {
+			return fmt.Errorf("sysctl %q is not allowed without a private %s namespace", key, ns)
+		}
+
+		c.sysctls = append(c.sysctls, sysctl{key, value})
+	}
+
+	return nil
+}
+
+// applySysctls writes the sysctls of the container. The values of namespaced sysctls are
+// those of the namespaces of the writer, so they're written once the child is in its own.
+func (c *Container) applySysctls() error {
+	for _, s := range c.sysctls {
+		path := filepath.Join("/proc/sys", strings.ReplaceAll(s.key, ".", "/"))
+
+		if err := os.WriteFile(path, []byte(s.value), 0644); err != nil {
+			return fmt.Errorf("failed to set sysctl %s: %v", s.key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+// TestParseUlimit tests the parsing of --ulimit values
+func TestParseUlimit(t *testing.T) {
+	tests := map[string]ulimit{
+		"nofile=65536:65536": {"nofile", syscall.RLIMIT_NOFILE, syscall.Rlimit{Cur: 65536, Max: 65536}},
+		"nproc=100":          {"nproc", rlimitNproc, syscall.Rlimit{Cur: 100, Max: 100}},
+		"core=0:-1":          {"core", syscall.RLIMIT_CORE, syscall.Rlimit{Cur: 0, Max: rlimitInfinity}},
+		"memlock=unlimited":  {"memlock", rlimitMemlock, syscall.Rlimit{Cur: rlimitInfinity, Max: rlimitInfinity}},
+	}
+
+	for value, expected := range tests {
+		if u, err := parseUlimit(value); err != nil || u != expected {
+			t.Errorf("Expected %q to be parsed as %+v, got %+v (%v)", value, expected, u, err)
+		}
+	}
+
+	for _, value := range []string{"nofile", "bogus=1", "nofile=x", "nofile=2:1", "nofile=1:2:3"} {
+		if _, err := parseUlimit(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestSetupSysctls tests that only sysctls of private namespaces are accepted
+func TestSetupSysctls(t *testing.T) {
+	private := &Container{Options: Options{Network: "none"}}
+	host := &Container{Options: Options{IPC: "host", UTS: "host"}}
+
+	for _, tt := range []struct {
+		c      *Container
+		sysctl string
+		valid  bool
+	}{
+		{private, "net.core.somaxconn=1024", true},
+		{private, "kernel.shmmax=1000000", true},
+		{private, "fs.mqueue.msg_max=100", true},
+		{private, "kernel.domainname=example.com", true},
+		{private, "kernel.pid_max=100", false},
+		{private, "vm.swappiness=0", false},
+		{private, "net.core.somaxconn", false},
+		{host, "net.core.somaxconn=1024", false},
+		{host, "kernel.shmmax=1000000", false},
+		{host, "kernel.domainname=example.com", false},
+	} {
+		tt.c.Sysctls, tt.c.sysctls = []string{tt.sysctl}, nil
+
+		if err := tt.c.setupSysctls(); (err == nil) != tt.valid {
+			t.Errorf("Expected sysctl %q to be valid: %v, got %v", tt.sysctl, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+const namespaceContainerPrefix = "container:"
+
+// NamespaceMode selects the namespace a container runs in: a private one by default,
+// the host's with "host", or the one of another container with "container:<name|id>".
+type NamespaceMode string
+
+// IsPrivate reports whether the container gets a namespace of its own.
+func (m NamespaceMode) IsPrivate() bool {
+	return m == "" || m == "private"
+}
+
+// IsHost reports whether the container shares the namespace of the host.
+func (m NamespaceMode) IsHost() bool {
+	return m == "host"
+}
+
+// Container returns the name or ID of the container whose namespace is shared.
+func (m NamespaceMode) Container() string {
+	ref, _ := strings.CutPrefix(string(m), namespaceContainerPrefix)
+	if ref == string(m) {
+		return ""
+	}
+
+	return ref
+}
+
+// namespace describes a namespace the container can share with the host or another container.
+type namespace struct {
+	name string
+	flag uintptr
+	mode NamespaceMode
+}
+
+// sharableNamespaces returns the namespaces selected by --ipc, --network, --pid and --uts.
+func (c *Container) sharableNamespaces() []namespace {
+	return []namespace{
+		{name: "ipc", flag: syscall.CLONE_NEWIPC, mode: c.IPC},
+		{name: "net", flag: syscall.CLONE_NEWNET, mode: c.netMode()},
+		{name: "pid", flag: syscall.CLONE_NEWPID, mode: c.PID},
+		{name: "uts", flag: syscall.CLONE_NEWUTS, mode: c.UTS},
+	}
+}
+
+// resolveNamespaces validates the namespace modes and determines the namespaces created
+// for the child. Namespaces can be shared with a single other container. Sharing its PID
+// namespace means sharing its user namespace as well, since only the owner of a PID
+// namespace can mount the proc filesystem showing its processes.
+func (c *Container) resolveNamespaces() error {
+	// Mount and cgroup namespaces are always private
+	c.cloneFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP
+
+	// The time namespace needs Linux 5.6
+	if _, err := os.Stat("/proc/self/ns/time"); err == nil {
+		c.cloneFlags |= syscall.CLONE_NEWTIME
+	}
+
+	for _, ns := range c.sharableNamespaces() {
+		switch ref := ns.mode.Container(); {
+		case ns.mode.IsPrivate():
+			c.cloneFlags |= ns.flag
+
+		case ns.mode.IsHost():
+
+		case ref != "":
+			if c.nsContainer != "" && c.nsContainer != ref {
+				return fmt.Errorf("namespaces can only be shared with a single container")
+			}
+			c.nsContainer = ref
+
+		default:
+			return fmt.Errorf("invalid %s namespace mode: %q", ns.name, ns.mode)
+		}
+	}
+
+	// Joining a namespace needs privileges in the user namespace of the caller as well
+	if c.nsContainer != "" && os.Geteuid() != 0 {
+		return fmt.Errorf("sharing namespaces with a container requires root privileges")
+	}
+
+	if c.PID.Container() == "" {
+		return nil
+	}
+
+	if c.UserNS != "" || len(c.UIDMaps) > 0 || len(c.GIDMaps) > 0 {
+		return fmt.Errorf("user namespace options can't be used when sharing the PID namespace of a container")
+	}
+	c.idMappings = nil
+
+	return nil
+}
+
+// startInNamespaces starts the child in the namespaces shared with another container.
+// The setns system call only switches the calling thread, whose children inherit its
+// namespaces, so the child is started from a dedicated OS thread that joins them first.
+func (c *Container) startInNamespaces(cmd *exec.Cmd) error {
+	s, err := FindState(c.nsContainer)
+	if err != nil {
+		return err
+	}
+
+	if !s.IsRunning() || s.Pid == 0 {
+		return fmt.Errorf("container %s is not running", s.ShortID())
+	}
+
+	if c.PID.Container() != "" {
+		if err := c.joinUserNamespace(cmd, s.Pid); err != nil {
+			return err
+		}
+	}
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		// Never unlocked, so the thread is discarded when the goroutine exits
+		runtime.LockOSThread()
+
+		for _, ns := range c.sharableNamespaces() {
+			if ns.mode.Container() == "" {
+				continue
+			}
+
+			if err := setns(s.Pid, ns.name, ns.flag); err != nil {
+				errChan <- err
+				return
+			}
+		}
+
+		errChan <- idmap.Start(cmd, c.idMappings)
+	}()
+
+	return <-errChan
+}
+
+// joinUserNamespace makes the child command enter the user namespace of the process
+// with the given PID. A multithreaded process can't join a user namespace, so nsenter
+// joins it and unshare then creates the private namespaces owned by it.
+func (c *Container) joinUserNamespace(cmd *exec.Cmd, pid int) error {
+	own, err := os.Readlink("/proc/self/ns/user")
+	if err != nil {
+		return fmt.Errorf("failed to read user namespace: %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "ns", "user"))
+	if err != nil {
+		return fmt.Errorf("failed to read user namespace of container: %v", err)
+	}
+
+	if own == target {
+		return nil
+	}
+
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return fmt.Errorf("nsenter is required to share namespaces with a container: %v", err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+
+	args := []string{nsenter, "--target", strconv.Itoa(pid), "--user", "--", "unshare", "--mount"}
+	for _, ns := range []struct {
+		flag   uintptr
+		option string
+	}{
+		{syscall.CLONE_NEWCGROUP, "--cgroup"},
+		{syscall.CLONE_NEWIPC, "--ipc"},
+		{syscall.CLONE_NEWNET, "--net"},
+		{syscall.CLONE_NEWUTS, "--uts"},
+		{syscall.CLONE_NEWTIME, "--time"},
+	} {
+		if c.cloneFlags&ns.flag != 0 {
+			args = append(args, ns.option)
+		}
+	}
+
+	cmd.Path, cmd.Args = nsenter, append(append(args, "--", self), cmd.Args[1:]...)
+	cmd.SysProcAttr.Cloneflags, cmd.SysProcAttr.Unshareflags = 0, 0
+
+	return nil
+}
+
+// setns moves the calling thread into a namespace of the process with the given PID.
+func setns(pid int, name string, flag uintptr) error {
+	nr, ok := syscallNumbers["setns"]
+	if !ok {
+		return fmt.Errorf("sharing namespaces is not supported on this architecture")
+	}
+
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "ns", name))
+	if err != nil {
+		return fmt.Errorf("failed to open %s namespace: %v", name, err)
+	}
+	defer f.Close()
+
+	if _, _, errno := syscall.RawSyscall(uintptr(nr), f.Fd(), flag, 0); errno != 0 {
+		return fmt.Errorf("failed to join %s namespace: %v", name, errno)
+	}
+
+	return nil
+}
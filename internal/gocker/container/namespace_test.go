@@ -0,0 +1,52 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+// TestResolveNamespaces tests validation of the --ipc, --pid and --uts values
+func TestResolveNamespaces(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		created       uintptr
+		shared        uintptr
+		expectedError bool
+	}{
+		{name: "private", created: syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWCGROUP},
+		{name: "host", opts: Options{IPC: "host", PID: "host"}, created: syscall.CLONE_NEWUTS, shared: syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID},
+		{name: "container", opts: Options{UTS: "container:web"}, created: syscall.CLONE_NEWPID, shared: syscall.CLONE_NEWUTS},
+		{name: "invalid", opts: Options{PID: "sibling"}, expectedError: true},
+		{name: "several containers", opts: Options{IPC: "container:web", UTS: "container:db"}, expectedError: true},
+		{name: "mapped PID sharing", opts: Options{PID: "container:web", UIDMaps: []string{"0:1000:1"}}, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Container{Options: tt.opts}
+			err := c.resolveNamespaces()
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %+v", tt.opts)
+				}
+				return
+			}
+
+			if err != nil {
+				if c.nsContainer != "" {
+					t.Skipf("Sharing namespaces with a container is not possible: %v", err)
+				}
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.cloneFlags&tt.created != tt.created {
+				t.Errorf("Expected flags %#x to be set in %#x", tt.created, c.cloneFlags)
+			}
+			if c.cloneFlags&tt.shared != 0 {
+				t.Errorf("Expected flags %#x to be unset in %#x", tt.shared, c.cloneFlags)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+)
+
+const (
+	// netnsTimeout is how long to wait for a child started through nsenter to enter its network namespace
+	netnsTimeout = 5 * time.Second
+)
+
+// netMode returns the mode of the network namespace selected by --network. Containers share
+// the network of the host unless given another one, bridge networks and none get their own.
+func (c *Container) netMode() NamespaceMode {
+	switch mode := NamespaceMode(c.Network); {
+	case c.Network == "" || c.Network == network.Host:
+		return "host"
+	case mode.Container() != "":
+		return mode
+	default:
+		return "private"
+	}
+}
+
+// networkMode returns the --network value recorded in the container state.
+func (c *Container) networkMode() string {
+	if c.Network == "" {
+		return network.Host
+	}
+
+	return c.Network
+}
+
+// isBridged reports whether a container with the given network mode is connected to bridge networks.
+func isBridged(mode string) bool {
+	return mode != "" && mode != network.Host && mode != network.None && NamespaceMode(mode).Container() == ""
+}
+
+// validateNetwork checks the network settings of the container.
+func (c *Container) validateNetwork() error {
+	if !isBridged(c.networkMode()) || c.Network == network.DefaultBridge {
+		if len(c.NetworkAliases) > 0 {
+			return fmt.Errorf("network-scoped aliases are only supported for user-defined networks")
+		}
+		if c.IP != "" {
+			return fmt.Errorf("user specified IP address is supported on user defined networks only")
+		}
+	}
+
+	if isBridged(c.networkMode()) && os.Geteuid() != 0 {
+		return fmt.Errorf("bridge networks require root privileges")
+	}
+
+	return nil
+}
+
+// connectNetwork connects a new container to the network given with --network. The embedded
+// DNS server forwards the queries about other names to the --dns nameservers.
+func (c *Container) connectNetwork() ([]*network.Endpoint, error) {
+	if !isBridged(c.networkMode()) {
+		return nil, nil
+	}
+
+	if err := checkNameservers(c.DNS); err != nil {
+		return nil, err
+	}
+
+	ep, err := network.Connect(c.Network, c.ID, c.Name, c.NetworkAliases, c.IP, c.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*network.Endpoint{ep}, nil
+}
+
+// attachNetworks moves the interfaces of the container's endpoints into the network
+// namespace of the child with the given PID. The first endpoint is the primary one.
+func attachNetworks(pid int, endpoints []*network.Endpoint) error {
+	if err := waitNetNamespace(pid); err != nil {
+		return err
+	}
+
+	for i, ep := range endpoints {
+		if err := ep.Attach(pid, i == 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitNetNamespace waits until the process with the given PID is in a network namespace
+// of its own. Children started through nsenter unshare their namespaces only after starting.
+func waitNetNamespace(pid int) error {
+	own, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		return fmt.Errorf("failed to read network namespace: %v", err)
+	}
+
+	deadline := time.Now().Add(netnsTimeout)
+	for {
+		ns, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "ns", "net"))
+		if err != nil {
+			return fmt.Errorf("failed to read network namespace of container: %v", err)
+		}
+
+		if ns != own {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container did not enter its network namespace")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ifreqFlags is the struct ifreq of the SIOCGIFFLAGS and SIOCSIFFLAGS ioctls.
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// setupLoopback brings up the loopback interface of a private network namespace.
+func setupLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open socket: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	var ifr ifreqFlags
+	copy(ifr.name[:], "lo")
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to get loopback flags: %v", errno)
+	}
+
+	ifr.flags |= syscall.IFF_UP
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("failed to bring up loopback: %v", errno)
+	}
+
+	return nil
+}
+
+// serveDNS runs the embedded DNS servers of the container's networks until the returned function is called.
+func (c *Container) serveDNS() func() {
+	endpoints, err := network.ContainerEndpoints(c.ID)
+	if err != nil || len(endpoints) == 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	running := func(id string) bool {
+		s, err := LoadState(id)
+		return err == nil && s.IsRunning()
+	}
+
+	for _, ep := range endpoints {
+		go func() {
+			if err := network.ServeDNS(ctx, ep.Network, running); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}()
+	}
+
+	return cancel
+}
+
+// ConnectNetwork connects a container to another network, attaching it right away if it's
+// running. Its queries about other names are forwarded like those on its first network.
+func ConnectNetwork(id, ref string, aliases []string, ip string) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if !isBridged(s.NetworkMode) {
+		// Containers created before networks existed use the host's
+		mode := s.NetworkMode
+		if mode == "" {
+			mode = network.Host
+		}
+		return fmt.Errorf("container %s uses the %s network and can't be connected to other networks", s.ShortID(), mode)
+	}
+
+	endpoints, err := network.ContainerEndpoints(s.ID)
+	if err != nil {
+		return err
+	}
+
+	var dns []string
+	if len(endpoints) > 0 {
+		dns = endpoints[0].DNS
+	}
+
+	ep, err := network.Connect(ref, s.ID, s.Name, aliases, ip, dns)
+	if err != nil {
+		return err
+	}
+
+	if !s.IsRunning() || s.Pid == 0 {
+		return nil
+	}
+
+	if err := ep.Attach(s.Pid, len(endpoints) == 0); err != nil {
+		network.Disconnect(ref, s.ID)
+		return err
+	}
+
+	return nil
+}
+
+// DisconnectNetwork disconnects a container from a network.
+func DisconnectNetwork(id, ref string) error {
+	return network.Disconnect(ref, id)
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// memoryEventsFile counts the memory events of a cgroup, among them the processes killed by the OOM killer.
+const memoryEventsFile = "memory.events"
+
+// oomKills returns the number of processes of the cgroup at the given path killed by the
+// OOM killer so far. Cgroups without the memory controller have no such events.
+func oomKills(cgroupPath string) (int, error) {
+	f, err := os.Open(filepath.Join(cgroupPath, memoryEventsFile))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open memory events: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || key != "oom_kill" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid oom_kill count: %q", value)
+		}
+
+		return n, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read memory events: %v", err)
+	}
+
+	return 0, nil
+}
+
+// watchOOM watches the memory events of the container cgroup until stop is closed.
+// Each process killed by the OOM killer since the given count is reported right away,
+// and the container state records that it ran out of memory.
+func (c *Container) watchOOM(kills int, stop <-chan struct{}) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		c.logWarning("OOM kills of the container aren't reported: failed to watch memory events: %v", err)
+		return
+	}
+
+	// Non-blocking, the file is closed through the runtime poller as soon as the run ends
+	events := os.NewFile(uintptr(fd), "inotify")
+
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Join(c.cgroupPath, memoryEventsFile), syscall.IN_MODIFY); err != nil {
+		events.Close()
+		c.logWarning("OOM kills of the container aren't reported: failed to watch memory events: %v", err)
+		return
+	}
+
+	go func() {
+		<-stop
+		events.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		if _, err := events.Read(buf); err != nil {
+			return
+		}
+
+		n, err := oomKills(c.cgroupPath)
+		if err != nil || n <= kills {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "Warning: %s, %d process(es) killed by the OOM killer\n", c.outOfMemory(), n-kills)
+		kills = n
+
+		UpdateState(c.ID, func(s *State) { s.OOMKilled = true })
+	}
+}
+
+// outOfMemory describes the container running out of memory, with its memory limit if it has one.
+func (c *Container) outOfMemory() string {
+	msg := fmt.Sprintf("container %s ran out of memory", shortID(c.ID))
+
+	if s, err := LoadState(c.ID); err == nil && s.resources().Memory > 0 {
+		msg += " (limit: " + formatMemory(s.resources().Memory) + ")"
+	}
+
+	return msg
+}
+
+// formatMemory formats a size in bytes with the binary units ParseMemory reads.
+func formatMemory(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value, unit := float64(size), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.4g%s", value, units[unit])
+}
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOOMKills tests reading the OOM kill count from the memory events of a cgroup
+func TestOOMKills(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := oomKills(dir); err == nil {
+		t.Error("Expected a cgroup without memory events to be rejected")
+	}
+
+	events := "low 0\nhigh 0\nmax 12\noom 3\noom_kill 2\noom_group_kill 0\n"
+	if err := os.WriteFile(filepath.Join(dir, memoryEventsFile), []byte(events), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := oomKills(dir); err != nil || n != 2 {
+		t.Errorf("Expected 2 OOM kills, got %d (%v)", n, err)
+	}
+}
+
+// TestWatchOOMUnavailable tests that the container log tells when OOM kills can't be watched
+func TestWatchOOMUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	c := &Container{dir: dir, cgroupPath: filepath.Join(dir, "missing")}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	c.watchOOM(0, stop)
+
+	data, err := os.ReadFile(filepath.Join(dir, logFile))
+	if err != nil || !strings.Contains(string(data), "Warning: OOM kills of the container aren't reported") {
+		t.Errorf("Expected a warning in the container log, got %q (%v)", data, err)
+	}
+}
+
+// TestFormatMemory tests the formatting of memory limits in messages
+func TestFormatMemory(t *testing.T) {
+	for size, expected := range map[int64]string{
+		512:       "512B",
+		6 << 20:   "6MiB",
+		50 << 20:  "50MiB",
+		3 << 29:   "1.5GiB",
+		100 << 10: "100KiB",
+	} {
+		if s := formatMemory(size); s != expected {
+			t.Errorf("Expected %d bytes to be formatted as %s, got %s", size, expected, s)
+		}
+	}
+}
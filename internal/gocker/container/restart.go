@@ -0,0 +1,99 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Restart delays start small and double on every restart, like Docker does
+	initialRestartDelay = 100 * time.Millisecond
+	maxRestartDelay     = time.Minute
+
+	// A container that ran at least this long is considered healthy again
+	restartResetPeriod = 10 * time.Second
+)
+
+// RestartPolicy describes when the supervisor restarts an exited container.
+type RestartPolicy struct {
+	Name              string
+	MaximumRetryCount int
+}
+
+// ParseRestartPolicy parses a restart policy in the
+// no|on-failure[:max-retries]|always|unless-stopped format.
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(s, ":")
+
+	switch name {
+	case "", "no", "always", "unless-stopped":
+		if hasCount {
+			return RestartPolicy{}, fmt.Errorf("maximum retry count cannot be used with restart policy %q", name)
+		}
+		if name == "" {
+			name = "no"
+		}
+
+		return RestartPolicy{Name: name}, nil
+
+	case "on-failure":
+		p := RestartPolicy{Name: name}
+		if hasCount {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return RestartPolicy{}, fmt.Errorf("invalid maximum retry count: %q", count)
+			}
+			p.MaximumRetryCount = n
+		}
+
+		return p, nil
+	}
+
+	return RestartPolicy{}, fmt.Errorf("invalid restart policy: %q", s)
+}
+
+// String formats the policy the same way ParseRestartPolicy accepts it.
+func (p RestartPolicy) String() string {
+	if p.Name == "on-failure" && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+
+	if p.Name == "" {
+		return "no"
+	}
+
+	return p.Name
+}
+
+// ShouldRestart reports whether a container that exited with the given code
+// after restarts previous restarts has to be started again.
+// Without a daemon that outlives its containers, unless-stopped behaves like always.
+func (p RestartPolicy) ShouldRestart(exitCode, restarts int, stopRequested bool) bool {
+	if stopRequested {
+		return false
+	}
+
+	switch p.Name {
+	case "always", "unless-stopped":
+		return true
+	case "on-failure":
+		if exitCode == 0 {
+			return false
+		}
+
+		return p.MaximumRetryCount == 0 || restarts < p.MaximumRetryCount
+	}
+
+	return false
+}
+
+// nextRestartDelay returns the backoff delay before the next restart.
+func nextRestartDelay(prev, uptime time.Duration) time.Duration {
+	if prev == 0 || uptime >= restartResetPeriod {
+		return initialRestartDelay
+	}
+
+	return min(prev*2, maxRestartDelay)
+}
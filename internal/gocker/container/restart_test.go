@@ -0,0 +1,134 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestParseRestartPolicy tests parsing of the --restart flag values
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      RestartPolicy
+		expectedError bool
+	}{
+		{input: "", expected: RestartPolicy{Name: "no"}},
+		{input: "no", expected: RestartPolicy{Name: "no"}},
+		{input: "always", expected: RestartPolicy{Name: "always"}},
+		{input: "unless-stopped", expected: RestartPolicy{Name: "unless-stopped"}},
+		{input: "on-failure", expected: RestartPolicy{Name: "on-failure"}},
+		{input: "on-failure:5", expected: RestartPolicy{Name: "on-failure", MaximumRetryCount: 5}},
+		{input: "on-failure:-1", expectedError: true},
+		{input: "on-failure:x", expectedError: true},
+		{input: "always:3", expectedError: true},
+		{input: "sometimes", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p, err := ParseRestartPolicy(tt.input)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got policy %+v", tt.input, p)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if p != tt.expected {
+				t.Errorf("Expected policy %+v, got %+v", tt.expected, p)
+			}
+		})
+	}
+}
+
+// TestShouldRestart tests the restart decisions of each policy
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        RestartPolicy
+		exitCode      int
+		restarts      int
+		stopRequested bool
+		expected      bool
+	}{
+		{name: "no", policy: RestartPolicy{Name: "no"}, exitCode: 1, expected: false},
+		{name: "always on success", policy: RestartPolicy{Name: "always"}, exitCode: 0, expected: true},
+		{name: "always when stopped", policy: RestartPolicy{Name: "always"}, stopRequested: true, expected: false},
+		{name: "unless-stopped", policy: RestartPolicy{Name: "unless-stopped"}, exitCode: 137, expected: true},
+		{name: "on-failure on success", policy: RestartPolicy{Name: "on-failure"}, exitCode: 0, expected: false},
+		{name: "on-failure unlimited", policy: RestartPolicy{Name: "on-failure"}, exitCode: 1, restarts: 100, expected: true},
+		{name: "on-failure below limit", policy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, exitCode: 1, restarts: 2, expected: true},
+		{name: "on-failure at limit", policy: RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, exitCode: 1, restarts: 3, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ShouldRestart(tt.exitCode, tt.restarts, tt.stopRequested); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestNextRestartDelay tests the exponential restart backoff
+func TestNextRestartDelay(t *testing.T) {
+	delay := nextRestartDelay(0, 0)
+	if delay != initialRestartDelay {
+		t.Errorf("Expected initial delay %v, got %v", initialRestartDelay, delay)
+	}
+
+	for i := 0; i < 20; i++ {
+		delay = nextRestartDelay(delay, time.Second)
+	}
+	if delay != maxRestartDelay {
+		t.Errorf("Expected delay capped at %v, got %v", maxRestartDelay, delay)
+	}
+
+	// A container that ran long enough starts over with the initial delay
+	if delay = nextRestartDelay(delay, restartResetPeriod); delay != initialRestartDelay {
+		t.Errorf("Expected delay reset to %v, got %v", initialRestartDelay, delay)
+	}
+}
+
+// TestParseSignal tests parsing of signal names and numbers
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      syscall.Signal
+		expectedError bool
+	}{
+		{input: "", expected: syscall.SIGTERM},
+		{input: "SIGTERM", expected: syscall.SIGTERM},
+		{input: "quit", expected: syscall.SIGQUIT},
+		{input: "9", expected: syscall.SIGKILL},
+		{input: "SIGRTMIN", expectedError: true},
+		{input: "0", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			sig, err := ParseSignal(tt.input)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %v", tt.input, sig)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if sig != tt.expected {
+				t.Errorf("Expected signal %v, got %v", tt.expected, sig)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/spec"
+)
+
+const (
+	// createTimeout is how long gocker runtime create waits for the container to be created
+	createTimeout = 10 * time.Second
+
+	// oPath is O_PATH, missing from the syscall package, with the value of the architectures gocker supports
+	oPath = 0x200000
+)
+
+// FindBundle looks up a container created from an OCI bundle by its OCI ID, which is its name.
+func FindBundle(id string) (*State, error) {
+	states, err := ListStates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range states {
+		if s.Name == id && s.Bundle != "" {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("container %s does not exist", id)
+}
+
+// OCIState returns the state of a bundle container in the OCI format.
+func (s *State) OCIState() *spec.State {
+	st := &spec.State{
+		Version:     spec.Version,
+		ID:          s.Name,
+		Status:      s.ociStatus(),
+		Bundle:      s.Bundle,
+		Annotations: s.Annotations,
+	}
+
+	if st.Status == spec.StatusCreated || st.Status == spec.StatusRunning {
+		st.Pid = s.Pid
+	}
+
+	return st
+}
+
+// ociStatus returns the OCI status of the container. A created container has a
+// child waiting for Start, a container still being created has none yet.
+func (s *State) ociStatus() string {
+	switch {
+	case s.Status == StatusCreated && s.SupervisorPid == 0:
+		return spec.StatusCreating
+	case s.Status == StatusCreated && s.supervised() && s.Pid == 0:
+		return spec.StatusCreating
+	case s.Status == StatusCreated && s.supervised():
+		return spec.StatusCreated
+	case s.IsRunning():
+		return spec.StatusRunning
+	}
+
+	return spec.StatusStopped
+}
+
+// waitCreated waits until the child of a container created without being started waits for Start.
+func (c *Container) waitCreated() error {
+	deadline := time.Now().Add(createTimeout)
+
+	for {
+		s, err := LoadState(c.ID)
+		if err != nil {
+			return err
+		}
+
+		switch s.ociStatus() {
+		case spec.StatusCreated:
+			return nil
+		case spec.StatusStopped:
+			return fmt.Errorf("container %s exited while being created, see %s", c.Name, filepath.Join(c.dir, logFile))
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for container %s to be created", c.Name)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// openPath opens a file only to refer to it, without reading or writing it.
+func openPath(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// waitForStart blocks until the container is started. Opening the exec FIFO for writing blocks
+// until Start opens it for reading. The FIFO is reopened through proc, since it's out of the root.
+func waitForStart(execFifo *os.File) error {
+	f, err := os.OpenFile("/proc/self/fd/"+strconv.Itoa(int(execFifo.Fd())), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open exec FIFO: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to write exec FIFO: %v", err)
+	}
+
+	return nil
+}
+
+// Start runs the command of a container created without being started.
+func Start(id string) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if status := s.ociStatus(); status != spec.StatusCreated {
+		return fmt.Errorf("container %s is %s, not created", s.Name, status)
+	}
+
+	path := filepath.Join(containerDir(id), execFifoFile)
+
+	// Opening the FIFO blocks until the child opens it too, which it never does if it exits first
+	started := make(chan error, 1)
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			started <- fmt.Errorf("failed to open exec FIFO: %v", err)
+			return
+		}
+		defer f.Close()
+
+		_, err = io.ReadAll(f)
+		started <- err
+	}()
+
+	for done := false; !done; {
+		select {
+		case err := <-started:
+			if err != nil {
+				return err
+			}
+			done = true
+
+		case <-time.After(pollInterval):
+			if st, err := LoadState(id); err != nil || st.ociStatus() == spec.StatusStopped {
+				return fmt.Errorf("container %s exited before it was started", s.Name)
+			}
+		}
+	}
+
+	os.Remove(path)
+
+	// A command that exits right away may have left the container stopped already
+	err = UpdateState(id, func(s *State) {
+		if s.Status == StatusCreated {
+			s.Status, s.StartedAt = StatusRunning, time.Now()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.runHooks(hookPoststart); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	return nil
+}
+
+// runHooks runs the hooks of the given kind from the bundle of the container with its current state.
+func (s *State) runHooks(kind string) error {
+	cfg, err := spec.Load(s.Bundle)
+	if err != nil {
+		return err
+	}
+
+	if len(specHooks(cfg, kind)) == 0 {
+		return nil
+	}
+
+	st, err := LoadState(s.ID)
+	if err != nil {
+		return err
+	}
+
+	return runHooks(cfg, kind, st.OCIState())
+}
+
+// Kill sends the signal to the init process of a created or running container.
+func Kill(id string, sig syscall.Signal) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if status := s.ociStatus(); status != spec.StatusCreated && status != spec.StatusRunning {
+		return fmt.Errorf("container %s is %s, not created or running", s.Name, status)
+	}
+
+	if err := syscall.Kill(s.Pid, sig); err != nil {
+		return fmt.Errorf("failed to send %s to container %s: %v", signalName(sig), s.Name, err)
+	}
+
+	return nil
+}
+
+// Delete removes a stopped container. A container that isn't stopped is only removed
+// when force is set, after its init process and with it the container are killed.
+func Delete(id string, force bool) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if status := s.ociStatus(); status != spec.StatusStopped {
+		if !force {
+			return fmt.Errorf("cannot delete container %s that is %s, not stopped", s.Name, status)
+		}
+
+		if err := UpdateState(id, func(s *State) { s.StopRequested = true }); err != nil {
+			return err
+		}
+
+		if s, err = LoadState(id); err != nil {
+			return err
+		}
+
+		if s.Pid != 0 {
+			if err := syscall.Kill(s.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return fmt.Errorf("failed to kill container %s: %v", s.Name, err)
+			}
+		}
+
+		// The supervisor records the exit before it's gone
+		deadline := time.Now().Add(killTimeout)
+		for s.supervised() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("container %s did not stop", s.Name)
+			}
+
+			time.Sleep(pollInterval)
+		}
+	}
+
+	// The poststop hooks get the state of the container before it's gone
+	cfg, cerr := spec.Load(s.Bundle)
+	state := s.OCIState()
+
+	if err := Remove(id, false); err != nil {
+		return err
+	}
+
+	if cerr == nil {
+		cerr = runHooks(cfg, hookPoststop, state)
+	}
+	if cerr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", cerr)
+	}
+
+	return nil
+}
@@ -0,0 +1,359 @@
+package container
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Seccomp filter return values
+	seccompRetKillThread  = 0x00000000
+	seccompRetKillProcess = 0x80000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetTrace       = 0x7ff00000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+
+	seccompModeFilter = 2
+
+	// Offsets of the seccomp_data fields
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+
+	// x32 system calls on amd64 have this bit set in their number
+	x32SyscallBit = 0x40000000
+
+	// bpfMaxJump is the longest forward jump of a conditional BPF instruction
+	bpfMaxJump = 255
+)
+
+// SeccompUnconfined disables syscall filtering when given as the seccomp profile.
+const SeccompUnconfined = "unconfined"
+
+//go:embed seccomp_default.json
+var defaultSeccompProfile []byte
+
+// SeccompProfile is a syscall filtering profile in the Docker JSON format.
+type SeccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet *uint32          `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string         `json:"architectures,omitempty"`
+	Syscalls        []SeccompSyscall `json:"syscalls"`
+}
+
+// SeccompSyscall is a profile rule applying an action to a group of syscalls.
+type SeccompSyscall struct {
+	Name     string        `json:"name,omitempty"`
+	Names    []string      `json:"names,omitempty"`
+	Action   string        `json:"action"`
+	ErrnoRet *uint32       `json:"errnoRet,omitempty"`
+	Args     []SeccompArg  `json:"args,omitempty"`
+	Includes SeccompFilter `json:"includes"`
+	Excludes SeccompFilter `json:"excludes"`
+}
+
+// SeccompArg is a condition on a syscall argument.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// SeccompFilter limits a rule to some architectures or capability sets.
+type SeccompFilter struct {
+	Arches []string `json:"arches,omitempty"`
+	Caps   []string `json:"caps,omitempty"`
+}
+
+// loadSeccompProfile reads the profile from the given path, the built-in
+// default profile for an empty path, or returns nil for an unconfined container.
+func loadSeccompProfile(path string) (*SeccompProfile, error) {
+	if path == SeccompUnconfined {
+		return nil, nil
+	}
+
+	data := defaultSeccompProfile
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read seccomp profile: %v", err)
+		}
+	}
+
+	var p SeccompProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode seccomp profile: %v", err)
+	}
+
+	return &p, nil
+}
+
+// bpfInsn is a BPF instruction whose jump targets are
+// referenced by label until the program is assembled.
+type bpfInsn struct {
+	code   uint16
+	k      uint32
+	jt, jf string
+}
+
+// bpfProgram assembles BPF instructions with labeled jump targets.
+type bpfProgram struct {
+	insns  []bpfInsn
+	labels map[string]int
+}
+
+// add appends an instruction to the program.
+func (p *bpfProgram) add(code uint16, k uint32, jt, jf string) {
+	p.insns = append(p.insns, bpfInsn{code: code, k: k, jt: jt, jf: jf})
+}
+
+// label marks the position of the next instruction.
+func (p *bpfProgram) label(name string) {
+	p.labels[name] = len(p.insns)
+}
+
+// assemble resolves the labels into relative jump offsets.
+func (p *bpfProgram) assemble() ([]syscall.SockFilter, error) {
+	filter := make([]syscall.SockFilter, len(p.insns))
+
+	for i, in := range p.insns {
+		jt, err := p.offset(i, in.jt)
+		if err != nil {
+			return nil, err
+		}
+
+		jf, err := p.offset(i, in.jf)
+		if err != nil {
+			return nil, err
+		}
+
+		filter[i] = syscall.SockFilter{Code: in.code, Jt: jt, Jf: jf, K: in.k}
+	}
+
+	return filter, nil
+}
+
+// offset computes the jump from instruction i to the given label.
+// An empty label continues with the next instruction.
+func (p *bpfProgram) offset(i int, label string) (uint8, error) {
+	if label == "" {
+		return 0, nil
+	}
+
+	target, ok := p.labels[label]
+	if !ok {
+		return 0, fmt.Errorf("undefined BPF label %q", label)
+	}
+
+	off := target - i - 1
+	if off < 0 || off > bpfMaxJump {
+		return 0, fmt.Errorf("BPF jump to %q out of range", label)
+	}
+
+	return uint8(off), nil
+}
+
+// compile translates the profile into a BPF program for the native
+// architecture. Rules are filtered by the container capabilities.
+func (p *SeccompProfile) compile(caps []string) ([]syscall.SockFilter, error) {
+	defAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := &bpfProgram{labels: make(map[string]int)}
+
+	// Reject foreign architectures and the x32 ABI, whose syscall numbers differ
+	prog.add(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch, "", "")
+	prog.add(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, "native", "")
+	prog.add(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess, "", "")
+	prog.label("native")
+	prog.add(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr, "", "")
+	prog.add(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, "", "syscalls")
+	prog.add(syscall.BPF_RET|syscall.BPF_K, defAction, "", "")
+	prog.label("syscalls")
+
+	rule := 0
+	for _, sc := range p.Syscalls {
+		if !sc.applies(caps) {
+			continue
+		}
+
+		action, err := seccompAction(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+
+		names := sc.Names
+		if sc.Name != "" {
+			names = append([]string{sc.Name}, sc.Names...)
+		}
+
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				// Like libseccomp, ignore syscalls unknown on this architecture
+				continue
+			}
+
+			next := fmt.Sprintf("rule%d", rule)
+			rule++
+
+			prog.add(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr, "", "")
+			prog.add(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, "", next)
+
+			for i, arg := range sc.Args {
+				if err := prog.addArgCheck(arg, fmt.Sprintf("%s.%d", next, i), next); err != nil {
+					return nil, fmt.Errorf("syscall %s: %v", name, err)
+				}
+			}
+
+			prog.add(syscall.BPF_RET|syscall.BPF_K, action, "", "")
+			prog.label(next)
+		}
+	}
+
+	prog.add(syscall.BPF_RET|syscall.BPF_K, defAction, "", "")
+
+	return prog.assemble()
+}
+
+// addArgCheck emits a 64-bit comparison of a syscall argument, made of
+// comparisons of its high and low halves. Execution continues after the
+// check when the condition holds and jumps to fail otherwise.
+func (p *bpfProgram) addArgCheck(arg SeccompArg, pass, fail string) error {
+	if arg.Index > 5 {
+		return fmt.Errorf("invalid argument index %d", arg.Index)
+	}
+
+	hiOff := uint32(seccompDataArgs + 8*arg.Index + 4)
+	loOff := uint32(seccompDataArgs + 8*arg.Index)
+	hi, lo := uint32(arg.Value>>32), uint32(arg.Value)
+
+	ld := uint16(syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS)
+	jeq := uint16(syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K)
+	jgt := uint16(syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K)
+	jge := uint16(syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K)
+
+	switch arg.Op {
+	case "SCMP_CMP_EQ":
+		p.add(ld, hiOff, "", "")
+		p.add(jeq, hi, "", fail)
+		p.add(ld, loOff, "", "")
+		p.add(jeq, lo, "", fail)
+	case "SCMP_CMP_NE":
+		p.add(ld, hiOff, "", "")
+		p.add(jeq, hi, "", pass)
+		p.add(ld, loOff, "", "")
+		p.add(jeq, lo, fail, "")
+	case "SCMP_CMP_MASKED_EQ":
+		and := uint16(syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K)
+		p.add(ld, hiOff, "", "")
+		p.add(and, hi, "", "")
+		p.add(jeq, uint32(arg.ValueTwo>>32), "", fail)
+		p.add(ld, loOff, "", "")
+		p.add(and, lo, "", "")
+		p.add(jeq, uint32(arg.ValueTwo), "", fail)
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		p.add(ld, hiOff, "", "")
+		p.add(jgt, hi, pass, "")
+		p.add(jeq, hi, "", fail)
+		p.add(ld, loOff, "", "")
+		if arg.Op == "SCMP_CMP_GT" {
+			p.add(jgt, lo, "", fail)
+		} else {
+			p.add(jge, lo, "", fail)
+		}
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		p.add(ld, hiOff, "", "")
+		p.add(jgt, hi, fail, "")
+		p.add(jeq, hi, "", pass)
+		p.add(ld, loOff, "", "")
+		if arg.Op == "SCMP_CMP_LT" {
+			p.add(jge, lo, fail, "")
+		} else {
+			p.add(jgt, lo, fail, "")
+		}
+	default:
+		return fmt.Errorf("unsupported comparison %q", arg.Op)
+	}
+
+	p.label(pass)
+
+	return nil
+}
+
+// applies reports whether the rule is enabled for the native
+// architecture and a container with the given capabilities.
+func (sc *SeccompSyscall) applies(caps []string) bool {
+	if len(sc.Includes.Arches) > 0 && !slices.Contains(sc.Includes.Arches, runtime.GOARCH) {
+		return false
+	}
+	if slices.Contains(sc.Excludes.Arches, runtime.GOARCH) {
+		return false
+	}
+
+	for _, c := range sc.Includes.Caps {
+		if !slices.Contains(caps, c) {
+			return false
+		}
+	}
+	for _, c := range sc.Excludes.Caps {
+		if slices.Contains(caps, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// seccompAction converts a profile action name into a filter return value.
+func seccompAction(action string, errnoRet *uint32) (uint32, error) {
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return seccompRetAllow, nil
+	case "SCMP_ACT_ERRNO":
+		errno := uint32(syscall.EPERM)
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		return seccompRetErrno | (errno & 0xffff), nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return seccompRetKillThread, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return seccompRetKillProcess, nil
+	case "SCMP_ACT_TRAP":
+		return seccompRetTrap, nil
+	case "SCMP_ACT_TRACE":
+		return seccompRetTrace | uint32(syscall.EPERM), nil
+	case "SCMP_ACT_LOG":
+		return seccompRetLog, nil
+	}
+
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// installSeccompFilter loads the filter for the calling thread.
+// Threads and processes created by it afterwards inherit the filter.
+func installSeccompFilter(filter []syscall.SockFilter) error {
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %v", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !amd64 && !arm64
+
+package container
+
+// auditArch is unknown, so seccomp filtering isn't supported on this architecture.
+const auditArch = 0
+
+// syscallNumbers is empty on architectures without a system call table.
+var syscallNumbers = map[string]uint32{}
@@ -0,0 +1,147 @@
+package container
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+const prSetNoNewPrivs = 38
+
+// Mount flags a user namespace can't clear when remounting a bind mount
+const lockedMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// SetSecurityOpts applies --security-opt values in the
+// seccomp=<profile.json|unconfined> and no-new-privileges[:true|false] formats.
+func (o *Options) SetSecurityOpts(opts []string) error {
+	for _, opt := range opts {
+		key, value, hasValue := strings.Cut(opt, "=")
+		if !hasValue {
+			key, value, hasValue = strings.Cut(opt, ":")
+		}
+
+		switch key {
+		case "seccomp":
+			if value == "" {
+				return fmt.Errorf("missing seccomp profile in security option %q", opt)
+			}
+			o.Seccomp = value
+
+		case "no-new-privileges":
+			switch {
+			case !hasValue || value == "true":
+				o.NoNewPrivileges = true
+			case value == "false":
+				o.NoNewPrivileges = false
+			default:
+				return fmt.Errorf("invalid no-new-privileges value: %q", value)
+			}
+
+		default:
+			return fmt.Errorf("unsupported security option: %q", opt)
+		}
+	}
+
+	return nil
+}
+
+// setupSecurity resolves the capability set and compiles the seccomp profile,
+// so invalid settings are reported before the container is started.
+func (c *Container) setupSecurity() error {
+	caps, err := capabilitySet(c.CapAdd, c.CapDrop)
+	if err != nil {
+		return err
+	}
+	c.caps = caps
+
+	// Bundles are confined by the filter of their config only
+	profile := specSeccompProfile(c.spec)
+	if c.spec == nil {
+		if profile, err = loadSeccompProfile(c.Seccomp); err != nil {
+			return err
+		}
+	}
+	if profile == nil {
+		return nil
+	}
+
+	if len(syscallNumbers) == 0 {
+		return fmt.Errorf("seccomp is not supported on this architecture, use --security-opt seccomp=unconfined")
+	}
+
+	if c.seccompFilter, err = profile.compile(caps); err != nil {
+		return fmt.Errorf("failed to compile seccomp profile: %v", err)
+	}
+
+	return nil
+}
+
+// startConfined starts the command from a dedicated OS thread that drops its privileges
+// first. The command inherits the restrictions while the rest of the child process,
+// which still has to clean up after the command, keeps running unrestricted.
+func (c *Container) startConfined(cmd *exec.Cmd) error {
+	errChan := make(chan error, 1)
+
+	go func() {
+		// Never unlocked, so the thread is discarded when the goroutine exits
+		runtime.LockOSThread()
+
+		if err := c.applySecurity(); err != nil {
+			errChan <- err
+			return
+		}
+
+		errChan <- cmd.Start()
+	}()
+
+	return <-errChan
+}
+
+// applySecurity restricts the calling thread. The seccomp filter is installed
+// while the thread still holds CAP_SYS_ADMIN, which the filter requires unless
+// no_new_privs is set, and capabilities are dropped last.
+func (c *Container) applySecurity() error {
+	if c.NoNewPrivileges {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to set no_new_privs: %v", errno)
+		}
+	}
+
+	if len(c.seccompFilter) > 0 {
+		if err := installSeccompFilter(c.seccompFilter); err != nil {
+			return err
+		}
+	}
+
+	return dropCapabilities(c.caps)
+}
+
+// mountReadOnly turns the directory into a read-only bind mount of itself.
+func mountReadOnly(path string) error {
+	if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount rootfs: %v", err)
+	}
+
+	if err := remountBind(path, syscall.MS_RDONLY); err != nil {
+		return fmt.Errorf("failed to remount rootfs read-only: %v", err)
+	}
+
+	return nil
+}
+
+// remountBind remounts the bind mount at the path with the given flags,
+// keeping the flags of the mount that the user namespace can't clear.
+func remountBind(path string, flags uintptr) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return err
+	}
+
+	// Statfs flags share their values with the corresponding mount flags
+	flags |= syscall.MS_BIND | syscall.MS_REMOUNT | uintptr(st.Flags)&lockedMountFlags
+
+	return syscall.Mount("", path, "", flags, "")
+}
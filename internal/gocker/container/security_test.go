@@ -0,0 +1,196 @@
+package container
+
+import (
+	"encoding/binary"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// runFilter interprets the subset of classic BPF emitted by compile
+// against the seccomp data of a syscall and returns the filter result.
+func runFilter(t *testing.T, filter []syscall.SockFilter, nr uint32, args ...uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[seccompDataNr:], nr)
+	binary.LittleEndian.PutUint32(data[seccompDataArch:], auditArch)
+	for i, a := range args {
+		binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], a)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		in := filter[pc]
+
+		switch in.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[in.K:])
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			acc &= in.K
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			pc += int(jump(acc == in.K, in))
+		case syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K:
+			pc += int(jump(acc > in.K, in))
+		case syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			pc += int(jump(acc >= in.K, in))
+		case syscall.BPF_RET | syscall.BPF_K:
+			return in.K
+		default:
+			t.Fatalf("Unexpected BPF instruction %#x", in.Code)
+		}
+	}
+
+	t.Fatal("Filter ended without returning")
+	return 0
+}
+
+// jump selects the jump offset of a conditional instruction.
+func jump(cond bool, in syscall.SockFilter) uint8 {
+	if cond {
+		return in.Jt
+	}
+	return in.Jf
+}
+
+// TestSeccompCompile tests that compiled filters apply rules and argument conditions
+func TestSeccompCompile(t *testing.T) {
+	errno := uint32(13)
+	profile := &SeccompProfile{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls: []SeccompSyscall{
+			{Names: []string{"ptrace", "no_such_syscall"}, Action: "SCMP_ACT_ERRNO"},
+			{Name: "personality", Action: "SCMP_ACT_ERRNO", ErrnoRet: &errno, Args: []SeccompArg{
+				{Index: 0, Value: 0x100000000, Op: "SCMP_CMP_GE"},
+			}},
+			{Name: "clone", Action: "SCMP_ACT_KILL", Args: []SeccompArg{
+				{Index: 0, Value: syscall.CLONE_NEWUSER, ValueTwo: syscall.CLONE_NEWUSER, Op: "SCMP_CMP_MASKED_EQ"},
+			}},
+			{Name: "mount", Action: "SCMP_ACT_ERRNO", Excludes: SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		},
+	}
+
+	filter, err := profile.compile(defaultCapabilities)
+	if err != nil {
+		t.Fatalf("Failed to compile profile: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		syscall  string
+		args     []uint64
+		expected uint32
+	}{
+		{name: "allowed by default", syscall: "read", expected: seccompRetAllow},
+		{name: "denied", syscall: "ptrace", expected: seccompRetErrno | uint32(syscall.EPERM)},
+		{name: "argument below", syscall: "personality", args: []uint64{0xffffffff}, expected: seccompRetAllow},
+		{name: "argument above", syscall: "personality", args: []uint64{0x100000001}, expected: seccompRetErrno | errno},
+		{name: "masked match", syscall: "clone", args: []uint64{syscall.CLONE_NEWUSER | syscall.CLONE_VM}, expected: seccompRetKillThread},
+		{name: "masked mismatch", syscall: "clone", args: []uint64{syscall.CLONE_VM}, expected: seccompRetAllow},
+		{name: "excluded by capability", syscall: "mount", expected: seccompRetErrno | uint32(syscall.EPERM)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runFilter(t, filter, syscallNumbers[tt.syscall], tt.args...); got != tt.expected {
+				t.Errorf("Expected %#x, got %#x", tt.expected, got)
+			}
+		})
+	}
+
+	// The rule is skipped for containers with CAP_SYS_ADMIN
+	filter, err = profile.compile(append(slices.Clone(defaultCapabilities), "CAP_SYS_ADMIN"))
+	if err != nil {
+		t.Fatalf("Failed to compile profile: %v", err)
+	}
+
+	if got := runFilter(t, filter, syscallNumbers["mount"]); got != seccompRetAllow {
+		t.Errorf("Expected mount to be allowed with CAP_SYS_ADMIN, got %#x", got)
+	}
+}
+
+// TestDefaultSeccompProfile tests that the built-in profile compiles
+func TestDefaultSeccompProfile(t *testing.T) {
+	profile, err := loadSeccompProfile("")
+	if err != nil {
+		t.Fatalf("Failed to load default profile: %v", err)
+	}
+
+	if _, err := profile.compile(defaultCapabilities); err != nil {
+		t.Fatalf("Failed to compile default profile: %v", err)
+	}
+
+	if profile, _ := loadSeccompProfile(SeccompUnconfined); profile != nil {
+		t.Error("Expected no profile for an unconfined container")
+	}
+}
+
+// TestCapabilitySet tests applying --cap-add and --cap-drop to the default set
+func TestCapabilitySet(t *testing.T) {
+	tests := []struct {
+		name          string
+		add, drop     []string
+		contains      []string
+		missing       []string
+		expectedError bool
+	}{
+		{name: "default", contains: []string{"CAP_CHOWN", "CAP_KILL"}, missing: []string{"CAP_SYS_ADMIN"}},
+		{name: "add", add: []string{"sys_ptrace"}, contains: []string{"CAP_SYS_PTRACE", "CAP_CHOWN"}},
+		{name: "drop", drop: []string{"CAP_NET_RAW"}, missing: []string{"CAP_NET_RAW"}},
+		{name: "drop all", add: []string{"NET_ADMIN"}, drop: []string{"all"}, contains: []string{"CAP_NET_ADMIN"}, missing: []string{"CAP_CHOWN"}},
+		{name: "add all", add: []string{"ALL"}, drop: []string{"SYS_ADMIN"}, contains: []string{"CAP_SYS_MODULE"}, missing: []string{"CAP_SYS_ADMIN"}},
+		{name: "unknown", add: []string{"CAP_FLY"}, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps, err := capabilitySet(tt.add, tt.drop)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error, got %v", caps)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			for _, c := range tt.contains {
+				if !slices.Contains(caps, c) {
+					t.Errorf("Expected %s in %v", c, caps)
+				}
+			}
+			for _, c := range tt.missing {
+				if slices.Contains(caps, c) {
+					t.Errorf("Unexpected %s in %v", c, caps)
+				}
+			}
+		})
+	}
+}
+
+// TestSetSecurityOpts tests parsing of the --security-opt flag values
+func TestSetSecurityOpts(t *testing.T) {
+	var o Options
+	if err := o.SetSecurityOpts([]string{"seccomp=/tmp/profile.json", "no-new-privileges"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if o.Seccomp != "/tmp/profile.json" || !o.NoNewPrivileges {
+		t.Errorf("Unexpected options: %+v", o)
+	}
+
+	if err := o.SetSecurityOpts([]string{"no-new-privileges:false", "seccomp:unconfined"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if o.Seccomp != SeccompUnconfined || o.NoNewPrivileges {
+		t.Errorf("Unexpected options: %+v", o)
+	}
+
+	for _, opt := range []string{"apparmor=unconfined", "seccomp=", "no-new-privileges:maybe"} {
+		if err := o.SetSecurityOpts([]string{opt}); err == nil {
+			t.Errorf("Expected error for %q", opt)
+		}
+	}
+}
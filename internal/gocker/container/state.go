@@ -0,0 +1,271 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+)
+
+const (
+	// RelativeContainersPath is the relative containers path under the user's home directory.
+	RelativeContainersPath = ".local/share/gocker/containers/"
+
+	stateFile = "state.json"
+	lockFile  = ".lock"
+	logFile   = "container.log"
+
+	// exitSignalFile records the signal that killed the container command
+	exitSignalFile = "exit-signal"
+
+	// execFifoFile is the FIFO a container created without being started waits on
+	execFifoFile = "exec.fifo"
+)
+
+// Status describes the lifecycle phase of a container.
+type Status string
+
+const (
+	StatusCreated    Status = "created"
+	StatusRunning    Status = "running"
+	StatusRestarting Status = "restarting"
+	StatusExited     Status = "exited"
+)
+
+// State is the persisted record of a container, shared between
+// the supervisor process and the CLI commands that inspect it.
+type State struct {
+	ID            string
+	Name          string
+	Image         string
+	Root          string            `json:",omitempty"`
+	Bundle        string            `json:",omitempty"`
+	Annotations   map[string]string `json:",omitempty"`
+	Command       []string
+	Created       time.Time
+	Status        Status
+	Pid           int
+	SupervisorPid int
+	ExitCode      int
+	ExitSignal    string `json:",omitempty"`
+	OOMKilled     bool
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	RestartPolicy RestartPolicy
+	RestartCount  int
+	StopSignal    string
+	StopRequested bool
+	AutoRemove    bool
+	NetworkMode   string     `json:",omitempty"`
+	Health        *Health    `json:",omitempty"`
+	Resources     *Resources `json:",omitempty"`
+	Paused        bool
+	ExecArgs      []string `json:",omitempty"`
+}
+
+// IsRunning reports whether the container has a live supervisor
+// that is either running or about to restart the container.
+func (s *State) IsRunning() bool {
+	if s.Status != StatusRunning && s.Status != StatusRestarting {
+		return false
+	}
+
+	// A supervisor killed without cleaning up leaves a stale state behind
+	return s.supervised()
+}
+
+// supervised reports whether the supervisor of the container is alive.
+func (s *State) supervised() bool {
+	return s.SupervisorPid != 0 && syscall.Kill(s.SupervisorPid, 0) == nil
+}
+
+// Abandoned reports whether the container stays created for good,
+// as its supervisor is gone without having run it.
+func (s *State) Abandoned() bool {
+	return s.Status == StatusCreated && s.SupervisorPid != 0 && !s.supervised()
+}
+
+// ShortID returns the abbreviated container ID used in CLI output.
+func (s *State) ShortID() string {
+	return shortID(s.ID)
+}
+
+// containersRoot returns the directory holding all container state directories.
+func containersRoot() string {
+	return filepath.Join(os.Getenv("HOME"), RelativeContainersPath)
+}
+
+// containerDir returns the state directory of the container with the given ID.
+func containerDir(id string) string {
+	return filepath.Join(containersRoot(), id)
+}
+
+// LogPath returns the path of the log of the container with the given full ID, which
+// holds the output of the container when it runs in the background.
+func LogPath(id string) string {
+	return filepath.Join(containerDir(id), logFile)
+}
+
+// LoadState reads the state of the container with the given full ID.
+func LoadState(id string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(containerDir(id), stateFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container state: %v", err)
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode container state: %v", err)
+	}
+
+	return &s, nil
+}
+
+// ListStates returns the states of all known containers, newest first.
+func ListStates() ([]*State, error) {
+	entries, err := os.ReadDir(containersRoot())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read containers dir: %v", err)
+	}
+
+	var states []*State
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		s, err := LoadState(e.Name())
+		if err != nil {
+			// Skip containers that are being created or removed
+			continue
+		}
+
+		states = append(states, s)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Created.After(states[j].Created)
+	})
+
+	return states, nil
+}
+
+// FindState looks up a container by name, full ID or unique ID prefix.
+func FindState(ref string) (*State, error) {
+	states, err := ListStates()
+	if err != nil {
+		return nil, err
+	}
+
+	var match *State
+	for _, s := range states {
+		if s.ID == ref || (s.Name != "" && s.Name == ref) {
+			return s, nil
+		}
+
+		if strings.HasPrefix(s.ID, ref) {
+			if match != nil {
+				return nil, fmt.Errorf("multiple containers match %q", ref)
+			}
+			match = s
+		}
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("no such container: %s", ref)
+	}
+
+	return match, nil
+}
+
+// ImageUsers returns the containers created from the image with the given name.
+// Containers run on the root filesystem of their image, so it must outlive them.
+func ImageUsers(name string) ([]*State, error) {
+	states, err := ListStates()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*State
+	for _, s := range states {
+		if image.Path(s.Image) == image.Path(name) {
+			users = append(users, s)
+		}
+	}
+
+	return users, nil
+}
+
+// UpdateState applies fn to the stored state of the container while holding
+// an exclusive lock, so concurrent writers don't lose each other's changes.
+func UpdateState(id string, fn func(*State)) error {
+	lock, err := os.OpenFile(filepath.Join(containerDir(id), lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open state lock: %v", err)
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock container state: %v", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	fn(s)
+
+	return s.save()
+}
+
+// save atomically writes the state into the container directory.
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal container state: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial state
+	path := filepath.Join(containerDir(s.ID), stateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write container state: %v", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save container state: %v", err)
+	}
+
+	return nil
+}
+
+// newID generates a random 64 character hexadecimal container ID.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate container ID: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// shortID returns the first 12 characters of a container ID.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
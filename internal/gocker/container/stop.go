@@ -0,0 +1,143 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+)
+
+const (
+	pollInterval = 100 * time.Millisecond
+
+	// killTimeout is how long to wait for the supervisor to notice a SIGKILL
+	killTimeout = 5 * time.Second
+)
+
+// Stop sends the container's stop signal to its init process and
+// kills it if it's still running after the given timeout.
+// Stopped containers are never restarted by their restart policy.
+func Stop(id string, timeout time.Duration) error {
+	var s *State
+	if err := UpdateState(id, func(st *State) {
+		st.StopRequested = true
+		s = st
+	}); err != nil {
+		return err
+	}
+
+	if !s.IsRunning() {
+		return nil
+	}
+
+	// Frozen processes can't handle the stop signal
+	if s.Paused {
+		if err := Unpause(id); err != nil {
+			return err
+		}
+	}
+
+	sig, err := ParseSignal(s.StopSignal)
+	if err != nil {
+		return err
+	}
+
+	if s.Pid != 0 {
+		if err := syscall.Kill(s.Pid, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to send %v to container: %v", sig, err)
+		}
+	}
+
+	if waitStopped(id, timeout) {
+		return nil
+	}
+
+	// The process ignored the stop signal, kill the whole PID namespace through its init
+	if s, err = LoadState(id); err != nil {
+		return err
+	}
+
+	if s.Pid != 0 {
+		if err := syscall.Kill(s.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to kill container: %v", err)
+		}
+	}
+
+	if !waitStopped(id, killTimeout) {
+		return fmt.Errorf("container %s did not stop", shortID(id))
+	}
+
+	return nil
+}
+
+// Wait blocks until the container stops and returns its final state.
+// Containers that were never started or never will be can't be waited for.
+func Wait(id string) (*State, error) {
+	for {
+		s, err := LoadState(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case s.Status == StatusCreated && s.SupervisorPid == 0:
+			return nil, fmt.Errorf("container %s was never started", shortID(id))
+		case s.Abandoned():
+			return nil, fmt.Errorf("supervisor of container %s exited before running it", shortID(id))
+		case s.Status != StatusCreated && !s.IsRunning():
+			return s, nil
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// Remove deletes the container state directory.
+// A running container is only removed when force is set, after killing it.
+func Remove(id string, force bool) error {
+	s, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+
+	if s.IsRunning() {
+		if !force {
+			return fmt.Errorf("cannot remove running container %s, stop it first", s.ShortID())
+		}
+
+		if err := Stop(id, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := network.DisconnectAll(id); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(containerDir(id)); err != nil {
+		return fmt.Errorf("failed to remove container dir: %v", err)
+	}
+
+	return nil
+}
+
+// waitStopped polls the container state until it's no longer
+// running or the timeout expires, and reports whether it stopped.
+func waitStopped(id string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		s, err := LoadState(id)
+		if err != nil || !s.IsRunning() {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
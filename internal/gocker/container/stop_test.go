@@ -0,0 +1,42 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestWaitNotStarted tests that waiting for a container that never runs fails instead of blocking
+func TestWaitNotStarted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// The pid of a process that is gone
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	gone := cmd.Process.Pid
+
+	for _, s := range []*State{
+		{ID: "created", Status: StatusCreated},
+		{ID: "abandoned", Status: StatusCreated, SupervisorPid: gone},
+		{ID: "exited", Status: StatusExited, SupervisorPid: gone, ExitCode: 3},
+	} {
+		if err := os.MkdirAll(containerDir(s.ID), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{"created", "abandoned"} {
+		if _, err := Wait(id); err == nil {
+			t.Errorf("Expected waiting for the %s container to fail", id)
+		}
+	}
+
+	if s, err := Wait("exited"); err != nil || s.ExitCode != 3 {
+		t.Errorf("Expected the state of the exited container, got %+v (%v)", s, err)
+	}
+}
@@ -0,0 +1,386 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/network"
+)
+
+// create registers the container by writing its initial state.
+func (c *Container) create() error {
+	if c.Name != "" {
+		if s, err := FindState(c.Name); err == nil && s.Name == c.Name {
+			return fmt.Errorf("container name %q is already in use by container %s", c.Name, s.ShortID())
+		}
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create container dir: %v", err)
+	}
+
+	endpoints, err := c.connectNetwork()
+	if err != nil {
+		os.RemoveAll(c.dir)
+		return err
+	}
+
+	if err := c.writeNetworkFiles(endpoints); err != nil {
+		network.DisconnectAll(c.ID)
+		os.RemoveAll(c.dir)
+		return err
+	}
+
+	resources := defaultResources
+	if c.resources != nil {
+		resources = *c.resources
+	}
+
+	s := &State{
+		ID:            c.ID,
+		Name:          c.Name,
+		Image:         c.imgName,
+		Root:          c.imgRoot,
+		Bundle:        c.Bundle,
+		Command:       append([]string{c.cmd}, c.args...),
+		Created:       time.Now(),
+		Status:        StatusCreated,
+		RestartPolicy: c.RestartPolicy,
+		StopSignal:    c.StopSignal,
+		AutoRemove:    c.Remove,
+		NetworkMode:   c.networkMode(),
+		Resources:     &resources,
+		ExecArgs:      c.ExecArgs,
+	}
+	if c.spec != nil {
+		s.Annotations = c.spec.Annotations
+	}
+
+	// Containers created without being started wait for Start on the exec FIFO
+	if c.DeferStart {
+		if err := syscall.Mkfifo(filepath.Join(c.dir, execFifoFile), 0600); err != nil {
+			network.DisconnectAll(c.ID)
+			os.RemoveAll(c.dir)
+			return fmt.Errorf("failed to create exec FIFO: %v", err)
+		}
+	}
+
+	return s.save()
+}
+
+// detach starts the container supervisor in the background and prints the container ID.
+func (c *Container) detach() error {
+	cmd, err := c.startSupervisor()
+	if err != nil {
+		return err
+	}
+
+	// Bundle containers are known by their name
+	if c.Bundle == "" {
+		fmt.Println(c.ID)
+	}
+
+	return cmd.Process.Release()
+}
+
+// startSupervisor starts the supervisor of the container in a new session, logging to the container log.
+func (c *Container) startSupervisor() (*exec.Cmd, error) {
+	log, err := os.OpenFile(filepath.Join(c.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file: %v", err)
+	}
+	defer log.Close()
+
+	cmd := exec.Command("/proc/self/exe", c.execArgs()...)
+
+	cmd.Env = append(os.Environ(), "IS_SUPERVISOR=1", "CONTAINER_ID="+c.ID)
+	cmd.Stdout, cmd.Stderr = log, log
+
+	// Start a new session so the supervisor outlives the terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start container supervisor: %v", err)
+	}
+
+	// Recorded right away, so the container isn't taken for one that was never started
+	UpdateState(c.ID, func(s *State) { s.SupervisorPid = cmd.Process.Pid })
+
+	return cmd, nil
+}
+
+// logWarning writes a warning to the container log, which detached containers log to
+// anyway. The output of attached containers is left as the command writes it.
+func (c *Container) logWarning(format string, args ...any) {
+	log, err := os.OpenFile(filepath.Join(c.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer log.Close()
+
+	fmt.Fprintf(log, "Warning: "+format+"\n", args...)
+}
+
+// Create registers the container without starting it, for Start to run it later.
+func (c *Container) Create() error {
+	return c.create()
+}
+
+// Restore returns the container of a state Create wrote, so a process other
+// than the one that created the container can Start it.
+func Restore(s *State) (*Container, error) {
+	if s.Status != StatusCreated || s.SupervisorPid != 0 || len(s.ExecArgs) == 0 {
+		return nil, fmt.Errorf("container %s is not waiting to be started", shortID(s.ID))
+	}
+
+	c := &Container{
+		Options:    Options{Detach: true, ExecArgs: s.ExecArgs},
+		ID:         s.ID,
+		dir:        containerDir(s.ID),
+		cgroupPath: cgroupDir(s.ID),
+	}
+
+	return c, nil
+}
+
+// Start runs a container registered by Create in the background. The supervisor
+// is reaped once it exits, since the caller outlives it unlike the CLI does.
+func (c *Container) Start() error {
+	cmd, err := c.startSupervisor()
+	if err != nil {
+		return err
+	}
+
+	go cmd.Wait()
+
+	return nil
+}
+
+// supervise runs the container until it exits for good,
+// restarting it according to its restart policy.
+func (c *Container) supervise() error {
+	if err := UpdateState(c.ID, func(s *State) { s.SupervisorPid = os.Getpid() }); err != nil {
+		return err
+	}
+
+	if err := c.setupCgroup(); err != nil {
+		s, lerr := LoadState(c.ID)
+		if lerr != nil {
+			return lerr
+		}
+
+		// Limits asked for aren't dropped silently, the defaults are only applied where possible
+		if s.resources().custom() {
+			UpdateState(c.ID, func(s *State) {
+				s.Status, s.ExitCode, s.FinishedAt = StatusExited, 125, time.Now()
+			})
+			c.cleanupCgroup()
+			return fmt.Errorf("failed to apply resource limits: %v", err)
+		}
+
+		c.logWarning("%v, the container runs without resource limits and OOM kills aren't reported", err)
+	}
+	defer c.cleanupCgroup()
+
+	stopDNS := c.serveDNS()
+	defer stopDNS()
+
+	// Termination signals sent to the supervisor stop the container for good
+	var stopRequested atomic.Bool
+	stop := make(chan struct{})
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	go func() {
+		for sig := range sigs {
+			if !stopRequested.Swap(true) {
+				close(stop)
+			}
+			c.signalChild(sig)
+		}
+	}()
+
+	var (
+		err   error
+		delay time.Duration
+	)
+
+	for {
+		s, lerr := LoadState(c.ID)
+		if lerr != nil {
+			return lerr
+		}
+		if s.StopRequested || stopRequested.Load() {
+			break
+		}
+
+		started := time.Now()
+		err = c.runParentProcess()
+
+		code, exited := ExitCode(err)
+		sig := c.exitSignal(err)
+		if !exited {
+			// The child could not be started at all, so restarting is pointless
+			UpdateState(c.ID, func(s *State) {
+				s.Status, s.Pid, s.ExitCode, s.FinishedAt = StatusExited, 0, 125, time.Now()
+			})
+			break
+		}
+
+		var restart, oomKilled, stopping bool
+		UpdateState(c.ID, func(s *State) {
+			s.Pid, s.ExitCode, s.FinishedAt, s.Paused = 0, code, time.Now(), false
+			if sig != 0 {
+				s.ExitSignal = signalName(sig)
+			}
+			oomKilled, stopping = s.OOMKilled, s.StopRequested || stopRequested.Load()
+
+			restart = s.RestartPolicy.ShouldRestart(code, s.RestartCount, stopping)
+			if restart {
+				s.Status = StatusRestarting
+				s.RestartCount++
+			} else {
+				s.Status = StatusExited
+			}
+		})
+
+		// Containers stopped on request die by their stop signal as expected
+		if !stopping && (oomKilled || sig != 0) {
+			fmt.Fprintln(os.Stderr, c.exitMessage(code, sig, oomKilled))
+		}
+
+		if !restart {
+			break
+		}
+
+		delay = nextRestartDelay(delay, time.Since(started))
+		if !c.backoff(delay, stop) {
+			break
+		}
+	}
+
+	// A stop requested while restarting leaves a restarting state behind
+	UpdateState(c.ID, func(s *State) {
+		if s.Status != StatusExited {
+			s.Status, s.FinishedAt = StatusExited, time.Now()
+		}
+	})
+
+	if c.Remove {
+		if rerr := network.DisconnectAll(c.ID); rerr != nil {
+			fmt.Printf("Warning: Failed to disconnect container from its networks: %v\n", rerr)
+		}
+		if rerr := os.RemoveAll(c.dir); rerr != nil {
+			fmt.Printf("Warning: Failed to remove container directory: %v\n", rerr)
+		}
+	}
+
+	return err
+}
+
+// backoff sleeps for the given delay before a restart. It returns false as soon
+// as a stop is requested either through a signal or through the container state.
+func (c *Container) backoff(delay time.Duration, stop <-chan struct{}) bool {
+	deadline := time.Now().Add(delay)
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(min(pollInterval, time.Until(deadline))):
+		}
+
+		if s, err := LoadState(c.ID); err != nil || s.StopRequested {
+			return false
+		}
+	}
+
+	return true
+}
+
+// signalChild sends the signal to the running child process, if any.
+func (c *Container) signalChild(sig os.Signal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.child != nil {
+		c.child.Signal(sig)
+	}
+}
+
+// ExitCode converts the error returned by a finished child process into the
+// container exit code. Deaths by signal are reported as 128 + signal number.
+// It returns false if the error doesn't come from an exited process.
+func ExitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	if sig := killedBy(err); sig != 0 {
+		return 128 + int(sig), true
+	}
+
+	return exitErr.ExitCode(), true
+}
+
+// killedBy returns the signal that killed the process the error comes from, if any.
+func killedBy(err error) syscall.Signal {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0
+	}
+
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal()
+	}
+
+	return 0
+}
+
+// exitSignal returns the signal that killed the container, if any: the one that killed
+// the child itself, or the one the child recorded for its command.
+func (c *Container) exitSignal(err error) syscall.Signal {
+	path := filepath.Join(c.dir, exitSignalFile)
+
+	data, _ := os.ReadFile(path)
+	os.Remove(path)
+
+	if sig := killedBy(err); sig != 0 {
+		return sig
+	}
+
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0
+	}
+
+	return syscall.Signal(n)
+}
+
+// exitMessage explains why the container exited, telling deaths by signal and by
+// the OOM killer apart from the exit codes of the command.
+func (c *Container) exitMessage(code int, sig syscall.Signal, oomKilled bool) string {
+	switch {
+	case oomKilled:
+		return fmt.Sprintf("Error: %s and exited with code %d (OOMKilled)", c.outOfMemory(), code)
+	case sig != 0:
+		return fmt.Sprintf("Error: container %s was killed by signal %s (exit code %d)", shortID(c.ID), signalName(sig), code)
+	}
+
+	return fmt.Sprintf("Container %s exited with code %d", shortID(c.ID), code)
+}
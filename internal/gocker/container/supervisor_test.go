@@ -0,0 +1,29 @@
+package container
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestRestore tests that only containers created for a later start are restored from their state
+func TestRestore(t *testing.T) {
+	args := []string{"run", "--detach", "alpine", "true"}
+
+	c, err := Restore(&State{ID: "pending", Status: StatusCreated, ExecArgs: args})
+	if err != nil {
+		t.Fatalf("Failed to restore container: %v", err)
+	}
+	if c.ID != "pending" || c.dir != containerDir("pending") || !slices.Equal(c.execArgs(), args) {
+		t.Errorf("Expected the container of the state, got %+v", c)
+	}
+
+	for _, s := range []*State{
+		{ID: "cli", Status: StatusCreated},
+		{ID: "started", Status: StatusCreated, SupervisorPid: 1, ExecArgs: args},
+		{ID: "exited", Status: StatusExited, ExecArgs: args},
+	} {
+		if _, err := Restore(s); err == nil {
+			t.Errorf("Expected the %s container not to be restored", s.ID)
+		}
+	}
+}
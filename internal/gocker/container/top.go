@@ -0,0 +1,220 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc, USER_HZ on every architecture Go supports.
+const clockTicks = 100
+
+// Process is a process running in a container, with the details ps -ef shows.
+type Process struct {
+	UID   string
+	PID   int
+	PPID  int
+	CPU   int
+	Start time.Time
+	TTY   string
+	Time  time.Duration
+	Cmd   string
+}
+
+// Top returns the processes of a running container, as listed in its cgroup. Containers
+// without a cgroup of their own have the processes descending from their init process.
+func Top(id string) ([]Process, error) {
+	s, err := LoadState(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.IsRunning() || s.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", s.ShortID())
+	}
+
+	pids, err := containerPids(s)
+	if err != nil {
+		return nil, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []Process
+	for _, pid := range pids {
+		// Processes that exited in the meantime are left out
+		if p, err := readProcess(pid, boot); err == nil {
+			processes = append(processes, p)
+		}
+	}
+
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+
+	return processes, nil
+}
+
+// containerPids returns the host PIDs of the processes of a running container.
+func containerPids(s *State) ([]int, error) {
+	var pids []int
+
+	data, err := os.ReadFile(filepath.Join(cgroupDir(s.ID), "cgroup.procs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cgroup processes: %v", err)
+	}
+
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	if len(pids) > 0 {
+		return pids, nil
+	}
+
+	pids = []int{s.Pid}
+	for i := 0; i < len(pids); i++ {
+		tasks, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(pids[i]), "task"))
+		if err != nil {
+			continue
+		}
+
+		for _, task := range tasks {
+			children, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pids[i]), "task", task.Name(), "children"))
+			if err != nil {
+				continue
+			}
+
+			for _, field := range strings.Fields(string(children)) {
+				if pid, err := strconv.Atoi(field); err == nil {
+					pids = append(pids, pid)
+				}
+			}
+		}
+	}
+
+	return pids, nil
+}
+
+// readProcess reads the details of a process from /proc.
+func readProcess(pid int, boot time.Time) (Process, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return Process{}, err
+	}
+
+	// The command name is in parentheses and may contain spaces and parentheses itself
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return Process{}, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	comm := string(stat[open+1 : end])
+
+	// Fields from the state on, the third field of the file
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return Process{}, fmt.Errorf("invalid stat of process %d", pid)
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	ttyNr, _ := strconv.Atoi(fields[4])
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	starttime, _ := strconv.ParseInt(fields[19], 10, 64)
+
+	p := Process{
+		PID:   pid,
+		PPID:  ppid,
+		Start: boot.Add(time.Duration(starttime) * time.Second / clockTicks),
+		TTY:   ttyName(ttyNr),
+		Time:  time.Duration(utime+stime) * time.Second / clockTicks,
+		Cmd:   "[" + comm + "]",
+	}
+
+	if elapsed := time.Since(p.Start); elapsed > 0 {
+		p.CPU = int(100 * p.Time / elapsed)
+	}
+
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Cmd = strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+	}
+
+	p.UID, err = processUser(dir)
+	if err != nil {
+		return Process{}, err
+	}
+
+	return p, nil
+}
+
+// processUser returns the name of the real user of a process, or its UID if it has none on the host.
+func processUser(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+
+		if u, err := user.LookupId(fields[1]); err == nil {
+			return u.Username, nil
+		}
+		return fields[1], nil
+	}
+
+	return "", fmt.Errorf("no user in %s", filepath.Join(dir, "status"))
+}
+
+// ttyName returns the name of the controlling terminal with the given device number, ? for none.
+func ttyName(dev int) string {
+	major, minor := (dev>>8)&0xfff, (dev&0xff)|((dev>>12)&0xfff00)
+
+	switch {
+	case major >= 136 && major <= 143:
+		return "pts/" + strconv.Itoa((major-136)*256+minor)
+	case major == 4 && minor < 64:
+		return "tty" + strconv.Itoa(minor)
+	case major == 4:
+		return "ttyS" + strconv.Itoa(minor-64)
+	}
+
+	return "?"
+}
+
+// bootTime returns the time the host booted, which process start times are relative to.
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read boot time: %v", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid boot time: %q", value)
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no boot time in /proc/stat")
+}
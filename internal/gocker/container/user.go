@@ -0,0 +1,120 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	passwdFile    = "/etc/passwd"
+	groupFile     = "/etc/group"
+	setgroupsFile = "/proc/self/setgroups"
+)
+
+// LookupUser resolves a user in the user[:group] format, where both can be names or
+// numeric IDs, against the passwd and group files of the root filesystem, which is "/"
+// after changing root. Without a user the credentials are left alone and nil is returned.
+func LookupUser(root, spec string) (*syscall.Credential, string, error) {
+	if spec == "" {
+		return nil, "", nil
+	}
+
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+
+	uid, gid, home := -1, -1, ""
+	name := userPart
+
+	for _, fields := range readDatabase(filepath.Join(root, passwdFile), 7) {
+		if fields[0] == userPart || fields[2] == userPart {
+			name, home = fields[0], fields[5]
+			uid, _ = strconv.Atoi(fields[2])
+			gid, _ = strconv.Atoi(fields[3])
+			break
+		}
+	}
+
+	if uid < 0 {
+		n, err := strconv.ParseUint(userPart, 10, 32)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		uid, gid = int(n), 0
+	}
+
+	var groups []uint32
+	for _, fields := range readDatabase(filepath.Join(root, groupFile), 4) {
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if hasGroup && (fields[0] == groupPart || fields[2] == groupPart) {
+			gid, hasGroup = id, false
+		}
+
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == name && id != gid {
+				groups = append(groups, uint32(id))
+			}
+		}
+	}
+
+	if hasGroup {
+		n, err := strconv.ParseUint(groupPart, 10, 32)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+		}
+		gid = int(n)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+
+	// Supplementary groups can't be set in user namespaces which deny setgroups
+	if data, err := os.ReadFile(setgroupsFile); err == nil && strings.TrimSpace(string(data)) == "deny" {
+		cred.Groups, cred.NoSetGroups = nil, true
+	}
+
+	return cred, home, nil
+}
+
+// readDatabase returns the entries of a colon separated database file like /etc/passwd
+// that have the given number of fields. A missing file has no entries.
+func readDatabase(path string, fields int) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if parts := strings.Split(line, ":"); len(parts) == fields {
+			entries = append(entries, parts)
+		}
+	}
+
+	return entries
+}
+
+// hasEnv reports whether the environment sets the variable.
+func hasEnv(env []string, name string) bool {
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == name {
+			return true
+		}
+	}
+
+	return false
+}
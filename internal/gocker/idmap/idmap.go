@@ -0,0 +1,448 @@
+package idmap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	subUIDFile = "/etc/subuid"
+	subGIDFile = "/etc/subgid"
+
+	// overflowID is the ID unmapped IDs appear as, like the kernel's overflowuid
+	overflowID = 65534
+
+	// identitySize is the number of IDs mapped onto themselves for root without subordinate IDs
+	identitySize = 65536
+
+	// inNamespaceEnv marks a process re-executed inside a user namespace by ReexecInNamespace
+	inNamespaceEnv = "GOCKER_USERNS"
+
+	// mappedEnv marks a child that executed itself again after its mappings were written
+	mappedEnv = "GOCKER_USERNS_MAPPED"
+
+	// syncFd is the descriptor on which a child waits until its mappings are written
+	syncFd = 3
+)
+
+// Mapping maps a contiguous range of container IDs onto host IDs.
+type Mapping struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// Mappings holds the UID and GID mappings of a user namespace.
+type Mappings struct {
+	UIDs []Mapping
+	GIDs []Mapping
+}
+
+// ParseMapping parses a mapping in the container_id:host_id:size format.
+func ParseMapping(s string) (Mapping, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Mapping{}, fmt.Errorf("invalid ID mapping %q, expected container_id:host_id:size", s)
+	}
+
+	var ids [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Mapping{}, fmt.Errorf("invalid ID mapping %q: %q is not a valid ID", s, p)
+		}
+		ids[i] = n
+	}
+
+	if ids[2] == 0 {
+		return Mapping{}, fmt.Errorf("invalid ID mapping %q: size must be positive", s)
+	}
+
+	return Mapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// New builds mappings from --uidmap and --gidmap values. Without GID
+// mappings the UID mappings are used for both. Without any mapping,
+// the default mappings for the current user are returned.
+func New(uidMaps, gidMaps []string) (*Mappings, error) {
+	if len(uidMaps) == 0 && len(gidMaps) == 0 {
+		return Default()
+	}
+
+	if len(gidMaps) == 0 {
+		gidMaps = uidMaps
+	}
+	if len(uidMaps) == 0 {
+		uidMaps = gidMaps
+	}
+
+	m := &Mappings{}
+	for _, s := range uidMaps {
+		mapping, err := ParseMapping(s)
+		if err != nil {
+			return nil, err
+		}
+		m.UIDs = append(m.UIDs, mapping)
+	}
+
+	for _, s := range gidMaps {
+		mapping, err := ParseMapping(s)
+		if err != nil {
+			return nil, err
+		}
+		m.GIDs = append(m.GIDs, mapping)
+	}
+
+	return m, nil
+}
+
+// Default returns the mappings for the current user. Container root is the
+// user itself and the following IDs come from the user's subordinate ID
+// ranges in /etc/subuid and /etc/subgid. Root without subordinate IDs gets
+// an identity mapping, any other user just the mapping of container root.
+func Default() (*Mappings, error) {
+	uid, gid := os.Getuid(), os.Getgid()
+
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+
+	subUIDs, err := readSubIDs(subUIDFile, name, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	subGIDs, err := readSubIDs(subGIDFile, name, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid == 0 && (len(subUIDs) == 0 || len(subGIDs) == 0) {
+		return &Mappings{
+			UIDs: []Mapping{{ContainerID: 0, HostID: 0, Size: identitySize}},
+			GIDs: []Mapping{{ContainerID: 0, HostID: 0, Size: identitySize}},
+		}, nil
+	}
+
+	return &Mappings{
+		UIDs: append([]Mapping{{ContainerID: 0, HostID: uid, Size: 1}}, stack(subUIDs, 1)...),
+		GIDs: append([]Mapping{{ContainerID: 0, HostID: gid, Size: 1}}, stack(subGIDs, 1)...),
+	}, nil
+}
+
+// readSubIDs returns the subordinate ID ranges of the user from a subuid or subgid file.
+// Entries are matched by user name or numeric ID. A missing file means no ranges.
+func readSubIDs(path, name string, id int) ([]Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var ranges []Mapping
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || (parts[0] != name && parts[0] != strconv.Itoa(id)) {
+			continue
+		}
+
+		start, err1 := strconv.Atoi(parts[1])
+		count, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid entry in %s: %q", path, line)
+		}
+
+		ranges = append(ranges, Mapping{HostID: start, Size: count})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	return ranges, nil
+}
+
+// stack places host ID ranges one after another in the container ID space from first on.
+func stack(ranges []Mapping, first int) []Mapping {
+	mappings := make([]Mapping, 0, len(ranges))
+
+	for _, r := range ranges {
+		mappings = append(mappings, Mapping{ContainerID: first, HostID: r.HostID, Size: r.Size})
+		first += r.Size
+	}
+
+	return mappings
+}
+
+// toHost translates a container ID into the host ID it's mapped onto.
+func toHost(mappings []Mapping, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+
+	return 0, false
+}
+
+// Lchown gives a file extracted for containers to the host IDs the container IDs map to.
+// Inside a namespace set up by ReexecInNamespace the kernel does the translation.
+// Ownership is left alone for unmapped IDs and when the process can't change it.
+func (m *Mappings) Lchown(path string, uid, gid int) error {
+	if InNamespace() {
+		// IDs the namespace doesn't map keep the default owner
+		if err := os.Lchown(path, uid, gid); err != nil && !errors.Is(err, syscall.EINVAL) {
+			return err
+		}
+
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	hostUID, uidOK := toHost(m.UIDs, uid)
+	hostGID, gidOK := toHost(m.GIDs, gid)
+	if !uidOK || !gidOK {
+		return nil
+	}
+
+	return os.Lchown(path, hostUID, hostGID)
+}
+
+// ToContainer translates the owner of a file extracted for containers back into
+// container IDs, reversing Lchown. Unmapped IDs belong to the overflow ID nobody.
+func (m *Mappings) ToContainer(uid, gid int) (int, int) {
+	if m == nil || InNamespace() {
+		return uid, gid
+	}
+
+	return toContainer(m.UIDs, uid), toContainer(m.GIDs, gid)
+}
+
+// toContainer translates a host ID into the container ID mapped onto it.
+func toContainer(mappings []Mapping, id int) int {
+	for _, m := range mappings {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID
+		}
+	}
+
+	return overflowID
+}
+
+// NeedsSync reports whether Start makes the command wait on a sync pipe until the setuid
+// helpers write the mappings. The pipe then comes before the extra files of the command.
+func (m *Mappings) NeedsSync() bool {
+	return m != nil && os.Geteuid() != 0 && !m.isSelfOnly()
+}
+
+// isSelfOnly reports whether the mappings only map the current user and group,
+// which an unprivileged process is allowed to write without helper binaries.
+func (m *Mappings) isSelfOnly() bool {
+	return len(m.UIDs) == 1 && len(m.GIDs) == 1 &&
+		m.UIDs[0].HostID == os.Getuid() && m.UIDs[0].Size == 1 &&
+		m.GIDs[0].HostID == os.Getgid() && m.GIDs[0].Size == 1
+}
+
+// apply writes the mappings of the user namespace of the process with the given PID
+// through the setuid newuidmap and newgidmap helpers, which check them against
+// /etc/subuid and /etc/subgid.
+func (m *Mappings) apply(pid int) error {
+	if err := runHelper("newuidmap", pid, m.UIDs); err != nil {
+		return err
+	}
+
+	return runHelper("newgidmap", pid, m.GIDs)
+}
+
+// sysProcIDMaps converts mappings into the form written by the Go runtime.
+func sysProcIDMaps(mappings []Mapping) []syscall.SysProcIDMap {
+	maps := make([]syscall.SysProcIDMap, 0, len(mappings))
+	for _, m := range mappings {
+		maps = append(maps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+
+	return maps
+}
+
+// runHelper runs newuidmap or newgidmap for the given process.
+func runHelper(helper string, pid int, mappings []Mapping) error {
+	path, err := exec.LookPath(helper)
+	if err != nil {
+		return fmt.Errorf("%s is required to map subordinate IDs: %v", helper, err)
+	}
+
+	args := append([]string{strconv.Itoa(pid)}, strings.Fields(format(mappings, " "))...)
+
+	if output, err := exec.Command(path, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", helper, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// format formats the mappings as "container host size" triples joined by sep.
+func format(mappings []Mapping, sep string) string {
+	var lines []string
+	for _, m := range mappings {
+		lines = append(lines, fmt.Sprintf("%d %d %d", m.ContainerID, m.HostID, m.Size))
+	}
+
+	return strings.Join(lines, sep)
+}
+
+// Start starts a command in a new user namespace with the given mappings.
+// The command has to call WaitForMappings before relying on its credentials.
+// With nil mappings the command is started without a user namespace.
+//
+// Root and self-only mappings are written by the Go runtime before the command
+// is executed, which then switches to container root. Other mappings need the
+// setuid helpers, so the command waits on a pipe until they are written.
+func Start(cmd *exec.Cmd, m *Mappings) error {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+
+	if !m.NeedsSync() {
+		cmd.Env = append(cmd.Env, mappedEnv+"=1")
+
+		if m != nil {
+			attr.Cloneflags |= syscall.CLONE_NEWUSER
+			attr.UidMappings, attr.GidMappings = sysProcIDMaps(m.UIDs), sysProcIDMaps(m.GIDs)
+			attr.GidMappingsEnableSetgroups = os.Geteuid() == 0
+			attr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true}
+		}
+
+		return cmd.Start()
+	}
+
+	// The kernel only lets the helpers map the namespace root to a process's own ID,
+	// so the mapped child has to be the namespace root to keep its capabilities
+	if hostID, ok := toHost(m.UIDs, 0); !ok || hostID != os.Getuid() {
+		return fmt.Errorf("container root must be mapped to the current user (UID %d)", os.Getuid())
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create sync pipe: %v", err)
+	}
+	defer w.Close()
+
+	// The sync pipe must be the first extra file to end up on syncFd
+	cmd.ExtraFiles = append([]*os.File{r}, cmd.ExtraFiles...)
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := m.apply(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return err
+	}
+
+	// Closing the write end releases the child
+	return nil
+}
+
+// WaitForMappings blocks a child started by Start until its mappings are written.
+// A process executed with an unmapped UID has no capabilities in its user namespace,
+// so the child then executes itself again as the mapped root and returns in the new image.
+func WaitForMappings() error {
+	if os.Getenv(mappedEnv) == "1" {
+		return nil
+	}
+
+	f := os.NewFile(syncFd, "sync")
+
+	_, err := f.Read(make([]byte, 1))
+	f.Close()
+
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to wait for ID mappings: %v", err)
+	}
+
+	if err := syscall.Exec("/proc/self/exe", os.Args, append(os.Environ(), mappedEnv+"=1")); err != nil {
+		return fmt.Errorf("failed to execute mapped child: %v", err)
+	}
+
+	return nil
+}
+
+// InNamespace reports whether the process was re-executed by ReexecInNamespace.
+func InNamespace() bool {
+	return os.Getenv(inNamespaceEnv) == "1"
+}
+
+// ReexecInNamespace re-executes the current command inside a user namespace with the
+// default mappings, so an unprivileged user can give files to subordinate IDs.
+// The calling process exits with the status of the re-executed one. It returns
+// right away for root, inside the namespace, and when there is nothing to map.
+func ReexecInNamespace() error {
+	if InNamespace() {
+		return WaitForMappings()
+	}
+
+	if os.Geteuid() == 0 {
+		return nil
+	}
+
+	m, err := Default()
+	if err != nil {
+		return err
+	}
+
+	if m.isSelfOnly() {
+		return nil
+	}
+
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+
+	cmd.Env = append(os.Environ(), inNamespaceEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	if err := Start(cmd, m); err != nil {
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+
+	os.Exit(0)
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package idmap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestParseMapping tests parsing of the --uidmap and --gidmap values
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      Mapping
+		expectedError bool
+	}{
+		{input: "0:100000:65536", expected: Mapping{ContainerID: 0, HostID: 100000, Size: 65536}},
+		{input: "1000:1000:1", expected: Mapping{ContainerID: 1000, HostID: 1000, Size: 1}},
+		{input: "0:100000", expectedError: true},
+		{input: "0:100000:0", expectedError: true},
+		{input: "0:-1:10", expectedError: true},
+		{input: "a:b:c", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			m, err := ParseMapping(tt.input)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got mapping %+v", tt.input, m)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if m != tt.expected {
+				t.Errorf("Expected mapping %+v, got %+v", tt.expected, m)
+			}
+		})
+	}
+}
+
+// TestNew tests that one side of the mappings defaults to the other
+func TestNew(t *testing.T) {
+	m, err := New([]string{"0:100000:65536"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Mapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	if !reflect.DeepEqual(m.UIDs, expected) || !reflect.DeepEqual(m.GIDs, expected) {
+		t.Errorf("Expected %+v for both sides, got %+v", expected, m)
+	}
+
+	m, err = New(nil, []string{"0:200000:10"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if m.UIDs[0].HostID != 200000 {
+		t.Errorf("Expected UIDs to follow the GID mappings, got %+v", m)
+	}
+
+	if _, err := New([]string{"0:1"}, nil); err == nil {
+		t.Error("Expected error for an invalid mapping")
+	}
+}
+
+// TestReadSubIDs tests reading the ranges of a user from a subuid file
+func TestReadSubIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subuid")
+	content := "# comment\nalice:100000:65536\nbob:165536:65536\n1000:300000:1000\n"
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write subuid file: %v", err)
+	}
+
+	ranges, err := readSubIDs(path, "alice", 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Mapping{{HostID: 100000, Size: 65536}, {HostID: 300000, Size: 1000}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Expected ranges %+v, got %+v", expected, ranges)
+	}
+
+	if ranges, err := readSubIDs(filepath.Join(t.TempDir(), "missing"), "alice", 1000); err != nil || ranges != nil {
+		t.Errorf("Expected no ranges for a missing file, got %+v, %v", ranges, err)
+	}
+
+	if err := os.WriteFile(path, []byte("alice:x:1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write subuid file: %v", err)
+	}
+
+	if _, err := readSubIDs(path, "alice", 1000); err == nil {
+		t.Error("Expected error for an invalid entry")
+	}
+}
+
+// TestStack tests translating container IDs through stacked ranges
+func TestStack(t *testing.T) {
+	mappings := append([]Mapping{{ContainerID: 0, HostID: 1000, Size: 1}},
+		stack([]Mapping{{HostID: 100000, Size: 10}, {HostID: 500000, Size: 5}}, 1)...)
+
+	tests := []struct {
+		id       int
+		expected int
+		ok       bool
+	}{
+		{id: 0, expected: 1000, ok: true},
+		{id: 1, expected: 100000, ok: true},
+		{id: 10, expected: 100009, ok: true},
+		{id: 11, expected: 500000, ok: true},
+		{id: 15, expected: 500004, ok: true},
+		{id: 16, ok: false},
+	}
+
+	for _, tt := range tests {
+		if got, ok := toHost(mappings, tt.id); got != tt.expected || ok != tt.ok {
+			t.Errorf("Expected ID %d to map to %d (%v), got %d (%v)", tt.id, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,673 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+const (
+	ociLayoutFile          = "oci-layout"
+	ociIndexFile           = "index.json"
+	dockerManifestFile     = "manifest.json"
+	dockerRepositoriesFile = "repositories"
+
+	// annotationImageName holds the full reference of an image in an OCI layout, as docker and containerd write it
+	annotationImageName = "io.containerd.image.name"
+	// annotationRefName holds the tag, or with some tools the reference, of an image in an OCI layout
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// ociDescriptor is a descriptor of an OCI image index.
+type ociDescriptor struct {
+	registry.Descriptor
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// ociIndex is the index.json of an OCI image layout, or a nested image index.
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// archiveManifest is an entry of the manifest.json of a docker archive.
+type archiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// archiveImage is an image written to an archive along with the names it's saved under.
+type archiveImage struct {
+	image        *Image
+	manifest     *registry.Manifest
+	manifestData []byte
+	names        []Reference
+}
+
+// WriteArchive writes the images the references refer to into a tar archive that is both an
+// OCI image layout and a docker archive, like docker save does. Images referred to by name keep
+// their names, images referred to by ID are saved without one.
+func WriteArchive(w io.Writer, refs []string) error {
+	var images []*archiveImage
+
+	for _, ref := range refs {
+		found, err := Find(ref)
+		if err != nil {
+			return err
+		}
+		img := found[0]
+
+		var entry *archiveImage
+		for _, e := range images {
+			if e.image.ID == img.ID {
+				entry = e
+			}
+		}
+		if entry == nil {
+			manifest, data, err := loadManifestFile(Path(img.Name))
+			if err != nil {
+				return err
+			}
+
+			entry = &archiveImage{image: img, manifest: manifest, manifestData: data}
+			images = append(images, entry)
+		}
+
+		if _, err := Get(ref); err != nil || img.Dangling() {
+			continue
+		}
+		if name, err := ParseReference(img.Name); err == nil && name.Digest == "" {
+			entry.names = append(entry.names, name)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	written := make(map[string]bool)
+
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755, ModTime: time.Unix(0, 0)}); err != nil {
+			return fmt.Errorf("failed to write archive: %v", err)
+		}
+	}
+
+	index := ociIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []ociDescriptor{}}
+	manifests := []archiveManifest{}
+	repositories := make(map[string]map[string]string)
+
+	for _, e := range images {
+		digests := []string{e.image.Digest, e.manifest.Config.Digest}
+		for _, layer := range e.manifest.Layers {
+			digests = append(digests, layer.Digest)
+		}
+
+		for _, digest := range digests {
+			if written[digest] {
+				continue
+			}
+			if err := writeBlobEntry(tw, digest); err != nil {
+				return err
+			}
+			written[digest] = true
+		}
+
+		mediaType := e.manifest.MediaType
+		if mediaType == "" {
+			mediaType = registry.MediaTypeOCIManifest
+		}
+		desc := registry.Descriptor{MediaType: mediaType, Size: int64(len(e.manifestData)), Digest: e.image.Digest}
+
+		entry := archiveManifest{Config: blobEntryName(e.manifest.Config.Digest), RepoTags: []string{}}
+		for _, layer := range e.manifest.Layers {
+			entry.Layers = append(entry.Layers, blobEntryName(layer.Digest))
+		}
+
+		if len(e.names) == 0 {
+			index.Manifests = append(index.Manifests, ociDescriptor{Descriptor: desc})
+		}
+
+		for _, name := range e.names {
+			index.Manifests = append(index.Manifests, ociDescriptor{
+				Descriptor: desc,
+				Annotations: map[string]string{
+					annotationImageName: name.Registry + "/" + name.Repository + ":" + name.Tag,
+					annotationRefName:   name.Tag,
+				},
+			})
+
+			entry.RepoTags = append(entry.RepoTags, name.Name()+":"+name.Tag)
+
+			if repositories[name.Name()] == nil {
+				repositories[name.Name()] = make(map[string]string)
+			}
+			repositories[name.Name()][name.Tag] = strings.TrimPrefix(e.image.ID, "sha256:")
+		}
+
+		manifests = append(manifests, entry)
+	}
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{ociLayoutFile, map[string]string{"imageLayoutVersion": "1.0.0"}},
+		{ociIndexFile, index},
+		{dockerManifestFile, manifests},
+		{dockerRepositoriesFile, repositories},
+	}
+	for _, f := range files {
+		data, err := json.Marshal(f.v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", f.name, err)
+		}
+
+		if err := writeFileEntry(tw, f.name, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+
+	return nil
+}
+
+// blobEntryName returns the path of a blob in an OCI image layout.
+func blobEntryName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// writeBlobEntry adds a blob of the blob store to the archive.
+func writeBlobEntry(tw *tar.Writer, digest string) error {
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return fmt.Errorf("blob %s is missing, pull or build the image again", ShortDigest(digest))
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeFileEntry(tw, blobEntryName(digest), f, fi.Size())
+}
+
+// writeFileEntry adds a regular file to the archive. Entries carry no timestamps,
+// so saving the same images twice produces the same archive.
+func writeFileEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size, ModTime: time.Unix(0, 0)}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("failed to write %s to archive: %v", name, err)
+	}
+
+	return nil
+}
+
+// LoadArchive loads the images of an OCI image layout or docker archive into the local image store.
+// It returns the names the images were stored under. Images without a name are stored under their ID.
+func LoadArchive(r io.Reader) ([]string, error) {
+	dir, err := TempDir("load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := unpackArchive(r, dir); err != nil {
+		return nil, err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &archiveLoader{dir: dir, idMappings: m}
+
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); err == nil {
+		return l.loadOCI()
+	}
+	if _, err := os.Stat(filepath.Join(dir, dockerManifestFile)); err == nil {
+		return l.loadDocker()
+	}
+
+	return nil, fmt.Errorf("archive is neither an OCI image layout nor a docker archive")
+}
+
+// unpackArchive extracts the directories, files and symlinks of an archive. Docker archives
+// link duplicate layers to each other, links are only followed within the directory
+// and links pointing out of it are rejected.
+func unpackArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		target, err := ResolveInRoot(dir, header.Name)
+		if err != nil {
+			return fmt.Errorf("failed to unpack %s: %v", header.Name, err)
+		}
+		if target == dir {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to unpack %s: %v", header.Name, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+
+		case tar.TypeReg:
+			// A symlink in place of the file isn't followed
+			os.Remove(target)
+
+			var f *os.File
+			if f, err = os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+				_, err = io.Copy(f, tr)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+
+		case tar.TypeSymlink:
+			if !linkInArchive(header.Name, header.Linkname) {
+				return fmt.Errorf("failed to unpack %s: link to %s leaves the archive", header.Name, header.Linkname)
+			}
+			os.Remove(target)
+			err = os.Symlink(header.Linkname, target)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to unpack %s: %v", header.Name, err)
+		}
+	}
+}
+
+// linkInArchive reports whether a symlink of an archive points to a path inside of it.
+func linkInArchive(name, link string) bool {
+	if path.IsAbs(link) {
+		return false
+	}
+
+	target := path.Join(path.Dir(path.Clean("/" + name)[1:]), link)
+	return target != ".." && !strings.HasPrefix(target, "../")
+}
+
+// archiveLoader stores the images of an unpacked archive.
+type archiveLoader struct {
+	dir        string
+	idMappings *idmap.Mappings
+}
+
+// open opens a file of the archive, following symlinks within the archive.
+func (l *archiveLoader) open(name string) (*os.File, error) {
+	p, err := resolvePath(l.dir, name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("archive is missing %s", name)
+	}
+
+	return f, nil
+}
+
+// readJSON decodes a JSON file of the archive.
+func (l *archiveLoader) readJSON(name string, v any) error {
+	f, err := l.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// storeBlob adds a file of the archive to the blob store, checking its digest if one is expected.
+func (l *archiveLoader) storeBlob(name, expected string) (string, int64, error) {
+	f, err := l.open(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	digest, size, err := WriteBlob(f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if expected != "" && digest != expected {
+		return "", 0, fmt.Errorf("%s has digest %s, not %s", name, digest, expected)
+	}
+
+	return digest, size, nil
+}
+
+// loadOCI loads the images listed in the index of an OCI image layout.
+func (l *archiveLoader) loadOCI() ([]string, error) {
+	var index ociIndex
+	if err := l.readJSON(ociIndexFile, &index); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, desc := range index.Manifests {
+		manifestDesc, err := l.platformManifest(desc)
+		if err != nil {
+			return names, err
+		}
+
+		if !ValidDigest(manifestDesc.Digest) {
+			return names, fmt.Errorf("invalid manifest digest %q", manifestDesc.Digest)
+		}
+
+		if _, _, err := l.storeBlob(blobEntryName(manifestDesc.Digest), manifestDesc.Digest); err != nil {
+			return names, err
+		}
+
+		data, err := ReadBlob(manifestDesc.Digest)
+		if err != nil {
+			return names, err
+		}
+
+		var manifest registry.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return names, fmt.Errorf("failed to decode manifest: %v", err)
+		}
+
+		for _, blob := range append([]registry.Descriptor{manifest.Config}, manifest.Layers...) {
+			if !ValidDigest(blob.Digest) {
+				return names, fmt.Errorf("invalid blob digest %q", blob.Digest)
+			}
+			if _, _, err := l.storeBlob(blobEntryName(blob.Digest), blob.Digest); err != nil {
+				return names, err
+			}
+		}
+
+		// Some tools only record the tag, which doesn't name an image on its own
+		name := desc.Annotations[annotationImageName]
+		if refName := desc.Annotations[annotationRefName]; name == "" && strings.ContainsAny(refName, ":/") {
+			name = refName
+		}
+
+		stored, err := l.install(name, &manifest, data)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, stored)
+	}
+
+	return names, nil
+}
+
+// platformManifest returns the descriptor of the manifest for the platform gocker runs on
+// if the descriptor is that of a multi-platform index, and the descriptor itself otherwise.
+func (l *archiveLoader) platformManifest(desc ociDescriptor) (ociDescriptor, error) {
+	if desc.MediaType != registry.MediaTypeOCIIndex && desc.MediaType != registry.MediaTypeDockerManifestList {
+		return desc, nil
+	}
+
+	var index ociIndex
+	if err := l.readJSON(blobEntryName(desc.Digest), &index); err != nil {
+		return desc, err
+	}
+
+	for _, m := range index.Manifests {
+		if m.Platform != nil && m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH {
+			return m, nil
+		}
+	}
+
+	return desc, fmt.Errorf("no manifest for %s/%s in index %s", runtime.GOOS, runtime.GOARCH, ShortDigest(desc.Digest))
+}
+
+// loadDocker loads the images listed in the manifest.json of a docker archive. Docker archives
+// have no image manifests, so a manifest is made for every image.
+func (l *archiveLoader) loadDocker() ([]string, error) {
+	var entries []archiveManifest
+	if err := l.readJSON(dockerManifestFile, &entries); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		configDigest, configSize, err := l.storeBlob(entry.Config, "")
+		if err != nil {
+			return names, err
+		}
+
+		data, err := ReadBlob(configDigest)
+		if err != nil {
+			return names, err
+		}
+
+		var cfg registry.ImageConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return names, fmt.Errorf("failed to decode config %s: %v", entry.Config, err)
+		}
+
+		manifest := registry.Manifest{
+			SchemaVersion: 2,
+			MediaType:     registry.MediaTypeOCIManifest,
+			Config:        registry.Descriptor{MediaType: registry.MediaTypeOCIConfig, Size: configSize, Digest: configDigest},
+			Layers:        []registry.Descriptor{},
+		}
+
+		for i, layer := range entry.Layers {
+			mediaType, err := l.layerMediaType(layer)
+			if err != nil {
+				return names, err
+			}
+
+			digest, size, err := l.storeBlob(layer, "")
+			if err != nil {
+				return names, err
+			}
+
+			// The digests of uncompressed layers are their diff IDs, which the config lists
+			if mediaType == registry.MediaTypeOCIUncompressedLayer && i < len(cfg.Rootfs.DiffIds) && cfg.Rootfs.DiffIds[i] != digest {
+				return names, fmt.Errorf("layer %s doesn't match the image config", layer)
+			}
+
+			manifest.Layers = append(manifest.Layers, registry.Descriptor{MediaType: mediaType, Size: size, Digest: digest})
+		}
+
+		_, manifestData, err := writeJSONBlob(manifest, registry.MediaTypeOCIManifest)
+		if err != nil {
+			return names, err
+		}
+
+		tags := entry.RepoTags
+		if len(tags) == 0 {
+			tags = []string{""}
+		}
+
+		for _, tag := range tags {
+			stored, err := l.install(tag, &manifest, manifestData)
+			if err != nil {
+				return names, err
+			}
+			names = append(names, stored)
+		}
+	}
+
+	return names, nil
+}
+
+// layerMediaType tells gzip compressed layers of docker archives from uncompressed ones.
+func (l *archiveLoader) layerMediaType(name string) (string, error) {
+	f, err := l.open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic, err := bufio.NewReader(f).Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return registry.MediaTypeOCILayer, nil
+	}
+
+	return registry.MediaTypeOCIUncompressedLayer, nil
+}
+
+// install extracts the layers of a loaded image into the image directory of its name.
+func (l *archiveLoader) install(name string, manifest *registry.Manifest, manifestData []byte) (string, error) {
+	if name == "" {
+		name = manifest.Config.Digest
+	} else {
+		ref, err := ParseReference(name)
+		if err != nil {
+			return "", err
+		}
+		name = ref.String()
+	}
+
+	data, err := ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return "", err
+	}
+
+	var cfg registry.ImageConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("failed to decode config: %v", err)
+	}
+
+	rootfs, err := ExtractLayers(manifest.Layers, l.idMappings)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %v", name, err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := saveImageDir(Path(name), rootfs, &cfg, manifestData); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Import creates a single layer image from a tar archive of a root filesystem, gzip compressed
+// or not, and stores it under the given name. It returns the ID of the image.
+func Import(r io.Reader, name, source string) (string, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", fmt.Errorf("refusing to import an image under a digest reference")
+	}
+
+	digest, size, err := WriteBlob(r)
+	if err != nil {
+		return "", err
+	}
+
+	mediaType, diffID, err := inspectLayer(digest)
+	if err != nil {
+		return "", err
+	}
+	layers := []registry.Descriptor{{MediaType: mediaType, Size: size, Digest: digest}}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	cfg := &registry.ImageConfig{
+		Architecture: runtime.GOARCH,
+		Os:           runtime.GOOS,
+		Created:      created,
+		History:      []registry.History{{Created: created, Comment: "Imported from " + source}},
+	}
+	cfg.Rootfs.Type = "layers"
+	cfg.Rootfs.DiffIds = []string{diffID}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return "", err
+	}
+
+	rootfs, err := ExtractLayers(layers, m)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(rootfs)
+
+	return Save(ref.String(), rootfs, cfg, layers)
+}
+
+// inspectLayer checks that a blob is a tar archive and returns its media type and diff ID.
+func inspectLayer(digest string) (string, string, error) {
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var stream io.Reader = br
+
+	mediaType := registry.MediaTypeOCIUncompressedLayer
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to decompress archive: %v", err)
+		}
+		defer gr.Close()
+
+		stream, mediaType = gr, registry.MediaTypeOCILayer
+	}
+
+	hash := sha256.New()
+	tee := io.TeeReader(stream, hash)
+
+	tr := tar.NewReader(tee)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", "", fmt.Errorf("not a tar archive: %v", err)
+		}
+	}
+
+	// The diff ID covers the padding after the end of the archive as well
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return "", "", fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	return mediaType, "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
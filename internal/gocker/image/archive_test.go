@@ -0,0 +1,191 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// TestArchiveRoundTrip tests that saved images load into another image store
+// with their names, IDs and files, whichever format of the archive is read
+func TestArchiveRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	rootfs, err := TempDir("rootfs-")
+	must(t, err)
+	before, err := TakeSnapshot(rootfs)
+	must(t, err)
+	writeFiles(t, rootfs, map[string]string{"etc/motd": "hello"})
+
+	layer, diffID, err := WriteLayer(rootfs, before, identity)
+	must(t, err)
+	cfg := &registry.ImageConfig{Os: "linux"}
+	cfg.Rootfs.DiffIds = []string{diffID}
+
+	id, err := Save("example.com/team/app:v1", rootfs, cfg, []registry.Descriptor{layer})
+	must(t, err)
+
+	var archive bytes.Buffer
+	must(t, WriteArchive(&archive, []string{"example.com/team/app:v1", ShortDigest(id)}))
+
+	// The docker archive part alone is loaded too
+	var dockerOnly bytes.Buffer
+	tr, tw := tar.NewReader(bytes.NewReader(archive.Bytes())), tar.NewWriter(&dockerOnly)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		must(t, err)
+
+		if header.Name == ociIndexFile || header.Name == ociLayoutFile {
+			continue
+		}
+		must(t, tw.WriteHeader(header))
+		_, err = io.Copy(tw, tr)
+		must(t, err)
+	}
+	must(t, tw.Close())
+
+	for _, data := range [][]byte{archive.Bytes(), dockerOnly.Bytes()} {
+		t.Setenv("HOME", t.TempDir())
+
+		names, err := LoadArchive(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to load archive: %v", err)
+		}
+		if !slices.Equal(names, []string{"example.com/team/app:v1"}) {
+			t.Errorf("Expected the image to be loaded under its name, got %v", names)
+		}
+
+		img, err := Get("example.com/team/app:v1")
+		must(t, err)
+		if img.ID != id {
+			t.Errorf("Expected image ID %s, got %s", id, img.ID)
+		}
+
+		motd, err := os.ReadFile(filepath.Join(Path(img.Name), "rootfs", "etc", "motd"))
+		if err != nil || string(motd) != "hello" {
+			t.Errorf("Expected the loaded image to have its files, got %q (%v)", motd, err)
+		}
+	}
+
+	// The manifest.json lists the image once with its tag
+	tr = tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		must(t, err)
+
+		if header.Name == dockerManifestFile {
+			var manifests []archiveManifest
+			must(t, json.NewDecoder(tr).Decode(&manifests))
+
+			if len(manifests) != 1 || !slices.Equal(manifests[0].RepoTags, []string{"example.com/team/app:v1"}) {
+				t.Errorf("Expected a single tagged image in manifest.json, got %+v", manifests)
+			}
+		}
+	}
+
+	if _, err := LoadArchive(bytes.NewReader(dockerOnly.Bytes()[:1024])); err == nil {
+		t.Errorf("Expected a truncated archive to fail")
+	}
+}
+
+// TestUnpackArchiveEscape tests that archive entries can't be written outside of the directory through symlinks
+func TestUnpackArchiveEscape(t *testing.T) {
+	outside := t.TempDir()
+
+	for _, link := range []string{outside, "../../" + filepath.Base(outside)} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		must(t, tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: link}))
+		must(t, tw.WriteHeader(&tar.Header{Name: "a/pwned", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}))
+		_, err := tw.Write([]byte("owned"))
+		must(t, err)
+		must(t, tw.Close())
+
+		dir := filepath.Join(t.TempDir(), "archive")
+		must(t, os.Mkdir(dir, 0755))
+
+		if err := unpackArchive(&archive, dir); err == nil {
+			t.Errorf("Expected the link to %s to be rejected", link)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+			t.Fatalf("Expected no file to be written through the link to %s", link)
+		}
+	}
+}
+
+// TestImport tests importing a filesystem archive as an image and
+// telling later changes to its root filesystem apart with the stored snapshot
+func TestImport(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"etc/hostname": "box", "bin/tool": "binary"})
+
+	var archive bytes.Buffer
+	must(t, WriteTar(&archive, src, identity))
+
+	if _, err := Import(bytes.NewReader([]byte("not a tar archive")), "broken", "-"); err == nil {
+		t.Errorf("Expected importing a file that isn't an archive to fail")
+	}
+
+	id, err := Import(&archive, "imported:v1", "rootfs.tar")
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	img, err := Get("imported:v1")
+	must(t, err)
+	if img.ID != id || len(img.Config.Rootfs.DiffIds) != 1 || img.Config.History[0].Comment != "Imported from rootfs.tar" {
+		t.Errorf("Expected a single layer image with its source in the history, got %+v", img.Config)
+	}
+
+	root := filepath.Join(Path("imported:v1"), "rootfs")
+	data, err := os.ReadFile(filepath.Join(root, "bin", "tool"))
+	if err != nil || string(data) != "binary" {
+		t.Errorf("Expected the imported files, got %q (%v)", data, err)
+	}
+
+	before, err := LoadSnapshot("imported:v1")
+	must(t, err)
+
+	writeFiles(t, root, map[string]string{"etc/motd": "hi"})
+	must(t, os.Remove(filepath.Join(root, "bin", "tool")))
+
+	layer, _, err := WriteLayer(root, before, identity)
+	must(t, err)
+
+	f, err := OpenBlob(layer.Digest)
+	must(t, err)
+	defer f.Close()
+
+	var changed []string
+	gr, err := gzip.NewReader(f)
+	must(t, err)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		must(t, err)
+		changed = append(changed, header.Name)
+	}
+
+	if !slices.Equal(changed, []string{"bin/", "bin/.wh.tool", "etc/", "etc/motd"}) {
+		t.Errorf("Expected only the changes since the image was stored, got %v", changed)
+	}
+}
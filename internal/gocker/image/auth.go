@@ -0,0 +1,86 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// dockerConfigFile holds registry credentials in the format of docker login
+	dockerConfigFile = ".docker/config.json"
+
+	// dockerHubAuthKey is the key of Docker Hub credentials in the docker config
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+)
+
+// credentials returns the username and password stored for the registry by docker login.
+// Without credentials both are empty and requests are made anonymously.
+func credentials(host string) (string, string) {
+	data, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), dockerConfigFile))
+	if err != nil {
+		return "", ""
+	}
+
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+
+	key := host
+	if host == DefaultRegistry {
+		key = dockerHubAuthKey
+	}
+
+	for k, entry := range cfg.Auths {
+		if k != key && strings.TrimPrefix(strings.TrimPrefix(k, "https://"), "http://") != key {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", ""
+		}
+
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return username, password
+	}
+
+	return "", ""
+}
+
+// parseChallenge parses a WWW-Authenticate header like
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+// into its lower case scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+			continue
+		}
+
+		params[key], rest, _ = strings.Cut(value, ",")
+	}
+
+	return strings.ToLower(scheme), params
+}
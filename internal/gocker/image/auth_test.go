@@ -0,0 +1,55 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestParseChallenge tests parsing WWW-Authenticate headers
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull,push"`)
+
+	expected := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/alpine:pull,push",
+	}
+	if scheme != "bearer" || !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected bearer %v, got %s %v", expected, scheme, params)
+	}
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	if scheme != "basic" || params["realm"] != "registry" {
+		t.Errorf("Expected basic challenge with realm registry, got %s %v", scheme, params)
+	}
+}
+
+// TestCredentials tests reading credentials stored by docker login
+func TestCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.MkdirAll(filepath.Join(home, ".docker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// user:secret and hub:password
+	config := `{"auths":{"https://registry.example.com":{"auth":"dXNlcjpzZWNyZXQ="},"https://index.docker.io/v1/":{"auth":"aHViOnBhc3N3b3Jk"}}}`
+	if err := os.WriteFile(filepath.Join(home, dockerConfigFile), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][2]string{
+		"registry.example.com": {"user", "secret"},
+		DefaultRegistry:        {"hub", "password"},
+		"localhost:5000":       {"", ""},
+	}
+
+	for host, expected := range tests {
+		if username, password := credentials(host); username != expected[0] || password != expected[1] {
+			t.Errorf("Expected %v for %s, got %s:%s", expected, host, username, password)
+		}
+	}
+}
@@ -1,21 +1,20 @@
 package image
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	nethttp "net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
 	"github.com/z1z0v1c/gclone/internal/gocker/registry"
 	"github.com/z1z0v1c/gclone/pkg/http"
 )
@@ -24,70 +23,141 @@ const (
 	// RelativeImagesPath is the relative images path under the user's home directory.
 	RelativeImagesPath = ".local/share/gocker/images/"
 
-	authURLBase     = "https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s:pull"
-	manifestURLBase = "https://%s/v2/%s/manifests/"
-	blobsURLBase    = "https://%s/v2/%s/blobs/"
+	// manifestAccept lists the manifest media types the client understands.
+	manifestAccept = registry.MediaTypeDockerManifest + ", " + registry.MediaTypeDockerManifestList + ", " +
+		registry.MediaTypeOCIManifest + ", " + registry.MediaTypeOCIIndex
 )
 
-var (
-	authURL     string
-	manifestURL string
-	blobsURL    string
-)
-
-// Client encapsulates the parameters to pull and unpack an image.
+// Client encapsulates the parameters to pull and unpack or push an image.
 type Client struct {
-	sync.Mutex
+	imageName     string
+	imageTag      string
+	imagePath     string
+	imageRoot     string
+	configPath    string
+	registry      string
+	repository    string
+	authorization string
+
+	// endpoint is the endpoint requests go to, one of endpoints, which pulls try in order
+	endpoint  endpoint
+	endpoints []endpoint
+
+	// chunkSize is the size of upload chunks, DefaultChunkSize unless set
+	chunkSize int64
+	// maxConcurrentDownloads limits the layers pulled at once, DefaultMaxConcurrentDownloads unless set
+	maxConcurrentDownloads int
+
+	manifest     *registry.Manifest
+	manifestData []byte
+	config       *registry.ImageConfig
+	idMappings   *idmap.Mappings
+
+	// digest is the digest of the manifest or index the reference resolves to, signatures are made for it
+	digest string
+	// policy is the signature verification policy of pulls, nil if images aren't verified
+	policy *Policy
+
+	// httpClient makes the requests to the endpoint, secureClient is the one given for secure endpoints
+	httpClient   *http.Client
+	secureClient *http.Client
+}
+
+// NewClient creates and initializes a new Client for the given image name.
+func NewClient(imgName string, httpClient *http.Client) (*Client, error) {
+	ref, err := ParseReference(imgName)
+	if err != nil {
+		return nil, err
+	}
+
+	imgName, imgTag := ref.Name(), ref.Tag
+	if ref.Digest != "" {
+		imgTag = ref.Digest
+	}
+
+	imgPath := Path(ref.String())
+	imgRoot := filepath.Join(imgPath, "rootfs")
+	cfgPath := filepath.Join(imgPath, configFile)
 
-	imageName  string
-	imageTag   string
-	imagePath  string
-	imageRoot  string
-	configPath string
-	repository string
-	token      string
-	layers     map[string][]byte
+	daemonCfg, err := LoadDaemonConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints, err := daemonCfg.endpoints(ref.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := loadDefaultPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		imageName:    imgName,
+		imageTag:     imgTag,
+		imagePath:    imgPath,
+		imageRoot:    imgRoot,
+		configPath:   cfgPath,
+		registry:     ref.Registry,
+		repository:   ref.Repository,
+		endpoints:    endpoints,
+		policy:       policy,
+		secureClient: httpClient,
+	}
 
-	manifest *registry.Manifest
-	config   *registry.ImageConfig
+	// The registry itself is the last endpoint, requests other than pulls go to it
+	c.use(endpoints[len(endpoints)-1])
 
-	httpClient *http.Client
+	return c, nil
 }
 
-// NewClient creates and initializes a new ImagePuller for the given image name.
-func NewClient(imgName string, httpClient *http.Client) *Client {
-	imgTag := "latest"
-	homeDir := os.Getenv("HOME")
+// use directs the requests of the client to the endpoint.
+func (c *Client) use(ep endpoint) {
+	c.endpoint, c.authorization, c.httpClient = ep, "", c.secureClient
+	if ep.insecure {
+		c.httpClient = http.NewInsecureHttpClient()
+	}
+}
 
-	imgPath := filepath.Join(homeDir, RelativeImagesPath, imgName)
-	imgRoot := filepath.Join(imgPath, "rootfs")
-	cfgPath := filepath.Join(imgPath, ".config.json")
-	repository := filepath.Join("library", imgName)
-
-	authURL = fmt.Sprintf(authURLBase, repository)
-	manifestURL = fmt.Sprintf(manifestURLBase, registry.URL, repository)
-	blobsURL = fmt.Sprintf(blobsURLBase, registry.URL, repository)
-
-	return &Client{
-		imageName:  imgName,
-		imageTag:   imgTag,
-		imagePath:  imgPath,
-		imageRoot:  imgRoot,
-		configPath: cfgPath,
-		repository: repository,
-		httpClient: httpClient,
+// url returns the URL of a manifest, blob or upload of the repository.
+func (c *Client) url(kind, ref string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/%s", c.endpoint.url, c.repository, kind, ref)
+}
+
+// headers returns request headers with the authorization obtained by authenticate
+// and the given header names and values.
+func (c *Client) headers(kv ...string) map[string]string {
+	headers := make(map[string]string, len(kv)/2+1)
+	if c.authorization != "" {
+		headers["Authorization"] = c.authorization
 	}
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		headers[kv[i]] = kv[i+1]
+	}
+
+	return headers
 }
 
 // Pull downloads and extracts an image.
 func (c *Client) Pull() error {
-	fmt.Printf("Pulling from %s using default tag: %s\n", c.repository, c.imageTag)
+	fmt.Printf("Pulling from %s using tag: %s\n", c.repository, c.imageTag)
 
-	if err := c.authenticate(); err != nil {
+	// Extracted files are given to the host IDs of their owners in the container
+	m, err := idmap.Default()
+	if err != nil {
 		return err
 	}
+	c.idMappings = m
 
-	if err := c.fetchManifest(); err != nil {
+	if err := c.resolveManifest(); err != nil {
+		return err
+	}
+
+	// Images the policy doesn't accept are rejected before anything is downloaded
+	if err := c.checkPolicy(); err != nil {
 		return err
 	}
 
@@ -108,41 +178,139 @@ func (c *Client) Pull() error {
 		return err
 	}
 
+	if err := c.saveManifest(); err != nil {
+		return err
+	}
+
+	if err := saveSnapshot(c.imagePath); err != nil {
+		return err
+	}
+
+	digests := []string{c.manifest.Config.Digest}
+	for _, layer := range c.manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	if err := recordSources(c.registry, c.repository, digests...); err != nil {
+		return err
+	}
+
 	fmt.Printf("Status: Downloaded image for %s:%s\n", c.imageName, c.imageTag)
 
 	return nil
 }
 
-// authenticate retrieves an access token from Docker Hub.
-func (c *Client) authenticate() error {
-	var authResp registry.AuthResponse
-	c.httpClient.SendRequestAndDecode(&authResp, http.MethodGet, authURL, nil)
+// authenticate obtains the authorization for the actions on the repository the registry
+// asks for. Bearer tokens are requested from the realm of the challenge with the scopes of
+// the repository and the extra ones, like those of the source repositories of blob mounts.
+func (c *Client) authenticate(actions string, extraScopes ...string) error {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodGet, c.endpoint.url+"/v2/", nil, nil)
+
+	// Insecure registries that don't speak TLS at all are reached over plain HTTP
+	if err != nil && c.endpoint.insecure && strings.HasPrefix(c.endpoint.url, "https://") {
+		c.endpoint.url = "http://" + strings.TrimPrefix(c.endpoint.url, "https://")
+		resp, err = c.httpClient.Send(context.Background(), http.MethodGet, c.endpoint.url+"/v2/", nil, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reach registry %s: %v", c.endpoint.host, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == nethttp.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != nethttp.StatusUnauthorized {
+		return fmt.Errorf("unexpected status from registry %s: %d", c.endpoint.host, resp.StatusCode)
+	}
+
+	username, password := credentials(c.endpoint.host)
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	switch scheme {
+	case "basic":
+		if username == "" {
+			return fmt.Errorf("registry %s requires credentials", c.endpoint.host)
+		}
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+		return nil
+
+	case "bearer":
+		query := url.Values{}
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		for _, scope := range append([]string{"repository:" + c.repository + ":" + actions}, extraScopes...) {
+			query.Add("scope", scope)
+		}
+
+		headers := map[string]string{}
+		if username != "" {
+			headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		}
+
+		var authResp registry.AuthResponse
+		if err := c.httpClient.SendRequestAndDecode(&authResp, http.MethodGet, params["realm"]+"?"+query.Encode(), headers); err != nil {
+			return fmt.Errorf("failed to authenticate with %s: %v", c.endpoint.host, err)
+		}
+
+		token := authResp.Token
+		if token == "" {
+			token = authResp.AccessToken
+		}
+		c.authorization = "Bearer " + token
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported authentication scheme of registry %s: %q", c.endpoint.host, scheme)
+}
+
+// resolveManifest fetches the manifest from the first endpoint that serves it.
+// Mirrors that fail are skipped, the registry itself is the last resort.
+func (c *Client) resolveManifest() error {
+	for _, ep := range c.endpoints {
+		c.use(ep)
 
-	c.token = authResp.Token
+		err := c.authenticate("pull")
+		if err == nil {
+			err = c.fetchManifest()
+		}
+
+		if err == nil || !ep.mirror {
+			return err
+		}
+
+		fmt.Printf("Mirror %s failed, trying the next endpoint: %v\n", ep.url, err)
+	}
 
 	return nil
 }
 
 // fetchManifest retrieves the manifest or manifest index for the image.
 func (c *Client) fetchManifest() error {
-	headers := map[string]string{
-		"Authorization": "Bearer " + c.token,
-		"Accept":        "application/vnd.docker.distribution.manifest.v2+json",
-	}
-
-	resp, err := c.httpClient.SendRequest(http.MethodGet, manifestURL+c.imageTag, headers)
+	resp, err := c.httpClient.SendRequest(http.MethodGet, c.url("manifests", c.imageTag), c.headers("Accept", manifestAccept))
 	if err != nil {
 		return fmt.Errorf("failed to download layer: %v", err)
 	}
 	defer resp.Body.Close()
 
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	c.digest = digestOf(data)
+	if strings.HasPrefix(c.imageTag, "sha256:") && c.digest != c.imageTag {
+		return fmt.Errorf("manifest digest mismatch: expected %s, got %s", c.imageTag, c.digest)
+	}
+
 	c.manifest = &registry.Manifest{}
 	ctype := resp.Header.Get("Content-Type")
 
 	// Handle OCI Index (manifest list)
-	if ctype == "application/vnd.oci.image.index.v1+json" || ctype == "application/vnd.docker.distribution.manifest.list.v2+json" {
+	if ctype == registry.MediaTypeOCIIndex || ctype == registry.MediaTypeDockerManifestList {
 		var index registry.ManifestIndex
-		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		if err := json.Unmarshal(data, &index); err != nil {
 			return fmt.Errorf("error decoding manifest index: %v", err)
 		}
 
@@ -159,9 +327,10 @@ func (c *Client) fetchManifest() error {
 		return fmt.Errorf("no matching platform found in manifest index")
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(c.manifest); err != nil {
+	if err := json.Unmarshal(data, c.manifest); err != nil {
 		return err
 	}
+	c.manifestData = data
 
 	fmt.Printf("Found %d layers to download\n", len(c.manifest.Layers))
 
@@ -170,185 +339,42 @@ func (c *Client) fetchManifest() error {
 
 // fetchManifestByDigest fetches a platform-specific manifest by its digest.
 func (c *Client) fetchManifestByDigest(digest string) error {
-	headers := map[string]string{
-		"Authorization": "Bearer " + c.token,
-		"Accept":        "application/vnd.docker.distribution.manifest.v2+json",
-	}
-
-	c.httpClient.SendRequestAndDecode(c.manifest, http.MethodGet, manifestURL+digest, headers)
-
-	return nil
-}
-
-func (c *Client) downloadImage() error {
-	c.layers = make(map[string][]byte)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	var wg sync.WaitGroup
-	errChan := make(chan error, 1)
-
-	for j, layer := range c.manifest.Layers {
-		wg.Add(1)
-
-		go func(index int, digest string) {
-			defer wg.Done()
-
-			if err := c.downloadLayer(ctx, index, digest); err != nil {
-				select {
-				case errChan <- err:
-					cancel() // Cancel context to signal other goroutines to stop
-				default:
-				}
-			}
-		}(j, layer.Digest)
-	}
-
-	// Wait for either completion or first error
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
-
-	select {
-	case err := <-errChan:
-		return err
-	case <-done:
-		return nil
-	}
-}
-
-func (c *Client) downloadLayer(ctx context.Context, index int, digest string) error {
-	fmt.Printf("Downloading layer %d/%d...\n", index+1, len(c.manifest.Layers))
-
-	// Check if context is cancelled
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
-	headers := map[string]string{
-		"Authorization": "Bearer " + c.token,
-	}
-
-	resp, err := c.httpClient.SendRequestWithContext(ctx, http.MethodGet, blobsURL+digest, headers)
+	resp, err := c.httpClient.SendRequest(http.MethodGet, c.url("manifests", digest), c.headers("Accept", manifestAccept))
 	if err != nil {
-		return fmt.Errorf("failed to download layer: %v", err)
+		return fmt.Errorf("failed to download manifest: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// Check if context is cancelled
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-	}
-
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read layer %s: %v", digest, err)
+		return fmt.Errorf("failed to read manifest: %v", err)
 	}
 
-	// Verify digest
-	hasher := sha256.Sum256(data)
-	actual := "sha256:" + hex.EncodeToString(hasher[:])
-	if actual != digest {
-		return fmt.Errorf("digest mismatch for layer %d: expected %s, got %s", index+1, digest, actual)
+	// The index is what's signed, so the manifest has to be the one it lists
+	if actual := digestOf(data); actual != digest {
+		return fmt.Errorf("manifest digest mismatch: expected %s, got %s", digest, actual)
 	}
 
-	// Thread-safe write to map
-	c.Lock()
-	c.layers[digest] = data
-	c.Unlock()
+	if err := json.Unmarshal(data, c.manifest); err != nil {
+		return fmt.Errorf("error decoding manifest: %v", err)
+	}
+	c.manifestData = data
 
 	return nil
 }
 
 func (c *Client) extractImage() error {
 	for j, layer := range c.manifest.Layers {
-		data, ok := c.layers[layer.Digest]
-		if !ok {
-			return fmt.Errorf("layer data for %s not found", layer.Digest)
-		}
-
-		gr, err := gzip.NewReader(bytes.NewReader(data))
+		f, err := OpenBlob(layer.Digest)
 		if err != nil {
-			return fmt.Errorf("failed to create gzip reader for layer %d: %v", j+1, err)
-		}
-		defer gr.Close()
-
-		tr := tar.NewReader(gr)
-
-		if err := c.extractLayer(tr, c.imageRoot); err != nil {
-			return fmt.Errorf("failed to extract layer %d: %v", j+1, err)
+			return fmt.Errorf("layer data for %s not found", layer.Digest)
 		}
-	}
 
-	return nil
-}
+		err = ApplyLayer(f, c.imageRoot, c.idMappings)
+		f.Close()
 
-// extractLayer unpacks the contents of a tar stream into the image root filesystem.
-func (c *Client) extractLayer(tr *tar.Reader, imgRoot string) error {
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %v", err)
-		}
-
-		targetPath := filepath.Join(imgRoot, header.Name)
-
-		// Prevent path traversal
-		if !strings.HasPrefix(targetPath, imgRoot) {
-			continue
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("failed to create directory %s: %v", targetPath, err)
-			}
-
-		case tar.TypeReg:
-			// Create directory for file if it doesn't exist
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory for %s: %v", targetPath, err)
-			}
-
-			// Create and write file
-			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return fmt.Errorf("failed to create file %s: %v", targetPath, err)
-			}
-			defer file.Close()
-
-			if _, err := io.Copy(file, tr); err != nil {
-				return fmt.Errorf("failed to write file %s: %v", targetPath, err)
-			}
-
-		case tar.TypeSymlink:
-			// Create symbolic link
-			if err := os.Symlink(header.Linkname, targetPath); err != nil {
-				// Ignore if symlink already exists
-				if !os.IsExist(err) {
-					return fmt.Errorf("failed to create symlink %s: %v", targetPath, err)
-				}
-			}
-
-		case tar.TypeLink:
-			// Create hard link
-			linkTarget := filepath.Join(imgRoot, header.Linkname)
-			if err := os.Link(linkTarget, targetPath); err != nil {
-				// Ignore if link already exists
-				if !os.IsExist(err) {
-					return fmt.Errorf("failed to create hard link %s: %v", targetPath, err)
-				}
-			}
+			return fmt.Errorf("failed to extract layer %d: %v", j+1, err)
 		}
 	}
 
@@ -361,11 +387,20 @@ func (c *Client) fetchConfig() error {
 
 	fmt.Printf("Downloading config file...\n")
 
-	headers := make(map[string]string, 1)
-	headers["Authorization"] = "Bearer " + c.token
+	// The config is stored as it is, so its digest stays the image ID
+	if err := c.downloadBlob(context.Background(), c.manifest.Config, nil); err != nil {
+		return fmt.Errorf("failed to download config: %v", err)
+	}
+
+	data, err := ReadBlob(digest)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
 
 	c.config = &registry.ImageConfig{}
-	c.httpClient.SendRequestAndDecode(c.config, http.MethodGet, blobsURL+digest, headers)
+	if err := json.Unmarshal(data, c.config); err != nil {
+		return fmt.Errorf("failed to decode config: %v", err)
+	}
 
 	cfgData, err := json.MarshalIndent(c.config, "", "\t")
 	if err != nil {
@@ -380,6 +415,19 @@ func (c *Client) fetchConfig() error {
 	return nil
 }
 
+// saveManifest stores the manifest in the blob store and next to the image rootfs.
+func (c *Client) saveManifest() error {
+	if _, _, err := WriteBlob(bytes.NewReader(c.manifestData)); err != nil {
+		return fmt.Errorf("failed to store manifest: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(c.imagePath, manifestFile), c.manifestData, 0644); err != nil {
+		return fmt.Errorf("failed to save manifest: %v", err)
+	}
+
+	return nil
+}
+
 // makeRootfs removes existing image data and creates the rootfs directory structure.
 func (c *Client) makeRootfs() error {
 	if err := os.RemoveAll(c.imagePath); err != nil {
@@ -0,0 +1,238 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+// CopySource is the file or directory copied by gocker cp, resolved in its root filesystem.
+type CopySource struct {
+	// Path is the resolved path of the file
+	Path string
+	// Name is the name of the top entry of the copy, empty when only the contents of a directory are copied
+	Name string
+	// IsDir reports whether the source is a directory
+	IsDir bool
+}
+
+// CopyOptions control how the files of a copy are unpacked.
+type CopyOptions struct {
+	// Mappings give the copied files their owners, which are left alone without them
+	Mappings *idmap.Mappings
+	// Archive keeps the owners of the copied files, which are given to root otherwise
+	Archive bool
+}
+
+// StatSource resolves the source of a copy in the root filesystem. Paths ending in "/."
+// copy the contents of a directory. A symlink is copied as it is unless followLink is set.
+func StatSource(root, name string, followLink bool) (*CopySource, error) {
+	contents := strings.HasSuffix(name, "/.") || name == "."
+	trailing := contents || strings.HasSuffix(name, "/")
+
+	p, err := resolvePath(root, name, followLink || trailing)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: no such file or directory", name)
+		}
+		return nil, err
+	}
+
+	if trailing && !fi.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", name)
+	}
+
+	src := &CopySource{Path: p, IsDir: fi.IsDir()}
+
+	// The root itself has no name to be copied under
+	if !contents && p != filepath.Clean(root) {
+		src.Name = filepath.Base(p)
+	}
+
+	return src, nil
+}
+
+// WriteTar writes the source into a tar stream, its entries named after the source or
+// relative to it if only its contents are copied.
+func (src *CopySource) WriteTar(w io.Writer, m *idmap.Mappings) error {
+	tw := tar.NewWriter(w)
+	links := make(map[uint64]string)
+
+	parent := src.Path
+	if src.Name != "" {
+		parent = filepath.Dir(src.Path)
+
+		fi, err := os.Lstat(src.Path)
+		if err != nil {
+			return err
+		}
+
+		if err := writeEntry(tw, parent, src.Name, fi, links, m); err != nil {
+			return err
+		}
+	}
+
+	if src.IsDir {
+		err := walkRoot(src.Path, func(rel string, fi fs.FileInfo) error {
+			return writeEntry(tw, parent, filepath.Join(src.Name, rel), fi, links, m)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", src.Path, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// ExtractCopy unpacks the tar stream of a copy to the destination path in the root filesystem
+// the way docker cp does. A file replaces the destination file, a source goes into the
+// destination directory if there is one and becomes the destination otherwise. Streams without
+// a known source are unpacked into the destination directory. Entries can't be written
+// outside of the directory they are unpacked in.
+func ExtractCopy(r io.Reader, root, dest string, src *CopySource, opts CopyOptions) error {
+	target, err := resolvePath(root, dest, true)
+	if err != nil {
+		return err
+	}
+
+	dir, rename := target, ""
+
+	fi, err := os.Stat(target)
+	switch {
+	case err == nil && fi.IsDir():
+		// Sources are unpacked into existing directories
+
+	case err == nil:
+		if src == nil || src.IsDir {
+			return fmt.Errorf("cannot copy a directory to file %s", dest)
+		}
+		dir, rename = filepath.Dir(target), filepath.Base(target)
+
+	case os.IsNotExist(err):
+		if src == nil {
+			return fmt.Errorf("destination directory %s does not exist", dest)
+		}
+		if strings.HasSuffix(dest, "/") && !src.IsDir {
+			return fmt.Errorf("destination directory %s does not exist", dest)
+		}
+
+		if pfi, err := os.Stat(filepath.Dir(target)); err != nil || !pfi.IsDir() {
+			return fmt.Errorf("parent directory of %s does not exist", dest)
+		}
+
+		// Copied contents need the directory they're unpacked in
+		if src.Name == "" {
+			if err := os.Mkdir(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", dest, err)
+			}
+			break
+		}
+		dir, rename = filepath.Dir(target), filepath.Base(target)
+
+	default:
+		return fmt.Errorf("failed to stat %s: %v", dest, err)
+	}
+
+	// The source is copied under the name of the destination
+	name := func(entry string) string {
+		entry = path.Clean("/" + entry)[1:]
+		if rename == "" || src.Name == "" {
+			return entry
+		}
+
+		if top, rest, _ := strings.Cut(entry, "/"); top == src.Name {
+			return path.Join(rename, rest)
+		}
+		return entry
+	}
+
+	tr := tar.NewReader(r)
+	dirTimes := make(map[string]time.Time)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %v", err)
+		}
+
+		targetPath, err := ResolveInRoot(dir, name(header.Name))
+		if err != nil {
+			return err
+		}
+		if targetPath == dir {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = name(header.Linkname)
+		}
+
+		if opts.Mappings != nil && !opts.Archive {
+			header.Uid, header.Gid = 0, 0
+		}
+
+		if err := unpackEntry(tr, header, dir, targetPath, opts.Mappings, opts.Mappings != nil); err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			dirTimes[targetPath] = header.ModTime
+		}
+	}
+
+	for path, mtime := range dirTimes {
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	return nil
+}
+
+// resolvePath resolves a path in the root filesystem like ResolveInRoot,
+// following a symlink in its last component as well if follow is set.
+func resolvePath(root, name string, follow bool) (string, error) {
+	for links := 0; ; links++ {
+		p, err := ResolveInRoot(root, name)
+		if err != nil || !follow {
+			return p, err
+		}
+
+		fi, err := os.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+
+		if links == maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %s: %v", name, err)
+		}
+
+		if !filepath.IsAbs(target) {
+			rel, err := filepath.Rel(root, filepath.Dir(p))
+			if err != nil {
+				return "", err
+			}
+			target = filepath.Join("/", rel, target)
+		}
+		name = target
+	}
+}
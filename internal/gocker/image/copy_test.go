@@ -0,0 +1,83 @@
+package image
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopy tests copying files and directories between root filesystems like docker cp
+func TestCopy(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"dir/a": "a", "dir/sub/b": "b", "file": "file"})
+	must(t, os.Symlink("file", filepath.Join(src, "link")))
+
+	tests := []struct {
+		name       string
+		src, dest  string
+		followLink bool
+		expected   map[string]string
+	}{
+		{"file to new file", "/file", "/copy", false, map[string]string{"copy": "file"}},
+		{"file into directory", "/file", "/existing", false, map[string]string{"existing/file": "file"}},
+		{"directory to new directory", "/dir", "/copy", false, map[string]string{"copy/a": "a", "copy/sub/b": "b"}},
+		{"directory into directory", "/dir", "/existing/", false, map[string]string{"existing/dir/a": "a", "existing/dir/sub/b": "b"}},
+		{"directory contents", "/dir/.", "/existing", false, map[string]string{"existing/a": "a", "existing/sub/b": "b"}},
+		{"followed symlink", "/link", "/copy", true, map[string]string{"copy": "file"}},
+		{"escaping symlink", "/file", "/escape/file", false, map[string]string{"etc/file": "file"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			must(t, os.Mkdir(filepath.Join(dest, "existing"), 0755))
+			must(t, os.Mkdir(filepath.Join(dest, "etc"), 0755))
+			must(t, os.Symlink("/etc", filepath.Join(dest, "escape")))
+
+			source, err := StatSource(src, tt.src, tt.followLink)
+			if err != nil {
+				t.Fatalf("Failed to stat source: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := source.WriteTar(&buf, nil); err != nil {
+				t.Fatalf("Failed to write source: %v", err)
+			}
+
+			if err := ExtractCopy(&buf, dest, tt.dest, source, CopyOptions{}); err != nil {
+				t.Fatalf("Failed to extract copy: %v", err)
+			}
+
+			for name, contents := range tt.expected {
+				data, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(data) != contents {
+					t.Errorf("Expected %s to contain %q, got %q (%v)", name, contents, data, err)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"/missing", "/file/"} {
+		if _, err := StatSource(src, name, false); err == nil {
+			t.Errorf("Expected %s to be rejected as a source", name)
+		}
+	}
+
+	source, err := StatSource(src, "/dir", false)
+	if err != nil {
+		t.Fatalf("Failed to stat source: %v", err)
+	}
+
+	dest := t.TempDir()
+	writeFiles(t, dest, map[string]string{"file": "file"})
+
+	for _, name := range []string{"/file", "/missing/dir"} {
+		var buf bytes.Buffer
+		must(t, source.WriteTar(&buf, nil))
+
+		if err := ExtractCopy(&buf, dest, name, source, CopyOptions{}); err == nil {
+			t.Errorf("Expected a directory not to be copied to %s", name)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+const (
+	// DefaultMaxConcurrentDownloads is the number of layers pulled at once unless set otherwise.
+	DefaultMaxConcurrentDownloads = 3
+
+	// downloadAttempts is the number of times a blob download is tried before the pull fails.
+	downloadAttempts = 5
+)
+
+// downloadBackoff is the delay before the first retry of a failed download, doubled for every further one.
+var downloadBackoff = time.Second
+
+// SetMaxConcurrentDownloads limits the number of layers pulled at once.
+func (c *Client) SetMaxConcurrentDownloads(n int) {
+	c.maxConcurrentDownloads = n
+}
+
+// downloadImage downloads the layers missing from the blob store, a bounded number at a time.
+// The first download to fail for good cancels the others.
+func (c *Client) downloadImage() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limit := c.maxConcurrentDownloads
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentDownloads
+	}
+
+	p := newProgress(os.Stdout)
+	bars := make([]*progressBar, len(c.manifest.Layers))
+	for i, layer := range c.manifest.Layers {
+		bars[i] = p.add(ShortDigest(layer.Digest), layer.Size, "Pulling fs layer")
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	slots := make(chan struct{}, limit)
+
+	for i, layer := range c.manifest.Layers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+
+			if err := c.downloadBlob(ctx, layer, bars[i]); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("failed to download layer %d: %v", i+1, err)
+					cancel()
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// downloadBlob stores a blob of the repository in the blob store, retrying failed attempts
+// with exponential backoff. Every attempt resumes where the one before it stopped.
+func (c *Client) downloadBlob(ctx context.Context, desc registry.Descriptor, bar *progressBar) error {
+	if HasBlob(desc.Digest) {
+		bar.setStatus("Already exists")
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := c.fetchBlob(ctx, desc, bar)
+		if err == nil {
+			bar.setStatus("Download complete")
+			return nil
+		}
+
+		if !retry || attempt == downloadAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := downloadBackoff << (attempt - 1)
+		bar.setStatus("Retrying in %s (attempt %d of %d): %v", delay, attempt+1, downloadAttempts, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fetchBlob makes one attempt at downloading a blob. The data is appended to a partial file
+// kept between attempts and pulls, which is locked so concurrent pulls of a blob take turns.
+// It reports whether a failed attempt is worth retrying.
+func (c *Client) fetchBlob(ctx context.Context, desc registry.Descriptor, bar *progressBar) (bool, error) {
+	dir := filepath.Join(os.Getenv("HOME"), RelativeTmpPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create temporary dir: %v", err)
+	}
+
+	partial, err := os.OpenFile(filepath.Join(dir, "download-"+strings.TrimPrefix(desc.Digest, "sha256:")), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open partial download: %v", err)
+	}
+	defer partial.Close()
+
+	if err := syscall.Flock(int(partial.Fd()), syscall.LOCK_EX); err != nil {
+		return false, fmt.Errorf("failed to lock partial download: %v", err)
+	}
+
+	// Another pull may have finished the blob while this one waited for the lock
+	if HasBlob(desc.Digest) {
+		return false, nil
+	}
+
+	offset, err := partial.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, fmt.Errorf("failed to read partial download: %v", err)
+	}
+
+	headers := c.headers()
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+
+	resp, err := c.httpClient.Send(ctx, http.MethodGet, c.url("blobs", desc.Digest), nil, headers)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case nethttp.StatusPartialContent:
+		if start := contentRangeStart(resp.Header.Get("Content-Range")); start != offset {
+			partial.Truncate(0)
+			return true, fmt.Errorf("registry resumed the download at byte %d instead of %d", start, offset)
+		}
+	case nethttp.StatusOK:
+		// The registry ignored the range and sends the whole blob
+		if err := partial.Truncate(0); err != nil {
+			return false, fmt.Errorf("failed to truncate partial download: %v", err)
+		}
+		if offset, err = partial.Seek(0, io.SeekStart); err != nil {
+			return false, fmt.Errorf("failed to truncate partial download: %v", err)
+		}
+	case nethttp.StatusRequestedRangeNotSatisfiable:
+		// The partial download is at least as long as the blob, so it's corrupt
+		partial.Truncate(0)
+		return true, fmt.Errorf("partial download is longer than the blob")
+	default:
+		retry := resp.StatusCode >= 500 || resp.StatusCode == nethttp.StatusTooManyRequests || resp.StatusCode == nethttp.StatusRequestTimeout
+		return retry, fmt.Errorf("unexpected status from registry: %d", resp.StatusCode)
+	}
+
+	bar.start(offset)
+
+	if _, err := io.Copy(io.MultiWriter(partial, bar), resp.Body); err != nil {
+		return true, fmt.Errorf("failed to read blob: %v", err)
+	}
+
+	bar.setStatus("Verifying Checksum")
+
+	hash := sha256.New()
+	if _, err := partial.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to read partial download: %v", err)
+	}
+	if _, err := io.Copy(hash, partial); err != nil {
+		return false, fmt.Errorf("failed to read partial download: %v", err)
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != desc.Digest {
+		partial.Truncate(0)
+		return true, fmt.Errorf("digest mismatch: expected %s, got %s", desc.Digest, actual)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(BlobPath(desc.Digest)), 0755); err != nil {
+		return false, fmt.Errorf("failed to create blob store: %v", err)
+	}
+	if err := os.Rename(partial.Name(), BlobPath(desc.Digest)); err != nil {
+		return false, fmt.Errorf("failed to store blob: %v", err)
+	}
+
+	return false, nil
+}
+
+// contentRangeStart returns the first byte of a "bytes start-end/size" range, or -1.
+func contentRangeStart(s string) int64 {
+	start, _, ok := strings.Cut(strings.TrimPrefix(s, "bytes "), "-")
+	if !ok {
+		return -1
+	}
+
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
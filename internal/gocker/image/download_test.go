@@ -0,0 +1,119 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// TestDownloadImage tests that layer downloads are retried, resumed and run a bounded number at a time
+func TestDownloadImage(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	downloadBackoff = time.Millisecond
+	defer func() { downloadBackoff = time.Second }()
+
+	var (
+		mu              sync.Mutex
+		blobs           = map[string][]byte{}
+		requests        = map[string][]string{} // Range headers by digest
+		active, maxSeen int
+	)
+
+	var layers []registry.Descriptor
+	for i := range 4 {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 10000)
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+
+		blobs[digest] = data
+		layers = append(layers, registry.Descriptor{Digest: digest, Size: int64(len(data))})
+	}
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		if req.URL.Path == "/v2/" {
+			return
+		}
+		digest := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+
+		mu.Lock()
+		requests[digest] = append(requests[digest], req.Header.Get("Range"))
+		attempt := len(requests[digest])
+		active++
+		maxSeen = max(maxSeen, active)
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		data, ok := blobs[digest]
+		switch {
+		case !ok:
+			w.WriteHeader(nethttp.StatusNotFound)
+		case digest == layers[0].Digest && attempt == 1:
+			// The connection breaks halfway through the blob
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:len(data)/2])
+		case digest == layers[1].Digest && attempt == 1:
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+		default:
+			nethttp.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(strings.TrimPrefix(server.URL, "http://")+"/team/app:v1", http.NewHttpClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.authenticate("pull"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetMaxConcurrentDownloads(2)
+	c.manifest = &registry.Manifest{Layers: layers}
+
+	if err := c.downloadImage(); err != nil {
+		t.Fatalf("Failed to download image: %v", err)
+	}
+
+	for _, layer := range layers {
+		data, err := ReadBlob(layer.Digest)
+		if err != nil || !bytes.Equal(data, blobs[layer.Digest]) {
+			t.Errorf("Expected layer %s to be stored (%v)", ShortDigest(layer.Digest), err)
+		}
+	}
+
+	if got := requests[layers[0].Digest]; len(got) != 2 || got[1] != "bytes=5000-" {
+		t.Errorf("Expected the broken download to resume at byte 5000, got ranges %q", got)
+	}
+	if got := requests[layers[1].Digest]; len(got) != 2 || got[1] != "" {
+		t.Errorf("Expected the failed download to be retried from the start, got ranges %q", got)
+	}
+	if maxSeen > 2 {
+		t.Errorf("Expected at most 2 concurrent downloads, got %d", maxSeen)
+	}
+
+	// Stored layers aren't downloaded again, and missing ones fail without retries
+	missing := registry.Descriptor{Digest: "sha256:" + strings.Repeat("0", 64)}
+	c.manifest = &registry.Manifest{Layers: append(layers, missing)}
+
+	if err := c.downloadImage(); err == nil {
+		t.Errorf("Expected the download of a missing layer to fail")
+	}
+	if len(requests[layers[2].Digest]) != 1 || len(requests[missing.Digest]) != 1 {
+		t.Errorf("Expected a single request for the missing layer and none for stored ones, got %v", requests)
+	}
+}
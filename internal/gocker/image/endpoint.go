@@ -0,0 +1,127 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// RelativeDaemonConfigPath is the relative path of the daemon-style configuration file under the user's home directory.
+const RelativeDaemonConfigPath = ".config/gocker/daemon.json"
+
+// DaemonConfig holds the registry settings of the daemon-style configuration file:
+//
+//	{
+//		"registry-mirrors": ["https://mirror.gcr.io"],
+//		"mirrors": {"ghcr.io": ["https://ghcr-cache.internal"]},
+//		"insecure-registries": ["registry.internal:5000", "10.0.0.0/8"]
+//	}
+type DaemonConfig struct {
+	// RegistryMirrors are pull-through mirrors of Docker Hub, tried in order before it
+	RegistryMirrors []string `json:"registry-mirrors,omitempty"`
+	// Mirrors are the pull-through mirrors of other registries by host
+	Mirrors map[string][]string `json:"mirrors,omitempty"`
+	// InsecureRegistries are the hosts, or CIDR ranges of their addresses, of registries
+	// with untrusted certificates or no TLS at all
+	InsecureRegistries []string `json:"insecure-registries,omitempty"`
+}
+
+// endpoint is a base URL the registry API of an image is served from.
+type endpoint struct {
+	url string
+	// host is the host the endpoint is known by, credentials are stored for it
+	host   string
+	mirror bool
+	// insecure endpoints are tried over HTTPS without verifying certificates, then over plain HTTP
+	insecure bool
+}
+
+// LoadDaemonConfig reads the daemon-style configuration file. Without one the configuration is empty.
+func LoadDaemonConfig() (*DaemonConfig, error) {
+	cfg := &DaemonConfig{}
+
+	data, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), RelativeDaemonConfigPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read daemon config: %v", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode daemon config: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// endpoints returns the endpoints images of the registry are pulled from: its mirrors in
+// order, then the registry itself. The registry itself comes last, so it is pushed to.
+func (cfg *DaemonConfig) endpoints(host string) ([]endpoint, error) {
+	mirrors := cfg.Mirrors[host]
+	if host == DefaultRegistry {
+		mirrors = cfg.RegistryMirrors
+	}
+
+	var endpoints []endpoint
+	for _, mirror := range mirrors {
+		if !strings.Contains(mirror, "://") {
+			mirror = "https://" + mirror
+		}
+
+		u, err := url.Parse(strings.TrimSuffix(mirror, "/"))
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid mirror of %s: %q", host, mirror)
+		}
+
+		endpoints = append(endpoints, endpoint{url: u.String(), host: u.Host, mirror: true, insecure: cfg.insecure(u.Host)})
+	}
+
+	return append(endpoints, endpoint{url: registryURL(host), host: host, insecure: cfg.insecure(host)}), nil
+}
+
+// insecure reports whether the registry host is listed as insecure, by its name or an address
+// in a listed range. Loopback registries are always insecure, like docker treats them.
+func (cfg *DaemonConfig) insecure(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	ip := net.ParseIP(hostname)
+	if hostname == "localhost" || ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, entry := range cfg.InsecureRegistries {
+		entry = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(entry, "https://"), "http://"), "/")
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if entry == host || entry == hostname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registryURL returns the base URL of a registry. Docker Hub serves the registry API from its own host.
+func registryURL(host string) string {
+	if host == DefaultRegistry {
+		return "https://" + registry.URL
+	}
+
+	return "https://" + host
+}
@@ -0,0 +1,138 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	nethttp "net/http"
+	"net/http/httptest"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// TestMirrorsAndRedirects tests pulling through mirrors and from registries redirecting blob downloads
+func TestMirrorsAndRedirects(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	reg := newTestRegistry()
+
+	// The mirror serves TLS with a certificate nobody trusts, loopback registries are insecure
+	mirror := httptest.NewTLSServer(reg)
+	defer mirror.Close()
+
+	var (
+		mu          sync.Mutex
+		upstreamReq int
+		cdnAuth     []string
+	)
+
+	cdn := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		mu.Lock()
+		cdnAuth = append(cdnAuth, req.Header.Get("Authorization"))
+		mu.Unlock()
+
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		w.Write(reg.blobs[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]])
+	}))
+	defer cdn.Close()
+
+	// The registry itself redirects blob downloads to the CDN
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		mu.Lock()
+		upstreamReq++
+		mu.Unlock()
+
+		if strings.Contains(req.URL.Path, "/blobs/sha256:") && req.Header.Get("Authorization") != "" {
+			nethttp.Redirect(w, req, cdn.URL+req.URL.Path, nethttp.StatusTemporaryRedirect)
+			return
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer upstream.Close()
+
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	mirrorHost, upstreamHost := strings.TrimPrefix(mirror.URL, "https://"), strings.TrimPrefix(upstream.URL, "http://")
+
+	rootfs, err := TempDir("rootfs-")
+	must(t, err)
+	before, err := TakeSnapshot(rootfs)
+	must(t, err)
+	writeFiles(t, rootfs, map[string]string{"etc/motd": "hello"})
+	layer, diffID, err := WriteLayer(rootfs, before, identity)
+	must(t, err)
+	cfg := &registry.ImageConfig{Os: "linux"}
+	cfg.Rootfs.DiffIds = []string{diffID}
+	_, err = Save(mirrorHost+"/team/app:v1", rootfs, cfg, []registry.Descriptor{layer})
+	must(t, err)
+
+	pushed, err := NewClient(mirrorHost+"/team/app:v1", http.NewHttpClient())
+	must(t, err)
+	must(t, pushed.Push())
+
+	pull := func(name string) {
+		t.Helper()
+
+		c, err := NewClient(name, http.NewHttpClient())
+		must(t, err)
+		if err := c.Pull(); err != nil {
+			t.Fatalf("Failed to pull %s: %v", name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(Path(name), "rootfs", "etc", "motd"))
+		if err != nil || string(data) != "hello" {
+			t.Errorf("Expected the pulled image to have its files, got %q (%v)", data, err)
+		}
+	}
+
+	// Mirrors are tried in order, and the registry isn't asked once one serves the image
+	writeFiles(t, os.Getenv("HOME"), map[string]string{
+		RelativeDaemonConfigPath: `{"mirrors": {"` + upstreamHost + `": ["` + dead.URL + `", "` + mirrorHost + `"]}}`,
+	})
+	pull(upstreamHost + "/team/app:v1")
+
+	if upstreamReq != 0 {
+		t.Errorf("Expected the mirror to serve the image, the registry got %d requests", upstreamReq)
+	}
+
+	// Without mirrors blobs come from the CDN, which never sees the token
+	t.Setenv("HOME", t.TempDir())
+	pull(upstreamHost + "/team/app:v1")
+
+	if len(cdnAuth) != 2 {
+		t.Errorf("Expected the config and layer to be downloaded from the CDN, got %d downloads", len(cdnAuth))
+	}
+	for _, auth := range cdnAuth {
+		if auth != "" {
+			t.Errorf("Expected no authorization to reach the CDN, got %q", auth)
+		}
+	}
+}
+
+// TestInsecureRegistries tests which registry hosts are insecure
+func TestInsecureRegistries(t *testing.T) {
+	cfg := &DaemonConfig{InsecureRegistries: []string{"registry.internal:5000", "http://plain.internal/", "10.0.0.0/8"}}
+
+	for host, want := range map[string]bool{
+		"registry.internal:5000": true,
+		"registry.internal":      false,
+		"plain.internal":         true,
+		"plain.internal:8080":    true,
+		"10.1.2.3:5000":          true,
+		"11.1.2.3":               false,
+		"localhost:5000":         true,
+		"127.0.0.1":              true,
+		"[::1]:5000":             true,
+		"ghcr.io":                false,
+	} {
+		if got := cfg.insecure(host); got != want {
+			t.Errorf("Expected %s to be insecure: %v, got %v", host, want, got)
+		}
+	}
+}
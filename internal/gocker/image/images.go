@@ -0,0 +1,253 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// Image describes an image of the local image store.
+type Image struct {
+	// Name is the reference the image is stored under
+	Name string
+	// ID is the digest of the image config
+	ID string
+	// Digest is the digest of the image manifest, empty for images stored without one
+	Digest string
+	// Size is the size of the unpacked root filesystem
+	Size    int64
+	Created time.Time
+	Config  *registry.ImageConfig
+}
+
+// Dangling reports whether the image has no name and is stored under its ID, like loaded images without one.
+func (img *Image) Dangling() bool {
+	return img.Name == img.ID
+}
+
+// Repository returns the repository part of the image name, empty for dangling images.
+func (img *Image) Repository() string {
+	if img.Dangling() {
+		return ""
+	}
+
+	if ref, err := ParseReference(img.Name); err == nil {
+		return ref.Name()
+	}
+
+	return img.Name
+}
+
+// Tag returns the tag of the image name, empty for dangling images and images stored by digest.
+func (img *Image) Tag() string {
+	if img.Dangling() {
+		return ""
+	}
+
+	if ref, err := ParseReference(img.Name); err == nil && ref.Digest == "" {
+		return ref.Tag
+	}
+
+	return ""
+}
+
+// Get returns the image stored under the given name.
+func Get(name string) (*Image, error) {
+	return loadImage(Path(name))
+}
+
+// loadImage reads the metadata of an image directory.
+func loadImage(imgPath string) (*Image, error) {
+	name, _ := url.PathUnescape(filepath.Base(imgPath))
+
+	data, err := os.ReadFile(filepath.Join(imgPath, configFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such image: %s", name)
+		}
+		return nil, fmt.Errorf("failed to read config of %s: %v", name, err)
+	}
+
+	img := &Image{Name: name, Config: &registry.ImageConfig{}}
+	if err := json.Unmarshal(data, img.Config); err != nil {
+		return nil, fmt.Errorf("failed to decode config of %s: %v", name, err)
+	}
+
+	if manifest, manifestData, err := loadManifestFile(imgPath); err == nil {
+		sum := sha256.Sum256(manifestData)
+		img.ID, img.Digest = manifest.Config.Digest, "sha256:"+hex.EncodeToString(sum[:])
+	} else {
+		// Images pulled before manifests were kept are identified by their config file
+		sum := sha256.Sum256(data)
+		img.ID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	img.Created, _ = time.Parse(time.RFC3339Nano, img.Config.Created)
+
+	err = filepath.WalkDir(filepath.Join(imgPath, "rootfs"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				img.Size += fi.Size()
+			}
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to measure %s: %v", name, err)
+	}
+
+	return img, nil
+}
+
+// List returns all images of the local image store, newest first.
+func List() ([]*Image, error) {
+	dir := filepath.Join(os.Getenv("HOME"), RelativeImagesPath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read image store: %v", err)
+	}
+
+	var images []*Image
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		img, err := loadImage(filepath.Join(dir, e.Name()))
+		if err != nil {
+			// Skip images that are being pulled or removed
+			continue
+		}
+
+		images = append(images, img)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		if !images[i].Created.Equal(images[j].Created) {
+			return images[i].Created.After(images[j].Created)
+		}
+		return images[i].Name < images[j].Name
+	})
+
+	return images, nil
+}
+
+// Find returns the images a reference refers to: the image stored under that name,
+// or every name of the image whose ID is or starts with the reference.
+func Find(ref string) ([]*Image, error) {
+	if img, err := Get(ref); err == nil {
+		return []*Image{img}, nil
+	}
+
+	images, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimPrefix(ref, "sha256:")
+
+	var matches []*Image
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err == nil && prefix != "" {
+		for _, img := range images {
+			if strings.HasPrefix(strings.TrimPrefix(img.ID, "sha256:"), prefix) {
+				matches = append(matches, img)
+			}
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no such image: %s", ref)
+	}
+
+	for _, img := range matches[1:] {
+		if img.ID != matches[0].ID {
+			return nil, fmt.Errorf("multiple images match %q", ref)
+		}
+	}
+
+	return matches, nil
+}
+
+// Remove removes the image stored under the given name. The blobs of the image stay in the
+// blob store, other names of the image and the build cache may still refer to them.
+func Remove(name string) error {
+	if !Exists(name) {
+		return fmt.Errorf("no such image: %s", name)
+	}
+
+	if err := os.RemoveAll(Path(name)); err != nil {
+		return fmt.Errorf("failed to remove image %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// Tag stores the image under another name. Images are modified in place by the containers
+// running them, so the new name gets its own root filesystem extracted from the layers.
+func Tag(source, target string) error {
+	ref, err := ParseReference(target)
+	if err != nil {
+		return err
+	}
+	target = ref.String()
+	if ref.Digest != "" {
+		return fmt.Errorf("refusing to create a tag with a digest reference")
+	}
+
+	srcs, err := Find(source)
+	if err != nil {
+		return err
+	}
+	src := srcs[0]
+
+	if existing, err := Get(target); err == nil && existing.ID == src.ID {
+		return nil
+	}
+
+	manifest, manifestData, err := loadManifestFile(Path(src.Name))
+	if err != nil {
+		return err
+	}
+
+	m, err := idmap.Default()
+	if err != nil {
+		return err
+	}
+
+	rootfs, err := ExtractLayers(manifest.Layers, m)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := saveImageDir(Path(target), rootfs, src.Config, manifestData); err != nil {
+		return err
+	}
+
+	// A dangling image isn't kept once it has a name
+	if src.Dangling() {
+		return Remove(src.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// TestImages tests listing, finding, tagging and removing local images
+func TestImages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	save := func(name, created string, files map[string]string) *Image {
+		t.Helper()
+
+		rootfs, err := TempDir("rootfs-")
+		must(t, err)
+
+		before, err := TakeSnapshot(rootfs)
+		must(t, err)
+		writeFiles(t, rootfs, files)
+
+		layer, diffID, err := WriteLayer(rootfs, before, identity)
+		must(t, err)
+
+		cfg := &registry.ImageConfig{Created: created}
+		cfg.Rootfs.DiffIds = []string{diffID}
+
+		_, err = Save(name, rootfs, cfg, []registry.Descriptor{layer})
+		must(t, err)
+
+		img, err := Get(name)
+		must(t, err)
+
+		return img
+	}
+
+	old := save("app:1.0", "2024-01-01T00:00:00Z", map[string]string{"version": "1"})
+	save("app", "2025-01-01T00:00:00Z", map[string]string{"version": "2"})
+
+	if old.Size != 1 || old.Repository() != "app" || old.Tag() != "1.0" {
+		t.Errorf("Expected app 1.0 of size 1, got %s %s of size %d", old.Repository(), old.Tag(), old.Size)
+	}
+
+	images, err := List()
+	must(t, err)
+	if len(images) != 2 || images[0].Name != "app" || images[1].Name != "app:1.0" {
+		t.Fatalf("Expected the images newest first, got %v", images)
+	}
+
+	must(t, Tag("app:1.0", "registry.example.com/team/app:stable"))
+
+	tagged, err := Get("registry.example.com/team/app:stable")
+	must(t, err)
+	if tagged.ID != old.ID || tagged.Digest != old.Digest {
+		t.Errorf("Expected the tag to refer to image %s, got %s", old.ID, tagged.ID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(Path(tagged.Name), "rootfs", "version"))
+	if err != nil || string(data) != "1" {
+		t.Errorf("Expected the tag to have the files of the image, got %q (%v)", data, err)
+	}
+
+	// Both names of the image are found by its ID, the short form included
+	for _, ref := range []string{old.ID, ShortDigest(old.ID)} {
+		found, err := Find(ref)
+		if err != nil || len(found) != 2 {
+			t.Errorf("Expected %s to find both names of the image, got %v (%v)", ref, found, err)
+		}
+	}
+
+	if found, err := Find("app:1.0"); err != nil || len(found) != 1 {
+		t.Errorf("Expected a name to find a single image, got %v (%v)", found, err)
+	}
+
+	if _, err := Find("sha256:" + strings.Repeat("0", 64)); err == nil {
+		t.Errorf("Expected an unknown ID not to be found")
+	}
+
+	must(t, Remove("app:1.0"))
+	if Exists("app:1.0") || !Exists("registry.example.com/team/app:stable") {
+		t.Errorf("Expected only the removed name to be gone")
+	}
+	if !HasBlob(old.ID) {
+		t.Errorf("Expected the blobs of removed images to stay")
+	}
+
+	if err := Remove("app:1.0"); err == nil {
+		t.Errorf("Expected removing a missing image to fail")
+	}
+}
@@ -0,0 +1,692 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// maxSymlinks limits the symlinks followed while resolving a path, like the kernel's MAXSYMLINKS
+	maxSymlinks = 40
+)
+
+// permissionBits are the mode bits restored on extracted files.
+const permissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ApplyLayer unpacks a layer tar stream, gzip compressed or not, on top of the root
+// filesystem. Whiteout entries remove the files of lower layers they hide.
+func ApplyLayer(r io.Reader, root string, m *idmap.Mappings) error {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to create gzip reader: %v", err)
+		}
+		defer gr.Close()
+
+		return applyTar(tar.NewReader(gr), root, m)
+	}
+
+	return applyTar(tar.NewReader(br), root, m)
+}
+
+// applyTar unpacks the entries of a tar stream into the root filesystem.
+func applyTar(tr *tar.Reader, root string, m *idmap.Mappings) error {
+	// Entries of the layer itself survive an opaque whiteout of their directory
+	created := make(map[string]bool)
+
+	// Directory times are restored last, since adding entries changes them
+	dirTimes := make(map[string]time.Time)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %v", err)
+		}
+
+		targetPath, err := ResolveInRoot(root, header.Name)
+		if err != nil {
+			return err
+		}
+		if targetPath == root {
+			continue
+		}
+
+		base := filepath.Base(targetPath)
+
+		if base == whiteoutOpaque {
+			if err := clearDir(filepath.Dir(targetPath), created); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			// Only entries of the directory can be whited out, not the directory or its parent
+			if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+				return fmt.Errorf("invalid whiteout %s", header.Name)
+			}
+			if err := os.RemoveAll(filepath.Join(filepath.Dir(targetPath), name)); err != nil {
+				return fmt.Errorf("failed to remove whited out %s: %v", name, err)
+			}
+			continue
+		}
+
+		if err := unpackEntry(tr, header, root, targetPath, m, true); err != nil {
+			return err
+		}
+		created[targetPath] = true
+
+		if header.Typeflag == tar.TypeDir {
+			dirTimes[targetPath] = header.ModTime
+		}
+	}
+
+	for path, mtime := range dirTimes {
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	return nil
+}
+
+// unpackEntry creates the file of a tar entry at the target path in place of whatever is there,
+// merging directories with their existing versions, and restores its mode and modification time.
+// The owner is restored through the mappings if chown is set. Directory times are left to the
+// caller, since adding entries changes them.
+func unpackEntry(tr *tar.Reader, header *tar.Header, root, targetPath string, m *idmap.Mappings, chown bool) error {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory for %s: %v", targetPath, err)
+	}
+
+	// Entries replace whatever is there, except for directories merged with their lower versions
+	if fi, err := os.Lstat(targetPath); err == nil && !(fi.IsDir() && header.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(targetPath); err != nil {
+			return fmt.Errorf("failed to replace %s: %v", targetPath, err)
+		}
+	}
+
+	if err := extractEntry(tr, header, root, targetPath); err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeLink {
+		return nil
+	}
+
+	if chown {
+		if err := m.Lchown(targetPath, header.Uid, header.Gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %v", targetPath, err)
+		}
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+
+	// Changing the owner clears the setuid and setgid bits, so the mode comes last
+	if err := os.Chmod(targetPath, header.FileInfo().Mode()&permissionBits); err != nil {
+		return fmt.Errorf("failed to change mode of %s: %v", targetPath, err)
+	}
+
+	if header.Typeflag != tar.TypeDir {
+		os.Chtimes(targetPath, header.ModTime, header.ModTime)
+	}
+
+	return nil
+}
+
+// extractEntry creates the file described by a tar header.
+func extractEntry(tr *tar.Reader, header *tar.Header, root, targetPath string) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", targetPath, err)
+		}
+
+	case tar.TypeReg:
+		file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %v", targetPath, err)
+		}
+
+		_, err = io.Copy(file, tr)
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to write file %s: %v", targetPath, err)
+		}
+
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, targetPath); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %v", targetPath, err)
+		}
+
+	case tar.TypeLink:
+		linkTarget, err := ResolveInRoot(root, header.Linkname)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Link(linkTarget, targetPath); err != nil {
+			return fmt.Errorf("failed to create hard link %s: %v", targetPath, err)
+		}
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(header.Mode & 07777)
+		switch header.Typeflag {
+		case tar.TypeChar:
+			mode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			mode |= syscall.S_IFBLK
+		default:
+			mode |= syscall.S_IFIFO
+		}
+
+		dev := int((header.Devmajor&0xfff)<<8 | header.Devminor&0xff | (header.Devminor&^0xff)<<12)
+
+		// Device nodes can't be created without privileges, which containers can live without
+		if err := syscall.Mknod(targetPath, mode, dev); err != nil && !errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("failed to create device %s: %v", targetPath, err)
+		}
+	}
+
+	return nil
+}
+
+// clearDir removes the contents of a directory that weren't created by the current layer.
+func clearDir(dir string, created map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if created[path] {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// ResolveInRoot resolves a path as if root were the filesystem root. The parent directories
+// are resolved following symlinks within root, so entries can't be written outside of it.
+func ResolveInRoot(root, name string) (string, error) {
+	dir, base := filepath.Split(filepath.Clean("/" + name))
+
+	resolved := "/"
+	parts := strings.Split(dir, "/")
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink %s: %v", next, err)
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return filepath.Join(root, resolved, base), nil
+}
+
+// fileState holds the attributes of a file that change when it's modified.
+type fileState struct {
+	mode     os.FileMode
+	uid, gid uint32
+	size     int64
+	mtime    syscall.Timespec
+	ctime    syscall.Timespec
+	ino      uint64
+	link     string
+}
+
+// Snapshot records the state of every file in a root filesystem, keyed by relative path.
+type Snapshot map[string]fileState
+
+// TakeSnapshot records the state of the root filesystem, so the changes made to it
+// afterwards can be written as a layer.
+func TakeSnapshot(root string) (Snapshot, error) {
+	snapshot := make(Snapshot)
+
+	err := walkRoot(root, func(rel string, fi fs.FileInfo) error {
+		state, err := stateOf(filepath.Join(root, rel), fi)
+		if err != nil {
+			return err
+		}
+		snapshot[rel] = state
+
+		return nil
+	})
+
+	return snapshot, err
+}
+
+// walkRoot calls fn for every file in the root filesystem in lexical order with its relative path.
+// Filesystems mounted in the root filesystem, like the proc filesystem of a running container,
+// aren't part of it, so only their mount points are visited.
+func walkRoot(root string, fn func(rel string, fi fs.FileInfo) error) error {
+	var rootDev uint64
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				rootDev = uint64(st.Dev)
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(rel, fi); err != nil {
+			return err
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && d.IsDir() && uint64(st.Dev) != rootDev {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// stateOf returns the state of a file.
+func stateOf(path string, fi fs.FileInfo) (fileState, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileState{}, fmt.Errorf("failed to stat %s", path)
+	}
+
+	state := fileState{
+		mode:  fi.Mode(),
+		uid:   st.Uid,
+		gid:   st.Gid,
+		size:  fi.Size(),
+		mtime: st.Mtim,
+		ctime: st.Ctim,
+		ino:   st.Ino,
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return fileState{}, fmt.Errorf("failed to read symlink %s: %v", path, err)
+		}
+		state.link = link
+	}
+
+	return state, nil
+}
+
+// snapshotEntry is the stored form of a file state.
+type snapshotEntry struct {
+	Mode         os.FileMode
+	UID, GID     uint32
+	Size         int64
+	Mtime, Ctime syscall.Timespec
+	Ino          uint64
+	Link         string
+}
+
+// saveSnapshot stores a snapshot of the root filesystem of an image in its image directory.
+// Containers change the root filesystem of their image, the snapshot tells their changes apart.
+func saveSnapshot(imgPath string) error {
+	snapshot, err := TakeSnapshot(filepath.Join(imgPath, "rootfs"))
+	if err != nil {
+		return fmt.Errorf("failed to take snapshot of image: %v", err)
+	}
+
+	entries := make(map[string]snapshotEntry, len(snapshot))
+	for rel, st := range snapshot {
+		entries[rel] = snapshotEntry{st.mode, st.uid, st.gid, st.size, st.mtime, st.ctime, st.ino, st.link}
+	}
+
+	f, err := os.Create(filepath.Join(imgPath, snapshotFile))
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+
+	err = gob.NewEncoder(f).Encode(entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot returns the snapshot of the root filesystem of an image taken when it was stored.
+func LoadSnapshot(name string) (Snapshot, error) {
+	f, err := os.Open(filepath.Join(Path(name), snapshotFile))
+	if err != nil {
+		if os.IsNotExist(err) && Exists(name) {
+			return nil, fmt.Errorf("image %s has no snapshot of its files, pull it again", name)
+		}
+		return nil, fmt.Errorf("failed to read snapshot of %s: %v", name, err)
+	}
+	defer f.Close()
+
+	var entries map[string]snapshotEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot of %s: %v", name, err)
+	}
+
+	snapshot := make(Snapshot, len(entries))
+	for rel, e := range entries {
+		snapshot[rel] = fileState{e.Mode, e.UID, e.GID, e.Size, e.Mtime, e.Ctime, e.Ino, e.Link}
+	}
+
+	return snapshot, nil
+}
+
+// ExtractLayers unpacks the layers of the blob store into a new temporary root filesystem.
+func ExtractLayers(layers []registry.Descriptor, m *idmap.Mappings) (string, error) {
+	rootfs, err := TempDir("rootfs-")
+	if err != nil {
+		return "", err
+	}
+
+	for i, layer := range layers {
+		f, err := OpenBlob(layer.Digest)
+		if err != nil {
+			os.RemoveAll(rootfs)
+			return "", fmt.Errorf("layer %s is missing, pull or build the image again", ShortDigest(layer.Digest))
+		}
+
+		err = ApplyLayer(f, rootfs, m)
+		f.Close()
+
+		if err != nil {
+			os.RemoveAll(rootfs)
+			return "", fmt.Errorf("failed to extract layer %d: %v", i+1, err)
+		}
+	}
+
+	return rootfs, nil
+}
+
+// WriteTar writes every file of the root filesystem into a tar stream, with their owners as
+// the container sees them.
+func WriteTar(w io.Writer, root string, m *idmap.Mappings) error {
+	tw := tar.NewWriter(w)
+
+	if err := writeChanges(tw, root, Snapshot{}, m); err != nil {
+		return fmt.Errorf("failed to write %s: %v", root, err)
+	}
+
+	return tw.Close()
+}
+
+// WriteLayer writes the changes made to the root filesystem since the snapshot was taken
+// into a gzip compressed layer in the blob store. Files removed since then are recorded
+// as whiteouts. It returns the descriptor of the layer and its uncompressed digest.
+func WriteLayer(root string, before Snapshot, m *idmap.Mappings) (registry.Descriptor, string, error) {
+	pr, pw := io.Pipe()
+	diffID := make(chan string, 1)
+
+	go func() {
+		gw := gzip.NewWriter(pw)
+		hash := sha256.New()
+		tw := tar.NewWriter(io.MultiWriter(gw, hash))
+
+		err := writeChanges(tw, root, before, m)
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil {
+			err = gw.Close()
+		}
+
+		diffID <- "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		pw.CloseWithError(err)
+	}()
+
+	digest, size, err := WriteBlob(pr)
+	pr.Close()
+	if err != nil {
+		return registry.Descriptor{}, "", fmt.Errorf("failed to write layer: %v", err)
+	}
+
+	return registry.Descriptor{MediaType: registry.MediaTypeOCILayer, Size: size, Digest: digest}, <-diffID, nil
+}
+
+// ChangeKind is the kind of change made to a file of a root filesystem.
+type ChangeKind string
+
+const (
+	ChangeModified ChangeKind = "C"
+	ChangeAdded    ChangeKind = "A"
+	ChangeDeleted  ChangeKind = "D"
+)
+
+// Change is a file of a root filesystem changed since a snapshot was taken.
+type Change struct {
+	Kind ChangeKind
+	Path string
+}
+
+// fileChange is a change along with the current info of the file, if it wasn't deleted.
+type fileChange struct {
+	Change
+	fi fs.FileInfo
+}
+
+// Changes returns the files of the root filesystem that were added, changed or deleted since
+// the snapshot was taken, sorted by path. The files of a deleted directory aren't listed.
+func Changes(root string, before Snapshot) ([]Change, error) {
+	changes, err := compare(root, before)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Change, len(changes))
+	for i, c := range changes {
+		result[i] = c.Change
+		result[i].Path = "/" + filepath.ToSlash(c.Path)
+	}
+
+	return result, nil
+}
+
+// compare returns the changes made to the root filesystem since the snapshot was taken,
+// sorted by relative path.
+func compare(root string, before Snapshot) ([]fileChange, error) {
+	seen := make(map[string]bool, len(before))
+
+	var changes []fileChange
+
+	err := walkRoot(root, func(rel string, fi fs.FileInfo) error {
+		seen[rel] = true
+
+		state, err := stateOf(filepath.Join(root, rel), fi)
+		if err != nil {
+			return err
+		}
+
+		if prev, ok := before[rel]; !ok {
+			changes = append(changes, fileChange{Change{ChangeAdded, rel}, fi})
+		} else if prev != state {
+			changes = append(changes, fileChange{Change{ChangeModified, rel}, fi})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel := range before {
+		// Removing a directory hides everything below it
+		if !seen[rel] && (filepath.Dir(rel) == "." || seen[filepath.Dir(rel)]) {
+			changes = append(changes, fileChange{Change: Change{ChangeDeleted, rel}})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes, nil
+}
+
+// writeChanges writes tar entries for the files that were added or changed
+// since the snapshot was taken and whiteouts for the removed ones.
+func writeChanges(tw *tar.Writer, root string, before Snapshot, m *idmap.Mappings) error {
+	changes, err := compare(root, before)
+	if err != nil {
+		return err
+	}
+
+	links := make(map[uint64]string)
+
+	for _, c := range changes {
+		if c.Kind == ChangeDeleted {
+			dir, base := filepath.Split(c.Path)
+			header := &tar.Header{Name: dir + whiteoutPrefix + base, Typeflag: tar.TypeReg, Mode: 0600}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeEntry(tw, root, c.Path, c.fi, links, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeEntry writes the tar entry of a file. Files sharing an inode with
+// a file written before are written as hard links to that file.
+func writeEntry(tw *tar.Writer, root, rel string, fi fs.FileInfo, links map[uint64]string, m *idmap.Mappings) error {
+	path := filepath.Join(root, rel)
+
+	// Sockets only exist while their server runs
+	if fi.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return fmt.Errorf("failed to read symlink %s: %v", path, err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %v", path, err)
+	}
+
+	st := fi.Sys().(*syscall.Stat_t)
+	uid, gid := m.ToContainer(int(st.Uid), int(st.Gid))
+
+	header.Name = filepath.ToSlash(rel)
+	header.Uid, header.Gid = uid, gid
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+
+	if fi.IsDir() {
+		header.Name += "/"
+	}
+
+	if fi.Mode().IsRegular() && st.Nlink > 1 {
+		if target, ok := links[st.Ino]; ok {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, target, 0
+		} else {
+			links[st.Ino] = header.Name
+		}
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %v", path, err)
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write %s to layer: %v", path, err)
+	}
+
+	return nil
+}
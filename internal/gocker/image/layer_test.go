@@ -0,0 +1,206 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+)
+
+// identity maps every ID onto itself.
+var identity = &idmap.Mappings{
+	UIDs: []idmap.Mapping{{ContainerID: 0, HostID: 0, Size: 65536}},
+	GIDs: []idmap.Mapping{{ContainerID: 0, HostID: 0, Size: 65536}},
+}
+
+// TestLayerRoundTrip tests that applying a written layer on top of the
+// original root filesystem reproduces the changes, removals included
+func TestLayerRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	root, lower := t.TempDir(), t.TempDir()
+	for _, dir := range []string{root, lower} {
+		writeFiles(t, dir, map[string]string{
+			"etc/config":      "old",
+			"etc/removed":     "gone",
+			"var/cache/a":     "a",
+			"var/cache/b":     "b",
+			"usr/bin/program": "binary",
+		})
+	}
+
+	before, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	writeFiles(t, root, map[string]string{"etc/config": "new", "home/user/file": "added"})
+	must(t, os.Remove(filepath.Join(root, "etc/removed")))
+	must(t, os.RemoveAll(filepath.Join(root, "var/cache")))
+	must(t, os.Symlink("program", filepath.Join(root, "usr/bin/alias")))
+	must(t, os.Chmod(filepath.Join(root, "usr/bin/program"), 0755))
+
+	layer, diffID, err := WriteLayer(root, before, identity)
+	if err != nil {
+		t.Fatalf("Failed to write layer: %v", err)
+	}
+
+	if !HasBlob(layer.Digest) || !ValidDigest(diffID) {
+		t.Fatalf("Expected the layer in the blob store, got %+v with diff ID %q", layer, diffID)
+	}
+
+	f, err := OpenBlob(layer.Digest)
+	if err != nil {
+		t.Fatalf("Failed to open layer: %v", err)
+	}
+	defer f.Close()
+
+	if err := ApplyLayer(f, lower, identity); err != nil {
+		t.Fatalf("Failed to apply layer: %v", err)
+	}
+
+	expected, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	actual, err := TakeSnapshot(lower)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	for rel, state := range expected {
+		got, ok := actual[rel]
+		if !ok {
+			t.Errorf("Expected %s after applying the layer", rel)
+			continue
+		}
+		if got.mode != state.mode || got.size != state.size || got.link != state.link {
+			t.Errorf("Expected %s to match, got mode %v size %d link %q instead of mode %v size %d link %q",
+				rel, got.mode, got.size, got.link, state.mode, state.size, state.link)
+		}
+	}
+
+	for rel := range actual {
+		if _, ok := expected[rel]; !ok {
+			t.Errorf("Expected %s to be removed by a whiteout", rel)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(lower, "etc/config"))
+	if err != nil || string(data) != "new" {
+		t.Errorf("Expected the changed contents of etc/config, got %q (%v)", data, err)
+	}
+}
+
+// TestInvalidWhiteouts tests that whiteouts of the directory they're in or of its parent are refused
+func TestInvalidWhiteouts(t *testing.T) {
+	for _, name := range []string{".wh.", ".wh..", ".wh...", "etc/.wh..."} {
+		parent := t.TempDir()
+		root := filepath.Join(parent, "rootfs")
+		writeFiles(t, parent, map[string]string{"sibling": "kept", "rootfs/etc/config": "kept"})
+
+		var layer bytes.Buffer
+		tw := tar.NewWriter(&layer)
+		must(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600}))
+		must(t, tw.Close())
+
+		if err := ApplyLayer(&layer, root, identity); err == nil {
+			t.Errorf("Expected the whiteout %s to be refused", name)
+		}
+
+		for _, file := range []string{"sibling", "rootfs/etc/config"} {
+			if _, err := os.Stat(filepath.Join(parent, file)); err != nil {
+				t.Errorf("Expected %s to survive the whiteout %s: %v", file, name, err)
+			}
+		}
+	}
+}
+
+// TestChanges tests listing the files added, changed and deleted since a snapshot
+func TestChanges(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"etc/config": "old", "etc/kept": "kept", "var/cache/a": "a"})
+
+	before, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	writeFiles(t, root, map[string]string{"etc/config": "new", "home/file": "added"})
+	must(t, os.RemoveAll(filepath.Join(root, "var/cache")))
+
+	changes, err := Changes(root, before)
+	if err != nil {
+		t.Fatalf("Failed to list changes: %v", err)
+	}
+
+	expected := []Change{
+		{ChangeModified, "/etc/config"},
+		{ChangeAdded, "/home"},
+		{ChangeAdded, "/home/file"},
+		{ChangeModified, "/var"},
+		{ChangeDeleted, "/var/cache"},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected change %v, got %v", expected[i], changes[i])
+		}
+	}
+}
+
+// TestResolveInRoot tests that paths can't escape the root through symlinks or dot dots
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+
+	must(t, os.MkdirAll(filepath.Join(root, "real"), 0755))
+	must(t, os.Symlink("/etc", filepath.Join(root, "abs")))
+	must(t, os.Symlink("../../..", filepath.Join(root, "real/up")))
+
+	tests := map[string]string{
+		"../../etc/passwd":  "etc/passwd",
+		"abs/passwd":        "etc/passwd",
+		"real/up/file":      "file",
+		"real/./dir/file":   "real/dir/file",
+		"/abs":              "abs",
+		"real/up/abs/inner": "etc/inner",
+	}
+
+	for name, expected := range tests {
+		resolved, err := ResolveInRoot(root, name)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", name, err)
+			continue
+		}
+
+		if resolved != filepath.Join(root, expected) {
+			t.Errorf("Expected %q to resolve to %q, got %q", name, filepath.Join(root, expected), resolved)
+		}
+	}
+}
+
+// writeFiles creates files with the given contents under the root directory.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		must(t, os.MkdirAll(filepath.Dir(path), 0755))
+		must(t, os.WriteFile(path, []byte(contents), 0644))
+	}
+}
+
+// must fails the test on errors.
+func must(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
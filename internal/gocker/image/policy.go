@@ -0,0 +1,308 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RelativePolicyPath is the relative path of the signature verification policy under the user's home directory.
+const RelativePolicyPath = ".config/gocker/policy.json"
+
+// Policy is a signature verification policy in the containers-policy.json format. The requirements
+// of the most specific scope of an image under the docker transport apply, or the default ones:
+//
+//	{
+//		"default": [{"type": "insecureAcceptAnything"}],
+//		"transports": {
+//			"docker": {
+//				"registry.internal/team": [{"type": "sigstoreSigned", "keyPath": "/etc/gocker/team.pub"}],
+//				"untrusted.example.com": [{"type": "reject"}]
+//			}
+//		}
+//	}
+type Policy struct {
+	Default    []PolicyRequirement                       `json:"default"`
+	Transports map[string]map[string][]PolicyRequirement `json:"transports,omitempty"`
+}
+
+// PolicyRequirement is a requirement an image has to satisfy to be pulled.
+type PolicyRequirement struct {
+	// Type is insecureAcceptAnything, reject or sigstoreSigned
+	Type string `json:"type"`
+	// KeyPath, KeyPaths or KeyData, the base64 encoded PEM, give the public keys of sigstoreSigned
+	// requirements, a signature by any of them satisfies it
+	KeyPath        string          `json:"keyPath,omitempty"`
+	KeyPaths       []string        `json:"keyPaths,omitempty"`
+	KeyData        string          `json:"keyData,omitempty"`
+	SignedIdentity *SignedIdentity `json:"signedIdentity,omitempty"`
+}
+
+// SignedIdentity restricts the image reference a signature has to be made for.
+type SignedIdentity struct {
+	// Type is matchRepoDigestOrExact, the default, matchExact, matchRepository,
+	// exactReference or exactRepository
+	Type             string `json:"type"`
+	DockerReference  string `json:"dockerReference,omitempty"`
+	DockerRepository string `json:"dockerRepository,omitempty"`
+}
+
+// LoadPolicy reads a signature verification policy.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %v", err)
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy %s: %v", path, err)
+	}
+
+	if len(policy.Default) == 0 {
+		return nil, fmt.Errorf("policy %s has no default requirements", path)
+	}
+
+	return policy, nil
+}
+
+// loadDefaultPolicy reads the policy in the user's configuration. Without one images aren't verified.
+func loadDefaultPolicy() (*Policy, error) {
+	path := filepath.Join(os.Getenv("HOME"), RelativePolicyPath)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return LoadPolicy(path)
+}
+
+// SetSignaturePolicy makes pulls verify images with the policy at the path instead of the user's one.
+func (c *Client) SetSignaturePolicy(path string) error {
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	c.policy = policy
+
+	return nil
+}
+
+// requirements returns the requirements of the most specific scope matching the image:
+// the reference itself, its repository, the parent namespaces of the repository, the
+// registry, wildcards of the registry's domains, and the transport default.
+func (p *Policy) requirements(host, repository, tag string) ([]PolicyRequirement, string) {
+	scopes := p.Transports["docker"]
+
+	name := host + "/" + repository
+	candidates := []string{name + ":" + tag, name}
+	if strings.HasPrefix(tag, "sha256:") {
+		candidates[0] = name + "@" + tag
+	}
+
+	for ns := repository; strings.Contains(ns, "/"); {
+		ns = ns[:strings.LastIndex(ns, "/")]
+		candidates = append(candidates, host+"/"+ns)
+	}
+	candidates = append(candidates, host)
+
+	hostname := host
+	if i := strings.LastIndex(hostname, ":"); i >= 0 {
+		hostname = hostname[:i]
+	}
+	for domain := hostname; strings.Contains(domain, "."); {
+		domain = domain[strings.Index(domain, ".")+1:]
+		candidates = append(candidates, "*."+domain)
+	}
+	candidates = append(candidates, "")
+
+	for _, scope := range candidates {
+		if reqs, ok := scopes[scope]; ok {
+			if scope == "" {
+				scope = "docker transport default"
+			}
+			return reqs, scope
+		}
+	}
+
+	return p.Default, "default"
+}
+
+// checkPolicy verifies the resolved manifest of the image against the signature policy.
+// All requirements of the scope of the image have to be satisfied.
+func (c *Client) checkPolicy() error {
+	if c.policy == nil {
+		return nil
+	}
+
+	reqs, scope := c.policy.requirements(c.registry, c.repository, c.imageTag)
+	if len(reqs) == 0 {
+		return fmt.Errorf("policy scope %q has no requirements", scope)
+	}
+
+	for _, req := range reqs {
+		switch req.Type {
+		case "insecureAcceptAnything":
+
+		case "reject":
+			return fmt.Errorf("policy rejects images of %s/%s (scope %q)", c.registry, c.repository, scope)
+
+		case "sigstoreSigned":
+			if err := c.checkSigstoreSigned(req); err != nil {
+				return fmt.Errorf("signature verification of %s failed: %v", c.digest, err)
+			}
+
+			fmt.Printf("Verified signature of %s\n", c.digest)
+
+		default:
+			return fmt.Errorf("unsupported policy requirement type %q", req.Type)
+		}
+	}
+
+	return nil
+}
+
+// checkSigstoreSigned checks that the manifest is signed by one of the keys of the requirement.
+func (c *Client) checkSigstoreSigned(req PolicyRequirement) error {
+	var keys [][]byte
+	for _, path := range append(req.KeyPaths, req.KeyPath) {
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %v", err)
+		}
+		keys = append(keys, data)
+	}
+
+	if req.KeyData != "" {
+		data, err := base64.StdEncoding.DecodeString(req.KeyData)
+		if err != nil {
+			return fmt.Errorf("failed to decode keyData: %v", err)
+		}
+		keys = append(keys, data)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("sigstoreSigned requirement has no public key")
+	}
+
+	match, err := c.identityMatcher(req.SignedIdentity)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, data := range keys {
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse public key: %v", err)
+		}
+
+		err = c.verifySignatures(c.digest, key, match)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// identityMatcher returns the check of the identity signed for against the image reference.
+func (c *Client) identityMatcher(identity *SignedIdentity) (func(string) error, error) {
+	name := c.registry + "/" + c.repository
+
+	// The tag of by-digest references is the digest, signatures name only the repository for them
+	ref := name + ":" + c.imageTag
+	if strings.HasPrefix(c.imageTag, "sha256:") {
+		ref = name + "@" + c.imageTag
+	}
+
+	typ := "matchRepoDigestOrExact"
+	if identity != nil {
+		typ = identity.Type
+	}
+
+	switch typ {
+	case "matchRepoDigestOrExact":
+		return func(signed string) error {
+			if strings.HasPrefix(c.imageTag, "sha256:") {
+				return matchRepository(signed, name)
+			}
+			return matchExact(signed, ref)
+		}, nil
+
+	case "matchExact":
+		return func(signed string) error { return matchExact(signed, ref) }, nil
+
+	case "matchRepository":
+		return func(signed string) error { return matchRepository(signed, name) }, nil
+
+	case "exactReference":
+		expected, err := normalizeIdentity(identity.DockerReference)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dockerReference in policy: %v", err)
+		}
+		return func(signed string) error { return matchExact(signed, expected) }, nil
+
+	case "exactRepository":
+		expected, err := normalizeIdentity(identity.DockerRepository)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dockerRepository in policy: %v", err)
+		}
+		return func(signed string) error { return matchRepository(signed, expected) }, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signedIdentity type %q", typ)
+}
+
+// normalizeIdentity returns a reference with its registry and full repository, and the tag or digest only if given.
+func normalizeIdentity(s string) (string, error) {
+	ref, err := ParseReference(s)
+	if err != nil {
+		return "", err
+	}
+
+	name := ref.Registry + "/" + ref.Repository
+	switch {
+	case ref.Digest != "":
+		return name + "@" + ref.Digest, nil
+	case strings.LastIndex(s, ":") > strings.LastIndex(s, "/"):
+		return name + ":" + ref.Tag, nil
+	}
+
+	return name, nil
+}
+
+// matchExact checks that the signed identity is the reference, tag or digest included.
+func matchExact(signed, ref string) error {
+	normalized, err := normalizeIdentity(signed)
+	if err != nil || normalized != ref {
+		return fmt.Errorf("signature is for %q, not %q", signed, ref)
+	}
+
+	return nil
+}
+
+// matchRepository checks that the signed identity is in the repository.
+func matchRepository(signed, name string) error {
+	normalized, err := normalizeIdentity(signed)
+	if err == nil {
+		normalized, _, _ = strings.Cut(normalized, "@")
+		if i := strings.LastIndex(normalized, ":"); i > strings.LastIndex(normalized, "/") {
+			normalized = normalized[:i]
+		}
+	}
+
+	if err != nil || normalized != name {
+		return fmt.Errorf("signature is for %q, not repository %q", signed, name)
+	}
+
+	return nil
+}
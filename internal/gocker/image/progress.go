@@ -0,0 +1,165 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressBarWidth is the number of cells of a progress bar.
+	progressBarWidth = 40
+	// redrawInterval limits how often transferred bytes redraw the progress bars.
+	redrawInterval = 100 * time.Millisecond
+)
+
+// progress reports the state of concurrent transfers. On a terminal every transfer has a line
+// that is redrawn in place, otherwise status changes are logged one line each.
+type progress struct {
+	mu  sync.Mutex
+	out io.Writer
+	tty bool
+
+	bars     []*progressBar
+	drawn    int
+	lastDraw time.Time
+}
+
+// progressBar is the line of one transfer. A nil bar reports nothing.
+type progressBar struct {
+	p      *progress
+	id     string
+	status string
+
+	current int64
+	total   int64
+	// resumed is the number of bytes that were already there when the transfer started
+	resumed int64
+	started time.Time
+}
+
+// newProgress returns a progress display writing to out, drawing bars when it's a terminal.
+func newProgress(out *os.File) *progress {
+	fi, err := out.Stat()
+
+	return &progress{out: out, tty: err == nil && fi.Mode()&os.ModeCharDevice != 0}
+}
+
+// add adds the line of a transfer of total bytes.
+func (p *progress) add(id string, total int64, status string) *progressBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b := &progressBar{p: p, id: id, total: total}
+	p.bars = append(p.bars, b)
+	b.setStatusLocked(status)
+
+	return b
+}
+
+// setStatus changes the status shown for the transfer.
+func (b *progressBar) setStatus(format string, args ...any) {
+	if b == nil {
+		return
+	}
+
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+
+	b.setStatusLocked(fmt.Sprintf(format, args...))
+}
+
+func (b *progressBar) setStatusLocked(status string) {
+	b.status = status
+
+	if b.p.tty {
+		b.p.draw()
+	} else {
+		fmt.Fprintf(b.p.out, "%s: %s\n", b.id, status)
+	}
+}
+
+// start marks the start of a transfer resuming after offset bytes.
+func (b *progressBar) start(offset int64) {
+	if b == nil {
+		return
+	}
+
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+
+	b.current, b.resumed, b.started = offset, offset, time.Now()
+	if offset > 0 {
+		b.setStatusLocked(fmt.Sprintf("Resuming download at %s", humanBytes(offset)))
+	} else {
+		b.setStatusLocked("Downloading")
+	}
+}
+
+// Write counts the transferred bytes.
+func (b *progressBar) Write(data []byte) (int, error) {
+	if b == nil {
+		return len(data), nil
+	}
+
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+
+	b.current += int64(len(data))
+	if b.p.tty && time.Since(b.p.lastDraw) >= redrawInterval {
+		b.p.draw()
+	}
+
+	return len(data), nil
+}
+
+// draw redraws all lines over the ones drawn before.
+func (p *progress) draw() {
+	var sb strings.Builder
+
+	if p.drawn > 0 {
+		fmt.Fprintf(&sb, "\x1b[%dA", p.drawn)
+	}
+	for _, b := range p.bars {
+		fmt.Fprintf(&sb, "\x1b[2K%s\n", b)
+	}
+
+	io.WriteString(p.out, sb.String())
+	p.drawn, p.lastDraw = len(p.bars), time.Now()
+}
+
+// String renders the line of the transfer, with a bar, the transferred bytes and the rate while downloading.
+func (b *progressBar) String() string {
+	if b.status != "Downloading" || b.total <= 0 {
+		return b.id + ": " + b.status
+	}
+
+	filled := int(min(b.current, b.total) * progressBarWidth / b.total)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	rate := ""
+	if elapsed := time.Since(b.started).Seconds(); elapsed > 0 {
+		rate = fmt.Sprintf("  %s/s", humanBytes(int64(float64(b.current-b.resumed)/elapsed)))
+	}
+
+	return fmt.Sprintf("%s: Downloading [%s]  %s/%s%s", b.id, bar, humanBytes(b.current), humanBytes(b.total), rate)
+}
+
+// humanBytes formats a size in bytes with decimal units.
+func humanBytes(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+
+	value, unit := float64(size), 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	return fmt.Sprintf("%.4g%s", value, units[unit])
+}
@@ -0,0 +1,275 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// DefaultChunkSize is the size of the chunks blobs larger than it are uploaded in.
+const DefaultChunkSize = 16 << 20
+
+// Push uploads the blobs of the local image the registry doesn't have yet, followed by its manifest.
+// Blobs known to be in another repository of the registry are mounted from there.
+func (c *Client) Push() error {
+	if c.imageTag != "" && ValidDigest(c.imageTag) {
+		return fmt.Errorf("images can only be pushed by tag")
+	}
+
+	manifest, data, err := loadManifestFile(c.imagePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The push refers to repository [%s/%s]\n", c.registry, c.repository)
+
+	blobs := append([]registry.Descriptor{manifest.Config}, manifest.Layers...)
+
+	// Mounting needs pull access to the repositories the blobs are mounted from
+	var scopes []string
+	for _, blob := range blobs {
+		if !HasBlob(blob.Digest) {
+			return fmt.Errorf("blob %s of the image is missing, pull or build the image again", ShortDigest(blob.Digest))
+		}
+
+		for _, repo := range blobSources(c.registry, blob.Digest) {
+			if scope := "repository:" + repo + ":pull"; repo != c.repository && !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	if err := c.authenticate("pull,push", scopes...); err != nil {
+		return err
+	}
+
+	// The config goes last, so layers are in place when the registry sees the image
+	for _, blob := range append(slices.Clone(manifest.Layers), manifest.Config) {
+		status, err := c.pushBlob(blob)
+		if err != nil {
+			return fmt.Errorf("failed to push blob %s: %v", ShortDigest(blob.Digest), err)
+		}
+
+		fmt.Printf("%s: %s\n", ShortDigest(blob.Digest), status)
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = registry.MediaTypeOCIManifest
+	}
+
+	resp, err := c.httpClient.Send(context.Background(), http.MethodPut, c.url("manifests", c.imageTag),
+		bytes.NewReader(data), c.headers("Content-Type", mediaType))
+	if err != nil {
+		return fmt.Errorf("failed to push manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusCreated {
+		return fmt.Errorf("failed to push manifest: %v", registryError(resp))
+	}
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	fmt.Printf("%s: digest: %s size: %d\n", c.imageTag, digest, len(data))
+
+	digests := []string{digest}
+	for _, blob := range blobs {
+		digests = append(digests, blob.Digest)
+	}
+
+	return recordSources(c.registry, c.repository, digests...)
+}
+
+// pushBlob makes sure the registry has the blob and returns how it got there.
+func (c *Client) pushBlob(blob registry.Descriptor) (string, error) {
+	exists, err := c.blobExists(blob.Digest)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "Layer already exists", nil
+	}
+
+	var location string
+	for _, repo := range blobSources(c.registry, blob.Digest) {
+		if repo == c.repository {
+			continue
+		}
+
+		query := url.Values{"mount": {blob.Digest}, "from": {repo}}
+		mounted, loc, err := c.startUpload("?" + query.Encode())
+		if err != nil {
+			return "", err
+		}
+		if mounted {
+			return "Mounted from " + repo, nil
+		}
+
+		// Registries that can't mount start a regular upload instead
+		location = loc
+		break
+	}
+
+	if location == "" {
+		if _, location, err = c.startUpload(""); err != nil {
+			return "", err
+		}
+	}
+
+	if err := c.upload(location, blob); err != nil {
+		return "", err
+	}
+
+	return "Pushed", nil
+}
+
+// blobExists checks whether the repository has the blob.
+func (c *Client) blobExists(digest string) (bool, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodHead, c.url("blobs", digest), nil, c.headers())
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case nethttp.StatusOK:
+		return true, nil
+	case nethttp.StatusNotFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unexpected status checking blob: %d", resp.StatusCode)
+}
+
+// startUpload starts an upload session, or mounts a blob with the mount query. It returns
+// whether the blob was mounted and otherwise the location to upload the blob to.
+func (c *Client) startUpload(query string) (bool, string, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodPost, c.url("blobs", "uploads/")+query,
+		nil, c.headers("Content-Length", "0"))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case nethttp.StatusCreated:
+		return true, "", nil
+	case nethttp.StatusAccepted:
+		location, err := uploadLocation(resp)
+		return false, location, err
+	}
+
+	return false, "", fmt.Errorf("failed to start upload: %v", registryError(resp))
+}
+
+// upload sends the blob to an upload location. Blobs up to the chunk size are sent in a
+// single PUT request, larger ones in PATCH requests of a chunk each followed by a closing PUT.
+func (c *Client) upload(location string, blob registry.Descriptor) error {
+	f, err := OpenBlob(blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	chunkSize := c.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	var body io.Reader = f
+	if size > chunkSize {
+		for offset := int64(0); offset < size; {
+			n := min(chunkSize, size-offset)
+
+			resp, err := c.httpClient.Send(context.Background(), http.MethodPatch, location,
+				io.NewSectionReader(f, offset, n), c.headers(
+					"Content-Type", "application/octet-stream",
+					"Content-Range", fmt.Sprintf("%d-%d", offset, offset+n-1),
+					"Content-Length", strconv.FormatInt(n, 10),
+				))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != nethttp.StatusAccepted {
+				return fmt.Errorf("failed to upload chunk: %v", registryError(resp))
+			}
+
+			if location, err = uploadLocation(resp); err != nil {
+				return err
+			}
+			offset += n
+		}
+
+		body, size = nil, 0
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid upload location %q: %v", location, err)
+	}
+	query := u.Query()
+	query.Set("digest", blob.Digest)
+	u.RawQuery = query.Encode()
+
+	resp, err := c.httpClient.Send(context.Background(), http.MethodPut, u.String(), body, c.headers(
+		"Content-Type", "application/octet-stream",
+		"Content-Length", strconv.FormatInt(size, 10),
+	))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusCreated {
+		return fmt.Errorf("failed to complete upload: %v", registryError(resp))
+	}
+
+	return nil
+}
+
+// uploadLocation returns the absolute upload location a registry response points to.
+func uploadLocation(resp *nethttp.Response) (string, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("registry sent no upload location: %v", err)
+	}
+
+	return loc.String(), nil
+}
+
+// registryError describes a failed registry request with the errors the registry reported.
+func registryError(resp *nethttp.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("%s: %s (status %d)", body.Errors[0].Code, body.Errors[0].Message, resp.StatusCode)
+	}
+
+	return fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
@@ -0,0 +1,296 @@
+package image
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+const testToken = "test-token"
+
+// testRegistry is a minimal in-memory distribution registry requiring bearer tokens.
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte          // contents by digest
+	links     map[string]map[string]bool // digests by repository
+	manifests map[string][]byte          // contents by repository:reference
+	types     map[string]string          // media types by repository:reference
+	uploads   map[string]*bytes.Buffer
+	requests  []string
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		blobs:     make(map[string][]byte),
+		links:     make(map[string]map[string]bool),
+		manifests: make(map[string][]byte),
+		types:     make(map[string]string),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+}
+
+func (r *testRegistry) ServeHTTP(w nethttp.ResponseWriter, req *nethttp.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req.Method+" "+req.URL.RequestURI())
+
+	if req.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(registry.AuthResponse{Token: testToken})
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s://%s/token",service="test"`, scheme, req.Host))
+		w.WriteHeader(nethttp.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+
+	if i := strings.LastIndex(path, "/blobs/uploads/"); i >= 0 {
+		r.serveUpload(w, req, path[:i], path[i+len("/blobs/uploads/"):])
+		return
+	}
+
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		repo, digest := path[:i], path[i+len("/blobs/"):]
+		if !r.links[repo][digest] {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		w.Write(r.blobs[digest])
+		return
+	}
+
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		key := path[:i] + ":" + path[i+len("/manifests/"):]
+
+		if req.Method == nethttp.MethodPut {
+			data, _ := io.ReadAll(req.Body)
+			r.manifests[key], r.types[key] = data, req.Header.Get("Content-Type")
+
+			// Manifests are also served by their digest
+			byDigest := path[:i] + ":" + digestOf(data)
+			r.manifests[byDigest], r.types[byDigest] = data, req.Header.Get("Content-Type")
+			w.WriteHeader(nethttp.StatusCreated)
+			return
+		}
+
+		data, ok := r.manifests[key]
+		if !ok {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[key])
+		w.Write(data)
+		return
+	}
+
+	w.WriteHeader(nethttp.StatusNotFound)
+}
+
+func (r *testRegistry) serveUpload(w nethttp.ResponseWriter, req *nethttp.Request, repo, id string) {
+	switch req.Method {
+	case nethttp.MethodPost:
+		if digest, from := req.URL.Query().Get("mount"), req.URL.Query().Get("from"); digest != "" && r.links[from][digest] {
+			r.link(repo, digest)
+			w.WriteHeader(nethttp.StatusCreated)
+			return
+		}
+
+		id := fmt.Sprintf("upload-%d", len(r.uploads))
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.WriteHeader(nethttp.StatusAccepted)
+
+	case nethttp.MethodPatch, nethttp.MethodPut:
+		buf, ok := r.uploads[id]
+		if !ok {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		io.Copy(buf, req.Body)
+
+		if req.Method == nethttp.MethodPatch {
+			w.Header().Set("Location", req.URL.Path)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", buf.Len()-1))
+			w.WriteHeader(nethttp.StatusAccepted)
+			return
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		if digest != req.URL.Query().Get("digest") {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`)
+			return
+		}
+
+		r.blobs[digest] = buf.Bytes()
+		r.link(repo, digest)
+		delete(r.uploads, id)
+		w.WriteHeader(nethttp.StatusCreated)
+	}
+}
+
+func (r *testRegistry) link(repo, digest string) {
+	if r.links[repo] == nil {
+		r.links[repo] = make(map[string]bool)
+	}
+	r.links[repo][digest] = true
+}
+
+// countRequests counts the requests since the given index that start with the prefix.
+func (r *testRegistry) countRequests(since int, prefix string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, req := range r.requests[since:] {
+		if strings.HasPrefix(req, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// TestPush tests pushing an image to an in-process registry and pulling it back
+func TestPush(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	reg := newTestRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	name := host + "/team/app:v1"
+
+	rootfs, err := TempDir("rootfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Random data doesn't compress, so the second layer is uploaded in chunks
+	random := make([]byte, 200<<10)
+	rand.Read(random)
+
+	var layers []registry.Descriptor
+	cfg := &registry.ImageConfig{Architecture: "amd64", Os: "linux"}
+
+	for _, files := range []map[string]string{{"etc/hostname": "app"}, {"data/random": string(random)}} {
+		before, err := TakeSnapshot(rootfs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, rootfs, files)
+
+		layer, diffID, err := WriteLayer(rootfs, before, identity)
+		if err != nil {
+			t.Fatalf("Failed to write layer: %v", err)
+		}
+		layers = append(layers, layer)
+		cfg.Rootfs.DiffIds = append(cfg.Rootfs.DiffIds, diffID)
+	}
+
+	if _, err := Save(name, rootfs, cfg, layers); err != nil {
+		t.Fatalf("Failed to save image: %v", err)
+	}
+
+	push := func(name string) {
+		t.Helper()
+
+		c, err := NewClient(name, http.NewHttpClient())
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		c.chunkSize = 64 << 10
+
+		if err := c.Push(); err != nil {
+			t.Fatalf("Failed to push %s: %v", name, err)
+		}
+	}
+
+	push(name)
+
+	_, manifest, err := LoadManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reg.manifests["team/app:v1"], manifest) {
+		t.Errorf("Expected the registry to hold the local manifest")
+	}
+	for _, layer := range layers {
+		if !reg.links["team/app"][layer.Digest] {
+			t.Errorf("Expected the registry to hold layer %s", layer.Digest)
+		}
+	}
+	if reg.countRequests(0, "PATCH") < 2 {
+		t.Errorf("Expected the large layer to be uploaded in chunks, got requests %v", reg.requests)
+	}
+
+	// Pushing again only checks that the blobs exist
+	since := len(reg.requests)
+	push(name)
+	if n := reg.countRequests(since, "POST"); n != 0 {
+		t.Errorf("Expected no uploads for blobs the registry has, got %d", n)
+	}
+
+	// Another repository mounts the blobs of the first one
+	other := host + "/other/app:v1"
+	empty, err := TempDir("rootfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Save(other, empty, cfg, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	since = len(reg.requests)
+	push(other)
+	if n := reg.countRequests(since, "POST /v2/other/app/blobs/uploads/?from=team"); n != 3 {
+		t.Errorf("Expected the config and layers to be mounted, got %d mounts in %v", n, reg.requests[since:])
+	}
+	if n := reg.countRequests(since, "PUT /v2/other/app/blobs"); n != 0 {
+		t.Errorf("Expected no uploads for mounted blobs, got %d", n)
+	}
+
+	// The pushed image can be pulled back
+	pulled := host + "/team/app:v1"
+	os.RemoveAll(Path(pulled))
+
+	c, err := NewClient(pulled, http.NewHttpClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pull(); err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(Path(pulled), "rootfs", "data", "random"))
+	if err != nil || !bytes.Equal(data, random) {
+		t.Errorf("Expected the pulled image to contain the pushed files (%v)", err)
+	}
+}
@@ -0,0 +1,110 @@
+package image
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is the registry of references without a registry host.
+	DefaultRegistry = "docker.io"
+
+	defaultTag = "latest"
+)
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// Reference is a parsed image reference in the [registry/]repository[:tag][@digest] format.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference. References without a registry host
+// point to Docker Hub, where single component repositories live under library/.
+func ParseReference(s string) (Reference, error) {
+	ref := Reference{Registry: DefaultRegistry, Tag: defaultTag}
+	name := s
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !strings.HasPrefix(ref.Digest, "sha256:") || len(ref.Digest) != len("sha256:")+64 {
+			return Reference{}, fmt.Errorf("invalid digest in reference %q", s)
+		}
+	}
+
+	// A colon after the last slash starts the tag, any other one belongs to the registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid tag in reference %q", s)
+		}
+	}
+
+	if host, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		ref.Registry, name = host, rest
+	}
+
+	if ref.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	if !repositoryPattern.MatchString(name) {
+		return Reference{}, fmt.Errorf("invalid reference format: %q", s)
+	}
+	ref.Repository = name
+
+	return ref, nil
+}
+
+// ValidRepository reports whether the name is a well-formed repository name without a registry.
+func ValidRepository(name string) bool {
+	return repositoryPattern.MatchString(name)
+}
+
+// ValidTag reports whether the tag is a well-formed image tag.
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// Name returns the repository with its registry in the shortest form that refers to it.
+func (r Reference) Name() string {
+	if r.Registry != DefaultRegistry {
+		return r.Registry + "/" + r.Repository
+	}
+
+	return strings.TrimPrefix(r.Repository, "library/")
+}
+
+// String returns the shortest form of the reference, leaving out the default tag.
+func (r Reference) String() string {
+	s := r.Name()
+
+	if r.Tag != defaultTag {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+
+	return s
+}
+
+// Path returns the directory of the image with the given reference in the local image store.
+// Unparsable references are used as they are, so they simply don't match any image.
+func Path(name string) string {
+	if ref, err := ParseReference(name); err == nil {
+		name = ref.String()
+	}
+
+	return filepath.Join(os.Getenv("HOME"), RelativeImagesPath, url.PathEscape(name))
+}
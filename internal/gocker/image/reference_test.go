@@ -0,0 +1,69 @@
+package image
+
+import "testing"
+
+// TestParseReference tests parsing image references and their shortest forms
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		input         string
+		expected      Reference
+		expectedName  string
+		expectedError bool
+	}{
+		{
+			input:        "alpine",
+			expected:     Reference{Registry: DefaultRegistry, Repository: "library/alpine", Tag: "latest"},
+			expectedName: "alpine",
+		},
+		{
+			input:        "docker.io/library/alpine:3.20",
+			expected:     Reference{Registry: DefaultRegistry, Repository: "library/alpine", Tag: "3.20"},
+			expectedName: "alpine:3.20",
+		},
+		{
+			input:        "user/app:v1",
+			expected:     Reference{Registry: DefaultRegistry, Repository: "user/app", Tag: "v1"},
+			expectedName: "user/app:v1",
+		},
+		{
+			input:        "localhost:5000/app",
+			expected:     Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"},
+			expectedName: "localhost:5000/app",
+		},
+		{
+			input:        "alpine@" + digest,
+			expected:     Reference{Registry: DefaultRegistry, Repository: "library/alpine", Tag: "latest", Digest: digest},
+			expectedName: "alpine@" + digest,
+		},
+		{input: "Alpine", expectedError: true},
+		{input: "alpine:", expectedError: true},
+		{input: "alpine@sha256:123", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ref, err := ParseReference(tt.input)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %+v", tt.input, ref)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if ref != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, ref)
+			}
+
+			if ref.String() != tt.expectedName {
+				t.Errorf("Expected %q, got %q", tt.expectedName, ref.String())
+			}
+		})
+	}
+}
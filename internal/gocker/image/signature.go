@@ -0,0 +1,493 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+const (
+	// annotationSignature holds the base64 signature of a simple signing payload in cosign signatures.
+	annotationSignature = "dev.cosignproject.cosign/signature"
+
+	// signatureType is the type of the critical section of cosign signature payloads.
+	signatureType = "cosign container image signature"
+
+	// maxSignaturePayloadSize limits the size of downloaded signatures and their payloads.
+	maxSignaturePayloadSize = 1 << 20
+)
+
+// simpleSigning is the payload cosign signs, binding a manifest digest to a repository.
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS#8, EC or PKCS#1 private key to sign images with.
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q, encrypted keys have to be exported unencrypted", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key in %s", path)
+	}
+
+	return signer, nil
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// GenerateKeyPair writes a new ECDSA P-256 key pair, like cosign generates, to PEM files.
+// The private key is written unencrypted and only readable by its owner.
+func GenerateKeyPair(privatePath, publicPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %v", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	f, err := os.OpenFile(privatePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create private key file: %v", err)
+	}
+	defer f.Close()
+
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: private}); err != nil {
+		return fmt.Errorf("failed to write private key: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write private key: %v", err)
+	}
+
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %v", err)
+	}
+
+	return nil
+}
+
+// sign signs a payload the way cosign does: the SHA-256 digest of the payload for ECDSA and RSA keys,
+// the payload itself for Ed25519 keys.
+func sign(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(payload)
+
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verify checks a signature made by sign.
+func verify(key crypto.PublicKey, payload, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	}
+
+	return false
+}
+
+// Sign signs the manifest the reference of the client resolves to in its registry and pushes the
+// signature as a cosign signature artifact referring to it. It returns the digest of the signed manifest.
+func (c *Client) Sign(key crypto.Signer) (string, error) {
+	if err := c.authenticate("pull,push"); err != nil {
+		return "", err
+	}
+
+	subject, err := c.manifestDescriptor(c.imageTag)
+	if err != nil {
+		return "", err
+	}
+
+	// Signatures of tags name the tag, those of digests only the repository
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = c.registry + "/" + c.repository
+	if !strings.HasPrefix(c.imageTag, "sha256:") {
+		payload.Critical.Identity.DockerReference += ":" + c.imageTag
+	}
+	payload.Critical.Image.DockerManifestDigest = subject.Digest
+	payload.Critical.Type = signatureType
+
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal signature payload: %v", err)
+	}
+
+	signature, err := sign(key, payloadData)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s: %v", subject.Digest, err)
+	}
+
+	empty := []byte("{}")
+	config := registry.Descriptor{MediaType: registry.MediaTypeOCIEmpty, Size: int64(len(empty)), Digest: digestOf(empty)}
+	layer := registry.Descriptor{
+		MediaType:   registry.MediaTypeSimpleSigning,
+		Size:        int64(len(payloadData)),
+		Digest:      digestOf(payloadData),
+		Annotations: map[string]string{annotationSignature: base64.StdEncoding.EncodeToString(signature)},
+	}
+
+	for _, blob := range []struct {
+		desc registry.Descriptor
+		data []byte
+	}{{config, empty}, {layer, payloadData}} {
+		if _, _, err := WriteBlob(bytes.NewReader(blob.data)); err != nil {
+			return "", err
+		}
+		if _, err := c.pushBlob(blob.desc); err != nil {
+			return "", fmt.Errorf("failed to push blob %s: %v", ShortDigest(blob.desc.Digest), err)
+		}
+	}
+
+	manifest := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		ArtifactType:  registry.ArtifactTypeCosignSignature,
+		Config:        config,
+		Layers:        []registry.Descriptor{layer},
+		Subject:       &subject,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal signature manifest: %v", err)
+	}
+	digest := digestOf(data)
+
+	resp, err := c.putManifest(digest, registry.MediaTypeOCIManifest, data)
+	if err != nil {
+		return "", err
+	}
+
+	// Registries without the referrers API list the referrers of a subject in an index under a tag instead
+	if resp.Header.Get("OCI-Subject") == "" {
+		referrer := registry.Descriptor{
+			MediaType:    registry.MediaTypeOCIManifest,
+			Size:         int64(len(data)),
+			Digest:       digest,
+			ArtifactType: registry.ArtifactTypeCosignSignature,
+		}
+		if err := c.addReferrerToTag(subject.Digest, referrer); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Printf("Pushed signature %s of %s\n", ShortDigest(digest), subject.Digest)
+
+	return subject.Digest, nil
+}
+
+// manifestDescriptor returns the descriptor of the manifest a tag or digest refers to.
+func (c *Client) manifestDescriptor(ref string) (registry.Descriptor, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodGet, c.url("manifests", ref), nil, c.headers("Accept", manifestAccept))
+	if err != nil {
+		return registry.Descriptor{}, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusOK {
+		return registry.Descriptor{}, fmt.Errorf("manifest %s not found in %s: %s", ref, c.repository, registryError(resp))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignaturePayloadSize))
+	if err != nil {
+		return registry.Descriptor{}, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	return registry.Descriptor{MediaType: resp.Header.Get("Content-Type"), Size: int64(len(data)), Digest: digestOf(data)}, nil
+}
+
+// putManifest uploads a manifest under a tag or its digest.
+func (c *Client) putManifest(ref, mediaType string, data []byte) (*nethttp.Response, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodPut, c.url("manifests", ref), bytes.NewReader(data),
+		c.headers("Content-Type", mediaType, "Content-Length", fmt.Sprint(len(data))))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusCreated {
+		return nil, fmt.Errorf("failed to upload manifest: %s", registryError(resp))
+	}
+
+	return resp, nil
+}
+
+// referrersTag returns the tag of the fallback index listing the referrers of a subject.
+func referrersTag(subject string) string {
+	return strings.Replace(subject, ":", "-", 1)
+}
+
+// addReferrerToTag adds a referrer to the index under the referrers tag of the subject.
+func (c *Client) addReferrerToTag(subject string, referrer registry.Descriptor) error {
+	index, err := c.referrersFromTag(subject)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range index {
+		if m.Digest == referrer.Digest {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(registry.ReferrersIndex{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIIndex,
+		Manifests:     append(index, referrer),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal referrers index: %v", err)
+	}
+
+	_, err = c.putManifest(referrersTag(subject), registry.MediaTypeOCIIndex, data)
+
+	return err
+}
+
+// referrers lists the manifests of an artifact type that refer to the subject, with the referrers
+// API or, for registries without it, from the index under the referrers tag of the subject.
+func (c *Client) referrers(subject, artifactType string) ([]registry.Descriptor, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodGet,
+		c.url("referrers", subject)+"?artifactType="+url.QueryEscape(artifactType), nil, c.headers("Accept", registry.MediaTypeOCIIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var manifests []registry.Descriptor
+	switch resp.StatusCode {
+	case nethttp.StatusOK:
+		var index registry.ReferrersIndex
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxSignaturePayloadSize)).Decode(&index); err != nil {
+			return nil, fmt.Errorf("failed to decode referrers: %v", err)
+		}
+		manifests = index.Manifests
+
+	case nethttp.StatusNotFound:
+		if manifests, err = c.referrersFromTag(subject); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("failed to list referrers: %s", registryError(resp))
+	}
+
+	// Registries may ignore the filter
+	var filtered []registry.Descriptor
+	for _, m := range manifests {
+		if m.ArtifactType == artifactType {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered, nil
+}
+
+// referrersFromTag returns the referrers listed in the index under the referrers tag of the subject.
+func (c *Client) referrersFromTag(subject string) ([]registry.Descriptor, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodGet, c.url("manifests", referrersTag(subject)), nil,
+		c.headers("Accept", registry.MediaTypeOCIIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == nethttp.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != nethttp.StatusOK {
+		return nil, fmt.Errorf("failed to list referrers: %s", registryError(resp))
+	}
+
+	var index registry.ReferrersIndex
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSignaturePayloadSize)).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode referrers index: %v", err)
+	}
+
+	return index.Manifests, nil
+}
+
+// fetchVerified downloads a manifest or blob of the repository and checks it against its digest.
+func (c *Client) fetchVerified(kind, digest, accept string) ([]byte, error) {
+	resp, err := c.httpClient.Send(context.Background(), http.MethodGet, c.url(kind, digest), nil, c.headers("Accept", accept))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != nethttp.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, digest)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignaturePayloadSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if actual := digestOf(data); actual != digest {
+		return nil, fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+
+	return data, nil
+}
+
+// verifySignatures checks that a signature referring to the manifest digest is made by the key
+// over a payload for that digest, and that the signed repository is accepted by matchIdentity.
+func (c *Client) verifySignatures(digest string, key crypto.PublicKey, matchIdentity func(string) error) error {
+	signatures, err := c.referrers(digest, registry.ArtifactTypeCosignSignature)
+	if err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return fmt.Errorf("no signatures found")
+	}
+
+	var errs []string
+	for _, desc := range signatures {
+		if err := c.verifySignature(desc.Digest, digest, key, matchIdentity); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", ShortDigest(desc.Digest), err))
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no valid signature found (%s)", strings.Join(errs, "; "))
+}
+
+// verifySignature checks one signature manifest, any of its signed payloads may match.
+func (c *Client) verifySignature(signatureDigest, digest string, key crypto.PublicKey, matchIdentity func(string) error) error {
+	data, err := c.fetchVerified("manifests", signatureDigest, registry.MediaTypeOCIManifest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature: %v", err)
+	}
+
+	var manifest registry.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	err = fmt.Errorf("no signed payload")
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != registry.MediaTypeSimpleSigning {
+			continue
+		}
+
+		signature, decodeErr := base64.StdEncoding.DecodeString(layer.Annotations[annotationSignature])
+		if decodeErr != nil || len(signature) == 0 {
+			err = fmt.Errorf("payload has no signature")
+			continue
+		}
+
+		payloadData, fetchErr := c.fetchVerified("blobs", layer.Digest, "")
+		if fetchErr != nil {
+			err = fmt.Errorf("failed to fetch payload: %v", fetchErr)
+			continue
+		}
+
+		if !verify(key, payloadData, signature) {
+			err = fmt.Errorf("signature doesn't match the key")
+			continue
+		}
+
+		var payload simpleSigning
+		if err = json.Unmarshal(payloadData, &payload); err != nil {
+			err = fmt.Errorf("failed to decode payload: %v", err)
+			continue
+		}
+
+		switch {
+		case payload.Critical.Type != signatureType:
+			err = fmt.Errorf("unexpected payload type %q", payload.Critical.Type)
+		case payload.Critical.Image.DockerManifestDigest != digest:
+			err = fmt.Errorf("payload signs %s", payload.Critical.Image.DockerManifestDigest)
+		default:
+			if err = matchIdentity(payload.Critical.Identity.DockerReference); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// digestOf returns the sha256 digest of the data.
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	"github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// TestSignaturePolicy tests signing images and pulling them under verification policies
+func TestSignaturePolicy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// The test registry has no referrers API, so signatures are listed under the referrers tag
+	reg := newTestRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	keys := t.TempDir()
+
+	for _, name := range []string{"team", "other"} {
+		must(t, GenerateKeyPair(filepath.Join(keys, name+".key"), filepath.Join(keys, name+".pub")))
+	}
+	if err := GenerateKeyPair(filepath.Join(keys, "team.key"), filepath.Join(keys, "team.pub")); err == nil {
+		t.Errorf("Expected existing keys not to be overwritten")
+	}
+
+	for _, name := range []string{"team/app:v1", "team/app:v2", "team/unsigned:v1"} {
+		rootfs, err := TempDir("rootfs-")
+		must(t, err)
+		before, err := TakeSnapshot(rootfs)
+		must(t, err)
+		writeFiles(t, rootfs, map[string]string{"etc/motd": name})
+		layer, diffID, err := WriteLayer(rootfs, before, identity)
+		must(t, err)
+		cfg := &registry.ImageConfig{Os: "linux"}
+		cfg.Rootfs.DiffIds = []string{diffID}
+		_, err = Save(host+"/"+name, rootfs, cfg, []registry.Descriptor{layer})
+		must(t, err)
+
+		c, err := NewClient(host+"/"+name, http.NewHttpClient())
+		must(t, err)
+		must(t, c.Push())
+	}
+
+	key, err := LoadPrivateKey(filepath.Join(keys, "team.key"))
+	must(t, err)
+
+	var digest string
+	for _, tag := range []string{"v1", "v2"} {
+		c, err := NewClient(host+"/team/app:"+tag, http.NewHttpClient())
+		must(t, err)
+		if digest, err = c.Sign(key); err != nil {
+			t.Fatalf("Failed to sign %s: %v", tag, err)
+		}
+	}
+
+	// Signatures are listed in the index under the referrers tag of the manifest they sign
+	index := reg.manifests["team/app:"+referrersTag(digest)]
+	if n := strings.Count(string(index), registry.ArtifactTypeCosignSignature); n != 1 {
+		t.Errorf("Expected 1 signature of v2 in the referrers index, got %d", n)
+	}
+
+	teamKey, err := os.ReadFile(filepath.Join(keys, "team.pub"))
+	must(t, err)
+
+	pull := func(policy, name string) error {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "policy.json")
+		must(t, os.WriteFile(path, []byte(policy), 0644))
+
+		c, err := NewClient(host+"/"+name, http.NewHttpClient())
+		must(t, err)
+		must(t, c.SetSignaturePolicy(path))
+
+		return c.Pull()
+	}
+
+	scoped := func(scope, requirement string) string {
+		return `{"default": [{"type": "insecureAcceptAnything"}], "transports": {"docker": {"` + scope + `": [` + requirement + `]}}}`
+	}
+	signedBy := func(key string) string {
+		return `{"type": "sigstoreSigned", "keyPath": "` + filepath.Join(keys, key+".pub") + `"}`
+	}
+
+	for _, test := range []struct {
+		policy, name string
+		accepted     bool
+	}{
+		{scoped(host+"/team", signedBy("team")), "team/app:v1", true},
+		{scoped(host, signedBy("team")), "team/app@" + digest, true},
+		{scoped(host+"/team/app", `{"type": "sigstoreSigned", "keyData": "`+base64.StdEncoding.EncodeToString(teamKey)+`"}`), "team/app:v2", true},
+		{scoped(host+"/team", signedBy("other")), "team/app:v1", false},
+		{scoped(host+"/team", signedBy("team")), "team/unsigned:v1", false},
+		{scoped(host+"/team/unsigned", signedBy("team")), "team/app:v1", true},
+		{scoped(host+"/team", `{"type": "reject"}`), "team/app:v1", false},
+		{scoped("", `{"type": "reject"}`), "team/app:v1", false},
+		{`{"default": [{"type": "reject"}]}`, "team/app:v1", false},
+		{`{"default": [{"type": "insecureAcceptAnything"}]}`, "team/unsigned:v1", true},
+		// The signature of v1 names v1, so it doesn't vouch for a tag pointing elsewhere
+		{scoped(host, `{"type": "sigstoreSigned", "keyPath": "`+filepath.Join(keys, "team.pub")+`", "signedIdentity": {"type": "exactReference", "dockerReference": "`+host+`/team/app:v2"}}`), "team/app:v1", false},
+		{scoped(host, `{"type": "sigstoreSigned", "keyPath": "`+filepath.Join(keys, "team.pub")+`", "signedIdentity": {"type": "matchRepository"}}`), "team/app:v1", true},
+		{scoped(host, `{"type": "sigstoreSigned", "keyPath": "`+filepath.Join(keys, "team.pub")+`", "signedIdentity": {"type": "exactRepository", "dockerRepository": "`+host+`/team/other"}}`), "team/app:v1", false},
+	} {
+		err := pull(test.policy, test.name)
+		if test.accepted && err != nil {
+			t.Errorf("Expected %s to be accepted by %s, got %v", test.name, test.policy, err)
+		}
+		if !test.accepted && err == nil {
+			t.Errorf("Expected %s to be rejected by %s", test.name, test.policy)
+		}
+	}
+
+	// The user's policy applies to pulls without one set
+	writeFiles(t, os.Getenv("HOME"), map[string]string{RelativePolicyPath: scoped(host, signedBy("team"))})
+
+	c, err := NewClient(host+"/team/unsigned:v1", http.NewHttpClient())
+	must(t, err)
+	if err := c.Pull(); err == nil {
+		t.Errorf("Expected the user's policy to reject the unsigned image")
+	}
+	// The image pulled before is left alone
+	if _, err := os.Stat(filepath.Join(Path(host+"/team/unsigned:v1"), "rootfs", "etc", "motd")); err != nil {
+		t.Errorf("Expected a rejected pull to keep the stored image, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// relativeSourcesPath is the relative path of the file recording the registry repositories blobs came from.
+const relativeSourcesPath = ".local/share/gocker/blobs/sources.json"
+
+// recordSources remembers that the registry repository holds the blobs, so pushing them to other
+// repositories of the same registry can mount them from there instead of uploading them again.
+func recordSources(registryHost, repository string, digests ...string) error {
+	return updateSources(func(sources map[string][]string) {
+		source := registryHost + "/" + repository
+
+		for _, digest := range digests {
+			if !slices.Contains(sources[digest], source) {
+				sources[digest] = append(sources[digest], source)
+			}
+		}
+	})
+}
+
+// blobSources returns the repositories of the registry the blob is known to be in.
+func blobSources(registryHost, digest string) []string {
+	var repositories []string
+
+	updateSources(func(sources map[string][]string) {
+		for _, source := range sources[digest] {
+			if repo, ok := strings.CutPrefix(source, registryHost+"/"); ok {
+				repositories = append(repositories, repo)
+			}
+		}
+	})
+
+	return repositories
+}
+
+// updateSources calls fn with the recorded sources under a lock and saves its changes.
+func updateSources(fn func(map[string][]string)) error {
+	path := filepath.Join(os.Getenv("HOME"), relativeSourcesPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create blob store: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open blob sources: %v", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock blob sources: %v", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read blob sources: %v", err)
+	}
+
+	sources := make(map[string][]string)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &sources); err != nil {
+			return fmt.Errorf("failed to decode blob sources: %v", err)
+		}
+	}
+
+	fn(sources)
+
+	if data, err = json.Marshal(sources); err != nil {
+		return fmt.Errorf("failed to marshal blob sources: %v", err)
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write blob sources: %v", err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("failed to write blob sources: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+const (
+	// RelativeBlobsPath is the relative path of the content addressable blob store under the user's home directory.
+	RelativeBlobsPath = ".local/share/gocker/blobs/sha256/"
+
+	// RelativeTmpPath is the relative path of temporary files under the user's home directory.
+	// It's on the same filesystem as the image store, so its files can be moved into the store.
+	RelativeTmpPath = ".local/share/gocker/tmp/"
+
+	configFile   = ".config.json"
+	manifestFile = ".manifest.json"
+	snapshotFile = ".snapshot"
+)
+
+// ValidDigest reports whether the digest is a well-formed sha256 digest.
+func ValidDigest(digest string) bool {
+	hexPart, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hexPart)
+
+	return err == nil && strings.ToLower(hexPart) == hexPart
+}
+
+// ShortDigest returns the first 12 hex digits of a digest, the way images and layers are shown.
+func ShortDigest(digest string) string {
+	hexPart := strings.TrimPrefix(digest, "sha256:")
+	if len(hexPart) > 12 {
+		hexPart = hexPart[:12]
+	}
+
+	return hexPart
+}
+
+// BlobStore is a content addressable store of blobs named after their sha256 digests.
+type BlobStore struct {
+	// Dir is the directory holding the blobs
+	Dir string
+}
+
+// DefaultBlobStore returns the blob store of the user's images.
+func DefaultBlobStore() BlobStore {
+	return BlobStore{Dir: filepath.Join(os.Getenv("HOME"), RelativeBlobsPath)}
+}
+
+// Path returns the path of the blob with the given digest.
+func (s BlobStore) Path(digest string) string {
+	return filepath.Join(s.Dir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// Has reports whether the store contains the blob with the given digest.
+func (s BlobStore) Has(digest string) bool {
+	if !ValidDigest(digest) {
+		return false
+	}
+
+	_, err := os.Stat(s.Path(digest))
+
+	return err == nil
+}
+
+// Open opens the blob with the given digest for reading.
+func (s BlobStore) Open(digest string) (*os.File, error) {
+	if !ValidDigest(digest) {
+		return nil, fmt.Errorf("invalid digest: %q", digest)
+	}
+
+	return os.Open(s.Path(digest))
+}
+
+// Write stores the contents of the reader and returns its digest and size.
+// The blob is written to a temporary file first, so the store never holds partial blobs.
+func (s BlobStore) Write(r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create blob store: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create blob: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write blob: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write blob: %v", err)
+	}
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if err := os.Rename(tmp.Name(), s.Path(digest)); err != nil {
+		return "", 0, fmt.Errorf("failed to store blob: %v", err)
+	}
+
+	return digest, size, nil
+}
+
+// BlobPath returns the path of the blob with the given digest in the blob store.
+func BlobPath(digest string) string {
+	return DefaultBlobStore().Path(digest)
+}
+
+// HasBlob reports whether the blob store contains the blob with the given digest.
+func HasBlob(digest string) bool {
+	return DefaultBlobStore().Has(digest)
+}
+
+// OpenBlob opens the blob with the given digest for reading.
+func OpenBlob(digest string) (*os.File, error) {
+	return DefaultBlobStore().Open(digest)
+}
+
+// ReadBlob returns the contents of the blob with the given digest.
+func ReadBlob(digest string) ([]byte, error) {
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// WriteBlob stores the contents of the reader in the blob store and returns its digest and size.
+func WriteBlob(r io.Reader) (string, int64, error) {
+	return DefaultBlobStore().Write(r)
+}
+
+// writeJSONBlob stores the value marshaled to JSON in the blob store.
+func writeJSONBlob(v any, mediaType string) (registry.Descriptor, []byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return registry.Descriptor{}, nil, fmt.Errorf("failed to marshal %s: %v", mediaType, err)
+	}
+
+	digest, size, err := WriteBlob(bytes.NewReader(data))
+	if err != nil {
+		return registry.Descriptor{}, nil, err
+	}
+
+	return registry.Descriptor{MediaType: mediaType, Size: size, Digest: digest}, data, nil
+}
+
+// LoadManifest returns the manifest of a local image and its raw contents.
+func LoadManifest(name string) (*registry.Manifest, []byte, error) {
+	return loadManifestFile(Path(name))
+}
+
+// loadManifestFile returns the manifest in an image directory and its raw contents.
+func loadManifestFile(imgPath string) (*registry.Manifest, []byte, error) {
+	name, _ := url.PathUnescape(filepath.Base(imgPath))
+
+	data, err := os.ReadFile(filepath.Join(imgPath, manifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if _, serr := os.Stat(imgPath); serr == nil {
+				return nil, nil, fmt.Errorf("image %s has no manifest, pull it again", name)
+			}
+			return nil, nil, fmt.Errorf("no such image: %s", name)
+		}
+		return nil, nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	return &m, data, nil
+}
+
+// LoadConfig returns the config of a local image.
+func LoadConfig(name string) (*registry.ImageConfig, error) {
+	data, err := os.ReadFile(filepath.Join(Path(name), configFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such image: %s", name)
+		}
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var cfg registry.ImageConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %v", err)
+	}
+
+	return &cfg, nil
+}
+
+// Exists reports whether the local image store holds an image with the given name.
+func Exists(name string) bool {
+	_, err := os.Stat(filepath.Join(Path(name), configFile))
+
+	return err == nil
+}
+
+// Save stores an image under the given name. The config and a manifest listing the
+// layers, which must already be in the blob store, are added to the blob store and
+// the root filesystem directory is moved into the image directory.
+// It returns the image ID, the digest of the config.
+func Save(name, rootfs string, cfg *registry.ImageConfig, layers []registry.Descriptor) (string, error) {
+	configDesc, _, err := writeJSONBlob(cfg, registry.MediaTypeOCIConfig)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	}
+	if manifest.Layers == nil {
+		manifest.Layers = []registry.Descriptor{}
+	}
+
+	_, manifestData, err := writeJSONBlob(manifest, registry.MediaTypeOCIManifest)
+	if err != nil {
+		return "", err
+	}
+
+	if err := saveImageDir(Path(name), rootfs, cfg, manifestData); err != nil {
+		return "", err
+	}
+
+	return configDesc.Digest, nil
+}
+
+// saveImageDir replaces the image directory with one holding the root filesystem, config and manifest.
+func saveImageDir(imgPath, rootfs string, cfg *registry.ImageConfig, manifest []byte) error {
+	cfgData, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	if err := os.RemoveAll(imgPath); err != nil {
+		return fmt.Errorf("failed to remove existing image dir: %v", err)
+	}
+
+	if err := os.MkdirAll(imgPath, 0755); err != nil {
+		return fmt.Errorf("failed to create image dir: %v", err)
+	}
+
+	if err := os.Rename(rootfs, filepath.Join(imgPath, "rootfs")); err != nil {
+		return fmt.Errorf("failed to move image rootfs: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(imgPath, manifestFile), manifest, 0644); err != nil {
+		return fmt.Errorf("failed to save manifest: %v", err)
+	}
+
+	if err := saveSnapshot(imgPath); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(imgPath, configFile), cfgData, 0644); err != nil {
+		return fmt.Errorf("failed to save config file: %v", err)
+	}
+
+	return nil
+}
+
+// TempDir creates a temporary directory next to the image store.
+func TempDir(pattern string) (string, error) {
+	dir := filepath.Join(os.Getenv("HOME"), RelativeTmpPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create temporary dir: %v", err)
+	}
+
+	return os.MkdirTemp(dir, pattern)
+}
+
+// ReferencedBlobs returns the digests of the manifests, configs and layers of all local images.
+func ReferencedBlobs() (map[string]bool, error) {
+	dir := filepath.Join(os.Getenv("HOME"), RelativeImagesPath)
+	referenced := make(map[string]bool)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return referenced, nil
+		}
+		return nil, fmt.Errorf("failed to read image store: %v", err)
+	}
+
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name(), manifestFile))
+		if err != nil {
+			// Images pulled before manifests were kept don't refer to blobs
+			continue
+		}
+
+		var m registry.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest of %s: %v", e.Name(), err)
+		}
+
+		sum := sha256.Sum256(data)
+		referenced["sha256:"+hex.EncodeToString(sum[:])] = true
+		referenced[m.Config.Digest] = true
+		for _, layer := range m.Layers {
+			referenced[layer.Digest] = true
+		}
+	}
+
+	return referenced, nil
+}
@@ -0,0 +1,315 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	dnsPort = 53
+
+	// dnsTTL is the TTL of answers about containers, short since containers come and go
+	dnsTTL = 10
+
+	// forwardTimeout limits how long a forwarded query waits for the upstream nameserver
+	forwardTimeout = 5 * time.Second
+
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	rcodeNameError = 3
+)
+
+// upstreamResolvConf lists the nameservers queries about other names are forwarded to.
+var upstreamResolvConf = "/etc/resolv.conf"
+
+// ServeDNS runs the embedded DNS server of the network on its gateway address until the
+// context is done. It answers queries for the names, aliases and short IDs of the running
+// containers of the network and forwards the others. Every container supervisor serves
+// its networks, sharing the port, so the server lives as long as any of their containers.
+func ServeDNS(ctx context.Context, n *Network, running func(containerID string) bool) error {
+	if err := n.ensureBridge(); err != nil {
+		return err
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, 0xf /* SO_REUSEPORT */, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+
+	conn, err := lc.ListenPacket(ctx, "udp4", net.JoinHostPort(n.Gateway, fmt.Sprint(dnsPort)))
+	if err != nil {
+		return fmt.Errorf("failed to start DNS server of network %s: %v", n.Name, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	r := &resolver{network: n, running: running}
+	serve(conn.(*net.UDPConn), r)
+
+	return nil
+}
+
+// resolver resolves the names of the containers on a network.
+type resolver struct {
+	network *Network
+	running func(containerID string) bool
+}
+
+// lookup returns the addresses known by the name on the networks of the client, and the
+// nameservers its other queries are forwarded to. Unknown clients only see the network itself.
+func (r *resolver) lookup(name string, client net.IP) ([]net.IP, []string) {
+	endpoints, err := r.network.Endpoints()
+	if err != nil {
+		return nil, nil
+	}
+
+	networks := []*Network{r.network}
+	var upstream []string
+
+	for _, ep := range endpoints {
+		if client == nil || ep.IPAddress != client.String() {
+			continue
+		}
+
+		upstream = ep.DNS
+		if own, err := ContainerEndpoints(ep.ContainerID); err == nil && len(own) > 0 {
+			networks = nil
+			for _, o := range own {
+				networks = append(networks, o.Network)
+			}
+		}
+	}
+
+	var addrs []net.IP
+	for _, n := range networks {
+		endpoints, err := n.Endpoints()
+		if err != nil {
+			continue
+		}
+
+		for _, ep := range endpoints {
+			if !ep.knownAs(name) || (r.running != nil && !r.running(ep.ContainerID)) {
+				continue
+			}
+
+			if ip := net.ParseIP(ep.IPAddress).To4(); ip != nil {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	return addrs, upstream
+}
+
+// knownAs reports whether the endpoint's container is known by the name on its network.
+func (ep *Endpoint) knownAs(name string) bool {
+	if strings.EqualFold(name, ep.ContainerName) || strings.EqualFold(name, shortID(ep.ContainerID)) {
+		return true
+	}
+
+	for _, alias := range ep.Aliases {
+		if strings.EqualFold(name, alias) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serve answers the queries arriving on the connection until it's closed.
+func serve(conn *net.UDPConn, r *resolver) {
+	buf := make([]byte, 4096)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+
+		go func() {
+			if resp := r.handle(query, addr.IP); resp != nil {
+				conn.WriteToUDP(resp, addr)
+			}
+		}()
+	}
+}
+
+// handle returns the response to a query, answered locally for container names or
+// by an upstream nameserver otherwise. Malformed queries are dropped.
+func (r *resolver) handle(query []byte, client net.IP) []byte {
+	name, qtype, end, err := parseQuestion(query)
+	if err != nil {
+		return nil
+	}
+
+	addrs, upstream := r.lookup(strings.TrimSuffix(name, "."), client)
+
+	if len(addrs) > 0 {
+		// Containers only have IPv4 addresses, so other types get an empty answer
+		if qtype != typeA {
+			addrs = nil
+		}
+		return buildResponse(query[:end], addrs, 0)
+	}
+
+	if resp, err := forward(query, upstream); err == nil {
+		return resp
+	}
+
+	return buildResponse(query[:end], nil, rcodeNameError)
+}
+
+// parseQuestion returns the name and type of the single question of a query,
+// and the offset where the question ends.
+func parseQuestion(msg []byte) (string, uint16, int, error) {
+	if len(msg) < 12 {
+		return "", 0, 0, fmt.Errorf("short message")
+	}
+
+	// Only queries with exactly one question are answered
+	if msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return "", 0, 0, fmt.Errorf("not a single question query")
+	}
+
+	var labels []string
+	i := 12
+	for {
+		if i >= len(msg) {
+			return "", 0, 0, fmt.Errorf("truncated name")
+		}
+
+		l := int(msg[i])
+		i++
+
+		if l == 0 {
+			break
+		}
+		if l > 63 || i+l > len(msg) {
+			return "", 0, 0, fmt.Errorf("invalid label")
+		}
+
+		labels = append(labels, string(msg[i:i+l]))
+		i += l
+	}
+
+	if i+4 > len(msg) {
+		return "", 0, 0, fmt.Errorf("truncated question")
+	}
+
+	qtype, qclass := binary.BigEndian.Uint16(msg[i:]), binary.BigEndian.Uint16(msg[i+2:])
+	if qclass != classIN {
+		return "", 0, 0, fmt.Errorf("unsupported class %d", qclass)
+	}
+
+	return strings.Join(labels, ".") + ".", qtype, i + 4, nil
+}
+
+// buildResponse answers the query, given up to the end of its question, with A records.
+func buildResponse(question []byte, addrs []net.IP, rcode byte) []byte {
+	resp := append([]byte(nil), question...)
+
+	// QR and RA are set, the opcode and RD are kept
+	resp[2] = 0x80 | question[2]&0x79
+	resp[3] = 0x80 | rcode
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(addrs)))
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+
+	for _, ip := range addrs {
+		// The name is a pointer to the one in the question
+		resp = binary.BigEndian.AppendUint16(resp, 0xc00c)
+		resp = binary.BigEndian.AppendUint16(resp, typeA)
+		resp = binary.BigEndian.AppendUint16(resp, classIN)
+		resp = binary.BigEndian.AppendUint32(resp, dnsTTL)
+		resp = binary.BigEndian.AppendUint16(resp, 4)
+		resp = append(resp, ip.To4()...)
+	}
+
+	return resp
+}
+
+// forward sends the query to the nameservers in turn, the host's unless given, and
+// returns the first response.
+func forward(query []byte, nameservers []string) ([]byte, error) {
+	if len(nameservers) == 0 {
+		nameservers = hostNameservers()
+	}
+
+	err := fmt.Errorf("no nameservers")
+	for _, ns := range nameservers {
+		var resp []byte
+		if resp, err = exchange(query, ns); err == nil {
+			return resp, nil
+		}
+	}
+
+	return nil, err
+}
+
+// exchange sends a query to a nameserver over UDP and waits for the response.
+func exchange(query []byte, nameserver string) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(nameserver, fmt.Sprint(dnsPort)), forwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Responses to other queries are ignored
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+// hostNameservers returns the nameservers of the host.
+func hostNameservers() []string {
+	f, err := os.Open(upstreamResolvConf)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var nameservers []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			nameservers = append(nameservers, fields[1])
+		}
+	}
+
+	return nameservers
+}
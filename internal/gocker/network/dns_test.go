@@ -0,0 +1,99 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// query builds a DNS query for the name with the given type.
+func query(id uint16, name string, qtype uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+// TestResolver tests the answers of the embedded DNS server
+func TestResolver(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Queries about other names find no nameserver to forward to
+	defer func(path string) { upstreamResolvConf = path }(upstreamResolvConf)
+	upstreamResolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+
+	front, err := Create("front", CreateOptions{Subnet: "10.0.1.0/24"})
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	if _, err := Create("back", CreateOptions{Subnet: "10.0.2.0/24"}); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+
+	for _, c := range []struct {
+		network, id, name string
+		aliases           []string
+	}{
+		{"front", "0123456789abcdef", "web", nil},
+		{"front", "fedcba9876543210", "stopped", nil},
+		{"back", "0123456789abcdef", "web", nil},
+		{"back", "1111111111111111", "db", []string{"Database"}},
+	} {
+		if _, err := Connect(c.network, c.id, c.name, c.aliases, "", nil); err != nil {
+			t.Fatalf("Failed to connect %s: %v", c.name, err)
+		}
+	}
+
+	r := &resolver{network: front, running: func(id string) bool { return id != "fedcba9876543210" }}
+	web := net.ParseIP("10.0.1.2")
+
+	for _, test := range []struct {
+		name   string
+		qtype  uint16
+		client net.IP
+		rcode  byte
+		answer string
+	}{
+		{"web", typeA, nil, 0, "10.0.1.2"},
+		{"WEB.", typeA, nil, 0, "10.0.1.2"},
+		{"0123456789ab", typeA, nil, 0, "10.0.1.2"},
+		{"web", typeAAAA, nil, 0, ""},
+		{"stopped", typeA, nil, rcodeNameError, ""},
+		// Containers resolve the names on all their networks
+		{"database", typeA, web, 0, "10.0.2.3"},
+		{"database", typeA, nil, rcodeNameError, ""},
+		{"example.com", typeA, web, rcodeNameError, ""},
+	} {
+		q := query(0xbeef, test.name, test.qtype)
+		resp := r.handle(q, test.client)
+
+		if len(resp) < len(q) || resp[0] != 0xbe || resp[1] != 0xef || resp[2]&0x80 == 0 {
+			t.Errorf("Expected a response to %s, got %x", test.name, resp)
+			continue
+		}
+		if rcode := resp[3] & 0x0f; rcode != test.rcode {
+			t.Errorf("Expected rcode %d for %s, got %d", test.rcode, test.name, rcode)
+		}
+
+		var answer string
+		if binary.BigEndian.Uint16(resp[6:]) == 1 {
+			answer = net.IP(resp[len(resp)-4:]).String()
+		}
+		if answer != test.answer {
+			t.Errorf("Expected answer %q for %s, got %q", test.answer, test.name, answer)
+		}
+	}
+
+	if resp := r.handle([]byte{0xbe, 0xef, 0x01}, nil); resp != nil {
+		t.Errorf("Expected a malformed query to be dropped, got %x", resp)
+	}
+}
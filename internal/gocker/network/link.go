@@ -0,0 +1,184 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ipForwardPath enables routing between the bridges and the outside world.
+const ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+
+// ensureBridge creates the bridge of the network on the host and gives it the gateway address.
+// Containers of networks that aren't internal reach the outside world through NAT.
+func (n *Network) ensureBridge() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("bridge networks require root privileges")
+	}
+
+	// Supervisors and starting containers race to create the bridge
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat("/sys/class/net/" + n.Bridge); err == nil {
+		return nil
+	}
+
+	ones, _ := n.prefix().Mask.Size()
+
+	for _, args := range [][]string{
+		{"link", "add", "name", n.Bridge, "type", "bridge"},
+		{"addr", "add", n.Gateway + "/" + strconv.Itoa(ones), "dev", n.Bridge},
+		{"link", "set", n.Bridge, "up"},
+	} {
+		if err := ip(args...); err != nil {
+			ip("link", "del", n.Bridge)
+			return fmt.Errorf("failed to set up bridge %s: %v", n.Bridge, err)
+		}
+	}
+
+	if n.Internal {
+		return nil
+	}
+
+	if err := os.WriteFile(ipForwardPath, []byte("1"), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to enable IP forwarding: %v\n", err)
+	}
+
+	if err := iptables("-A", n.masquerade()...); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: containers on network %s can't reach other networks: %v\n", n.Name, err)
+	}
+
+	return nil
+}
+
+// removeBridge deletes the bridge of the network and its NAT rule.
+func (n *Network) removeBridge() error {
+	if _, err := os.Stat("/sys/class/net/" + n.Bridge); err != nil {
+		return nil
+	}
+
+	if !n.Internal {
+		iptables("-D", n.masquerade()...)
+	}
+
+	if err := ip("link", "del", n.Bridge); err != nil {
+		return fmt.Errorf("failed to remove bridge %s: %v", n.Bridge, err)
+	}
+
+	return nil
+}
+
+// masquerade returns the NAT rule of the traffic leaving the network.
+func (n *Network) masquerade() []string {
+	return []string{"POSTROUTING", "-t", "nat", "-s", n.Subnet, "!", "-o", n.Bridge, "-j", "MASQUERADE"}
+}
+
+// Attach connects the network namespace of the process with the given PID to the network:
+// one end of a veth pair joins the bridge, the other one becomes the endpoint's interface.
+// The interface of the primary endpoint holds the default route.
+func (ep *Endpoint) Attach(pid int, primary bool) error {
+	n := ep.Network
+	if err := n.ensureBridge(); err != nil {
+		return err
+	}
+
+	// The pair of a restarted container may outlive its old network namespace for a moment
+	ep.Detach()
+
+	hostName, peerName := ep.vethNames()
+	ones, _ := n.prefix().Mask.Size()
+	target := strconv.Itoa(pid)
+
+	steps := [][]string{
+		{"link", "add", hostName, "type", "veth", "peer", "name", peerName},
+		{"link", "set", hostName, "master", n.Bridge, "up"},
+		{"link", "set", peerName, "netns", target},
+	}
+	inside := [][]string{
+		{"link", "set", peerName, "name", ep.Interface},
+		{"addr", "add", ep.IPAddress + "/" + strconv.Itoa(ones), "dev", ep.Interface},
+		{"link", "set", ep.Interface, "up"},
+	}
+	if primary {
+		inside = append(inside, []string{"route", "add", "default", "via", n.Gateway})
+	}
+
+	for _, args := range steps {
+		if err := ip(args...); err != nil {
+			ip("link", "del", hostName)
+			return fmt.Errorf("failed to connect to network %s: %v", n.Name, err)
+		}
+	}
+
+	for _, args := range inside {
+		if err := ipIn(target, args...); err != nil {
+			ip("link", "del", hostName)
+			return fmt.Errorf("failed to configure interface %s: %v", ep.Interface, err)
+		}
+	}
+
+	return nil
+}
+
+// Detach removes the veth pair of the endpoint, if the container is attached.
+func (ep *Endpoint) Detach() {
+	hostName, _ := ep.vethNames()
+
+	if _, err := os.Stat("/sys/class/net/" + hostName); err == nil {
+		ip("link", "del", hostName)
+	}
+}
+
+// vethNames returns the names of the host end and the temporary name of the container end
+// of the endpoint's veth pair, derived from the container and network IDs to fit IFNAMSIZ.
+func (ep *Endpoint) vethNames() (string, string) {
+	sum := sha256.Sum256([]byte(ep.ContainerID + ep.Network.ID))
+	suffix := hex.EncodeToString(sum[:])[:11]
+
+	return "veth" + suffix, "gkp" + suffix
+}
+
+// ip runs an iproute2 command.
+func ip(args ...string) error {
+	return run("ip", args...)
+}
+
+// ipIn runs an iproute2 command in the network namespace of the process with the given PID.
+func ipIn(pid string, args ...string) error {
+	return run("nsenter", append([]string{"--target", pid, "--net", "--", "ip"}, args...)...)
+}
+
+// iptables adds or deletes a rule unless it's already in that state.
+func iptables(action string, rule ...string) error {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return fmt.Errorf("iptables not found")
+	}
+
+	exists := run("iptables", append([]string{"-C"}, rule...)...) == nil
+	if (action == "-A") == exists {
+		return nil
+	}
+
+	return run("iptables", append([]string{action}, rule...)...)
+}
+
+// run executes a network configuration command, returning its output as the error.
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+
+	return nil
+}
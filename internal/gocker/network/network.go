@@ -0,0 +1,660 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// RelativeNetworksPath is the relative networks path under the user's home directory.
+	RelativeNetworksPath = ".local/share/gocker/networks/"
+
+	// Predefined networks. Containers use the host network unless given another one.
+	Host          = "host"
+	None          = "none"
+	DefaultBridge = "bridge"
+
+	// DriverBridge connects the containers of a network to a bridge on the host
+	DriverBridge = "bridge"
+
+	networkFile  = "network.json"
+	endpointsDir = "endpoints"
+	lockFile     = ".lock"
+
+	// defaultBridgeSubnet and defaultBridgeDevice are those of the predefined bridge network, like docker0's
+	defaultBridgeSubnet = "172.17.0.0/16"
+	defaultBridgeDevice = "gocker0"
+)
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Network is the persisted record of a network.
+type Network struct {
+	ID       string
+	Name     string
+	Driver   string
+	Subnet   string `json:",omitempty"`
+	Gateway  string `json:",omitempty"`
+	Bridge   string `json:",omitempty"`
+	Internal bool
+	Created  time.Time
+}
+
+// Endpoint is the attachment of a container to a network.
+type Endpoint struct {
+	ContainerID   string
+	ContainerName string   `json:",omitempty"`
+	Aliases       []string `json:",omitempty"`
+	IPAddress     string
+	// Interface is the name of the interface inside the container
+	Interface string
+	// DNS are the nameservers the embedded DNS server forwards the container's other queries to
+	DNS []string `json:",omitempty"`
+
+	Network *Network `json:"-"`
+}
+
+// CreateOptions holds the user-supplied settings of a new network.
+type CreateOptions struct {
+	Driver   string
+	Subnet   string
+	Gateway  string
+	Internal bool
+}
+
+// IsPredefined reports whether the network is created by gocker and can't be removed.
+func (n *Network) IsPredefined() bool {
+	return n.Name == Host || n.Name == None || n.Name == DefaultBridge
+}
+
+// ShortID returns the abbreviated network ID used in CLI output.
+func (n *Network) ShortID() string {
+	return n.ID[:12]
+}
+
+// prefix returns the subnet of the network.
+func (n *Network) prefix() *net.IPNet {
+	_, subnet, err := net.ParseCIDR(n.Subnet)
+	if err != nil {
+		return nil
+	}
+
+	return subnet
+}
+
+// networksRoot returns the directory holding all networks.
+func networksRoot() string {
+	return filepath.Join(os.Getenv("HOME"), RelativeNetworksPath)
+}
+
+// dir returns the directory of the network.
+func (n *Network) dir() string {
+	return filepath.Join(networksRoot(), n.ID)
+}
+
+// Create creates a bridge network with the given name. Without a subnet a free
+// private one is picked, and without a gateway it's the first address of the subnet.
+func Create(name string, opts CreateOptions) (*Network, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid network name %q", name)
+	}
+
+	if opts.Driver == "" {
+		opts.Driver = DriverBridge
+	}
+	if opts.Driver != DriverBridge {
+		return nil, fmt.Errorf("unsupported network driver %q", opts.Driver)
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	networks, err := list()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range networks {
+		if n.Name == name {
+			return nil, fmt.Errorf("network with name %s already exists", name)
+		}
+	}
+
+	n := &Network{ID: newID(), Name: name, Driver: opts.Driver, Internal: opts.Internal, Created: time.Now()}
+	n.Bridge = "gk-" + n.ShortID()
+
+	if err := n.setSubnet(opts.Subnet, opts.Gateway, networks); err != nil {
+		return nil, err
+	}
+
+	if err := n.save(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// setSubnet validates or picks the subnet of the network and sets its gateway.
+func (n *Network) setSubnet(subnet, gateway string, networks []*Network) error {
+	var candidates []*net.IPNet
+
+	if subnet != "" {
+		ip, ipnet, err := net.ParseCIDR(subnet)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 subnet %q", subnet)
+		}
+		if !ip.Equal(ipnet.IP) {
+			return fmt.Errorf("invalid subnet %s, did you mean %s?", subnet, ipnet)
+		}
+		if ones, _ := ipnet.Mask.Size(); ones > 30 {
+			return fmt.Errorf("subnet %s is too small", subnet)
+		}
+		candidates = append(candidates, ipnet)
+	} else {
+		// Like docker, user-defined networks get 172.18-31.0.0/16 and then 192.168.*.0/20
+		for i := 18; i < 32; i++ {
+			candidates = append(candidates, &net.IPNet{IP: net.IPv4(172, byte(i), 0, 0).To4(), Mask: net.CIDRMask(16, 32)})
+		}
+		for i := 0; i < 256; i += 16 {
+			candidates = append(candidates, &net.IPNet{IP: net.IPv4(192, 168, byte(i), 0).To4(), Mask: net.CIDRMask(20, 32)})
+		}
+	}
+
+	for _, candidate := range candidates {
+		var conflict *Network
+		for _, other := range networks {
+			if p := other.prefix(); p != nil && (p.Contains(candidate.IP) || candidate.Contains(p.IP)) {
+				conflict = other
+				break
+			}
+		}
+
+		if conflict != nil {
+			if subnet != "" {
+				return fmt.Errorf("subnet %s overlaps with network %s", subnet, conflict.Name)
+			}
+			continue
+		}
+
+		n.Subnet = candidate.String()
+
+		gw := nthAddress(candidate, 1)
+		if gateway != "" {
+			if gw = net.ParseIP(gateway).To4(); gw == nil || !candidate.Contains(gw) {
+				return fmt.Errorf("gateway %s is not in subnet %s", gateway, n.Subnet)
+			}
+		}
+		n.Gateway = gw.String()
+
+		return nil
+	}
+
+	return fmt.Errorf("no free subnet left for the network, set one with --subnet")
+}
+
+// Remove deletes networks without endpoints, and their bridges.
+func Remove(ref string) error {
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	n, err := find(ref)
+	if err != nil {
+		return err
+	}
+
+	if n.IsPredefined() {
+		return fmt.Errorf("%s is a pre-defined network and cannot be removed", n.Name)
+	}
+
+	endpoints, err := n.Endpoints()
+	if err != nil {
+		return err
+	}
+	if len(endpoints) > 0 {
+		return fmt.Errorf("network %s has active endpoints", n.Name)
+	}
+
+	if err := n.removeBridge(); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(n.dir()); err != nil {
+		return fmt.Errorf("failed to remove network dir: %v", err)
+	}
+
+	return nil
+}
+
+// List returns all networks, the predefined ones included, sorted by name.
+func List() ([]*Network, error) {
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return list()
+}
+
+// Find looks up a network by name, full ID or unique ID prefix.
+func Find(ref string) (*Network, error) {
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return find(ref)
+}
+
+// list reads all networks, creating the predefined ones first. The store must be locked.
+func list() ([]*Network, error) {
+	if err := ensurePredefined(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(networksRoot())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read networks dir: %v", err)
+	}
+
+	var networks []*Network
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		n, err := load(e.Name())
+		if err != nil {
+			continue
+		}
+		networks = append(networks, n)
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+
+	return networks, nil
+}
+
+// find looks up a network in the locked store.
+func find(ref string) (*Network, error) {
+	networks, err := list()
+	if err != nil {
+		return nil, err
+	}
+
+	var match *Network
+	for _, n := range networks {
+		if n.ID == ref || n.Name == ref {
+			return n, nil
+		}
+
+		if strings.HasPrefix(n.ID, ref) {
+			if match != nil {
+				return nil, fmt.Errorf("multiple networks match %q", ref)
+			}
+			match = n
+		}
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("network %s not found", ref)
+	}
+
+	return match, nil
+}
+
+// ensurePredefined creates the records of the host, none and default bridge networks.
+func ensurePredefined() error {
+	if err := os.MkdirAll(networksRoot(), 0755); err != nil {
+		return fmt.Errorf("failed to create networks dir: %v", err)
+	}
+
+	entries, err := os.ReadDir(networksRoot())
+	if err != nil {
+		return fmt.Errorf("failed to read networks dir: %v", err)
+	}
+
+	existing := map[string]bool{}
+	for _, e := range entries {
+		if n, err := load(e.Name()); err == nil {
+			existing[n.Name] = true
+		}
+	}
+
+	for _, n := range []*Network{
+		{Name: DefaultBridge, Driver: DriverBridge, Subnet: defaultBridgeSubnet, Gateway: "172.17.0.1", Bridge: defaultBridgeDevice},
+		{Name: Host, Driver: "host"},
+		{Name: None, Driver: "null"},
+	} {
+		if existing[n.Name] {
+			continue
+		}
+
+		n.ID, n.Created = newID(), time.Now()
+		if err := n.save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load reads the network with the given ID.
+func load(id string) (*Network, error) {
+	data, err := os.ReadFile(filepath.Join(networksRoot(), id, networkFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network: %v", err)
+	}
+
+	var n Network
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("failed to decode network: %v", err)
+	}
+
+	return &n, nil
+}
+
+// save writes the network record.
+func (n *Network) save() error {
+	if err := os.MkdirAll(filepath.Join(n.dir(), endpointsDir), 0755); err != nil {
+		return fmt.Errorf("failed to create network dir: %v", err)
+	}
+
+	return writeJSON(filepath.Join(n.dir(), networkFile), n)
+}
+
+// Connect allocates an address for the container on the network and records its endpoint.
+// An empty ip picks the lowest free address. The interface is the next free ethN of the container.
+func Connect(ref, containerID, containerName string, aliases []string, ip string, dns []string) (*Endpoint, error) {
+	n, err := Find(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Driver != DriverBridge {
+		return nil, fmt.Errorf("containers can't be connected to the %s network", n.Name)
+	}
+
+	// Interface names are allocated across networks, which the store lock covers
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	endpoints, err := n.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{n.Gateway: true}
+	for _, ep := range endpoints {
+		if ep.ContainerID == containerID {
+			return nil, fmt.Errorf("container %s is already connected to network %s", shortID(containerID), n.Name)
+		}
+		used[ep.IPAddress] = true
+	}
+
+	subnet := n.prefix()
+	if subnet == nil {
+		return nil, fmt.Errorf("network %s has an invalid subnet %q", n.Name, n.Subnet)
+	}
+
+	// The network and broadcast addresses are never assigned
+	ones, bits := subnet.Mask.Size()
+	size := 1 << (bits - ones)
+
+	if ip != "" {
+		addr := net.ParseIP(ip).To4()
+		if addr == nil || !subnet.Contains(addr) || addr.Equal(subnet.IP) || addr.Equal(nthAddress(subnet, size-1)) {
+			return nil, fmt.Errorf("address %s is not a valid address in subnet %s of network %s", ip, n.Subnet, n.Name)
+		}
+		if used[addr.String()] {
+			return nil, fmt.Errorf("address %s is already in use on network %s", ip, n.Name)
+		}
+		ip = addr.String()
+	} else {
+		for i := 1; i < size-1; i++ {
+			if addr := nthAddress(subnet, i).String(); !used[addr] {
+				ip = addr
+				break
+			}
+		}
+		if ip == "" {
+			return nil, fmt.Errorf("no free address left on network %s", n.Name)
+		}
+	}
+
+	own, err := ContainerEndpoints(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := map[string]bool{}
+	for _, ep := range own {
+		ifaces[ep.Interface] = true
+	}
+
+	ep := &Endpoint{ContainerID: containerID, ContainerName: containerName, Aliases: aliases, IPAddress: ip, DNS: dns, Network: n}
+	for i := 0; ep.Interface == ""; i++ {
+		if name := fmt.Sprintf("eth%d", i); !ifaces[name] {
+			ep.Interface = name
+		}
+	}
+
+	if err := writeJSON(n.endpointPath(containerID), ep); err != nil {
+		return nil, err
+	}
+
+	return ep, nil
+}
+
+// Disconnect removes the endpoint of the container from the network, detaching it if it's running.
+func Disconnect(ref, containerID string) error {
+	n, err := Find(ref)
+	if err != nil {
+		return err
+	}
+
+	ep, err := n.endpoint(containerID)
+	if err != nil {
+		return err
+	}
+
+	ep.Detach()
+
+	if err := os.Remove(n.endpointPath(containerID)); err != nil {
+		return fmt.Errorf("failed to remove endpoint: %v", err)
+	}
+
+	return nil
+}
+
+// DisconnectAll removes the endpoints of a container from all its networks.
+func DisconnectAll(containerID string) error {
+	endpoints, err := ContainerEndpoints(containerID)
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range endpoints {
+		ep.Detach()
+
+		if err := os.Remove(ep.Network.endpointPath(containerID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove endpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ContainerEndpoints returns the endpoints of a container, ordered by interface.
+// The first one is the primary endpoint, which holds the default route.
+func ContainerEndpoints(containerID string) ([]*Endpoint, error) {
+	entries, err := os.ReadDir(networksRoot())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read networks dir: %v", err)
+	}
+
+	var endpoints []*Endpoint
+	for _, e := range entries {
+		n, err := load(e.Name())
+		if err != nil {
+			continue
+		}
+
+		if ep, err := n.endpoint(containerID); err == nil {
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return interfaceIndex(endpoints[i].Interface) < interfaceIndex(endpoints[j].Interface)
+	})
+
+	return endpoints, nil
+}
+
+// Endpoints returns the endpoints of the network.
+func (n *Network) Endpoints() ([]*Endpoint, error) {
+	entries, err := os.ReadDir(filepath.Join(n.dir(), endpointsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read endpoints: %v", err)
+	}
+
+	var endpoints []*Endpoint
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+
+		if ep, err := n.endpoint(e.Name()); err == nil {
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// endpoint reads the endpoint of a container on the network.
+func (n *Network) endpoint(containerID string) (*Endpoint, error) {
+	data, err := os.ReadFile(n.endpointPath(containerID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %s is not connected to network %s", shortID(containerID), n.Name)
+		}
+		return nil, fmt.Errorf("failed to read endpoint: %v", err)
+	}
+
+	ep := &Endpoint{Network: n}
+	if err := json.Unmarshal(data, ep); err != nil {
+		return nil, fmt.Errorf("failed to decode endpoint: %v", err)
+	}
+
+	return ep, nil
+}
+
+func (n *Network) endpointPath(containerID string) string {
+	return filepath.Join(n.dir(), endpointsDir, containerID)
+}
+
+// lockStore takes an exclusive lock on the network store, released by the returned function.
+func lockStore() (func(), error) {
+	if err := os.MkdirAll(networksRoot(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create networks dir: %v", err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(networksRoot(), lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network lock: %v", err)
+	}
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock networks: %v", err)
+	}
+
+	return func() {
+		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
+
+// writeJSON atomically writes the value as indented JSON.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", filepath.Base(path), err)
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save %s: %v", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// nthAddress returns the address at the offset from the start of the subnet.
+func nthAddress(subnet *net.IPNet, n int) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+uint32(n))
+
+	return ip
+}
+
+// interfaceIndex returns N of an ethN interface name.
+func interfaceIndex(name string) int {
+	var i int
+	fmt.Sscanf(name, "eth%d", &i)
+
+	return i
+}
+
+// newID generates a random 64 character hexadecimal network ID.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate network ID: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// shortID returns the first 12 characters of an ID.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"testing"
+)
+
+// TestCreate tests the validation and allocation of network subnets
+func TestCreate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	n, err := Create("first", CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	if n.Subnet != "172.18.0.0/16" || n.Gateway != "172.18.0.1" {
+		t.Errorf("Expected the first free subnet 172.18.0.0/16 with gateway 172.18.0.1, got %s with %s", n.Subnet, n.Gateway)
+	}
+
+	n, err = Create("second", CreateOptions{Subnet: "10.1.0.0/24", Gateway: "10.1.0.254"})
+	if err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	if n.Gateway != "10.1.0.254" {
+		t.Errorf("Expected gateway 10.1.0.254, got %s", n.Gateway)
+	}
+
+	if n, err = Create("third", CreateOptions{}); err != nil || n.Subnet != "172.19.0.0/16" {
+		t.Errorf("Expected the next free subnet 172.19.0.0/16, got %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		opts CreateOptions
+	}{
+		{"first", CreateOptions{}},
+		{"bad name", CreateOptions{}},
+		{"overlap", CreateOptions{Subnet: "172.18.5.0/24"}},
+		{"default", CreateOptions{Subnet: "172.17.0.0/16"}},
+		{"unaligned", CreateOptions{Subnet: "10.2.0.1/24"}},
+		{"gateway", CreateOptions{Subnet: "10.3.0.0/24", Gateway: "10.4.0.1"}},
+		{"driver", CreateOptions{Driver: "overlay"}},
+	} {
+		if _, err := Create(test.name, test.opts); err == nil {
+			t.Errorf("Expected network %q with %+v to be rejected", test.name, test.opts)
+		}
+	}
+
+	networks, err := List()
+	if err != nil {
+		t.Fatalf("Failed to list networks: %v", err)
+	}
+	if len(networks) != 6 {
+		t.Errorf("Expected 3 predefined and 3 created networks, got %d", len(networks))
+	}
+
+	if err := Remove(DefaultBridge); err == nil {
+		t.Error("Expected the predefined bridge network not to be removable")
+	}
+}
+
+// TestConnect tests the allocation of addresses and interfaces to endpoints
+func TestConnect(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	for name, subnet := range map[string]string{"front": "10.0.1.0/29", "back": "10.0.2.0/29"} {
+		if _, err := Create(name, CreateOptions{Subnet: subnet}); err != nil {
+			t.Fatalf("Failed to create network: %v", err)
+		}
+	}
+
+	ep, err := Connect("front", "a", "web", nil, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if ep.IPAddress != "10.0.1.2" || ep.Interface != "eth0" {
+		t.Errorf("Expected 10.0.1.2 on eth0 after the gateway, got %s on %s", ep.IPAddress, ep.Interface)
+	}
+
+	if ep, err = Connect("back", "a", "web", nil, "10.0.2.5", nil); err != nil || ep.Interface != "eth1" {
+		t.Errorf("Expected the second network on eth1, got %v", err)
+	}
+
+	for _, test := range []struct {
+		network, container, ip string
+	}{
+		{"front", "a", ""},
+		{"front", "b", "10.0.1.2"},
+		{"front", "b", "10.0.1.1"},
+		{"front", "b", "10.0.2.3"},
+		{"front", "b", "10.0.1.7"},
+		{"host", "b", ""},
+		{"missing", "b", ""},
+	} {
+		if _, err := Connect(test.network, test.container, "", nil, test.ip, nil); err == nil {
+			t.Errorf("Expected connecting %s to %s with address %q to fail", test.container, test.network, test.ip)
+		}
+	}
+
+	// A /29 holds the gateway and 5 containers
+	for _, id := range []string{"b", "c", "d", "e"} {
+		if _, err := Connect("front", id, "", nil, "", nil); err != nil {
+			t.Fatalf("Failed to connect %s: %v", id, err)
+		}
+	}
+	if _, err := Connect("front", "f", "", nil, "", nil); err == nil {
+		t.Error("Expected the subnet to be exhausted")
+	}
+
+	if err := Remove("front"); err == nil {
+		t.Error("Expected a network with endpoints not to be removable")
+	}
+
+	if err := DisconnectAll("a"); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if endpoints, _ := ContainerEndpoints("a"); len(endpoints) != 0 {
+		t.Errorf("Expected no endpoints left, got %d", len(endpoints))
+	}
+
+	// Freed addresses are reused
+	if ep, err = Connect("front", "f", "", nil, "", nil); err != nil || ep.IPAddress != "10.0.1.2" {
+		t.Errorf("Expected the freed address 10.0.1.2 to be reused, got %v", err)
+	}
+}
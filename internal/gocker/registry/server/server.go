@@ -0,0 +1,909 @@
+// Package server implements a registry serving the OCI distribution API from a directory.
+// Blobs are kept in the same content addressable layout as gocker's own blob store,
+// so a registry rooted at gocker's data directory shares its blobs with the local images.
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+const (
+	// RelativeRepositoriesPath is the path of the repositories under the registry root.
+	RelativeRepositoriesPath = "registry/repositories"
+	// RelativeUploadsPath is the path of the unfinished uploads under the registry root.
+	RelativeUploadsPath = "registry/uploads"
+
+	// maxManifestSize limits the size of pushed manifests.
+	maxManifestSize = 4 << 20
+	// defaultPageSize is the number of entries tag and catalog listings return without n.
+	defaultPageSize = 100
+)
+
+// Error codes of the distribution spec.
+const (
+	codeBlobUnknown         = "BLOB_UNKNOWN"
+	codeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	codeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	codeDigestInvalid       = "DIGEST_INVALID"
+	codeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	codeManifestInvalid     = "MANIFEST_INVALID"
+	codeManifestUnknown     = "MANIFEST_UNKNOWN"
+	codeNameInvalid         = "NAME_INVALID"
+	codeNameUnknown         = "NAME_UNKNOWN"
+	codePaginationInvalid   = "PAGINATION_NUMBER_INVALID"
+	codeTagInvalid          = "TAG_INVALID"
+	codeUnsupported         = "UNSUPPORTED"
+)
+
+// Registry is an http.Handler serving the OCI distribution API.
+//
+// Under its root directory it keeps:
+//
+//	blobs/sha256/<hex>                                         blob contents
+//	registry/repositories/<name>/_layers/<hex>                 blobs linked into a repository
+//	registry/repositories/<name>/_manifests/revisions/<hex>    manifests, holding their media type
+//	registry/repositories/<name>/_manifests/tags/<tag>         tags, holding a manifest digest
+//	registry/repositories/<name>/_referrers/<subject>/<hex>    descriptors of manifests referring to a subject
+//	registry/uploads/<id>                                      unfinished uploads
+type Registry struct {
+	root  string
+	blobs image.BlobStore
+
+	// mu serializes changes to repositories, so tags and links are never half written
+	mu sync.Mutex
+}
+
+// New returns a registry storing its data under the root directory.
+func New(root string) (*Registry, error) {
+	r := &Registry{
+		root:  root,
+		blobs: image.BlobStore{Dir: filepath.Join(root, "blobs", "sha256")},
+	}
+
+	for _, dir := range []string{r.blobs.Dir, filepath.Join(root, RelativeRepositoriesPath), filepath.Join(root, RelativeUploadsPath)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create registry directory: %v", err)
+		}
+	}
+
+	return r, nil
+}
+
+// ServeHTTP routes a request to the endpoint handling it.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		writeError(w, http.StatusNotFound, codeUnsupported, "not a distribution API path")
+		return
+	}
+
+	switch {
+	case path == "":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+
+	case path == "_catalog":
+		r.serveCatalog(w, req)
+
+	case strings.HasSuffix(path, "/tags/list"):
+		r.withRepository(w, req, strings.TrimSuffix(path, "/tags/list"), "", r.serveTags)
+
+	case strings.Contains(path, "/blobs/uploads/"):
+		i := strings.LastIndex(path, "/blobs/uploads/")
+		r.withRepository(w, req, path[:i], path[i+len("/blobs/uploads/"):], r.serveUpload)
+
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		r.withRepository(w, req, path[:i], path[i+len("/blobs/"):], r.serveBlob)
+
+	case strings.Contains(path, "/referrers/"):
+		i := strings.LastIndex(path, "/referrers/")
+		r.withRepository(w, req, path[:i], path[i+len("/referrers/"):], r.serveReferrers)
+
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		r.withRepository(w, req, path[:i], path[i+len("/manifests/"):], r.serveManifest)
+
+	default:
+		writeError(w, http.StatusNotFound, codeUnsupported, "unknown endpoint")
+	}
+}
+
+// withRepository validates the repository name of a request before passing it on.
+func (r *Registry) withRepository(w http.ResponseWriter, req *http.Request, name, ref string,
+	handler func(http.ResponseWriter, *http.Request, string, string)) {
+	if !image.ValidRepository(name) {
+		writeError(w, http.StatusBadRequest, codeNameInvalid, fmt.Sprintf("invalid repository name %q", name))
+		return
+	}
+
+	handler(w, req, name, ref)
+}
+
+// serveBlob serves the blob endpoints: fetching blobs, with ranges, and unlinking them.
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, name, digest string) {
+	if !image.ValidDigest(digest) {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("invalid digest %q", digest))
+		return
+	}
+
+	if !r.linked(name, digest) {
+		writeError(w, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		f, err := r.blobs.Open(digest)
+		if err != nil {
+			writeError(w, http.StatusNotFound, codeBlobUnknown, "blob unknown to registry")
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", digest)
+		// Blobs never change, so their digest is a perfect validator for resumed downloads
+		w.Header().Set("ETag", `"`+digest+`"`)
+
+		http.ServeContent(w, req, "", time.Time{}, f)
+
+	case http.MethodDelete:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if err := os.Remove(r.layerPath(name, digest)); err != nil {
+			writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+// serveUpload serves the upload endpoints. POST starts an upload, completes a monolithic
+// one or mounts a blob from another repository, PATCH appends a chunk, PUT completes the
+// upload, GET reports its progress and DELETE cancels it.
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, name, id string) {
+	query := req.URL.Query()
+
+	if req.Method == http.MethodPost {
+		if id != "" {
+			writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+			return
+		}
+
+		if digest, from := query.Get("mount"), query.Get("from"); digest != "" {
+			if image.ValidDigest(digest) && image.ValidRepository(from) && r.linked(from, digest) {
+				if err := r.link(name, digest); err != nil {
+					writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+					return
+				}
+
+				blobCreated(w, name, digest)
+				return
+			}
+			// Blobs that can't be mounted are uploaded the regular way
+		}
+
+		if digest := query.Get("digest"); digest != "" {
+			r.completeUpload(w, name, "", digest, req.Body)
+			return
+		}
+
+		id, err := r.createUpload()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+			return
+		}
+
+		uploadStatus(w, name, id, 0, http.StatusAccepted)
+		return
+	}
+
+	if !validUploadID(id) {
+		writeError(w, http.StatusNotFound, codeBlobUploadUnknown, "upload unknown to registry")
+		return
+	}
+
+	fi, err := os.Stat(r.uploadPath(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeBlobUploadUnknown, "upload unknown to registry")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		uploadStatus(w, name, id, fi.Size(), http.StatusNoContent)
+
+	case http.MethodPatch:
+		if rng := req.Header.Get("Content-Range"); rng != "" {
+			start, _, ok := parseContentRange(rng)
+			if !ok || start != fi.Size() {
+				w.Header().Set("Range", fmt.Sprintf("0-%d", fi.Size()-1))
+				writeError(w, http.StatusRequestedRangeNotSatisfiable, codeBlobUploadInvalid,
+					fmt.Sprintf("chunk %q doesn't continue the upload at %d", rng, fi.Size()))
+				return
+			}
+		}
+
+		size, err := appendUpload(r.uploadPath(id), req.Body)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+			return
+		}
+
+		uploadStatus(w, name, id, size, http.StatusAccepted)
+
+	case http.MethodPut:
+		digest := query.Get("digest")
+		if digest == "" {
+			writeError(w, http.StatusBadRequest, codeDigestInvalid, "digest parameter missing")
+			return
+		}
+
+		r.completeUpload(w, name, id, digest, req.Body)
+
+	case http.MethodDelete:
+		os.Remove(r.uploadPath(id))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+// completeUpload appends the final data to an upload, or to a new one if the id is empty,
+// then verifies its digest and moves it into the blob store.
+func (r *Registry) completeUpload(w http.ResponseWriter, name, id, digest string, body io.Reader) {
+	if !image.ValidDigest(digest) {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("invalid digest %q", digest))
+		return
+	}
+
+	if id == "" {
+		var err error
+		if id, err = r.createUpload(); err != nil {
+			writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+			return
+		}
+	}
+	defer os.Remove(r.uploadPath(id))
+
+	if _, err := appendUpload(r.uploadPath(id), body); err != nil {
+		writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+		return
+	}
+
+	f, err := os.Open(r.uploadPath(id))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+		return
+	}
+	defer f.Close()
+
+	// The upload is checked before it goes into the store, which never holds blobs nobody asked for
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+		return
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid,
+			fmt.Sprintf("upload has digest %s, not %s", actual, digest))
+		return
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+		return
+	}
+	if _, _, err := r.blobs.Write(f); err != nil {
+		writeError(w, http.StatusInternalServerError, codeBlobUploadInvalid, err.Error())
+		return
+	}
+
+	if err := r.link(name, digest); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	blobCreated(w, name, digest)
+}
+
+// serveManifest serves the manifest endpoints for references that are tags or digests.
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	isDigest := image.ValidDigest(ref)
+	if !isDigest && !image.ValidTag(ref) {
+		writeError(w, http.StatusBadRequest, codeTagInvalid, fmt.Sprintf("invalid reference %q", ref))
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		digest, mediaType, err := r.resolveManifest(name, ref)
+		if err != nil {
+			writeError(w, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+			return
+		}
+
+		f, err := r.blobs.Open(digest)
+		if err != nil {
+			writeError(w, http.StatusNotFound, codeManifestUnknown, "manifest unknown to registry")
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("ETag", `"`+digest+`"`)
+
+		http.ServeContent(w, req, "", time.Time{}, f)
+
+	case http.MethodPut:
+		r.putManifest(w, req, name, ref, isDigest)
+
+	case http.MethodDelete:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if err := r.deleteManifest(name, ref, isDigest); err != nil {
+			writeError(w, http.StatusNotFound, codeManifestUnknown, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+	}
+}
+
+// putManifest stores a manifest after checking that everything it refers to is in the repository.
+func (r *Registry) putManifest(w http.ResponseWriter, req *http.Request, name, ref string, isDigest bool) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxManifestSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, err.Error())
+		return
+	}
+	if len(data) > maxManifestSize {
+		writeError(w, http.StatusRequestEntityTooLarge, codeManifestInvalid, "manifest is too large")
+		return
+	}
+
+	var manifest struct {
+		MediaType    string                `json:"mediaType"`
+		ArtifactType string                `json:"artifactType"`
+		Config       *registry.Descriptor  `json:"config"`
+		Layers       []registry.Descriptor `json:"layers"`
+		Manifests    []registry.Descriptor `json:"manifests"`
+		Subject      *registry.Descriptor  `json:"subject"`
+		Annotations  map[string]string     `json:"annotations"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, fmt.Sprintf("failed to parse manifest: %v", err))
+		return
+	}
+
+	mediaType := req.Header.Get("Content-Type")
+	if mediaType == "" {
+		mediaType = manifest.MediaType
+	}
+	if mediaType == "" {
+		writeError(w, http.StatusBadRequest, codeManifestInvalid, "manifest media type unknown")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if isDigest && digest != ref {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("manifest has digest %s, not %s", digest, ref))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var blobs []registry.Descriptor
+	if manifest.Config != nil {
+		blobs = append(blobs, *manifest.Config)
+	}
+	for _, blob := range append(blobs, manifest.Layers...) {
+		if !r.linked(name, blob.Digest) {
+			writeError(w, http.StatusBadRequest, codeManifestBlobUnknown, fmt.Sprintf("blob %s unknown to repository", blob.Digest))
+			return
+		}
+	}
+	for _, m := range manifest.Manifests {
+		if _, err := os.Stat(r.revisionPath(name, m.Digest)); !image.ValidDigest(m.Digest) || err != nil {
+			writeError(w, http.StatusBadRequest, codeManifestBlobUnknown, fmt.Sprintf("manifest %s unknown to repository", m.Digest))
+			return
+		}
+	}
+
+	if _, _, err := r.blobs.Write(bytes.NewReader(data)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	if err := writeFile(r.revisionPath(name, digest), mediaType); err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	if !isDigest {
+		if err := writeFile(r.tagPath(name, ref), digest); err != nil {
+			writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+			return
+		}
+	}
+
+	// The subject doesn't have to exist, artifacts may be pushed before what they refer to
+	if manifest.Subject != nil && image.ValidDigest(manifest.Subject.Digest) {
+		referrer := registry.Descriptor{
+			MediaType:    mediaType,
+			Size:         int64(len(data)),
+			Digest:       digest,
+			ArtifactType: manifest.ArtifactType,
+			Annotations:  manifest.Annotations,
+		}
+		if referrer.ArtifactType == "" && manifest.Config != nil {
+			referrer.ArtifactType = manifest.Config.MediaType
+		}
+
+		descriptor, err := json.Marshal(referrer)
+		if err == nil {
+			err = writeFile(r.referrerPath(name, manifest.Subject.Digest, digest), string(descriptor))
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+			return
+		}
+
+		w.Header().Set("OCI-Subject", manifest.Subject.Digest)
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteManifest removes a tag, or a manifest along with the tags pointing to it.
+func (r *Registry) deleteManifest(name, ref string, isDigest bool) error {
+	if !isDigest {
+		if err := os.Remove(r.tagPath(name, ref)); err != nil {
+			return fmt.Errorf("tag %s unknown to repository", ref)
+		}
+		return nil
+	}
+
+	if err := os.Remove(r.revisionPath(name, ref)); err != nil {
+		return fmt.Errorf("manifest %s unknown to repository", ref)
+	}
+
+	referrers, _ := filepath.Glob(r.referrerPath(name, "*", ref))
+	for _, p := range referrers {
+		os.Remove(p)
+	}
+
+	tags, err := r.tags(name)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if digest, err := os.ReadFile(r.tagPath(name, tag)); err == nil && string(digest) == ref {
+			os.Remove(r.tagPath(name, tag))
+		}
+	}
+
+	return nil
+}
+
+// resolveManifest returns the digest and media type of the manifest a tag or digest refers to.
+func (r *Registry) resolveManifest(name, ref string) (string, string, error) {
+	digest := ref
+	if !image.ValidDigest(ref) {
+		data, err := os.ReadFile(r.tagPath(name, ref))
+		if err != nil {
+			return "", "", err
+		}
+		digest = string(data)
+	}
+
+	mediaType, err := os.ReadFile(r.revisionPath(name, digest))
+	if err != nil {
+		return "", "", err
+	}
+
+	return digest, string(mediaType), nil
+}
+
+// serveReferrers lists the manifests referring to a subject, optionally only those of an artifact type.
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, name, digest string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		return
+	}
+	if !image.ValidDigest(digest) {
+		writeError(w, http.StatusBadRequest, codeDigestInvalid, fmt.Sprintf("invalid digest %q", digest))
+		return
+	}
+
+	artifactType := req.URL.Query().Get("artifactType")
+
+	index := registry.ReferrersIndex{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{}}
+
+	entries, err := os.ReadDir(r.referrersPath(name, digest))
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		data, err := os.ReadFile(r.referrerPath(name, digest, e.Name()))
+		if err != nil {
+			continue
+		}
+
+		var referrer registry.Descriptor
+		if json.Unmarshal(data, &referrer) != nil || (artifactType != "" && referrer.ArtifactType != artifactType) {
+			continue
+		}
+
+		index.Manifests = append(index.Manifests, referrer)
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", registry.MediaTypeOCIIndex)
+	json.NewEncoder(w).Encode(index)
+}
+
+// serveTags lists the tags of a repository.
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name, _ string) {
+	if _, err := os.Stat(r.repositoryPath(name)); err != nil {
+		writeError(w, http.StatusNotFound, codeNameUnknown, fmt.Sprintf("repository %s unknown to registry", name))
+		return
+	}
+
+	tags, err := r.tags(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	page, ok := paginate(w, req, tags)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{name, page})
+}
+
+// serveCatalog lists the repositories of the registry.
+func (r *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, codeUnsupported, "method not allowed")
+		return
+	}
+
+	repos, err := r.repositories()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeUnsupported, err.Error())
+		return
+	}
+
+	page, ok := paginate(w, req, repos)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, struct {
+		Repositories []string `json:"repositories"`
+	}{page})
+}
+
+// paginate returns the page of the sorted entries the n and last parameters ask for,
+// setting the Link header when more entries follow.
+func paginate(w http.ResponseWriter, req *http.Request, entries []string) ([]string, bool) {
+	query := req.URL.Query()
+
+	n := defaultPageSize
+	if s := query.Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, codePaginationInvalid, fmt.Sprintf("invalid page size %q", s))
+			return nil, false
+		}
+	}
+
+	if last := query.Get("last"); last != "" {
+		i, _ := slices.BinarySearch(entries, last)
+		for i < len(entries) && entries[i] <= last {
+			i++
+		}
+		entries = entries[i:]
+	}
+
+	if len(entries) > n {
+		entries = entries[:n]
+
+		next := url.Values{"n": {strconv.Itoa(n)}}
+		if n > 0 {
+			next.Set("last", entries[n-1])
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, req.URL.Path, next.Encode()))
+	}
+
+	return append([]string{}, entries...), true
+}
+
+// tags returns the sorted tags of a repository.
+func (r *Registry) tags(name string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.repositoryPath(name), "_manifests", "tags"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read tags: %v", err)
+	}
+
+	var tags []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".tmp-") {
+			tags = append(tags, entry.Name())
+		}
+	}
+
+	return tags, nil
+}
+
+// repositories returns the sorted names of all repositories.
+func (r *Registry) repositories() ([]string, error) {
+	dir := filepath.Join(r.root, RelativeRepositoriesPath)
+
+	var repos []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Repository names never start with an underscore, the directories of a repository do
+		if !d.IsDir() || !strings.HasPrefix(d.Name(), "_") {
+			return nil
+		}
+
+		if name, err := filepath.Rel(dir, filepath.Dir(p)); err == nil && !slices.Contains(repos, filepath.ToSlash(name)) {
+			repos = append(repos, filepath.ToSlash(name))
+		}
+
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repositories: %v", err)
+	}
+
+	slices.Sort(repos)
+
+	return repos, nil
+}
+
+// linked reports whether the blob is part of the repository.
+func (r *Registry) linked(name, digest string) bool {
+	if !image.ValidDigest(digest) || !r.blobs.Has(digest) {
+		return false
+	}
+
+	_, err := os.Stat(r.layerPath(name, digest))
+
+	return err == nil
+}
+
+// link makes a stored blob part of the repository.
+func (r *Registry) link(name, digest string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return writeFile(r.layerPath(name, digest), "")
+}
+
+// createUpload starts a new, empty upload and returns its id.
+func (r *Registry) createUpload() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create upload id: %v", err)
+	}
+	id := hex.EncodeToString(b)
+
+	f, err := os.OpenFile(r.uploadPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload: %v", err)
+	}
+
+	return id, f.Close()
+}
+
+// appendUpload appends data to an upload and returns the new size of the upload.
+func appendUpload(path string, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return 0, fmt.Errorf("failed to write upload: %v", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), f.Close()
+}
+
+func (r *Registry) repositoryPath(name string) string {
+	return filepath.Join(r.root, RelativeRepositoriesPath, filepath.FromSlash(name))
+}
+
+func (r *Registry) layerPath(name, digest string) string {
+	return filepath.Join(r.repositoryPath(name), "_layers", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (r *Registry) revisionPath(name, digest string) string {
+	return filepath.Join(r.repositoryPath(name), "_manifests", "revisions", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (r *Registry) tagPath(name, tag string) string {
+	return filepath.Join(r.repositoryPath(name), "_manifests", "tags", tag)
+}
+
+func (r *Registry) referrersPath(name, subject string) string {
+	return filepath.Join(r.repositoryPath(name), "_referrers", strings.TrimPrefix(subject, "sha256:"))
+}
+
+func (r *Registry) referrerPath(name, subject, digest string) string {
+	return filepath.Join(r.referrersPath(name, subject), strings.TrimPrefix(digest, "sha256:"))
+}
+
+func (r *Registry) uploadPath(id string) string {
+	return filepath.Join(r.root, RelativeUploadsPath, id)
+}
+
+// validUploadID reports whether the id is one the registry could have handed out.
+func validUploadID(id string) bool {
+	_, err := hex.DecodeString(id)
+
+	return err == nil && len(id) == 32
+}
+
+// parseContentRange parses the "start-end" ranges of chunked uploads.
+func parseContentRange(s string) (int64, int64, bool) {
+	startStr, endStr, ok := strings.Cut(strings.TrimPrefix(s, "bytes="), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+
+	return start, end, err1 == nil && err2 == nil && start <= end
+}
+
+// writeFile atomically replaces a file, creating its parent directories.
+func writeFile(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// blobCreated answers a finished upload or mount.
+func blobCreated(w http.ResponseWriter, name, digest string) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// uploadStatus answers a request that leaves an upload open with its progress.
+func uploadStatus(w http.ResponseWriter, name, id string, size int64, status int) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+// writeJSON writes a JSON response body.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response in the format of the distribution spec.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	type entry struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Errors []entry `json:"errors"`
+	}{[]entry{{code, message}}})
+}
+
+// DefaultRoot returns the root directory registries use by default, gocker's data directory.
+func DefaultRoot() string {
+	return filepath.Join(os.Getenv("HOME"), ".local/share/gocker")
+}
+
+// ReferencedBlobs returns the digests of the blobs and manifests the repositories under the root directory hold.
+func ReferencedBlobs(root string) (map[string]bool, error) {
+	r := &Registry{root: root}
+	referenced := make(map[string]bool)
+
+	if _, err := os.Stat(filepath.Join(root, RelativeRepositoriesPath)); os.IsNotExist(err) {
+		return referenced, nil
+	}
+
+	repos, err := r.repositories()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range repos {
+		for _, dir := range []string{filepath.Join(r.repositoryPath(name), "_layers"), filepath.Join(r.repositoryPath(name), "_manifests", "revisions")} {
+			entries, err := os.ReadDir(dir)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read repository %s: %v", name, err)
+			}
+
+			for _, e := range entries {
+				referenced["sha256:"+e.Name()] = true
+			}
+		}
+	}
+
+	return referenced, nil
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/idmap"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+	gockerhttp "github.com/z1z0v1c/gclone/pkg/http"
+)
+
+// newTestServer starts a registry in a temporary directory.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	reg, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	server := httptest.NewServer(reg)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// request sends a request to the test server and returns the response with its body read.
+func request(t *testing.T, method, url string, body []byte, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, data
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// TestPushAndPull tests that images pushed by the client can be pulled back
+func TestPushAndPull(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	server := newTestServer(t)
+	name := strings.TrimPrefix(server.URL, "http://") + "/team/app:v1"
+
+	rootfs, err := image.TempDir("rootfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := image.TakeSnapshot(rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "hostname"), []byte("app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := &idmap.Mappings{
+		UIDs: []idmap.Mapping{{ContainerID: 0, HostID: 0, Size: 65536}},
+		GIDs: []idmap.Mapping{{ContainerID: 0, HostID: 0, Size: 65536}},
+	}
+	layer, diffID, err := image.WriteLayer(rootfs, before, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &registry.ImageConfig{Architecture: "amd64", Os: "linux"}
+	cfg.Rootfs.DiffIds = []string{diffID}
+	if _, err := image.Save(name, rootfs, cfg, []registry.Descriptor{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := image.NewClient(name, gockerhttp.NewHttpClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	_, manifest, err := image.LoadManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := request(t, http.MethodGet, server.URL+"/v2/team/app/manifests/v1", nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, manifest) {
+		t.Fatalf("Expected the pushed manifest, got status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Docker-Content-Digest"); got != digestOf(manifest) {
+		t.Errorf("Expected digest %s, got %s", digestOf(manifest), got)
+	}
+
+	os.RemoveAll(image.Path(name))
+
+	if c, err = image.NewClient(name, gockerhttp.NewHttpClient()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pull(); err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(image.Path(name), "rootfs", "etc", "hostname"))
+	if err != nil || string(data) != "app\n" {
+		t.Errorf("Expected the pulled image to contain the pushed files, got %q (%v)", data, err)
+	}
+}
+
+// TestChunkedUpload tests uploads in chunks, rejected out of order chunks and digest checks
+func TestChunkedUpload(t *testing.T) {
+	server := newTestServer(t)
+	blob := []byte("hello, chunked world")
+	digest := digestOf(blob)
+
+	resp, _ := request(t, http.MethodPost, server.URL+"/v2/lib/app/blobs/uploads/", nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the upload to start, got status %d", resp.StatusCode)
+	}
+	location := server.URL + resp.Header.Get("Location")
+
+	resp, _ = request(t, http.MethodPatch, location, blob[:5], "Content-Range", "0-4")
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Range") != "0-4" {
+		t.Fatalf("Expected the first chunk to be accepted, got status %d range %q", resp.StatusCode, resp.Header.Get("Range"))
+	}
+
+	resp, _ = request(t, http.MethodPatch, location, blob[10:], "Content-Range", "10-19")
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected a chunk leaving a gap to be rejected, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodPatch, location, blob[5:10], "Content-Range", "5-9")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the second chunk to be accepted, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodGet, location, nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Range") != "0-9" {
+		t.Errorf("Expected the upload status to report 10 bytes, got status %d range %q", resp.StatusCode, resp.Header.Get("Range"))
+	}
+
+	resp, body := request(t, http.MethodPut, location+"?digest="+digestOf([]byte("other")), blob[10:])
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), codeDigestInvalid) {
+		t.Errorf("Expected a wrong digest to be rejected, got status %d: %s", resp.StatusCode, body)
+	}
+
+	// A failed completion ends the upload, so the blob is uploaded again in one piece
+	resp, _ = request(t, http.MethodPost, server.URL+"/v2/lib/app/blobs/uploads/?digest="+digest, blob)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Docker-Content-Digest") != digest {
+		t.Fatalf("Expected the monolithic upload to succeed, got status %d", resp.StatusCode)
+	}
+
+	resp, body = request(t, http.MethodGet, server.URL+"/v2/lib/app/blobs/"+digest, nil, "Range", "bytes=7-")
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "chunked world" {
+		t.Errorf("Expected a range of the blob, got status %d: %q", resp.StatusCode, body)
+	}
+
+	resp, _ = request(t, http.MethodHead, server.URL+"/v2/other/app/blobs/"+digest, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected blobs to be private to their repository, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodPost, server.URL+"/v2/other/app/blobs/uploads/?mount="+digest+"&from=lib/app", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected the blob to be mounted, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodHead, server.URL+"/v2/other/app/blobs/"+digest, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the mounted blob to exist, got status %d", resp.StatusCode)
+	}
+}
+
+// TestManifestsAndListings tests manifest validation, tag and catalog listings and deletion
+func TestManifestsAndListings(t *testing.T) {
+	server := newTestServer(t)
+
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	manifest, err := json.Marshal(registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeOCIConfig, Size: int64(len(config)), Digest: digestOf(config)},
+		Layers:        []registry.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(repo, ref string) *http.Response {
+		resp, _ := request(t, http.MethodPut, fmt.Sprintf("%s/v2/%s/manifests/%s", server.URL, repo, ref), manifest,
+			"Content-Type", registry.MediaTypeOCIManifest)
+		return resp
+	}
+
+	if resp := put("web", "latest"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a manifest with unknown blobs to be rejected, got status %d", resp.StatusCode)
+	}
+
+	for _, repo := range []string{"web", "team/api", "db"} {
+		request(t, http.MethodPost, server.URL+"/v2/"+repo+"/blobs/uploads/?digest="+digestOf(config), config)
+	}
+	for _, tag := range []string{"v3", "v1", "latest", "v2"} {
+		if resp := put("web", tag); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected tag %s to be pushed, got status %d", tag, resp.StatusCode)
+		}
+	}
+	put("team/api", "1.0")
+	put("db", digestOf(manifest))
+
+	var tags struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	resp, body := request(t, http.MethodGet, server.URL+"/v2/web/tags/list?n=2", nil)
+	if err := json.Unmarshal(body, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tags.Tags, []string{"latest", "v1"}) || !strings.Contains(resp.Header.Get("Link"), "last=v1") {
+		t.Errorf("Expected the first page of tags with a link to the next, got %v and %q", tags.Tags, resp.Header.Get("Link"))
+	}
+
+	_, body = request(t, http.MethodGet, server.URL+"/v2/web/tags/list?n=2&last=v1", nil)
+	if err := json.Unmarshal(body, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tags.Tags, []string{"v2", "v3"}) {
+		t.Errorf("Expected the second page of tags, got %v", tags.Tags)
+	}
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	_, body = request(t, http.MethodGet, server.URL+"/v2/_catalog", nil)
+	if err := json.Unmarshal(body, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(catalog.Repositories, []string{"db", "team/api", "web"}) {
+		t.Errorf("Expected all repositories in the catalog, got %v", catalog.Repositories)
+	}
+
+	resp, _ = request(t, http.MethodHead, server.URL+"/v2/db/manifests/"+digestOf(manifest), nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != registry.MediaTypeOCIManifest {
+		t.Errorf("Expected the manifest pushed by digest with its media type, got status %d", resp.StatusCode)
+	}
+
+	// Deleting the manifest removes every tag pointing to it
+	resp, _ = request(t, http.MethodDelete, server.URL+"/v2/web/manifests/"+digestOf(manifest), nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the manifest to be deleted, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodGet, server.URL+"/v2/web/manifests/v1", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the tags of a deleted manifest to be gone, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = request(t, http.MethodGet, server.URL+"/v2/Invalid/manifests/v1", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid repository name to be rejected, got status %d", resp.StatusCode)
+	}
+}
+
+// TestReferrers tests listing the manifests referring to a subject, like the signatures of an image
+func TestReferrers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	server := newTestServer(t)
+
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	empty := []byte("{}")
+	for _, blob := range [][]byte{config, empty} {
+		request(t, http.MethodPost, server.URL+"/v2/web/blobs/uploads/?digest="+digestOf(blob), blob)
+	}
+
+	put := func(ref string, manifest registry.Manifest) (*http.Response, string) {
+		t.Helper()
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref == "" {
+			ref = digestOf(data)
+		}
+
+		resp, _ := request(t, http.MethodPut, server.URL+"/v2/web/manifests/"+ref, data, "Content-Type", registry.MediaTypeOCIManifest)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected manifest %s to be pushed, got status %d", ref, resp.StatusCode)
+		}
+
+		return resp, digestOf(data)
+	}
+
+	_, subject := put("v1", registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeOCIConfig, Size: int64(len(config)), Digest: digestOf(config)},
+		Layers:        []registry.Descriptor{},
+	})
+
+	// An artifact without an artifact type is listed with the media type of its config
+	const sbomType = "application/spdx+json"
+	resp, sbom := put("", registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        registry.Descriptor{MediaType: sbomType, Size: int64(len(empty)), Digest: digestOf(empty)},
+		Layers:        []registry.Descriptor{},
+		Subject:       &registry.Descriptor{MediaType: registry.MediaTypeOCIManifest, Digest: subject},
+	})
+	if resp.Header.Get("OCI-Subject") != subject {
+		t.Errorf("Expected the registry to acknowledge the subject, got %q", resp.Header.Get("OCI-Subject"))
+	}
+
+	// Signatures are pushed by the client
+	keys := t.TempDir()
+	if err := image.GenerateKeyPair(filepath.Join(keys, "cosign.key"), filepath.Join(keys, "cosign.pub")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := image.LoadPrivateKey(filepath.Join(keys, "cosign.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := image.NewClient(strings.TrimPrefix(server.URL, "http://")+"/web:v1", gockerhttp.NewHttpClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed, err := c.Sign(key); err != nil || signed != subject {
+		t.Fatalf("Expected v1 to be signed, got %s (%v)", signed, err)
+	}
+
+	list := func(query string) []registry.Descriptor {
+		t.Helper()
+
+		resp, body := request(t, http.MethodGet, server.URL+"/v2/web/referrers/"+subject+query, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != registry.MediaTypeOCIIndex {
+			t.Fatalf("Expected a referrers index, got status %d", resp.StatusCode)
+		}
+
+		var index registry.ReferrersIndex
+		if err := json.Unmarshal(body, &index); err != nil {
+			t.Fatal(err)
+		}
+
+		return index.Manifests
+	}
+
+	referrers := list("")
+	if len(referrers) != 2 {
+		t.Fatalf("Expected the SBOM and the signature to refer to v1, got %v", referrers)
+	}
+
+	signatures := list("?artifactType=" + url.QueryEscape(registry.ArtifactTypeCosignSignature))
+	if len(signatures) != 1 || signatures[0].Digest == sbom {
+		t.Errorf("Expected only the signature of the artifact type, got %v", signatures)
+	}
+
+	sboms := list("?artifactType=" + url.QueryEscape(sbomType))
+	if len(sboms) != 1 || sboms[0].Digest != sbom {
+		t.Errorf("Expected only the SBOM of the artifact type, got %v", sboms)
+	}
+
+	if got := list("?artifactType=none"); len(got) != 0 {
+		t.Errorf("Expected no referrers of an unknown artifact type, got %v", got)
+	}
+
+	// Deleted artifacts no longer refer to the subject
+	resp, _ = request(t, http.MethodDelete, server.URL+"/v2/web/manifests/"+sbom, nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the SBOM to be deleted, got status %d", resp.StatusCode)
+	}
+	if got := list(""); len(got) != 1 || got[0].Digest == sbom {
+		t.Errorf("Expected only the signature to remain, got %v", got)
+	}
+
+	resp, _ = request(t, http.MethodGet, server.URL+"/v2/web/referrers/latest", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected referrers of a tag to be rejected, got status %d", resp.StatusCode)
+	}
+
+	// The signature pushed through the referrers API verifies
+	policy := filepath.Join(keys, "policy.json")
+	data := fmt.Sprintf(`{"default": [{"type": "sigstoreSigned", "keyPath": %q}]}`, filepath.Join(keys, "cosign.pub"))
+	if err := os.WriteFile(policy, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetSignaturePolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Pull(); err != nil {
+		t.Errorf("Expected the signed image to be pulled, got %v", err)
+	}
+}
@@ -1,27 +1,61 @@
 package registry
 
+import "time"
+
 const URL = "registry-1.docker.io"
 
+// Media types of the manifests, configs and layers handled by gocker.
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	MediaTypeOCIUncompressedLayer = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCIEmpty             = "application/vnd.oci.empty.v1+json"
+
+	// ArtifactTypeCosignSignature is the artifact type of cosign signature manifests
+	ArtifactTypeCosignSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// MediaTypeSimpleSigning is the media type of the signed payloads of cosign signatures
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+)
+
 // AuthResponse represents the token response from the Docker Registry auth API.
 type AuthResponse struct {
-	Token string
+	Token       string
+	AccessToken string `json:"access_token"`
+}
+
+// Descriptor describes a blob by its media type, size and digest.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 // Manifest represents a platform-specific image manifest (schema v2).
 // It includes metadata about the config blob and image layers.
+// Artifacts like signatures refer to the manifest they belong to as their subject.
 type Manifest struct {
-	SchemaVersion int
-	MediaType     string
-	Config        struct {
-		MediaType string
-		Size      int
-		Digest    string
-	}
-	Layers []struct {
-		MediaType string
-		Size      int
-		Digest    string
-	}
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ReferrersIndex is the image index listing the manifests that refer to a subject.
+type ReferrersIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
 }
 
 // ManifestIndex represents a manifest list (multi-platform index).
@@ -51,30 +85,51 @@ type Config struct {
 	StdinOnce    bool                `json:"StdinOnce,omitempty"`
 	Env          []string            `json:"Env,omitempty"`
 	Cmd          []string            `json:"Cmd,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"`
 	Image        string              `json:"Image,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
 	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
 	WorkingDir   string              `json:"WorkingDir,omitempty"`
 	Entrypoint   []string            `json:"Entrypoint,omitempty"`
 	OnBuild      []string            `json:"OnBuild,omitempty"`
 	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// HealthConfig describes how to check that a container is still working.
+type HealthConfig struct {
+	// Test is ["NONE"] to disable the check inherited from the base image,
+	// ["CMD", args...] to run a command or ["CMD-SHELL", command] to run it with the shell
+	Test []string `json:"Test,omitempty"`
+
+	// Zero values mean the defaults
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// History describes the instruction that created a layer of an image.
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
 }
 
 // ImageConfig represents the full image configuration.
 type ImageConfig struct {
-	Architecture    string `json:"architecture,omitempty"`
-	Config          Config
-	Container       string `json:"container,omitempty"`
-	ContainerConfig Config `json:"container_config"`
-	Created         string `json:"created,omitempty"`
-	DockerVersion   string `json:"docker_version,omitempty"`
-	History         []struct {
-		Created    string `json:"created,omitempty"`
-		CreatedBy  string `json:"created_by,omitempty"`
-		EmptyLayer bool   `json:"empty_layer,omitempty"`
-	}
-	Os     string `json:"os,omitempty"`
-	Rootfs struct {
+	Architecture    string    `json:"architecture,omitempty"`
+	Author          string    `json:"author,omitempty"`
+	Config          Config    `json:"config"`
+	Container       string    `json:"container,omitempty"`
+	ContainerConfig Config    `json:"container_config"`
+	Created         string    `json:"created,omitempty"`
+	DockerVersion   string    `json:"docker_version,omitempty"`
+	History         []History `json:"history,omitempty"`
+	Os              string    `json:"os,omitempty"`
+	Rootfs          struct {
 		Type    string   `json:"type,omitempty"`
 		DiffIds []string `json:"diff_ids,omitempty"`
-	}
+	} `json:"rootfs"`
 }
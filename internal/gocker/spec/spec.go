@@ -0,0 +1,339 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Version is the version of the OCI runtime specification gocker implements
+	Version = "1.0.2"
+
+	// ConfigFile is the name of the configuration file of a bundle
+	ConfigFile = "config.json"
+)
+
+// Statuses of containers in the OCI state.
+const (
+	StatusCreating = "creating"
+	StatusCreated  = "created"
+	StatusRunning  = "running"
+	StatusStopped  = "stopped"
+)
+
+// Spec is the configuration of an OCI bundle, the subset of the runtime specification gocker supports.
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       *Hooks            `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process is the process started in the container.
+type Process struct {
+	Terminal        bool          `json:"terminal,omitempty"`
+	User            User          `json:"user"`
+	Args            []string      `json:"args"`
+	Env             []string      `json:"env,omitempty"`
+	Cwd             string        `json:"cwd"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
+	Rlimits         []Rlimit      `json:"rlimits,omitempty"`
+	NoNewPrivileges bool          `json:"noNewPrivileges,omitempty"`
+}
+
+// User is the user the process runs as, by numeric IDs in the container.
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// Capabilities are the capability sets of the process.
+type Capabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// Rlimit is a resource limit of the process, like RLIMIT_NOFILE.
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root is the root filesystem of the container, with a path relative to the bundle or absolute.
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount is a filesystem mounted in the container.
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Hooks are the commands run at the points of the lifecycle of the container.
+type Hooks struct {
+	// Prestart hooks run like CreateRuntime hooks, they're deprecated in their favor
+	Prestart []Hook `json:"prestart,omitempty"`
+	// CreateRuntime hooks run in the runtime namespaces once the container namespaces exist
+	CreateRuntime []Hook `json:"createRuntime,omitempty"`
+	// CreateContainer hooks run in the container namespaces before the root is changed
+	CreateContainer []Hook `json:"createContainer,omitempty"`
+	// StartContainer hooks run in the container before its process is started
+	StartContainer []Hook `json:"startContainer,omitempty"`
+	// Poststart hooks run in the runtime namespaces once the process is started
+	Poststart []Hook `json:"poststart,omitempty"`
+	// Poststop hooks run in the runtime namespaces once the container is deleted
+	Poststop []Hook `json:"poststop,omitempty"`
+}
+
+// Hook is a command run with the state of the container on its standard input.
+type Hook struct {
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+	Env  []string `json:"env,omitempty"`
+	// Timeout is the number of seconds the hook may run, unlimited if nil
+	Timeout *int `json:"timeout,omitempty"`
+}
+
+// Linux holds the Linux specific settings of the container.
+type Linux struct {
+	UIDMappings   []IDMapping       `json:"uidMappings,omitempty"`
+	GIDMappings   []IDMapping       `json:"gidMappings,omitempty"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+	Resources     *Resources        `json:"resources,omitempty"`
+	Namespaces    []Namespace       `json:"namespaces,omitempty"`
+	Devices       []Device          `json:"devices,omitempty"`
+	Seccomp       *Seccomp          `json:"seccomp,omitempty"`
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+}
+
+// IDMapping maps a range of container IDs onto host IDs.
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// Namespace is a namespace of the container, joined by path if it has one.
+type Namespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// Namespace types.
+const (
+	PIDNamespace     = "pid"
+	NetworkNamespace = "network"
+	MountNamespace   = "mount"
+	IPCNamespace     = "ipc"
+	UTSNamespace     = "uts"
+	UserNamespace    = "user"
+	CgroupNamespace  = "cgroup"
+	TimeNamespace    = "time"
+)
+
+// Resources are the cgroup limits of the container.
+type Resources struct {
+	Memory *Memory `json:"memory,omitempty"`
+	CPU    *CPU    `json:"cpu,omitempty"`
+	Pids   *Pids   `json:"pids,omitempty"`
+}
+
+// Memory is the memory limit in bytes.
+type Memory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// CPU is the CPU quota of the container per period, both in microseconds.
+type CPU struct {
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+// Pids is the maximum number of processes.
+type Pids struct {
+	Limit int64 `json:"limit"`
+}
+
+// Device is a device node available in the container.
+type Device struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+}
+
+// Seccomp is the syscall filter of the container.
+type Seccomp struct {
+	DefaultAction   string    `json:"defaultAction"`
+	DefaultErrnoRet *uint32   `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	Syscalls        []Syscall `json:"syscalls,omitempty"`
+}
+
+// Syscall is a seccomp rule applying an action to a group of syscalls.
+type Syscall struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet *uint32      `json:"errnoRet,omitempty"`
+	Args     []SeccompArg `json:"args,omitempty"`
+}
+
+// SeccompArg is a condition on a syscall argument.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// State is the state of a container as reported by gocker runtime state.
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Load reads the configuration of the bundle in the given directory.
+func Load(bundle string) (*Spec, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, ConfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle config: %v", err)
+	}
+
+	var s Spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle config: %v", err)
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid bundle config: %v", err)
+	}
+
+	return &s, nil
+}
+
+// validate checks the settings every container needs.
+func (s *Spec) validate() error {
+	if s.Version == "" {
+		return fmt.Errorf("ociVersion is required")
+	}
+
+	if s.Root == nil || s.Root.Path == "" {
+		return fmt.Errorf("root path is required")
+	}
+
+	if s.Process == nil || len(s.Process.Args) == 0 {
+		return fmt.Errorf("process args are required")
+	}
+
+	if !filepath.IsAbs(s.Process.Cwd) {
+		return fmt.Errorf("process cwd must be an absolute path: %q", s.Process.Cwd)
+	}
+
+	for _, m := range s.Mounts {
+		if !filepath.IsAbs(m.Destination) {
+			return fmt.Errorf("mount destination must be an absolute path: %q", m.Destination)
+		}
+	}
+
+	if s.Hooks != nil {
+		for _, hooks := range [][]Hook{s.Hooks.Prestart, s.Hooks.CreateRuntime, s.Hooks.CreateContainer,
+			s.Hooks.StartContainer, s.Hooks.Poststart, s.Hooks.Poststop} {
+			for _, h := range hooks {
+				if !filepath.IsAbs(h.Path) {
+					return fmt.Errorf("hook path must be an absolute path: %q", h.Path)
+				}
+				if h.Timeout != nil && *h.Timeout <= 0 {
+					return fmt.Errorf("hook timeout must be positive: %d", *h.Timeout)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Save writes the configuration into the bundle in the given directory.
+func (s *Spec) Save(bundle string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bundle config: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(bundle, ConfigFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write bundle config: %v", err)
+	}
+
+	return nil
+}
+
+// Default returns the configuration gocker spec writes, a shell in a read-only rootfs
+// directory of the bundle with the default capabilities, run in private namespaces
+// with the given user namespace mappings.
+func Default(uidMappings, gidMappings []IDMapping, capabilities []string) *Spec {
+	return &Spec{
+		Version: Version,
+		Process: &Process{
+			Args: []string{"sh"},
+			Env:  []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "TERM=xterm"},
+			Cwd:  "/",
+			Capabilities: &Capabilities{
+				Bounding:  capabilities,
+				Effective: capabilities,
+				Permitted: capabilities,
+			},
+			Rlimits:         []Rlimit{{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024}},
+			NoNewPrivileges: true,
+		},
+		Root:     &Root{Path: "rootfs", Readonly: true},
+		Hostname: "gocker",
+		Mounts: []Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		},
+		Linux: &Linux{
+			UIDMappings: uidMappings,
+			GIDMappings: gidMappings,
+			Namespaces: []Namespace{
+				{Type: PIDNamespace},
+				{Type: NetworkNamespace},
+				{Type: IPCNamespace},
+				{Type: UTSNamespace},
+				{Type: MountNamespace},
+				{Type: UserNamespace},
+				{Type: CgroupNamespace},
+			},
+			MaskedPaths: []string{
+				"/proc/acpi", "/proc/asound", "/proc/kcore", "/proc/keys", "/proc/latency_stats",
+				"/proc/timer_list", "/proc/timer_stats", "/proc/sched_debug", "/proc/scsi",
+				"/sys/firmware", "/sys/devices/virtual/powercap",
+			},
+			ReadonlyPaths: []string{
+				"/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+			},
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestSaveLoad tests that the default config is saved and loaded unchanged
+func TestSaveLoad(t *testing.T) {
+	bundle := t.TempDir()
+	mappings := []IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}
+
+	s := Default(mappings, mappings, []string{"CAP_CHOWN"})
+	if err := s.Save(bundle); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	loaded, err := Load(bundle)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !reflect.DeepEqual(s, loaded) {
+		t.Errorf("Expected the loaded config to be %+v, got %+v", s, loaded)
+	}
+}
+
+// TestLoadInvalid tests that configs missing required settings are rejected
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no version":       `{"root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}}`,
+		"no root":          `{"ociVersion": "1.0.2", "process": {"args": ["sh"], "cwd": "/"}}`,
+		"no args":          `{"ociVersion": "1.0.2", "root": {"path": "rootfs"}, "process": {"cwd": "/"}}`,
+		"relative cwd":     `{"ociVersion": "1.0.2", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "tmp"}}`,
+		"relative mount":   `{"ociVersion": "1.0.2", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}, "mounts": [{"destination": "data"}]}`,
+		"relative hook":    `{"ociVersion": "1.0.2", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}, "hooks": {"prestart": [{"path": "hook"}]}}`,
+		"zero timeout":     `{"ociVersion": "1.0.2", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}, "hooks": {"poststop": [{"path": "/hook", "timeout": 0}]}}`,
+		"malformed config": `{"ociVersion": `,
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			bundle := t.TempDir()
+			if err := os.WriteFile(filepath.Join(bundle, ConfigFile), []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Load(bundle); err == nil {
+				t.Errorf("Expected config %s to be rejected", config)
+			}
+		})
+	}
+}
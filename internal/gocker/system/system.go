@@ -0,0 +1,358 @@
+// Package system reports and reclaims the disk space used by gocker's data directory.
+//
+// Space is reclaimed with a mark-and-sweep over the blob store: the blobs still in use by
+// local images, the build cache and a registry serving the data directory are marked,
+// and every other blob is swept.
+package system
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/build"
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry/server"
+)
+
+const (
+	// blobGracePeriod protects unreferenced blobs written recently, a pull writes
+	// the blobs of an image before the manifest that refers to them.
+	blobGracePeriod = time.Hour
+	// tmpGracePeriod protects the temporary files of pulls and builds still running.
+	tmpGracePeriod = 24 * time.Hour
+)
+
+// Usage is the disk usage of one type of data.
+type Usage struct {
+	Type string
+	// Total is the number of objects of the type
+	Total int
+	// Active is the number of objects in use
+	Active int
+	// Size is the space the objects take up
+	Size int64
+	// Reclaimable is the space pruning would free
+	Reclaimable int64
+}
+
+// PruneOptions configures what Prune removes.
+type PruneOptions struct {
+	// All removes every image no container uses instead of only dangling ones
+	All bool
+}
+
+// PruneReport lists what Prune removed.
+type PruneReport struct {
+	Containers []string
+	// Untagged holds the names of the removed images
+	Untagged []string
+	// Blobs holds the digests of the swept blobs
+	Blobs     []string
+	CacheKeys []string
+	Reclaimed int64
+}
+
+// ReferencedBlobs marks the blobs in use by local images and by the repositories of a registry
+// serving gocker's data directory. The build cache is left out, its layers can always be rebuilt.
+func ReferencedBlobs() (map[string]bool, error) {
+	referenced, err := image.ReferencedBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	registryBlobs, err := server.ReferencedBlobs(server.DefaultRoot())
+	if err != nil {
+		return nil, err
+	}
+
+	for digest := range registryBlobs {
+		referenced[digest] = true
+	}
+
+	return referenced, nil
+}
+
+// DiskUsage reports the disk usage of images, their layers, containers, volumes and the build cache.
+// Layers cover the whole blob store, image configs and manifests included.
+func DiskUsage() ([]Usage, error) {
+	images, err := image.List()
+	if err != nil {
+		return nil, err
+	}
+
+	states, err := container.ListStates()
+	if err != nil {
+		return nil, err
+	}
+
+	imageUsage := Usage{Type: "Images", Total: len(images)}
+	for _, img := range images {
+		imageUsage.Size += img.Size
+
+		if usedImage(img, states) {
+			imageUsage.Active++
+		} else {
+			imageUsage.Reclaimable += img.Size
+		}
+	}
+
+	containerUsage := Usage{Type: "Containers", Total: len(states)}
+	for _, s := range states {
+		size := dirSize(containerDir(s.ID))
+		containerUsage.Size += size
+
+		if s.IsRunning() {
+			containerUsage.Active++
+		} else {
+			containerUsage.Reclaimable += size
+		}
+	}
+
+	inUse, err := ReferencedBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, cached, err := build.CachedLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheUsage := Usage{Type: "Build Cache", Total: entries}
+	layerUsage := Usage{Type: "Layers"}
+
+	err = walkBlobs(func(digest string, fi fs.FileInfo) error {
+		layerUsage.Total++
+		layerUsage.Size += fi.Size()
+
+		switch {
+		case inUse[digest]:
+			layerUsage.Active++
+		case cached[digest]:
+			// Layers only the build cache refers to are accounted to the cache
+			layerUsage.Active++
+			cacheUsage.Size += fi.Size()
+		default:
+			layerUsage.Reclaimable += fi.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cacheUsage.Reclaimable = cacheUsage.Size
+
+	// gocker keeps no volumes, the row is there for parity with docker
+	volumeUsage := Usage{Type: "Local Volumes"}
+
+	return []Usage{imageUsage, layerUsage, containerUsage, volumeUsage, cacheUsage}, nil
+}
+
+// Prune removes stopped containers, the images no container uses, the build cache,
+// the blobs nothing refers to anymore and stale temporary files.
+func Prune(opts PruneOptions) (*PruneReport, error) {
+	report := &PruneReport{}
+
+	states, err := container.ListStates()
+	if err != nil {
+		return report, err
+	}
+
+	var remaining []*container.State
+	for _, s := range states {
+		if s.IsRunning() {
+			remaining = append(remaining, s)
+			continue
+		}
+
+		size := dirSize(containerDir(s.ID))
+		if err := container.Remove(s.ID, false); err != nil {
+			return report, err
+		}
+
+		report.Containers = append(report.Containers, s.ID)
+		report.Reclaimed += size
+	}
+
+	images, err := image.List()
+	if err != nil {
+		return report, err
+	}
+
+	for _, img := range images {
+		if usedImage(img, remaining) || (!opts.All && !img.Dangling()) {
+			continue
+		}
+
+		size := dirSize(image.Path(img.Name))
+		if err := image.Remove(img.Name); err != nil {
+			return report, err
+		}
+
+		if !img.Dangling() {
+			report.Untagged = append(report.Untagged, img.Name)
+		}
+		report.Reclaimed += size
+	}
+
+	referenced, err := ReferencedBlobs()
+	if err != nil {
+		return report, err
+	}
+
+	keys, reclaimed, err := build.Prune(referenced)
+	report.CacheKeys = keys
+	report.Reclaimed += reclaimed
+	if err != nil {
+		return report, err
+	}
+
+	// Builds running meanwhile may have cached new layers
+	_, cached, err := build.CachedLayers()
+	if err != nil {
+		return report, err
+	}
+	for digest := range cached {
+		referenced[digest] = true
+	}
+
+	if err := sweepBlobs(referenced, report); err != nil {
+		return report, err
+	}
+
+	return report, sweepTmp(report)
+}
+
+// sweepBlobs removes the blobs that aren't referenced, along with writes to the store that were never finished.
+func sweepBlobs(referenced map[string]bool, report *PruneReport) error {
+	store := image.DefaultBlobStore()
+
+	entries, err := os.ReadDir(store.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read blob store: %v", err)
+	}
+
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || fi.IsDir() {
+			continue
+		}
+
+		digest, unfinished := "sha256:"+e.Name(), strings.HasPrefix(e.Name(), ".tmp-")
+		switch {
+		case unfinished && time.Since(fi.ModTime()) < tmpGracePeriod:
+			continue
+		case !unfinished && (referenced[digest] || !image.ValidDigest(digest) || time.Since(fi.ModTime()) < blobGracePeriod):
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(store.Dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove blob: %v", err)
+		}
+
+		if !unfinished {
+			report.Blobs = append(report.Blobs, digest)
+		}
+		report.Reclaimed += fi.Size()
+	}
+
+	return nil
+}
+
+// sweepTmp removes the temporary files pulls, builds and imports left behind.
+func sweepTmp(report *PruneReport) error {
+	dir := filepath.Join(os.Getenv("HOME"), image.RelativeTmpPath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read temporary dir: %v", err)
+	}
+
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || time.Since(fi.ModTime()) < tmpGracePeriod {
+			continue
+		}
+
+		size := dirSize(filepath.Join(dir, e.Name()))
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("failed to remove temporary files: %v", err)
+		}
+		report.Reclaimed += size
+	}
+
+	return nil
+}
+
+// walkBlobs calls fn for every blob of the blob store.
+func walkBlobs(fn func(digest string, fi fs.FileInfo) error) error {
+	entries, err := os.ReadDir(image.DefaultBlobStore().Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read blob store: %v", err)
+	}
+
+	for _, e := range entries {
+		digest := "sha256:" + e.Name()
+		if !image.ValidDigest(digest) {
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		if err := fn(digest, fi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// usedImage reports whether one of the containers was created from the image.
+func usedImage(img *image.Image, states []*container.State) bool {
+	for _, s := range states {
+		if image.Path(s.Image) == image.Path(img.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containerDir returns the state directory of the container with the given ID.
+func containerDir(id string) string {
+	return filepath.Join(os.Getenv("HOME"), container.RelativeContainersPath, id)
+}
+
+// dirSize returns the size of the regular files under a directory.
+func dirSize(dir string) int64 {
+	var size int64
+
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if fi, err := d.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,143 @@
+package system
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z1z0v1c/gclone/internal/gocker/container"
+	"github.com/z1z0v1c/gclone/internal/gocker/image"
+	"github.com/z1z0v1c/gclone/internal/gocker/registry"
+)
+
+// TestPrune tests that pruning sweeps what nothing refers to and keeps what is in use
+func TestPrune(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	blob := func(content string) string {
+		t.Helper()
+
+		digest, _, err := image.WriteBlob(strings.NewReader(content))
+		must(t, err)
+
+		return digest
+	}
+
+	save := func(name, content string) string {
+		t.Helper()
+
+		rootfs, err := image.TempDir("rootfs-")
+		must(t, err)
+		must(t, os.WriteFile(filepath.Join(rootfs, "file"), []byte(content), 0644))
+
+		layer := registry.Descriptor{MediaType: registry.MediaTypeOCILayer, Digest: blob("layer " + content)}
+		id, err := image.Save(name, rootfs, &registry.ImageConfig{Created: content}, []registry.Descriptor{layer})
+		must(t, err)
+
+		return id
+	}
+
+	saveState := func(s container.State) {
+		t.Helper()
+
+		data, err := json.Marshal(s)
+		must(t, err)
+
+		dir := filepath.Join(home, container.RelativeContainersPath, s.ID)
+		must(t, os.MkdirAll(dir, 0755))
+		must(t, os.WriteFile(filepath.Join(dir, "state.json"), data, 0644))
+	}
+
+	save("app:1", "one")
+	save("app:2", "two")
+
+	// Images loaded without a name are stored under their ID
+	dangling := save("app:3", "three")
+	must(t, os.Rename(image.Path("app:3"), image.Path(dangling)))
+
+	running, stopped := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	saveState(container.State{ID: running, Image: "app:1", Status: container.StatusRunning, SupervisorPid: os.Getpid()})
+	saveState(container.State{ID: stopped, Image: "app:2", Status: container.StatusExited})
+
+	orphan, pushed := blob("orphan"), blob("pushed")
+	repo := filepath.Join(home, ".local/share/gocker", "registry/repositories/team/app/_layers")
+	must(t, os.MkdirAll(repo, 0755))
+	must(t, os.WriteFile(filepath.Join(repo, strings.TrimPrefix(pushed, "sha256:")), nil, 0644))
+
+	blobs := image.DefaultBlobStore().Dir
+	must(t, os.WriteFile(filepath.Join(blobs, ".tmp-123"), []byte("partial"), 0644))
+
+	tmp := filepath.Join(home, image.RelativeTmpPath)
+	must(t, os.MkdirAll(filepath.Join(tmp, "rootfs-stale"), 0755))
+
+	// Age everything past the grace periods, except for a blob a pull is still writing
+	entries, err := os.ReadDir(blobs)
+	must(t, err)
+	for _, e := range entries {
+		old := time.Now().Add(-2 * blobGracePeriod)
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			old = time.Now().Add(-2 * tmpGracePeriod)
+		}
+		must(t, os.Chtimes(filepath.Join(blobs, e.Name()), old, old))
+	}
+	old := time.Now().Add(-2 * tmpGracePeriod)
+	must(t, os.Chtimes(filepath.Join(tmp, "rootfs-stale"), old, old))
+	pulling := blob("pulling")
+	must(t, os.MkdirAll(filepath.Join(tmp, "rootfs-fresh"), 0755))
+
+	usage, err := DiskUsage()
+	must(t, err)
+	if u := usage[0]; u.Type != "Images" || u.Total != 3 || u.Active != 2 || u.Reclaimable != int64(len("three")) {
+		t.Errorf("Expected 3 images with the dangling one reclaimable, got %+v", u)
+	}
+	if u := usage[2]; u.Type != "Containers" || u.Total != 2 || u.Active != 1 {
+		t.Errorf("Expected one of 2 containers to be active, got %+v", u)
+	}
+
+	report, err := Prune(PruneOptions{})
+	must(t, err)
+
+	if !slices.Equal(report.Containers, []string{stopped}) {
+		t.Errorf("Expected only the stopped container to be removed, got %v", report.Containers)
+	}
+	if image.Exists(dangling) || !image.Exists("app:1") || !image.Exists("app:2") || len(report.Untagged) != 0 {
+		t.Errorf("Expected only the dangling image to be removed, got %v untagged", report.Untagged)
+	}
+	if !slices.Contains(report.Blobs, orphan) || !slices.Contains(report.Blobs, dangling) {
+		t.Errorf("Expected the orphan and the config of the dangling image to be swept, got %v", report.Blobs)
+	}
+	for _, digest := range []string{pushed, pulling} {
+		if !image.HasBlob(digest) {
+			t.Errorf("Expected blob %s to be kept", digest)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(blobs, ".tmp-123")); !os.IsNotExist(err) {
+		t.Errorf("Expected the unfinished blob to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "rootfs-stale")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale temporary dir to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "rootfs-fresh")); err != nil {
+		t.Errorf("Expected the fresh temporary dir to be kept")
+	}
+
+	report, err = Prune(PruneOptions{All: true})
+	must(t, err)
+
+	if !slices.Equal(report.Untagged, []string{"app:2"}) || !image.Exists("app:1") {
+		t.Errorf("Expected only the unused image to be removed, got %v", report.Untagged)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,14 +2,24 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 )
 
-// MethodGet is a constant for the HTTP GET method.
+// maxRedirects is the number of redirects a request follows, like http.Client does by default.
+const maxRedirects = 10
+
+// HTTP method constants.
 const (
-	MethodGet = "GET"
+	MethodGet   = "GET"
+	MethodHead  = "HEAD"
+	MethodPost  = "POST"
+	MethodPut   = "PUT"
+	MethodPatch = "PATCH"
 )
 
 // Client is a simple wrapper around http.Client that provides convenience methods
@@ -21,10 +31,35 @@ type Client struct {
 // NewHttpClient creates and returns a new instance of Client with a default http.Client.
 func NewHttpClient() *Client {
 	return &Client{
-		HttpClient: &http.Client{},
+		HttpClient: &http.Client{CheckRedirect: checkRedirect},
 	}
 }
 
+// NewInsecureHttpClient creates a Client that accepts any TLS certificate, for servers with self-signed ones.
+func NewInsecureHttpClient() *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return &Client{
+		HttpClient: &http.Client{Transport: transport, CheckRedirect: checkRedirect},
+	}
+}
+
+// checkRedirect drops the Authorization header when a redirect leaves the scheme and host
+// of the original request. Registries redirect blob downloads to object storage, which must
+// never see their tokens, and rejects requests carrying a second form of authentication.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if req.URL.Scheme != via[0].URL.Scheme || req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+
+	return nil
+}
+
 // SendRequest performs an HTTP request with the given method, URL, and headers.
 // It returns the response or an error if the request fails or the status is not 200 OK.
 func (hc *Client) SendRequest(method string, url string, headers map[string]string) (*http.Response, error) {
@@ -70,3 +105,25 @@ func (hc *Client) SendRequestAndDecode(v any, method string, url string, headers
 
 	return nil
 }
+
+// Send performs an HTTP request with the given context, method, URL, body and headers.
+// Unlike SendRequest it returns the response whatever its status is.
+func (hc *Client) Send(ctx context.Context, method string, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	// Go ignores the header, the length of streamed bodies has to be set on the request
+	if length := req.Header.Get("Content-Length"); length != "" {
+		if req.ContentLength, err = strconv.ParseInt(length, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid content length: %q", length)
+		}
+	}
+
+	return hc.HttpClient.Do(req)
+}